@@ -0,0 +1,81 @@
+package speedcam
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+// Degradation levels, each includes those before it.
+const (
+	degradeNone      = iota
+	degradeStride    // detect half as often
+	degradeSmall     // detect at half the width
+	degradeNoOverlay // evidence frames aren't annotated
+	degradeMax       = degradeNoOverlay
+)
+
+const (
+	adaptInterval = 2 * time.Second
+	adaptRecover  = 5 // calm checks in a row before stepping back up
+	maxDropRatio  = 0.1
+)
+
+// adapt watches for the stages falling behind the source, frames backing up
+// between them or being dropped, and lowers the quality a level at a time
+// until they keep up, restoring it once they have been keeping up for a
+// while.
+func (p *Pipeline) adapt(ctx context.Context, queues ...chan *frame) {
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+
+	last := p.Stats()
+	var calm int
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var backlog int
+		for _, q := range queues {
+			backlog += len(q)
+		}
+		stats := p.Stats()
+		frames, dropped := stats.Frames-last.Frames, stats.Dropped-last.Dropped
+		last = stats
+
+		level := p.degradation()
+		behind := backlog > p.cfg.StageBuffer || (dropped > 0 && float64(dropped) > maxDropRatio*float64(frames+dropped))
+		switch {
+		case behind && level < degradeMax:
+			calm = 0
+			p.setDegradation(level + 1)
+			fmt.Printf("Falling behind, %d frames queued and %d dropped, lowering quality to level %d\n", backlog, dropped, level+1)
+		case behind:
+			calm = 0
+		case level > degradeNone && backlog == 0 && dropped == 0:
+			if calm++; calm >= adaptRecover {
+				calm = 0
+				p.setDegradation(level - 1)
+				fmt.Printf("Keeping up, raising quality to level %d\n", level-1)
+			}
+		default:
+			calm = 0
+		}
+	}
+}
+
+// degradation is how far quality has been lowered, 0 for not at all.
+func (p *Pipeline) degradation() int {
+	return int(atomic.LoadInt32(&p.degrade))
+}
+
+func (p *Pipeline) setDegradation(level int) {
+	atomic.StoreInt32(&p.degrade, int32(level))
+	metrics.QualityDegradation.Set(float64(level))
+}
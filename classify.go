@@ -0,0 +1,45 @@
+package speedcam
+
+import (
+	"image"
+
+	"github.com/danhigham/speedcam/pkg/track"
+)
+
+// classifyPadding is how much of a car's box, on each side, is added around
+// it for the classifier, so it sees the whole vehicle when the box is tight.
+const classifyPadding = 0.1
+
+// classify labels car from its box in the evidence mid track, rather than
+// the whole road, which could hold other vehicles as well.
+func (p *Pipeline) classify(car *track.Car) string {
+	if len(car.Track) == 0 {
+		return ""
+	}
+	mid := car.Track[len(car.Track)/2]
+	if mid.Mat == nil || mid.Mat.Empty() {
+		return ""
+	}
+
+	box := classifyRegion(mid.Box, p.cfg.RoadRegion, image.Pt(mid.Mat.Cols(), mid.Mat.Rows()))
+	if box.Empty() {
+		return ""
+	}
+	region := mid.Mat.Region(box)
+	defer region.Close()
+	return p.cfg.Classifier.Classify(region)
+}
+
+// classifyRegion is box, in full frame pixels, padded by classifyPadding and
+// moved into an evidence frame of size cut from road, clamped to its edges.
+func classifyRegion(box image.Rectangle, road image.Rectangle, size image.Point) image.Rectangle {
+	if road.Min.X < 0 {
+		road.Min.X = 0
+	}
+	if road.Min.Y < 0 {
+		road.Min.Y = 0
+	}
+	pad := image.Pt(int(float64(box.Dx())*classifyPadding), int(float64(box.Dy())*classifyPadding))
+	box = image.Rectangle{Min: box.Min.Sub(pad), Max: box.Max.Add(pad)}
+	return box.Sub(road.Min).Intersect(image.Rectangle{Max: size})
+}
@@ -0,0 +1,31 @@
+package speedcam
+
+import (
+	"image"
+	"testing"
+)
+
+func TestClassifyRegionTwoCars(t *testing.T) {
+	road := image.Rect(0, 400, 1920, 800)
+	size := image.Pt(road.Dx(), road.Dy())
+	near := image.Rect(100, 500, 400, 700)
+	far := image.Rect(1200, 450, 1500, 600)
+
+	got := classifyRegion(near, road, size)
+	want := image.Rect(70, 80, 430, 320)
+	if got != want {
+		t.Errorf("near car region = %s, want %s", got, want)
+	}
+	if got.Overlaps(far.Sub(road.Min)) {
+		t.Errorf("near car region %s takes in the far car %s", got, far.Sub(road.Min))
+	}
+
+	// padding that runs off the evidence frame is clamped to it
+	edge := image.Rect(1800, 420, 1920, 520)
+	if got, want := classifyRegion(edge, road, size), image.Rect(1788, 10, 1920, 130); got != want {
+		t.Errorf("edge car region = %s, want %s", got, want)
+	}
+	if got := classifyRegion(edge, road, size); got.Overlaps(near.Sub(road.Min)) || got.Overlaps(far.Sub(road.Min)) {
+		t.Errorf("edge car region %s takes in another car", got)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+// localActor identifies whoever is running a command on the device itself.
+func localActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s", name, host)
+}
+
+// auditCommand records an action taken by a CLI command in the journal,
+// opened with open, journal.Open or journal.OpenExisting.
+func auditCommand(open func(string) (*journal.Journal, error), action string, subject string, detail string) error {
+	db, err := open(config.Env("JOURNAL_PATH", "./speedcam.db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Audit(action, localActor(), subject, detail)
+}
+
+func runAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	since := fs.Duration("since", 30*24*time.Hour, "Show entries newer than this")
+	fs.Parse(args)
+
+	db, err := journal.Open(config.Env("JOURNAL_PATH", "./speedcam.db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	entries, err := db.AuditEntries(time.Now().Add(-*since))
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\n", e.Seq, e.TimeStamp.Format(time.RFC3339), e.Action, e.Actor, e.Subject, e.Detail)
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+const backupManifestName = "manifest.json"
+
+type BackupManifest struct {
+	Created time.Time
+	Files   []BackupFile
+}
+
+type BackupFile struct {
+	Name   string // name inside the archive
+	Path   string // path on the device the file was taken from
+	Size   int64
+	SHA256 string
+}
+
+// localDataFiles returns the archive name and on-disk path of every piece of
+// local state worth keeping: the event journal, each camera's calibration
+// mask, the config and any persisted tuning.
+func localDataFiles() (map[string]string, error) {
+	files := map[string]string{
+		"journal.db":  config.Env("JOURNAL_PATH", "./speedcam.db"),
+		"config.env":  config.Env("CONFIG_PATH", "./env.sh"),
+		"tuning.json": config.Env("TUNING_PATH", "./tuning.json"),
+	}
+
+	ids, err := cameraIDs()
+	if err != nil {
+		return nil, err
+	}
+	masks := map[string]bool{}
+	for _, id := range ids {
+		// cameras without a mask of their own share MASK_PATH's
+		path := cameraEnv(id, "MASK_PATH", "./background_mask.jpg")
+		if masks[path] {
+			continue
+		}
+		masks[path] = true
+		name := "background_mask.jpg"
+		if id != "" {
+			name = "background_mask-" + id + ".jpg"
+		}
+		files[name] = path
+	}
+	return files, nil
+}
+
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	out := fs.String("o", fmt.Sprintf("speedcam-backup-%s.tar.gz", time.Now().Format("20060102-150405")), "Archive to write")
+	fs.Parse(args)
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	manifest := BackupManifest{Created: time.Now()}
+
+	files, err := localDataFiles()
+	if err != nil {
+		return err
+	}
+	for name, path := range files {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			fmt.Printf("Skipping %s, %s does not exist\n", name, path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		src := path
+		if name == "journal.db" {
+			// the journal may be mid-write, archive a snapshot of it rather
+			// than the live file
+			src, err = snapshotJournal(path)
+			if err != nil {
+				return fmt.Errorf("snapshotting %s: %s", path, err)
+			}
+			defer os.RemoveAll(filepath.Dir(src))
+
+			info, err = os.Stat(src)
+			if err != nil {
+				return err
+			}
+		}
+
+		sum, err := addFileToArchive(tw, name, src, info)
+		if err != nil {
+			return fmt.Errorf("adding %s to backup: %s", path, err)
+		}
+
+		manifest.Files = append(manifest.Files, BackupFile{Name: name, Path: path, Size: info.Size(), SHA256: sum})
+		fmt.Printf("Added %s (%d bytes)\n", path, info.Size())
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = tw.WriteHeader(&tar.Header{
+		Name:    backupManifestName,
+		Mode:    0644,
+		Size:    int64(len(manifestBytes)),
+		ModTime: manifest.Created,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifestBytes); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	// like the snapshot, the backup mustn't migrate the journal it copies
+	if err := auditCommand(journal.OpenExisting, journal.AuditExport, *out, fmt.Sprintf("backup of %d files", len(manifest.Files))); err != nil {
+		fmt.Printf("Failed to record backup in audit log, %s\n", err)
+	}
+
+	fmt.Printf("Backup written to %s\n", *out)
+	return nil
+}
+
+func snapshotJournal(path string) (string, error) {
+	dir, err := os.MkdirTemp("", "speedcam-backup")
+	if err != nil {
+		return "", err
+	}
+
+	snapshot := filepath.Join(dir, "journal.db")
+	return snapshot, journal.Snapshot(path, snapshot)
+}
+
+func addFileToArchive(tw *tar.Writer, name string, path string, info os.FileInfo) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), src); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "Overwrite existing local data")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("Usage: speedcam restore [-force] <archive>")
+	}
+
+	// extract into a staging directory first so a truncated or corrupt
+	// archive never leaves the device half restored
+	staging, err := os.MkdirTemp("", "speedcam-restore")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest, err := extractBackup(fs.Arg(0), staging)
+	if err != nil {
+		return err
+	}
+
+	targets, err := localDataFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, bf := range manifest.Files {
+		target, ok := targets[bf.Name]
+		if !ok {
+			return fmt.Errorf("Unknown file in backup: %s", bf.Name)
+		}
+		if _, err := os.Stat(target); err == nil && !*force {
+			return fmt.Errorf("%s already exists, use -force to overwrite", target)
+		}
+
+		sum, err := fileSHA256(filepath.Join(staging, bf.Name))
+		if err != nil {
+			return err
+		}
+		if sum != bf.SHA256 {
+			return fmt.Errorf("Checksum mismatch for %s", bf.Name)
+		}
+	}
+
+	for _, bf := range manifest.Files {
+		target := targets[bf.Name]
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(filepath.Join(staging, bf.Name), target); err != nil {
+			return fmt.Errorf("restoring %s: %s", target, err)
+		}
+		if bf.Name == "journal.db" {
+			// stale write-ahead log from the replaced journal must not be
+			// replayed over the restored one
+			os.Remove(target + "-wal")
+			os.Remove(target + "-shm")
+		}
+		fmt.Printf("Restored %s\n", target)
+	}
+
+	detail := fmt.Sprintf("backup taken %s", manifest.Created.Format(time.RFC3339))
+	if err := auditCommand(journal.Open, journal.AuditRestore, fs.Arg(0), detail); err != nil {
+		fmt.Printf("Failed to record restore in audit log, %s\n", err)
+	}
+
+	fmt.Printf("Restore of backup taken %s complete\n", manifest.Created.Format(time.RFC3339))
+	return nil
+}
+
+func extractBackup(archive string, dir string) (BackupManifest, error) {
+	var manifest BackupManifest
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return manifest, err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return manifest, err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	foundManifest := false
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, err
+		}
+
+		// only flat names are ever written by runBackup
+		name := filepath.Base(hdr.Name)
+		if name != hdr.Name || hdr.Typeflag != tar.TypeReg {
+			return manifest, fmt.Errorf("Unexpected entry in backup: %s", hdr.Name)
+		}
+
+		if name == backupManifestName {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return manifest, fmt.Errorf("reading manifest: %s", err)
+			}
+			foundManifest = true
+			continue
+		}
+
+		dst, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return manifest, err
+		}
+		_, err = io.Copy(dst, tr)
+		dst.Close()
+		if err != nil {
+			return manifest, err
+		}
+	}
+
+	if !foundManifest {
+		return manifest, errors.New("Backup has no manifest")
+	}
+	return manifest, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	// write alongside the target and rename so a power cut mid-restore
+	// leaves either the old or the new file, never a partial one
+	tmp := dst + ".restore"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
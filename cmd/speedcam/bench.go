@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/danhigham/speedcam"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/resources"
+)
+
+// runBench runs the pipeline over a recording as fast as it will go, with
+// the same detection settings as a normal run, and reports how it kept up.
+// Nothing is journalled, stored or published.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	input := fs.String("input", "", "Video file to process")
+	camera := fs.String("camera", "", "Camera whose settings to use, see CAMERAS")
+	fs.Parse(args)
+
+	if *input == "" {
+		return errors.New("--input is required")
+	}
+
+	cfg, err := pipelineConfig(*camera)
+	if err != nil {
+		return err
+	}
+	cfg.Source = *input
+	cfg.Lossless = true
+
+	if cfg.Tuning, err = control.NewTuning(); err != nil {
+		return err
+	}
+	if mask, err := detect.NewBackgroundMask(cameraEnv(*camera, "MASK_PATH", "./background_mask.jpg")); err == nil {
+		cfg.Mask = mask
+	} else {
+		fmt.Printf("No background mask, detecting anywhere - %s\n", err)
+	}
+
+	pipeline, err := speedcam.New(cfg)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for range pipeline.Events() {
+		}
+	}()
+
+	start := time.Now()
+	err = pipeline.Run(context.Background())
+	elapsed := time.Since(start)
+	if err != nil && err != speedcam.ErrSourceClosed {
+		return err
+	}
+
+	stats := pipeline.Stats()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "frames\t%d in %s, %.1f fps\n", stats.Frames, elapsed.Round(time.Millisecond), float64(stats.Frames)/elapsed.Seconds())
+	fmt.Fprintf(w, "dropped\t%d\n", stats.Dropped)
+	fmt.Fprintf(w, "detections\t%d\n", stats.Detections)
+	fmt.Fprintf(w, "events\t%d\n", stats.Events)
+	fmt.Fprintf(w, "counted\t%d\n", stats.Counted)
+	if stats.Shed > 0 {
+		fmt.Fprintf(w, "shed\t%d without evidence\n", stats.Shed)
+	}
+	if rss := peakRSS(); rss > 0 {
+		fmt.Fprintf(w, "peak memory\t%.1f MiB\n", float64(rss)/(1<<20))
+	}
+	// everything is closed once Run returns, anything left has leaked
+	for _, k := range resources.Kinds() {
+		if n := k.Count(); n != 0 {
+			fmt.Fprintf(w, "leaked %s\t%d\n", k.Name, n)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "stage\tcount\tmean\tmax")
+	for _, name := range speedcam.Stages {
+		s := stats.Stages[name]
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", name, s.Count, s.Mean().Round(time.Microsecond), s.Max.Round(time.Microsecond))
+	}
+	return w.Flush()
+}
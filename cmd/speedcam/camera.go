@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/danhigham/speedcam"
+	"github.com/danhigham/speedcam/pkg/api"
+	"github.com/danhigham/speedcam/pkg/auth"
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/health"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/output"
+	"github.com/danhigham/speedcam/pkg/stream"
+)
+
+var cameraIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// cameraIDs lists the cameras named in CAMERAS, comma separated, each
+// configured with CAMERA_<ID>_ variables over the shared ones. Without
+// CAMERAS there is a single unnamed camera configured as before.
+func cameraIDs() ([]string, error) {
+	v := os.Getenv("CAMERAS")
+	if v == "" {
+		return []string{""}, nil
+	}
+
+	var ids []string
+	seen := map[string]bool{}
+	for _, id := range strings.Split(v, ",") {
+		id = strings.TrimSpace(id)
+		if !cameraIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("CAMERAS: invalid camera ID %q, use lower case letters, digits and -", id)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("CAMERAS: camera %s listed twice", id)
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// camera is one capture source with its own pipeline settings, mask and
+// views, sharing the server, journal and sinks with the others.
+type camera struct {
+	id       string
+	cfg      speedcam.Config
+	hub      *stream.FrameHub
+	raw      stream.FrameView
+	thresh   stream.FrameView
+	tracking stream.FrameView
+	webrtc   *output.WebRTCOutput // set by register
+}
+
+func newCamera(id string, viewers *stream.ViewerLimit) (*camera, error) {
+	cfg, err := pipelineConfig(id)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Source == "" {
+		return nil, fmt.Errorf("neither %s nor %s is set", cameraKey(id, "STREAM_URL"), cameraKey(id, "GST_PIPELINE"))
+	}
+
+	maskPath := cameraEnv(id, "MASK_PATH", "./background_mask.jpg")
+	if cfg.Mask, err = detect.NewBackgroundMask(maskPath); err != nil {
+		return nil, fmt.Errorf("opening background mask: %s", err)
+	}
+
+	c := &camera{id: id, cfg: cfg, hub: stream.NewFrameHub()}
+	c.hub.Viewers = viewers
+	c.raw = stream.FrameView{Hub: c.hub}
+	c.thresh = stream.FrameView{Hub: c.hub, UseMask: true}
+	c.tracking = stream.FrameView{Hub: c.hub, Defaults: stream.OverlayOptions{Boxes: true, Tracks: true, Crop: cfg.RoadRegion}}
+	return c, nil
+}
+
+// name is how the camera is referred to in logs.
+func (c *camera) name() string {
+	if c.id == "" {
+		return c.cfg.Source
+	}
+	return c.id
+}
+
+func (c *camera) health() health.Camera {
+	return health.Camera{ID: c.id, Mask: c.cfg.Mask, Hub: c.hub}
+}
+
+// outputDir is the camera's setting for key, a directory, in a
+// subdirectory of its own when shared with other cameras.
+func (c *camera) outputDir(key string) string {
+	if dir := os.Getenv(cameraKey(c.id, key)); dir != "" || c.id == "" {
+		return dir
+	}
+	if dir := config.Env(key, ""); dir != "" {
+		return filepath.Join(dir, c.id)
+	}
+	return ""
+}
+
+// register serves the camera's views and starts its outputs. The unnamed
+// camera, or the first of several, is served at the top level, e.g.
+// /stream, every named camera under /cameras/<id>/ too.
+func (c *camera) register(mux *http.ServeMux, authn *auth.Auth, db *journal.Journal, prefixes ...string) error {
+	if dir := c.outputDir("HLS_DIR"); dir != "" {
+		hls, err := output.NewHLSOutput(dir, c.tracking)
+		if err != nil {
+			return fmt.Errorf("starting HLS output: %s", err)
+		}
+		go hls.Run()
+		for _, prefix := range prefixes {
+			mux.Handle(prefix+"/hls/", authn.Require(auth.ScopeRead, http.StripPrefix(prefix+"/hls/", hls.Handler())))
+		}
+	}
+
+	if dir := c.outputDir("RECORD_DIR"); dir != "" {
+		recorder, err := output.NewRecorder(dir, c.raw, db)
+		if err != nil {
+			return fmt.Errorf("starting recorder: %s", err)
+		}
+		go recorder.Run()
+	}
+
+	// a stream URL is never shared, cameras can't all push to one
+	if url := os.Getenv(cameraKey(c.id, "RTMP_URL")); url != "" {
+		go output.NewRTMPOutput(url, c.tracking).Run()
+	}
+
+	rtc, err := output.NewWebRTCOutput(c.tracking)
+	if err != nil {
+		return fmt.Errorf("creating WebRTC output: %s", err)
+	}
+	c.webrtc = rtc
+
+	for _, prefix := range prefixes {
+		mux.Handle(prefix+"/webrtc/offer", authn.Require(auth.ScopeRead, rtc))
+		mux.Handle(prefix+"/stream", authn.Require(auth.ScopeRead, c.tracking.Stream()))
+		mux.Handle(prefix+"/stream/raw", authn.Require(auth.ScopeRead, c.raw.Stream()))
+		mux.Handle(prefix+"/stream/thresh", authn.Require(auth.ScopeRead, c.thresh.Stream()))
+		mux.Handle(prefix+"/stream/tracking", authn.Require(auth.ScopeRead, c.tracking.Stream()))
+		mux.Handle(prefix+"/snapshot.jpg", authn.Require(auth.ScopeRead, c.tracking.Snapshot()))
+		mux.Handle(prefix+"/snapshot/raw.jpg", authn.Require(auth.ScopeRead, c.raw.Snapshot()))
+		mux.Handle(prefix+"/heatmap.jpg", authn.Require(auth.ScopeRead, api.HeatmapOverlay{Journal: db, Camera: c.id, Frames: c.raw}))
+	}
+	return nil
+}
+
+// cameraInfo reads what's known about where camera is installed, from its
+// own or the shared ROAD_NAME, POSTED_LIMIT, defaulting to SPEED_LIMIT,
+// DIRECTION_LEFT and DIRECTION_RIGHT, e.g. northbound, and LATITUDE and
+// LONGITUDE.
+func cameraInfo(id string) (config.CameraInfo, error) {
+	info := config.CameraInfo{
+		ID:         id,
+		Road:       cameraEnv(id, "ROAD_NAME", ""),
+		LeftLabel:  cameraEnv(id, "DIRECTION_LEFT", ""),
+		RightLabel: cameraEnv(id, "DIRECTION_RIGHT", ""),
+	}
+	var err error
+	if info.PostedLimit, err = strconv.ParseFloat(cameraEnv(id, "POSTED_LIMIT", cameraEnv(id, "SPEED_LIMIT", "0")), 64); err != nil || info.PostedLimit < 0 {
+		return info, fmt.Errorf("%s: invalid limit %q", cameraKey(id, "POSTED_LIMIT"), cameraEnv(id, "POSTED_LIMIT", ""))
+	}
+	if info.Latitude, err = strconv.ParseFloat(cameraEnv(id, "LATITUDE", "0"), 64); err != nil || math.Abs(info.Latitude) > 90 {
+		return info, fmt.Errorf("%s: invalid latitude %q", cameraKey(id, "LATITUDE"), cameraEnv(id, "LATITUDE", ""))
+	}
+	if info.Longitude, err = strconv.ParseFloat(cameraEnv(id, "LONGITUDE", "0"), 64); err != nil || math.Abs(info.Longitude) > 180 {
+		return info, fmt.Errorf("%s: invalid longitude %q", cameraKey(id, "LONGITUDE"), cameraEnv(id, "LONGITUDE", ""))
+	}
+	return info, nil
+}
+
+// cameraRegistry reads the cameraInfo of each camera.
+func cameraRegistry(ids []string) ([]config.CameraInfo, error) {
+	registry := make([]config.CameraInfo, 0, len(ids))
+	for _, id := range ids {
+		info, err := cameraInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		registry = append(registry, info)
+	}
+	return registry, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/event"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/publish"
+)
+
+// sendHeartbeats publishes a heartbeat every interval until ctx is done, so
+// a central consumer can tell the device is up and its cameras delivering.
+func sendHeartbeats(ctx context.Context, publisher *publish.Publisher, db *journal.Journal, device config.Device, cameras []*camera, interval time.Duration) {
+	started := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hb := event.Heartbeat{Device: device.ID, Name: device.Name, Site: device.Site, TimeStamp: time.Now(), Started: started}
+		for _, cam := range cameras {
+			fps, last := cam.hub.Stats()
+			hb.Cameras = append(hb.Cameras, event.CameraHeartbeat{Camera: cam.id, FPS: fps, LastFrame: last})
+		}
+		if n, err := db.UndeliveredCount(time.Minute); err == nil {
+			hb.Undelivered = n
+		}
+		if err := publisher.PublishHeartbeat(hb); err != nil {
+			fmt.Printf("Failed to publish heartbeat, %s\n", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
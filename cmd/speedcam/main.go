@@ -0,0 +1,928 @@
+// What it does:
+//
+// This example detects motion using a delta threshold from the first frame,
+// and then finds contours to determine where the object is located.
+//
+// Very loosely based on Adrian Rosebrock code located at:
+// http://www.pyimagesearch.com/2015/06/01/home-surveillance-and-motion-detection-with-the-raspberry-pi-python-and-opencv/
+//
+// How to run:
+//
+// 		go run ./cmd/speedcam
+//
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/danhigham/speedcam"
+	"github.com/danhigham/speedcam/pkg/anpr"
+	"github.com/danhigham/speedcam/pkg/api"
+	"github.com/danhigham/speedcam/pkg/auth"
+	"github.com/danhigham/speedcam/pkg/classify"
+	"github.com/danhigham/speedcam/pkg/clock"
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/event"
+	"github.com/danhigham/speedcam/pkg/evidence"
+	"github.com/danhigham/speedcam/pkg/health"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/logging"
+	"github.com/danhigham/speedcam/pkg/metrics"
+	"github.com/danhigham/speedcam/pkg/publish"
+	"github.com/danhigham/speedcam/pkg/report"
+	"github.com/danhigham/speedcam/pkg/resources"
+	"github.com/danhigham/speedcam/pkg/sentry"
+	"github.com/danhigham/speedcam/pkg/server"
+	"github.com/danhigham/speedcam/pkg/stream"
+	"github.com/danhigham/speedcam/pkg/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gocv.io/x/gocv"
+)
+
+func failOnError(err error, msg string) {
+	if err != nil {
+		log.Fatalf("%s: %s", msg, err)
+	}
+}
+
+// recordEvent journals a timed vehicle, spools its evidence and queues it
+// for upload, or straight for publishing when it has no evidence. If the
+// upload queue is full, e.g. while S3 is slow, the evidence is left spooled
+// for retryDeliveries to upload later rather than hold up the pipeline, the
+// event is only published once it has been.
+func recordEvent(uploads chan event.CarMessage, carMessageChan chan event.CarMessage, db *journal.Journal, store *evidence.Store, e speedcam.Event) {
+	id := e.ID
+	msg := e.CarMessage
+	if !e.Shed {
+		msg.ImageURI = evidence.Key(id)
+	}
+
+	if err := db.RecordEvent(msg); err != nil {
+		fmt.Printf("Failed to record %s in journal, %s\n", id.String(), err.Error())
+	}
+	metrics.EventsRecorded.Inc()
+	metrics.VehicleSpeed.WithLabelValues(msg.Camera, msg.Direction, msg.Lane).Observe(msg.Speed)
+	if msg.SpeedLimit > 0 && msg.Speed > msg.SpeedLimit {
+		metrics.Violations.WithLabelValues(msg.Camera, msg.Direction, msg.Lane).Inc()
+	}
+
+	if e.Shed {
+		db.SetUploadShed(id)
+		queuePublish(carMessageChan, db, msg)
+		return
+	}
+
+	// spool locally first so a failed upload can be retried by repair
+	var err error
+	if e.Image == nil {
+		err = errors.New("evidence image could not be encoded")
+	}
+	if err == nil {
+		_, err = store.Spool(id, e.Image)
+	}
+	if err != nil {
+		// there's nothing to upload, publish it without
+		fmt.Printf("Failed to spool evidence for %s, %s\n", id.String(), err.Error())
+		metrics.UploadFailures.Inc()
+		db.SetUploadShed(id)
+		msg.ImageURI = ""
+		queuePublish(carMessageChan, db, msg)
+		return
+	}
+
+	queueUpload(uploads, db, msg)
+}
+
+// queueUpload queues msg for its evidence to be uploaded. If the queue is
+// full the upload is marked failed for retryDeliveries to queue again later.
+func queueUpload(uploads chan event.CarMessage, db *journal.Journal, msg event.CarMessage) {
+	select {
+	case uploads <- msg:
+	default:
+		fmt.Printf("Upload queue full, leaving evidence for %s to retry\n", msg.ID.String())
+		metrics.LoadShed.WithLabelValues("upload").Inc()
+		db.SetUploadStatus(msg.ID, errors.New("upload queue full"))
+	}
+}
+
+// recordSighting journals a timed vehicle's signature as a sighting of the
+// vehicle it's closest to within distance, or of one not seen before.
+func recordSighting(db *journal.Journal, e speedcam.Event, distance float64) {
+	_, err := db.RecordSighting(journal.Sighting{EventID: e.ID, Camera: e.Camera, Class: e.Class, TimeStamp: e.TimeStamp, Signature: e.Signature}, distance)
+	if err != nil {
+		fmt.Printf("Failed to record sighting of %s in journal, %s\n", e.ID.String(), err)
+	}
+}
+
+// recordTrack journals the path of an event's vehicle for export.
+func recordTrack(db *journal.Journal, e speedcam.Event) {
+	if len(e.Track) == 0 {
+		return
+	}
+	points := make([]journal.TrackPoint, len(e.Track))
+	for i, t := range e.Track {
+		points[i] = journal.TrackPoint{X: t.Point.X, Y: t.Point.Y, Elapsed: t.Created.Sub(e.Track[0].Created).Seconds()}
+	}
+	if err := db.RecordTrack(e.ID, points); err != nil {
+		fmt.Printf("Failed to record track of %s in journal, %s\n", e.ID.String(), err)
+	}
+}
+
+// uploadEvidence uploads the spooled evidence of each event from uploads,
+// then queues it for publishing. Events whose upload fails aren't published
+// until retryDeliveries has uploaded it, so their ImageURI is never dangling.
+func uploadEvidence(uploads <-chan event.CarMessage, carMessageChan chan event.CarMessage, db *journal.Journal, store *evidence.Store) {
+	for msg := range uploads {
+		uploadStart := time.Now()
+		err := store.Upload(msg.ImageURI)
+		metrics.UploadSeconds.Observe(time.Since(uploadStart).Seconds())
+		if err != nil {
+			fmt.Printf("Failed to upload evidence for %s, %s\n", msg.ID.String(), err.Error())
+			metrics.UploadFailures.Inc()
+		}
+		db.SetUploadStatus(msg.ID, err)
+		if err == nil {
+			queuePublish(carMessageChan, db, msg)
+		}
+	}
+}
+
+// queuePublish queues msg for publishing. If the queue is full, e.g. while
+// the broker is unreachable, the event is marked failed for retryDeliveries
+// to queue again later.
+func queuePublish(carMessageChan chan event.CarMessage, db *journal.Journal, msg event.CarMessage) {
+	select {
+	case carMessageChan <- msg:
+	default:
+		fmt.Printf("Publish queue full, not publishing %s\n", msg.ID.String())
+		metrics.PublishFailures.Inc()
+		db.SetPublishStatus(msg.ID, errors.New("publish queue full"))
+	}
+}
+
+// retryDeliveries queues the events whose evidence upload or publish failed
+// again every interval, as many as there's room for, so they go out once S3
+// or the broker is back without waiting for repair. An event is only
+// published once its evidence is uploaded.
+func retryDeliveries(uploads chan event.CarMessage, carMessageChan chan event.CarMessage, db *journal.Journal, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		events, err := db.UndeliveredEvents(0)
+		if err != nil {
+			fmt.Printf("Failed to query undelivered events, %s\n", err)
+			continue
+		}
+		retriedUploads, retriedPublishes := 0, 0
+		for _, e := range events {
+			switch {
+			case e.UploadStatus == journal.DeliveryFailed:
+				if len(uploads) == cap(uploads) {
+					continue
+				}
+				if err := db.SetUploadPending(e.ID); err != nil {
+					fmt.Printf("Failed to mark %s for retry, %s\n", e.ID.String(), err)
+					continue
+				}
+				queueUpload(uploads, db, e.CarMessage)
+				retriedUploads++
+			case e.PublishStatus == journal.DeliveryFailed && (e.UploadStatus == journal.DeliveryDone || e.UploadStatus == journal.DeliveryShed):
+				if len(carMessageChan) == cap(carMessageChan) {
+					continue
+				}
+				if err := db.SetPublishPending(e.ID); err != nil {
+					fmt.Printf("Failed to mark %s for retry, %s\n", e.ID.String(), err)
+					continue
+				}
+				queuePublish(carMessageChan, db, e.CarMessage)
+				retriedPublishes++
+			}
+		}
+		if retriedUploads > 0 || retriedPublishes > 0 {
+			fmt.Printf("Retrying %d uploads and %d publishes\n", retriedUploads, retriedPublishes)
+		}
+	}
+}
+
+// rollUp keeps the journal's hourly and daily rollups current, each hour
+// rolled up within minutes of ending.
+func rollUp(db *journal.Journal) {
+	for {
+		if err := db.RollUp(time.Now()); err != nil {
+			fmt.Printf("Failed to roll up events, %s\n", err)
+		}
+		time.Sleep(5 * time.Minute)
+	}
+}
+
+// watchAnomalies compares each camera's traffic in every hour just ended
+// with the same hour in earlier weeks, alerting when it strays more than z
+// standard deviations from them.
+func watchAnomalies(db *journal.Journal, publisher *publish.Publisher, cameras []string, z float64) {
+	for {
+		// after the hour has been rolled up
+		now := time.Now()
+		next := journal.BucketStart(now, time.Hour).Add(time.Hour + 10*time.Minute)
+		time.Sleep(time.Until(next))
+
+		hour := journal.BucketStart(next, time.Hour).Add(-time.Hour)
+		for _, camera := range cameras {
+			anomalies, err := db.Anomalies(hour, camera, z)
+			if err != nil {
+				fmt.Printf("Failed to check traffic for anomalies, %s\n", err)
+				continue
+			}
+			for _, a := range anomalies {
+				metrics.TrafficAnomalies.WithLabelValues(camera, a.Metric).Inc()
+				err := publisher.PublishAlert(event.Alert{Camera: camera, Kind: event.AlertTrafficAnomaly, Message: a.String(), TimeStamp: time.Now()})
+				if err != nil {
+					fmt.Printf("Failed to publish alert, %s\n", err)
+				}
+			}
+		}
+	}
+}
+
+func openbrowser(url string) {
+	var err error
+
+	switch runtime.GOOS {
+	case "linux":
+		err = exec.Command("xdg-open", url).Start()
+	case "windows":
+		err = exec.Command("rundll32", "url.dll,FileProtocolHandler", url).Start()
+	case "darwin":
+		err = exec.Command("open", url).Start()
+	default:
+		err = fmt.Errorf("unsupported platform")
+	}
+	if err != nil {
+		fmt.Printf("Failed to open browser, %s\n", err)
+	}
+}
+
+// localURL is where a browser on this machine reaches the server on addr.
+func localURL(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return ""
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	scheme := "http"
+	if os.Getenv("TLS_CERT") != "" || os.Getenv("ACME_DOMAINS") != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s/", scheme, net.JoinHostPort(host, port), server.BasePath)
+}
+
+var showWindowsFlag bool
+
+// errorReporter reports errors and panics to Sentry, nil unless SENTRY_DSN
+// is set.
+var errorReporter *sentry.Client
+
+// commands are run instead of detection when named as the first argument
+var commands = map[string]func(args []string) error{
+	"backup":   runBackup,
+	"restore":  runRestore,
+	"repair":   runRepair,
+	"audit":    runAudit,
+	"report":   runReport,
+	"bench":    runBench,
+	"simulate": runSimulate,
+	"replay":   runReplay,
+}
+
+// versionRequested reports whether args ask for --version, outside of a
+// command, so it can be answered before any configuration is fetched.
+func versionRequested(args []string) bool {
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			return false
+		}
+	}
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || name != "version" {
+			continue
+		}
+		if !hasValue {
+			return true
+		}
+		show, _ := strconv.ParseBool(value)
+		return show
+	}
+	return false
+}
+
+func main() {
+	if versionRequested(os.Args[1:]) {
+		fmt.Print(version.Get())
+		return
+	}
+
+	// settings kept centrally are in the environment before anything reads
+	// it, a camera that can't reach them starts on its own
+	remote, err := config.NewRemote()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if remote != nil {
+		if _, err := remote.Apply(); err != nil {
+			fmt.Printf("Failed to fetch configuration from %s, %s, starting with the local configuration\n", remote.URL, err)
+		} else {
+			fmt.Printf("Loaded configuration from %s\n", remote.URL)
+		}
+	}
+
+	if err := config.LoadTimezone(); err != nil {
+		log.Fatal(err)
+	}
+
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			if err := cmd(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %s", os.Args[1], err)
+			}
+			return
+		}
+	}
+
+	flag.BoolVar(&showWindowsFlag, "show-windows", false, "Show windows for output preview (deprecated, use /stream/raw, /stream/thresh and /stream/tracking)")
+	listenAddr := flag.String("listen", config.Env("LISTEN_ADDR", "0.0.0.0:8080"), "Address to serve HTTP on")
+	openBrowser := flag.Bool("open-browser", false, "Open the dashboard in a browser once started, for desktop use")
+	pidFile := flag.String("pid-file", config.Env("PID_FILE", ""), "File to write the process ID to while running, for init systems that want one")
+	flag.Bool("version", false, "Print the version, OpenCV version and features, then exit") // see versionRequested
+	flag.Parse()
+
+	// deferred first, so it exits once everything else has been closed
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	if err := config.CheckProfile(); err != nil {
+		fmt.Printf("Error reading profile - %s\n", err)
+		return
+	}
+
+	logFile, err := logging.New()
+	if err != nil {
+		fmt.Printf("Error opening log file - %s\n", err)
+		return
+	}
+	logFormat := config.Env("LOG_FORMAT", logging.FormatText)
+	if logFormat != logging.FormatText && logFormat != logging.FormatJSON {
+		fmt.Printf("Error reading LOG_FORMAT - want %s or %s, got %q\n", logging.FormatText, logging.FormatJSON, logFormat)
+		return
+	}
+	if errorReporter, err = sentry.New(); err != nil {
+		fmt.Printf("Error configuring error reporting - %s\n", err)
+		return
+	}
+	defer errorReporter.Recover(nil)
+	if logFile != nil || logFormat == logging.FormatJSON || errorReporter != nil {
+		var out io.Writer = os.Stdout
+		if logFile != nil {
+			out = logFile
+			// a copy on stdout too, for the console or a container's logs
+			if tee, _ := strconv.ParseBool(config.Env("LOG_STDOUT", "false")); tee {
+				out = io.MultiWriter(logFile, os.Stdout)
+			}
+			defer logFile.Close()
+		}
+		if logFormat == logging.FormatJSON {
+			out = logging.JSON{W: out}
+			// each line carries its own time
+			log.SetFlags(0)
+		}
+		if errorReporter != nil {
+			// error lines are reported as they're logged
+			out = io.MultiWriter(out, errorReporter)
+		}
+		stop, err := logging.Capture(out)
+		if err != nil {
+			fmt.Printf("Error capturing logs - %s\n", err)
+			return
+		}
+		defer stop()
+	}
+
+	if *pidFile != "" {
+		remove, err := writePIDFile(*pidFile)
+		if err != nil {
+			fmt.Printf("Error writing PID file - %s\n", err)
+			return
+		}
+		defer remove()
+	}
+
+	// SIGTERM, from systemd or docker stop, shuts down as cleanly as Ctrl-C:
+	// the cameras stop, the server finishes its requests and the journal
+	// is closed
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	ctx, shutdown := context.WithCancel(ctx)
+	defer shutdown()
+
+	// where leaked OpenCV objects were created, on /debug/resources
+	resources.Stacks, _ = strconv.ParseBool(os.Getenv("RESOURCE_STACKS"))
+
+	ids, err := cameraIDs()
+	if err != nil {
+		fmt.Printf("Error reading cameras - %s\n", err)
+		return
+	}
+	registry, err := cameraRegistry(ids)
+	if err != nil {
+		fmt.Printf("Error reading cameras - %s\n", err)
+		return
+	}
+
+	db, err := journal.Open(config.Env("JOURNAL_PATH", "./speedcam.db"))
+	if err != nil {
+		fmt.Printf("Error opening journal - %s", err)
+		return
+	}
+	defer db.Close()
+
+	carMessageBuffer, err := strconv.Atoi(config.Env("CAR_MESSAGE_BUFFER", "64"))
+	if err != nil || carMessageBuffer < 0 {
+		fmt.Printf("Error reading CAR_MESSAGE_BUFFER - invalid size %q\n", os.Getenv("CAR_MESSAGE_BUFFER"))
+		return
+	}
+
+	uploadQueue, err := strconv.Atoi(config.Env("UPLOAD_QUEUE", "16"))
+	if err != nil || uploadQueue < 0 {
+		fmt.Printf("Error reading UPLOAD_QUEUE - invalid size %q\n", os.Getenv("UPLOAD_QUEUE"))
+		return
+	}
+
+	retryInterval, err := time.ParseDuration(config.Env("RETRY_INTERVAL", "1m"))
+	if err != nil || retryInterval <= 0 {
+		fmt.Printf("Error reading RETRY_INTERVAL - invalid duration %q\n", os.Getenv("RETRY_INTERVAL"))
+		return
+	}
+
+	// start thread listening for car messages
+	carMessageChan := make(chan event.CarMessage, carMessageBuffer)
+	uploads := make(chan event.CarMessage, uploadQueue)
+
+	controls := &control.Controls{}
+	metrics.RegisterControls(controls)
+
+	tuning, err := control.NewTuning()
+	if err != nil {
+		fmt.Printf("Error loading tuning - %s\n", err)
+		return
+	}
+
+	if remote != nil {
+		go remote.Watch(func(changed []string) {
+			restart := applyRemoteChange(tuning, changed, os.Getenv)
+			if len(restart) == 0 {
+				return
+			}
+			if ok, _ := strconv.ParseBool(config.Env("CONFIG_RESTART", "true")); !ok {
+				fmt.Printf("Configuration of %s changed remotely, restart to apply it\n", strings.Join(restart, ", "))
+				return
+			}
+			fmt.Printf("Configuration of %s changed remotely, restarting to apply it\n", strings.Join(restart, ", "))
+			exitCode = exitRestart
+			shutdown()
+		})
+	}
+
+	store, err := evidence.New()
+	if err != nil {
+		fmt.Printf("Error opening evidence store - %s", err)
+		return
+	}
+
+	publisher, err := publish.New()
+	failOnError(err, "Failed to start publisher")
+	defer publisher.Close()
+
+	device := config.LoadDevice()
+	metrics.RegisterDevice(device.Name, device.Site)
+	fmt.Printf("Running speedcam %s as device %s\n", version.Get().Version, device.ID)
+	if tz := config.Timezone(); tz != "" {
+		fmt.Printf("Times are in %s\n", tz)
+	}
+
+	clockChecker, err := clock.NewChecker()
+	if err != nil {
+		fmt.Printf("Error configuring the clock check - %s\n", err)
+		return
+	}
+	if clockChecker != nil {
+		onDrift := func(offset time.Duration) {
+			message := fmt.Sprintf("clock is %s, events are flagged as time-suspect", clockChecker.Describe(offset))
+			if err := publisher.PublishAlert(event.Alert{Kind: event.AlertClockDrift, Message: message, TimeStamp: time.Now()}); err != nil {
+				fmt.Printf("Failed to publish alert, %s\n", err)
+			}
+		}
+		if err := clockChecker.Check(onDrift); err != nil {
+			fmt.Printf("Failed to check the clock against %s, %s\n", clockChecker.Server, err)
+		}
+		go clockChecker.Run(ctx, onDrift)
+	}
+
+	// an uploader per camera, as many as uploaded at once before queueing
+	for range ids {
+		go uploadEvidence(uploads, carMessageChan, db, store)
+	}
+
+	go func() {
+		var lastAlerted time.Time
+		for carMessage := range carMessageChan {
+			if controls.Muted() {
+				fmt.Printf("Alerts muted, not publishing %s\n", carMessage.ID.String())
+				db.SetPublishMuted(carMessage.ID)
+				metrics.EventsMuted.Inc()
+				continue
+			}
+
+			err := publisher.Publish(carMessage)
+			if err == nil {
+				metrics.EventsPublished.Inc()
+			}
+			if err != nil {
+				fmt.Printf("Failed to publish %s, %s\n", carMessage.ID.String(), err.Error())
+				metrics.PublishFailures.Inc()
+			}
+			db.SetPublishStatus(carMessage.ID, err)
+
+			// every event is published, the cooldown only holds back the
+			// alerts raised for those over the limit
+			if carMessage.SpeedLimit <= 0 || carMessage.Speed <= carMessage.SpeedLimit {
+				continue
+			}
+			if cooldown := tuning.Values().Cooldown(); time.Since(lastAlerted) < cooldown {
+				fmt.Printf("Alert cooldown, not alerting on %s\n", carMessage.ID.String())
+				continue
+			}
+			message := fmt.Sprintf("%s timed at %.1f mph, limit %.0f", carMessage.ID.String(), carMessage.Speed, carMessage.SpeedLimit)
+			alert := event.Alert{Camera: carMessage.Camera, Kind: event.AlertSpeeding, Message: message, TimeStamp: carMessage.TimeStamp}
+			if err := publisher.PublishAlert(alert); err != nil {
+				fmt.Printf("Failed to publish alert, %s\n", err)
+				continue
+			}
+			lastAlerted = time.Now()
+		}
+	}()
+
+	go retryDeliveries(uploads, carMessageChan, db, retryInterval)
+
+	authn, err := auth.New()
+	if err != nil {
+		fmt.Printf("Error configuring authentication - %s", err)
+		return
+	}
+
+	viewers, err := stream.NewViewerLimit()
+	if err != nil {
+		fmt.Printf("Error configuring stream viewers - %s", err)
+		return
+	}
+
+	// a mux of our own rather than http.DefaultServeMux, which imported
+	// packages like net/http/pprof register on without any auth
+	mux := http.NewServeMux()
+
+	var cameras []*camera
+	checker := &health.Checker{Publisher: publisher, Evidence: store, Journal: db}
+	for i, id := range ids {
+		cam, err := newCamera(id, viewers)
+		if err != nil {
+			fmt.Printf("Error configuring %s - %s\n", strings.TrimSpace("camera "+id), err)
+			return
+		}
+		// the first camera also keeps the paths of a single camera setup
+		var prefixes []string
+		if i == 0 {
+			prefixes = append(prefixes, "")
+		}
+		if id != "" {
+			prefixes = append(prefixes, "/cameras/"+id)
+		}
+		if err := cam.register(mux, authn, db, prefixes...); err != nil {
+			fmt.Printf("Error configuring outputs of camera %s - %s\n", cam.name(), err)
+			return
+		}
+		cameras = append(cameras, cam)
+		checker.Cameras = append(checker.Cameras, cam.health())
+	}
+
+	// SELF_TEST=false skips it, e.g. to start while a camera is still
+	// coming up and leave the pipeline to keep retrying it
+	if run, _ := strconv.ParseBool(config.Env("SELF_TEST", "true")); run && !selfTest(cameras, store, publisher) {
+		fmt.Printf("Self-test failed, not starting detection\n")
+		return
+	}
+
+	heartbeatInterval, err := time.ParseDuration(config.Env("HEARTBEAT_INTERVAL", "1m"))
+	if err != nil || heartbeatInterval < 0 {
+		fmt.Printf("Error reading HEARTBEAT_INTERVAL - invalid duration %q\n", config.Env("HEARTBEAT_INTERVAL", ""))
+		return
+	}
+	if heartbeatInterval > 0 {
+		go sendHeartbeats(ctx, publisher, db, device, cameras, heartbeatInterval)
+	}
+
+	if os.Getenv("REPORT_SCHEDULE") != "" {
+		reporter, err := report.NewReporter(db, store)
+		if err != nil {
+			fmt.Printf("Error configuring reports - %s", err)
+			return
+		}
+		reporter.Cameras = registry
+		go reporter.Run()
+	}
+
+	go rollUp(db)
+
+	anomalyZ, err := strconv.ParseFloat(config.Env("ANOMALY_THRESHOLD", "3"), 64)
+	if err != nil || anomalyZ < 0 {
+		fmt.Printf("Error reading ANOMALY_THRESHOLD - invalid value %q\n", config.Env("ANOMALY_THRESHOLD", ""))
+		return
+	}
+	if anomalyZ > 0 {
+		go watchAnomalies(db, publisher, ids, anomalyZ)
+	}
+
+	sightingDistance, err := strconv.ParseFloat(config.Env("SIGHTING_DISTANCE", strconv.FormatFloat(journal.DefaultSightingDistance, 'f', -1, 64)), 64)
+	if err != nil || sightingDistance < 0 || sightingDistance > 1 {
+		fmt.Printf("Error reading SIGHTING_DISTANCE - invalid value %q\n", config.Env("SIGHTING_DISTANCE", ""))
+		return
+	}
+
+	p85Windows, err := journal.ParseWindows(config.Env("P85_WINDOWS", "15m,1h,24h,168h"))
+	if err != nil {
+		fmt.Printf("Error reading P85_WINDOWS - %s\n", err)
+		return
+	}
+	metrics.RegisterP85(p85Windows, func(window time.Duration) float64 {
+		p85s, err := db.P85Speeds(time.Now(), "", []time.Duration{window})
+		if err != nil {
+			fmt.Printf("Failed to compute 85th percentile speed, %s\n", err)
+			return 0
+		}
+		return p85s[0].P85Speed
+	})
+
+	rollingWindows, err := journal.ParseWindows(config.Env("ROLLING_WINDOWS", "24h,168h"))
+	if err != nil {
+		fmt.Printf("Error reading ROLLING_WINDOWS - %s\n", err)
+		return
+	}
+	rolling := journal.NewRolling(db, rollingWindows, time.Minute)
+	go rolling.Run()
+	metrics.RegisterRolling(rolling)
+
+	publicStats, err := api.NewPublicStats(db)
+	if err != nil {
+		fmt.Printf("Error configuring public stats - %s\n", err)
+		return
+	}
+	if publicStats != nil {
+		go publicStats.Run()
+		mux.Handle("/public/stats", publicStats)
+	}
+
+	plates, err := anpr.New(db)
+	if err != nil {
+		fmt.Printf("Error configuring plate tracking - %s\n", err)
+		return
+	}
+	if plates != nil {
+		fmt.Printf("Plate tracking enabled, plates of violations kept for %s\n", plates.Retention)
+		plates.OnRepeat = func(plate string, camera string, violations int) {
+			message := fmt.Sprintf("plate %s exceeded the limit %d times in %s", plate, violations, plates.Window)
+			if plates.Window%(24*time.Hour) == 0 {
+				message = fmt.Sprintf("plate %s exceeded the limit %d times in %d days", plate, violations, plates.Window/(24*time.Hour))
+			}
+			err := publisher.PublishAlert(event.Alert{Camera: camera, Kind: event.AlertRepeatOffender, Message: message, TimeStamp: time.Now()})
+			if err != nil {
+				fmt.Printf("Failed to publish alert, %s\n", err)
+			}
+		}
+		go plates.Run()
+	}
+
+	a := api.New(db, store, controls, authn)
+	a.P85Windows = p85Windows
+	a.Cameras = registry
+	a.Rolling = rolling
+	if a.FHWAClassMap, err = journal.ParseFHWAClassMap(config.Env("FHWA_CLASSES", "")); err != nil {
+		fmt.Printf("Error reading FHWA_CLASSES - %s\n", err)
+		return
+	}
+	factors, err := journal.ParseSeasonalFactors(config.Env("AADT_MONTH_FACTORS", ""), config.Env("AADT_DAY_FACTORS", ""))
+	if err != nil {
+		fmt.Printf("Error reading AADT_MONTH_FACTORS or AADT_DAY_FACTORS - %s\n", err)
+		return
+	}
+	a.SeasonalFactors = &factors
+	a.Register(mux)
+	checker.Register(mux)
+	mux.Handle("/metrics", authn.Require(auth.ScopeRead, promhttp.HandlerFor(metrics.DeviceGatherer(device.ID), promhttp.HandlerOpts{})))
+	mux.Handle("/version", authn.Require(auth.ScopeRead, http.HandlerFunc(version.Serve)))
+	api.RegisterTuning(mux, tuning, authn)
+	server.RegisterPprof(mux, authn)
+	api.RegisterOpenAPI(mux)
+	api.RegisterWeb(mux)
+
+	go func() {
+		handler, err := server.Middleware(mux)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := server.ListenAndServe(ctx, *listenAddr, handler); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	if *openBrowser {
+		openbrowser(localURL(*listenAddr))
+	}
+
+	classifier, err := classify.New()
+	if err != nil {
+		fmt.Printf("Error loading classifier - %s\n", err)
+		return
+	}
+	if classifier != nil {
+		defer classifier.Close()
+	}
+
+	var wg sync.WaitGroup
+	for _, cam := range cameras {
+		cfg := cam.cfg
+		cfg.Tuning = tuning
+		cfg.Controls = controls
+		if classifier != nil {
+			// one network serves every camera, Classify serialises them
+			cfg.Classifier = classifier
+		}
+
+		var feedWindow *gocv.Window
+		var blobWindow *gocv.Window
+
+		if showWindowsFlag {
+			feedWindow = gocv.NewWindow(strings.TrimSpace("Video Feed " + cam.id))
+			defer feedWindow.Close()
+
+			blobWindow = gocv.NewWindow(strings.TrimSpace("Blobs " + cam.id))
+			defer blobWindow.Close()
+		}
+
+		hub := cam.hub
+		id := cam.id
+		alert := func(kind string, message string) {
+			err := publisher.PublishAlert(event.Alert{Camera: id, Kind: kind, Message: message, TimeStamp: time.Now()})
+			if err != nil {
+				fmt.Printf("Failed to publish alert, %s\n", err)
+			}
+		}
+		cfg.OnFrozen = func(age time.Duration) {
+			alert(event.AlertFeedFrozen, fmt.Sprintf("no new frames for %s, reconnecting", age.Round(time.Second)))
+		}
+		cfg.OnConnection = func(connected bool) {
+			if connected {
+				alert(event.AlertSourceReconnected, "stream reopened")
+			} else {
+				alert(event.AlertSourceDisconnected, "stream dropped, reconnecting")
+			}
+		}
+		cfg.OnCount = func(c speedcam.Count) {
+			if err := db.CountVehicle(c.Camera, c.TimeStamp, c.Direction, c.Class); err != nil {
+				fmt.Printf("Failed to count vehicle in journal, %s\n", err)
+			}
+		}
+		cfg.OnNearMiss = func(nm speedcam.NearMiss) {
+			err := db.RecordNearMiss(journal.NearMiss{
+				ID:              nm.ID,
+				Camera:          nm.Camera,
+				TimeStamp:       nm.TimeStamp,
+				VehicleClass:    nm.VehicleClass,
+				VulnerableClass: nm.VulnerableClass,
+				VehicleSpeed:    nm.VehicleSpeed,
+				Distance:        nm.Distance,
+				TTC:             nm.TTC.Seconds(),
+			})
+			if err != nil {
+				fmt.Printf("Failed to record near miss in journal, %s\n", err)
+			}
+		}
+		cfg.OnYield = func(y speedcam.Yield) {
+			if !y.Yielded {
+				fmt.Printf("Vehicle failed to yield at the crosswalk, %.1f mph\n", y.MinSpeed)
+			}
+			err := db.RecordYield(journal.Yield{
+				ID:              y.ID,
+				Camera:          y.Camera,
+				EventID:         y.EventID,
+				TimeStamp:       y.TimeStamp,
+				VehicleClass:    y.VehicleClass,
+				PedestrianClass: y.PedestrianClass,
+				ApproachSpeed:   y.ApproachSpeed,
+				MinSpeed:        y.MinSpeed,
+				Yielded:         y.Yielded,
+			})
+			if err != nil {
+				fmt.Printf("Failed to record crosswalk yield in journal, %s\n", err)
+			}
+		}
+		cfg.OnOccupancy = func(o speedcam.Occupancy) {
+			if err := db.AddOccupancy(o.Camera, o.Start, o.Observed, o.Occupied, o.VehicleTime, o.MaxVehicles); err != nil {
+				fmt.Printf("Failed to record occupancy in journal, %s\n", err)
+			}
+		}
+		cfg.OnSpatial = func(s speedcam.Spatial) {
+			cells := make([]journal.SpatialCell, len(s.Cells))
+			for i, c := range s.Cells {
+				cells[i] = journal.SpatialCell{X: c.X, Y: c.Y, Detections: c.Detections, Timed: c.Timed}
+				if c.Timed > 0 {
+					cells[i].MeanSpeed = c.SpeedSum / float64(c.Timed)
+				}
+			}
+			if err := db.AddSpatial(s.Camera, s.Start, s.CellSize, cells); err != nil {
+				fmt.Printf("Failed to record heatmap in journal, %s\n", err)
+			}
+		}
+		cfg.OnEvent = func(e speedcam.Event) {
+			// in the configured zone, whatever the source's clock gave, so
+			// consumers get the camera's local time with its offset
+			e.TimeStamp = e.TimeStamp.In(time.Local)
+			e.TimeSuspect = clockChecker.Suspect()
+			recordEvent(uploads, carMessageChan, db, store, e)
+			recordTrack(db, e)
+			if e.Signature != nil {
+				recordSighting(db, e, sightingDistance)
+			}
+			if plates != nil {
+				plates.Add(e.CarMessage, e.Image)
+			}
+		}
+		cfg.OnPanic = func(stage string, v interface{}) {
+			errorReporter.CapturePanic(v, map[string]string{"camera": id, "stage": stage})
+		}
+		cfg.OnFrame = func(frame gocv.Mat, foreground gocv.Mat, overlay stream.Overlay) {
+			hub.Publish(frame, foreground, overlay)
+
+			if showWindowsFlag {
+				preview := frame.Clone()
+				stream.DrawOverlay(&preview, overlay, stream.OverlayOptions{Boxes: true, Tracks: true})
+				feedWindow.IMShow(preview)
+				blobWindow.IMShow(foreground)
+				preview.Close()
+			}
+		}
+
+		wg.Add(1)
+		go func(cam *camera, cfg speedcam.Config) {
+			defer wg.Done()
+			cam.supervise(ctx, cfg)
+		}(cam, cfg)
+	}
+
+	sdNotify("READY=1")
+	go sdWatchdog(ctx.Done())
+	go func() {
+		<-ctx.Done()
+		fmt.Printf("Shutting down\n")
+		sdNotify("STOPPING=1")
+		for _, cam := range cameras {
+			if cam.webrtc != nil {
+				cam.webrtc.Close()
+			}
+		}
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam"
+	"github.com/danhigham/speedcam/pkg/capture"
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/speed"
+)
+
+// pipelineConfig reads the detection settings of camera from the
+// environment, or the PROFILE defaults for those unset. See cameraEnv.
+func pipelineConfig(camera string) (speedcam.Config, error) {
+	env := func(key string, fallback string) string {
+		return cameraEnv(camera, key, fallback)
+	}
+
+	cfg := speedcam.Config{
+		Camera:      camera,
+		Source:      env("STREAM_URL", ""),
+		Backend:     env("DETECT_BACKEND", ""),
+		Decode:      env("DECODE", ""),
+		Tracker:     env("TRACKER", ""),
+		ThermalZone: env("THERMAL_ZONE", ""),
+	}
+
+	// a GStreamer pipeline reaches inputs OpenCV can't open by URL, e.g. CSI
+	// cameras, SRT or multicast
+	if pipeline := env("GST_PIPELINE", ""); pipeline != "" {
+		if !capture.IsPipeline(pipeline) {
+			return cfg, errors.New("GST_PIPELINE: pipeline must end in an appsink, e.g. ... ! videoconvert ! appsink")
+		}
+		cfg.Source = pipeline
+	}
+
+	var err error
+	if cfg.DetectWidth, err = strconv.Atoi(env("DETECT_WIDTH", "0")); err != nil {
+		return cfg, fmt.Errorf("DETECT_WIDTH: invalid width %q", env("DETECT_WIDTH", ""))
+	}
+	if cfg.MaxFPS, err = strconv.ParseFloat(env("MAX_FPS", "0"), 64); err != nil {
+		return cfg, fmt.Errorf("MAX_FPS: invalid rate %q", env("MAX_FPS", ""))
+	}
+	if cfg.JPEGQuality, err = strconv.Atoi(env("JPEG_QUALITY", "0")); err != nil {
+		return cfg, fmt.Errorf("JPEG_QUALITY: invalid quality %q", env("JPEG_QUALITY", ""))
+	}
+	if cfg.ThermalLimit, err = strconv.ParseFloat(env("THERMAL_LIMIT", "0"), 64); err != nil {
+		return cfg, fmt.Errorf("THERMAL_LIMIT: invalid temperature %q", env("THERMAL_LIMIT", ""))
+	}
+	if cfg.StageBuffer, err = strconv.Atoi(env("STAGE_BUFFER", "0")); err != nil {
+		return cfg, fmt.Errorf("STAGE_BUFFER: invalid size %q", env("STAGE_BUFFER", ""))
+	}
+	if cfg.EventBuffer, err = strconv.Atoi(env("EVENT_BUFFER", "0")); err != nil {
+		return cfg, fmt.Errorf("EVENT_BUFFER: invalid size %q", env("EVENT_BUFFER", ""))
+	}
+	if cfg.ShedDepth, err = strconv.Atoi(env("SHED_DEPTH", "8")); err != nil {
+		return cfg, fmt.Errorf("SHED_DEPTH: invalid depth %q", env("SHED_DEPTH", ""))
+	}
+	if cfg.StallTimeout, err = time.ParseDuration(env("STALL_TIMEOUT", "30s")); err != nil {
+		return cfg, fmt.Errorf("STALL_TIMEOUT: %s", err)
+	}
+	if cfg.Adaptive, err = strconv.ParseBool(env("ADAPTIVE_QUALITY", "false")); err != nil {
+		return cfg, fmt.Errorf("ADAPTIVE_QUALITY: invalid value %q", env("ADAPTIVE_QUALITY", ""))
+	}
+
+	if cfg.NearMissDistance, err = strconv.ParseFloat(env("NEAR_MISS_DISTANCE", "0"), 64); err != nil || cfg.NearMissDistance < 0 {
+		return cfg, fmt.Errorf("NEAR_MISS_DISTANCE: invalid distance %q", env("NEAR_MISS_DISTANCE", ""))
+	}
+	if cfg.NearMissTTC, err = time.ParseDuration(env("NEAR_MISS_TTC", "0s")); err != nil || cfg.NearMissTTC < 0 {
+		return cfg, fmt.Errorf("NEAR_MISS_TTC: invalid duration %q", env("NEAR_MISS_TTC", ""))
+	}
+	if v := env("NEAR_MISS_CLASSES", ""); v != "" {
+		cfg.NearMissClasses = strings.Split(v, ",")
+	}
+
+	if cfg.LimitSchedule, err = control.ParseLimitSchedule(env("SPEED_LIMIT_SCHEDULE", ""), env("SPEED_LIMIT_SCHEDULE_CLOSED", "")); err != nil {
+		return cfg, fmt.Errorf("SPEED_LIMIT_SCHEDULE: %s", err)
+	}
+
+	if v := env("LANES", ""); v != "" {
+		if cfg.Lanes, err = parseLanes(v); err != nil {
+			return cfg, fmt.Errorf("LANES: %s", err)
+		}
+	}
+
+	if cfg.Signatures, err = strconv.ParseBool(env("VEHICLE_SIGNATURES", "false")); err != nil {
+		return cfg, fmt.Errorf("VEHICLE_SIGNATURES: invalid value %q", env("VEHICLE_SIGNATURES", ""))
+	}
+
+	if cfg.HeatmapCell, err = strconv.Atoi(env("HEATMAP_CELL", "0")); err != nil || cfg.HeatmapCell < 0 {
+		return cfg, fmt.Errorf("HEATMAP_CELL: invalid size %q", env("HEATMAP_CELL", ""))
+	}
+
+	if v := env("CROSSWALK", ""); v != "" {
+		if cfg.Crosswalk, err = parseRect(v); err != nil {
+			return cfg, fmt.Errorf("CROSSWALK: %s", err)
+		}
+	}
+	if cfg.CrosswalkYieldSpeed, err = strconv.ParseFloat(env("CROSSWALK_YIELD_SPEED", "0"), 64); err != nil || cfg.CrosswalkYieldSpeed < 0 {
+		return cfg, fmt.Errorf("CROSSWALK_YIELD_SPEED: invalid speed %q", env("CROSSWALK_YIELD_SPEED", ""))
+	}
+
+	cal := speed.DefaultCalibration
+	if cal.FOV, err = strconv.ParseFloat(env("CAMERA_FOV", strconv.FormatFloat(cal.FOV, 'f', -1, 64)), 64); err != nil {
+		return cfg, fmt.Errorf("CAMERA_FOV: invalid angle %q", env("CAMERA_FOV", ""))
+	}
+	if cal.DistanceToRoad, err = strconv.ParseFloat(env("DISTANCE_TO_ROAD", strconv.FormatFloat(cal.DistanceToRoad, 'f', -1, 64)), 64); err != nil {
+		return cfg, fmt.Errorf("DISTANCE_TO_ROAD: invalid distance %q", env("DISTANCE_TO_ROAD", ""))
+	}
+	if cal.ImageWidth, err = strconv.ParseFloat(env("IMAGE_WIDTH", strconv.FormatFloat(cal.ImageWidth, 'f', -1, 64)), 64); err != nil {
+		return cfg, fmt.Errorf("IMAGE_WIDTH: invalid width %q", env("IMAGE_WIDTH", ""))
+	}
+	cfg.Calibration = cal
+
+	cfg.RoadRegion = detect.RoadRegion
+	if v := env("ROAD_REGION", ""); v != "" {
+		if cfg.RoadRegion, err = parseRect(v); err != nil {
+			return cfg, fmt.Errorf("ROAD_REGION: %s", err)
+		}
+	}
+	return cfg, nil
+}
+
+// parseRect reads a rectangle given as minX,minY,maxX,maxY.
+func parseRect(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid rectangle %q, want minX,minY,maxX,maxY", s)
+	}
+	var v [4]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid rectangle %q, want minX,minY,maxX,maxY", s)
+		}
+		v[i] = n
+	}
+	r := image.Rect(v[0], v[1], v[2], v[3])
+	if r.Empty() {
+		return r, fmt.Errorf("empty rectangle %q", s)
+	}
+	return r, nil
+}
+
+// parseLanes reads lanes given as name:minX,minY,maxX,maxY separated by
+// semicolons, e.g. "downhill:0,300,1920,500;uphill:0,500,1920,700".
+func parseLanes(s string) ([]speedcam.Lane, error) {
+	var lanes []speedcam.Lane
+	for _, l := range strings.Split(s, ";") {
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid lane %q, want name:minX,minY,maxX,maxY", l)
+		}
+		region, err := parseRect(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		lanes = append(lanes, speedcam.Lane{Name: strings.TrimSpace(parts[0]), Region: region})
+	}
+	return lanes, nil
+}
+
+// cameraKey is the environment variable holding key for camera, e.g.
+// CAMERA_NORTH_STREAM_URL for key STREAM_URL of camera north, or key itself
+// for the unnamed camera of a single camera setup.
+func cameraKey(camera string, key string) string {
+	if camera == "" {
+		return key
+	}
+	return "CAMERA_" + strings.ToUpper(strings.ReplaceAll(camera, "-", "_")) + "_" + key
+}
+
+// cameraEnv returns the camera's own setting for key if it has one, otherwise
+// the setting shared by every camera.
+func cameraEnv(camera string, key string, fallback string) string {
+	if v := os.Getenv(cameraKey(camera, key)); v != "" {
+		return v
+	}
+	return config.Env(key, fallback)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/danhigham/speedcam/pkg/control"
+)
+
+// exitRestart is the exit status after stopping to apply remote
+// configuration, EX_TEMPFAIL, so systemd's Restart=on-failure or docker's
+// on-failure policy starts it again.
+const exitRestart = 75
+
+// tuningVariables set the tuning a variable is read into, so a remote
+// change to it applies while running. Any other change needs a restart.
+var tuningVariables = map[string]func(p *control.TuningPatch, v string) error{
+	"THRESHOLD": func(p *control.TuningPatch, v string) error {
+		f, err := strconv.ParseFloat(v, 32)
+		t := float32(f)
+		p.Threshold = &t
+		return err
+	},
+	"MIN_AREA":       func(p *control.TuningPatch, v string) (err error) { p.MinArea, err = parsePatchFloat(v); return },
+	"MIN_DISTANCE":   func(p *control.TuningPatch, v string) (err error) { p.MinDistance, err = parsePatchFloat(v); return },
+	"SPEED_LIMIT":    func(p *control.TuningPatch, v string) (err error) { p.SpeedLimit, err = parsePatchFloat(v); return },
+	"ALERT_COOLDOWN": func(p *control.TuningPatch, v string) (err error) { p.AlertCooldown, err = parsePatchFloat(v); return },
+	"DETECT_STRIDE": func(p *control.TuningPatch, v string) error {
+		n, err := strconv.Atoi(v)
+		p.DetectStride = &n
+		return err
+	},
+}
+
+func parsePatchFloat(v string) (*float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	return &f, err
+}
+
+// applyRemoteChange applies the changed variables that are tuning straight
+// away, without persisting them, returning those that need a restart.
+func applyRemoteChange(tuning *control.Tuning, changed []string, lookup func(string) string) []string {
+	var patch control.TuningPatch
+	var tuned, restart []string
+	for _, name := range changed {
+		set, ok := tuningVariables[name]
+		if !ok {
+			restart = append(restart, name)
+			continue
+		}
+		if err := set(&patch, lookup(name)); err != nil {
+			fmt.Printf("Failed to apply %s from remote configuration, invalid value %q\n", name, lookup(name))
+			continue
+		}
+		tuned = append(tuned, name)
+	}
+	if len(tuned) == 0 {
+		return restart
+	}
+	if _, err := tuning.Update(patch, false); err != nil {
+		fmt.Printf("Failed to apply tuning from remote configuration, %s\n", err)
+	} else {
+		fmt.Printf("Applied %v from remote configuration\n", tuned)
+	}
+	return restart
+}
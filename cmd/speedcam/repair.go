@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/evidence"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/publish"
+)
+
+// runRepair retries the evidence upload and publish of every journalled event
+// that didn't make it out, e.g. after an S3 or network outage.
+func runRepair(args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "List events needing repair without retrying them")
+	minAge := fs.Duration("min-age", time.Minute, "Ignore events newer than this, they may still be in flight")
+	fs.Parse(args)
+
+	db, err := journal.Open(config.Env("JOURNAL_PATH", "./speedcam.db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	events, err := db.UndeliveredEvents(*minAge)
+	if err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		fmt.Println("Nothing to repair")
+		return nil
+	}
+
+	if *dryRun {
+		for _, e := range events {
+			fmt.Printf("%s %s upload=%s publish=%s %s\n", e.ID.String(), e.TimeStamp.Format(time.RFC3339), e.UploadStatus, e.PublishStatus, e.LastError)
+		}
+		fmt.Printf("%d events need repair\n", len(events))
+		return nil
+	}
+
+	store, err := evidence.New()
+	if err != nil {
+		return err
+	}
+
+	var publisher *publish.Publisher
+	uploaded, published, failed := 0, 0, 0
+
+	for _, e := range events {
+		var uploadErr error
+		if e.UploadStatus != journal.DeliveryDone && e.UploadStatus != journal.DeliveryShed {
+			uploadErr = store.Upload(e.ImageURI)
+			if uploadErr != nil {
+				fmt.Printf("Failed to upload evidence for %s, %s\n", e.ID.String(), uploadErr.Error())
+				failed++
+			} else {
+				uploaded++
+			}
+			if err := db.SetUploadStatus(e.ID, uploadErr); err != nil {
+				return err
+			}
+		}
+
+		// an event is only published once its evidence is uploaded, or it
+		// has none
+		if e.PublishStatus != journal.DeliveryDone && e.PublishStatus != journal.DeliveryMuted && uploadErr == nil {
+			if publisher == nil {
+				publisher, err = publish.New()
+				if err != nil {
+					return err
+				}
+				defer publisher.Close()
+			}
+
+			err := publisher.Publish(e.CarMessage)
+			if err != nil {
+				fmt.Printf("Failed to publish %s, %s\n", e.ID.String(), err.Error())
+				failed++
+			} else {
+				published++
+			}
+			if err := db.SetPublishStatus(e.ID, err); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Printf("Repaired %d uploads and %d publishes, %d failures\n", uploaded, published, failed)
+	return nil
+}
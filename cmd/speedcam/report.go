@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/evidence"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/report"
+)
+
+// runReport generates a report on demand, e.g. to check the output before
+// enabling the schedule.
+func runReport(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	period := fs.String("period", "daily", "Report period, daily or weekly")
+	fs.Parse(args)
+
+	db, err := journal.Open(config.Env("JOURNAL_PATH", "./speedcam.db"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	store, err := evidence.New()
+	if err != nil {
+		return err
+	}
+
+	reporter, err := report.NewReporter(db, store)
+	if err != nil {
+		return err
+	}
+	ids, err := cameraIDs()
+	if err != nil {
+		return err
+	}
+	if reporter.Cameras, err = cameraRegistry(ids); err != nil {
+		return err
+	}
+
+	_, err = reporter.Generate(*period, time.Now())
+	return err
+}
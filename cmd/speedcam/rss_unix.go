@@ -0,0 +1,21 @@
+//go:build !windows
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+)
+
+// peakRSS is the most memory the process has had resident, in bytes,
+// including OpenCV's allocations which the Go runtime doesn't see.
+func peakRSS() int64 {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	if runtime.GOOS == "darwin" {
+		return int64(usage.Maxrss)
+	}
+	return int64(usage.Maxrss) * 1024
+}
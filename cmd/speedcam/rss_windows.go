@@ -0,0 +1,6 @@
+package main
+
+// peakRSS isn't measured on Windows.
+func peakRSS() int64 {
+	return 0
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/capture"
+	"github.com/danhigham/speedcam/pkg/evidence"
+	"github.com/danhigham/speedcam/pkg/publish"
+	"github.com/danhigham/speedcam/pkg/speed"
+	"gocv.io/x/gocv"
+)
+
+// selfTestTimeout is how long each check of the self-test is given, a
+// camera to deliver its first frame or S3 to answer.
+const selfTestTimeout = 15 * time.Second
+
+// selfTestCheck is one check of the startup self-test. A critical check
+// failing stops detection starting, others only warn.
+type selfTestCheck struct {
+	Name     string
+	Detail   string // what was found, or why it failed
+	Passed   bool
+	Critical bool
+}
+
+// selfTest checks, before detection starts, that every camera delivers a
+// frame of the size its mask and calibration were made for, that evidence
+// can be spooled and that the sinks are reachable, printing a summary. It
+// reports whether every critical check passed.
+func selfTest(cameras []*camera, store *evidence.Store, publisher *publish.Publisher) bool {
+	var checks []selfTestCheck
+	for _, cam := range cameras {
+		checks = append(checks, cam.selfTest()...)
+	}
+
+	spool := selfTestCheck{Name: "evidence spool", Critical: true}
+	if err := checkWritable(store.SpoolDir); err != nil {
+		spool.Detail = err.Error()
+	} else {
+		spool.Passed, spool.Detail = true, "writable "+store.SpoolDir
+	}
+	checks = append(checks, spool)
+
+	// events are spooled while S3 is away, so it isn't critical
+	s3 := selfTestCheck{Name: "evidence upload"}
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	if err := store.Check(ctx); err != nil {
+		s3.Detail = fmt.Sprintf("bucket %s unreachable, evidence stays spooled: %s", store.Bucket, err)
+	} else {
+		s3.Passed, s3.Detail = true, "bucket "+store.Bucket+" reachable"
+	}
+	cancel()
+	checks = append(checks, s3)
+
+	amqp := selfTestCheck{Name: "AMQP", Critical: true}
+	if publisher.Connected() {
+		amqp.Passed, amqp.Detail = true, "connected"
+	} else {
+		amqp.Detail = "connection closed"
+	}
+	checks = append(checks, amqp)
+
+	ok := true
+	fmt.Printf("Self-test:\n")
+	for _, c := range checks {
+		result := "PASS"
+		switch {
+		case !c.Passed && c.Critical:
+			result, ok = "FAIL", false
+		case !c.Passed:
+			result = "WARN"
+		}
+		fmt.Printf("  %s  %s: %s\n", result, c.Name, c.Detail)
+	}
+	return ok
+}
+
+// selfTest reads a frame from the camera and checks its mask and
+// calibration match it. Sources given as Input aren't opened.
+func (c *camera) selfTest() []selfTestCheck {
+	name := "camera"
+	if c.id != "" {
+		name += " " + c.id
+	}
+	read := selfTestCheck{Name: name, Critical: true}
+	if c.cfg.Input != nil {
+		read.Passed, read.Detail = true, "not opened, frames are supplied"
+		return []selfTestCheck{read}
+	}
+
+	size, err := readFrameSize(c.cfg.Source, c.cfg.Decode)
+	if err != nil {
+		read.Detail = fmt.Sprintf("reading a frame from %s: %s", c.name(), err)
+		return []selfTestCheck{read}
+	}
+	read.Passed, read.Detail = true, fmt.Sprintf("read a %dx%d frame", size.X, size.Y)
+	checks := []selfTestCheck{read}
+
+	// boxes are looked up in the mask, a smaller one would be read past
+	mask := selfTestCheck{Name: name + " mask", Critical: true}
+	if m := c.cfg.Mask.Size(); m != size {
+		mask.Detail = fmt.Sprintf("mask is %dx%d, frames are %dx%d", m.X, m.Y, size.X, size.Y)
+	} else {
+		mask.Passed, mask.Detail = true, "matches the frame"
+	}
+	checks = append(checks, mask)
+
+	// every speed scales with the calibrated width
+	cal := c.cfg.Calibration
+	if cal == (speed.Calibration{}) {
+		cal = speed.DefaultCalibration
+	}
+	calibration := selfTestCheck{Name: name + " calibration", Critical: true}
+	if int(cal.ImageWidth) != size.X {
+		calibration.Detail = fmt.Sprintf("calibrated for frames %g pixels wide, frames are %d, set IMAGE_WIDTH", cal.ImageWidth, size.X)
+	} else {
+		calibration.Passed, calibration.Detail = true, "matches the frame"
+	}
+	return append(checks, calibration)
+}
+
+// readFrameSize opens source and reads frames until one isn't empty,
+// returning its size, within selfTestTimeout.
+func readFrameSize(source string, decode string) (image.Point, error) {
+	type result struct {
+		size image.Point
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		src, err := capture.Open(source, decode)
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer src.Close()
+		img := gocv.NewMat()
+		defer img.Close()
+		deadline := time.Now().Add(selfTestTimeout)
+		for time.Now().Before(deadline) {
+			if _, err := src.NextFrame(&img); err != nil {
+				done <- result{err: err}
+				return
+			}
+			if !img.Empty() {
+				done <- result{size: image.Pt(img.Cols(), img.Rows())}
+				return
+			}
+		}
+		done <- result{err: fmt.Errorf("only empty frames for %s", selfTestTimeout)}
+	}()
+
+	select {
+	case r := <-done:
+		return r.size, r.err
+	case <-time.After(selfTestTimeout):
+		return image.Point{}, fmt.Errorf("no frame within %s", selfTestTimeout)
+	}
+}
+
+// checkWritable creates and removes a file in dir.
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".selftest-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
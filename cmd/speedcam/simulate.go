@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/danhigham/speedcam"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/simulate"
+)
+
+// runSimulate drives synthetic vehicles at known speeds through the
+// pipeline, with a camera's calibration and detection settings, and reports
+// how close it timed them, to check a calibration before deployment. Nothing
+// is journalled, stored or published.
+func runSimulate(args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	speeds := fs.String("speeds", "20,30,40", "Comma separated speeds in mph of the vehicles to drive")
+	direction := fs.String("direction", "both", "Which way vehicles drive, left, right or both in turn")
+	background := fs.String("background", "", "Image of the empty road, a plain grey road if omitted")
+	camera := fs.String("camera", "", "Camera whose settings to use, see CAMERAS")
+	fs.Parse(args)
+
+	var vehicles []simulate.Vehicle
+	for i, v := range strings.Split(*speeds, ",") {
+		mph, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || mph <= 0 {
+			return fmt.Errorf("invalid speed %q", v)
+		}
+		dir := *direction
+		if dir == "both" {
+			dir = []string{"right", "left"}[i%2]
+		} else if dir != "left" && dir != "right" {
+			return fmt.Errorf("invalid direction %q", dir)
+		}
+		vehicles = append(vehicles, simulate.Vehicle{Speed: mph, Direction: dir})
+	}
+
+	cfg, err := pipelineConfig(*camera)
+	if err != nil {
+		return err
+	}
+	sim, err := simulate.New(*background, cfg.Calibration, vehicles)
+	if err != nil {
+		return err
+	}
+	sim.Lane = cfg.RoadRegion
+	cfg.Source = "simulator"
+	cfg.Input = sim
+
+	if cfg.Tuning, err = control.NewTuning(); err != nil {
+		return err
+	}
+	if mask, err := detect.NewBackgroundMask(cameraEnv(*camera, "MASK_PATH", "./background_mask.jpg")); err == nil {
+		cfg.Mask = mask
+	} else {
+		fmt.Printf("No background mask, detecting anywhere - %s\n", err)
+	}
+
+	pipeline, err := speedcam.New(cfg)
+	if err != nil {
+		return err
+	}
+	var timed []speedcam.Event
+	done := make(chan struct{})
+	go func() {
+		for e := range pipeline.Events() {
+			timed = append(timed, e)
+		}
+		close(done)
+	}()
+	if err := pipeline.Run(context.Background()); err != nil && err != speedcam.ErrSourceClosed {
+		return err
+	}
+	<-done
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "vehicle\tdirection\tspeed\ttimed\terror")
+	for i, v := range vehicles {
+		if i >= len(timed) {
+			fmt.Fprintf(w, "%d\t%s\t%.1f\t-\t-\n", i+1, v.Direction, v.Speed)
+			continue
+		}
+		e := timed[i]
+		fmt.Fprintf(w, "%d\t%s\t%.1f\t%.1f\t%+.1f%%\n", i+1, v.Direction, v.Speed, e.Speed, 100*(e.Speed-v.Speed)/v.Speed)
+	}
+	if len(timed) != len(vehicles) {
+		fmt.Fprintf(w, "\ntimed %d vehicles of %d, check the mask, MinArea and MinDistance\n", len(timed), len(vehicles))
+	} else {
+		var worst float64
+		for i, v := range vehicles {
+			worst = math.Max(worst, math.Abs(timed[i].Speed-v.Speed))
+		}
+		fmt.Fprintf(w, "\nworst error %.1f mph\n", worst)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/danhigham/speedcam"
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+const (
+	minRestartDelay = time.Second
+	maxRestartDelay = time.Minute
+)
+
+// supervise runs the camera's pipeline until ctx is done, starting a new one
+// whenever it fails to open, fails or panics, so one flaky feed can't take
+// down the other cameras or the API. The delay between restarts doubles up
+// to maxRestartDelay and resets once a pipeline has run for that long. A
+// recording that has ended isn't restarted.
+func (c *camera) supervise(ctx context.Context, cfg speedcam.Config) {
+	delay := minRestartDelay
+	for {
+		started := time.Now()
+		err := c.run(ctx, cfg)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == speedcam.ErrSourceClosed && isFile(cfg.Source) {
+			fmt.Printf("Finished reading %s\n", c.name())
+			return
+		}
+
+		if time.Since(started) > maxRestartDelay {
+			delay = minRestartDelay
+		}
+		fmt.Printf("Pipeline for %s stopped, %v, restarting in %s\n", c.name(), err, delay)
+		metrics.PipelineRestarts.WithLabelValues(c.id).Inc()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// run opens and runs one pipeline, returning a panic as an error. Panics
+// and failures, other than the source closing or freezing, which alert
+// anyway, are reported.
+func (c *camera) run(ctx context.Context, cfg speedcam.Config) (err error) {
+	tags := map[string]string{"camera": c.id}
+	defer func() {
+		if r := recover(); r != nil {
+			errorReporter.CapturePanic(r, tags)
+			err = fmt.Errorf("panicked: %v", r)
+		}
+	}()
+
+	pipeline, err := speedcam.New(cfg)
+	if err != nil {
+		return fmt.Errorf("opening video capture: %s", err)
+	}
+	err = pipeline.Run(ctx)
+	if err != nil && ctx.Err() == nil && err != speedcam.ErrSourceClosed && err != speedcam.ErrFeedFrozen {
+		errorReporter.CaptureError(err, tags)
+	}
+	return err
+}
+
+// isFile reports whether source is a recording, a video file or directory
+// of images, which isn't reopened once it has been read.
+func isFile(source string) bool {
+	info, err := os.Stat(source)
+	return err == nil && (info.Mode().IsRegular() || info.IsDir())
+}
@@ -0,0 +1,110 @@
+package speedcam
+
+import (
+	"image"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/metrics"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultYieldSpeed is the speed, in mph, a vehicle has to slow to before a
+// crosswalk someone is on to count as yielding.
+const DefaultYieldSpeed = 5.0
+
+// Yield is a vehicle reaching the crosswalk while a pedestrian, or another
+// of Config.NearMissClasses, was on it, and whether it yielded: slowed to
+// Config.CrosswalkYieldSpeed first, or waited for them to clear it.
+type Yield struct {
+	ID              uuid.UUID
+	Camera          string
+	EventID         uuid.UUID // the vehicle's Event, whose Image is the evidence, uuid.Nil if it wasn't timed
+	TimeStamp       time.Time // when the vehicle reached the crosswalk
+	VehicleClass    string    // empty when the classifier couldn't tell
+	PedestrianClass string
+	ApproachSpeed   float64 // mph, when the pedestrian was first seen on the crosswalk
+	MinSpeed        float64 // mph, the slowest from then until reaching the crosswalk
+	Yielded         bool
+}
+
+// crosswalkEnabled reports whether vehicles are checked for yielding.
+func (p *Pipeline) crosswalkEnabled() bool {
+	return p.cfg.Classifier != nil && !p.cfg.Crosswalk.Empty()
+}
+
+// onCrosswalk reports whether a path was on the crosswalk at t.
+func (p *Pipeline) onCrosswalk(f finishedPath, t time.Time) bool {
+	x, y, _, _, ok := f.positionAt(t)
+	return ok && image.Pt(int(x), int(y)).In(p.cfg.Crosswalk)
+}
+
+// yield checks whether a vehicle reached the crosswalk while a pedestrian
+// was on it and, if so, whether it yielded. ok is false when they never
+// met there.
+func (p *Pipeline) yield(vehicle finishedPath, pedestrian finishedPath) (Yield, bool) {
+	reached := -1
+	for i, s := range vehicle.samples {
+		if s.point.In(p.cfg.Crosswalk) {
+			reached = i
+			break
+		}
+	}
+	if reached < 0 {
+		return Yield{}, false
+	}
+
+	// from when the pedestrian was first on the crosswalk as the vehicle
+	// approached
+	first := -1
+	for i := 0; i <= reached; i++ {
+		if p.onCrosswalk(pedestrian, vehicle.samples[i].at) {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return Yield{}, false
+	}
+
+	feetPerPixel := p.cfg.Calibration.FeetPerPixel()
+	y := Yield{
+		Camera:          p.cfg.Camera,
+		EventID:         vehicle.event,
+		TimeStamp:       vehicle.samples[reached].at,
+		VehicleClass:    vehicle.class,
+		PedestrianClass: pedestrian.class,
+		ApproachSpeed:   vehicle.speedAt(first, feetPerPixel),
+	}
+	y.MinSpeed = y.ApproachSpeed
+	for i := first + 1; i <= reached; i++ {
+		if mph := vehicle.speedAt(i, feetPerPixel); mph < y.MinSpeed {
+			y.MinSpeed = mph
+		}
+	}
+
+	yieldSpeed := p.cfg.CrosswalkYieldSpeed
+	if yieldSpeed <= 0 {
+		yieldSpeed = DefaultYieldSpeed
+	}
+	y.Yielded = y.MinSpeed <= yieldSpeed || !p.onCrosswalk(pedestrian, y.TimeStamp)
+	return y, true
+}
+
+// reportYield records a vehicle meeting a pedestrian at the crosswalk and
+// hands it to Config.OnYield.
+func (p *Pipeline) reportYield(y Yield) {
+	if p.ids != nil {
+		y.ID = seededID(p.ids)
+	} else {
+		y.ID = uuid.NewV4()
+	}
+	yielded := "true"
+	if !y.Yielded {
+		yielded = "false"
+		p.stats.add(&p.stats.stats.FailedToYield, 1)
+	}
+	metrics.CrosswalkYields.WithLabelValues(y.Camera, yielded).Inc()
+	if p.cfg.OnYield != nil {
+		p.cfg.OnYield(y)
+	}
+}
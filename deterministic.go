@@ -0,0 +1,79 @@
+package speedcam
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/track"
+	uuid "github.com/satori/go.uuid"
+)
+
+// runSerial is Run for Config.Deterministic, passing each frame through
+// every stage before the next is read.
+func (p *Pipeline) runSerial(ctx context.Context) (err error) {
+	defer close(p.events)
+
+	stage := StageCapture
+	defer func() {
+		if r := recover(); r != nil {
+			err = recovered(stage, r)
+		}
+		for _, car := range p.cars {
+			p.release(car)
+		}
+		p.cars = make(track.Register)
+	}()
+
+	var n int
+	var last time.Duration
+	return p.capture(ctx, func(f *frame) bool {
+		stage = StagePreprocess
+		p.preprocessFrame(f, &n)
+		stage = StageDetect
+		p.detectFrame(f)
+		stage = StageTrack
+		gone := p.trackFrame(f)
+		for _, r := range gone {
+			stage = StageMeasure
+			if e, ok := p.measureCar(r, false); ok {
+				stage = StageOutput
+				p.outputEvent(ctx, e)
+			}
+		}
+		stage = StageOutput
+		last = p.outputFrame(f, last)
+		stage = StageCapture
+		return true
+	})
+}
+
+// seededID draws a version 4 UUID from r, in place of the random one the
+// blob tracker gave the car.
+func seededID(r *rand.Rand) uuid.UUID {
+	var id uuid.UUID
+	r.Read(id[:])
+	id.SetVersion(uuid.V4)
+	id.SetVariant(uuid.VariantRFC4122)
+	return id
+}
+
+// sortRemovals orders cars leaving on the same frame by when and where they
+// were first seen, rather than the random order of their tracker IDs.
+func sortRemovals(gone []removal) {
+	sort.Slice(gone, func(i, j int) bool {
+		a, b := gone[i].car.Track, gone[j].car.Track
+		if len(a) == 0 || len(b) == 0 {
+			return len(a) < len(b)
+		}
+		pa, pb := a[0].TrackPoint, b[0].TrackPoint
+		if !pa.Created.Equal(pb.Created) {
+			return pa.Created.Before(pb.Created)
+		}
+		if pa.Point.X != pb.Point.X {
+			return pa.Point.X < pb.Point.X
+		}
+		return pa.Point.Y < pb.Point.Y
+	})
+}
@@ -0,0 +1,154 @@
+package speedcam_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/danhigham/speedcam"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/simulate"
+	"github.com/danhigham/speedcam/pkg/speed"
+)
+
+// goldenClip describes a short recording in testdata/golden and the
+// vehicles known to pass in it, e.g. timed with a radar gun. Each clip.json
+// sits beside its video, named by Video, or drives Simulate past instead.
+type goldenClip struct {
+	Video       string
+	Simulate    []simulate.Vehicle    // rendered by pkg/simulate rather than read from Video
+	Mask        string                // optional, beside the video
+	Calibration speed.Calibration     // zero for speed.DefaultCalibration
+	Tuning      *control.TuningValues // nil for control.DefaultTuning
+	Tracker     string
+	DetectWidth int
+	Tolerance   float64 // mph either side of each expected speed, 0 for 2
+	Events      []goldenEvent
+}
+
+type goldenEvent struct {
+	Speed     float64
+	Direction string // empty for either
+}
+
+// TestGolden runs the pipeline over every clip in testdata/golden, checking
+// it times the vehicles expected, in order, to within the clip's tolerance.
+// Recorded clips are kept in Git LFS, their speeds measured independently of
+// the pipeline, and fail rather than skip when they haven't been pulled.
+func TestGolden(t *testing.T) {
+	manifests, err := filepath.Glob(filepath.Join("testdata", "golden", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifests) == 0 {
+		t.Fatal("no golden clips in testdata/golden")
+	}
+
+	for _, manifest := range manifests {
+		manifest := manifest
+		t.Run(strings.TrimSuffix(filepath.Base(manifest), ".json"), func(t *testing.T) {
+			runGolden(t, manifest)
+		})
+	}
+}
+
+func runGolden(t *testing.T, manifest string) {
+	b, err := os.ReadFile(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clip goldenClip
+	if err := json.Unmarshal(b, &clip); err != nil {
+		t.Fatalf("reading %s: %s", manifest, err)
+	}
+
+	dir := filepath.Dir(manifest)
+	cfg := speedcam.Config{
+		Calibration:   clip.Calibration,
+		Tracker:       clip.Tracker,
+		DetectWidth:   clip.DetectWidth,
+		Deterministic: true,
+	}
+	if len(clip.Simulate) > 0 {
+		cal := clip.Calibration
+		if cal == (speed.Calibration{}) {
+			cal = speed.DefaultCalibration
+		}
+		sim, err := simulate.New("", cal, clip.Simulate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.Source, cfg.Input = "simulator", sim
+	} else {
+		cfg.Source = filepath.Join(dir, clip.Video)
+		if pointer, err := lfsPointer(cfg.Source); err != nil {
+			t.Fatal(err)
+		} else if pointer {
+			t.Fatalf("%s is a Git LFS pointer, run git lfs pull", cfg.Source)
+		}
+	}
+	if clip.Tuning != nil {
+		cfg.Tuning = speedcam.StaticTuning(*clip.Tuning)
+	}
+	if clip.Mask != "" {
+		if cfg.Mask, err = detect.NewBackgroundMask(filepath.Join(dir, clip.Mask)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pipeline, err := speedcam.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []speedcam.Event
+	done := make(chan struct{})
+	go func() {
+		for e := range pipeline.Events() {
+			got = append(got, e)
+		}
+		close(done)
+	}()
+	if err := pipeline.Run(context.Background()); err != nil && err != speedcam.ErrSourceClosed {
+		t.Fatal(err)
+	}
+	<-done
+
+	tolerance := clip.Tolerance
+	if tolerance == 0 {
+		tolerance = 2
+	}
+	if len(got) != len(clip.Events) {
+		for _, e := range got {
+			t.Logf("timed %.1f mph %s", e.Speed, e.Direction)
+		}
+		t.Fatalf("timed %d vehicles, want %d", len(got), len(clip.Events))
+	}
+	for i, want := range clip.Events {
+		if math.Abs(got[i].Speed-want.Speed) > tolerance {
+			t.Errorf("vehicle %d: %.1f mph, want %.1f ± %.1f", i+1, got[i].Speed, want.Speed, tolerance)
+		}
+		if want.Direction != "" && got[i].Direction != want.Direction {
+			t.Errorf("vehicle %d: heading %s, want %s", i+1, got[i].Direction, want.Direction)
+		}
+	}
+}
+
+// lfsPointer reports whether path is the pointer Git LFS leaves in place of
+// a file it hasn't pulled.
+func lfsPointer(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	head := make([]byte, 64)
+	n, _ := io.ReadFull(f, head)
+	return bytes.HasPrefix(head[:n], []byte("version https://git-lfs.github.com/spec/")), nil
+}
@@ -0,0 +1,41 @@
+package speedcam
+
+import (
+	"image"
+
+	"github.com/danhigham/speedcam/pkg/track"
+)
+
+// Lane is the part of the frame vehicles in a lane pass through, in full
+// frame pixels.
+type Lane struct {
+	Name   string
+	Region image.Rectangle
+}
+
+// laneOf returns the lane most of car's track was in, empty if none of it
+// was in any of Config.Lanes.
+func (p *Pipeline) laneOf(car *track.Car) string {
+	if len(p.cfg.Lanes) == 0 {
+		return ""
+	}
+	counts := make([]int, len(p.cfg.Lanes))
+	for _, t := range car.Track {
+		for i, lane := range p.cfg.Lanes {
+			if t.TrackPoint.Point.In(lane.Region) {
+				counts[i]++
+			}
+		}
+	}
+
+	best := -1
+	for i, n := range counts {
+		if n > 0 && (best < 0 || n > counts[best]) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return ""
+	}
+	return p.cfg.Lanes[best].Name
+}
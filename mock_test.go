@@ -0,0 +1,98 @@
+package speedcam_test
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/danhigham/speedcam"
+	"github.com/danhigham/speedcam/pkg/capture"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/speed"
+	"gocv.io/x/gocv"
+)
+
+// mockFrames renders a car driving left to right along the middle of the
+// road at mph, between stretches of empty road for the background model to
+// settle on. An empty Mat stands in for a dropped frame mid way.
+func mockFrames(mph float64, fps float64) []gocv.Mat {
+	cal := speed.DefaultCalibration
+	road := detect.RoadRegion
+	size := image.Pt(120, 50)
+	blank := func() gocv.Mat {
+		return gocv.NewMatWithSizeFromScalar(gocv.NewScalar(90, 90, 90, 0), 480, int(cal.ImageWidth), gocv.MatTypeCV8UC3)
+	}
+
+	var frames []gocv.Mat
+	for i := 0; i < int(2*fps); i++ {
+		frames = append(frames, blank())
+	}
+	step := speed.FeetPerSecond(mph) / fps / cal.FeetPerPixel()
+	top := (road.Min.Y+road.Max.Y)/2 - size.Y/2
+	middle := float64(road.Min.X+road.Max.X-size.X) / 2
+	for x := float64(road.Min.X - size.X); x < float64(road.Max.X); x += step {
+		if x <= middle && x+step > middle {
+			frames = append(frames, gocv.NewMat())
+			continue
+		}
+		frame := blank()
+		left := int(math.Round(x))
+		if body := image.Rect(left, top, left+size.X, top+size.Y).Intersect(road); !body.Empty() {
+			gocv.Rectangle(&frame, body, color.RGBA{40, 40, 160, 0}, -1)
+		}
+		frames = append(frames, frame)
+	}
+	for i := 0; i < int(fps); i++ {
+		frames = append(frames, blank())
+	}
+	return frames
+}
+
+// TestPipelineMock runs the pipeline over frames from a capture.Mock,
+// checking the one car in them is timed at the speed it was drawn at.
+func TestPipelineMock(t *testing.T) {
+	const mph, fps = 30.0, 30.0
+	frames := mockFrames(mph, fps)
+	defer func() {
+		for _, f := range frames {
+			f.Close()
+		}
+	}()
+
+	pipeline, err := speedcam.New(speedcam.Config{
+		Source:        "mock",
+		Input:         &capture.Mock{Frames: frames, Interval: time.Second / fps, Start: time.Date(2024, 6, 11, 8, 0, 0, 0, time.UTC)},
+		Deterministic: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []speedcam.Event
+	done := make(chan struct{})
+	go func() {
+		for e := range pipeline.Events() {
+			got = append(got, e)
+		}
+		close(done)
+	}()
+	if err := pipeline.Run(context.Background()); err != nil && err != speedcam.ErrSourceClosed {
+		t.Fatal(err)
+	}
+	<-done
+
+	if len(got) != 1 {
+		for _, e := range got {
+			t.Logf("timed %.1f mph %s", e.Speed, e.Direction)
+		}
+		t.Fatalf("timed %d vehicles, want 1", len(got))
+	}
+	if math.Abs(got[0].Speed-mph) > 2 {
+		t.Errorf("timed %.1f mph, want %.1f ± 2", got[0].Speed, mph)
+	}
+	if got[0].Direction != "right" {
+		t.Errorf("heading %s, want right", got[0].Direction)
+	}
+}
@@ -0,0 +1,132 @@
+package speedcam
+
+import (
+	"math"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/metrics"
+	"github.com/danhigham/speedcam/pkg/speed"
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultNearMissClasses are the classes of vulnerable road users, as
+// labelled by common detection networks.
+var DefaultNearMissClasses = []string{"person", "pedestrian", "bicycle", "cyclist"}
+
+// NearMiss is a vehicle and a pedestrian, or another vulnerable road user,
+// coming close or on course to collide, a measure of how dangerous a street
+// is short of collisions.
+type NearMiss struct {
+	ID              uuid.UUID
+	Camera          string
+	TimeStamp       time.Time     // when they were closest, or on course to collide soonest
+	VehicleClass    string        // empty when the classifier couldn't tell
+	VulnerableClass string        // one of Config.NearMissClasses
+	VehicleSpeed    float64       // mph, over the vehicle's path
+	Distance        float64       // feet, the closest they came
+	TTC             time.Duration // least time to collision on their courses, 0 when they came within Config.NearMissDistance
+}
+
+// nearMissEnabled reports whether near misses are looked for at all.
+func (p *Pipeline) nearMissEnabled() bool {
+	return p.cfg.Classifier != nil && (p.cfg.NearMissDistance > 0 || p.cfg.NearMissTTC > 0)
+}
+
+func (p *Pipeline) isVulnerable(class string) bool {
+	classes := p.cfg.NearMissClasses
+	if classes == nil {
+		classes = DefaultNearMissClasses
+	}
+	for _, c := range classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// nearMiss finds how close two paths came while both were in view and how
+// soon they were on course to collide, distances in feet by the
+// calibration's scale across the frame.
+func (p *Pipeline) nearMiss(vehicle finishedPath, vulnerable finishedPath) (NearMiss, bool) {
+	feetPerPixel := p.cfg.Calibration.FeetPerPixel()
+	nm := NearMiss{
+		Camera:          p.cfg.Camera,
+		VehicleClass:    vehicle.class,
+		VulnerableClass: vulnerable.class,
+		Distance:        math.Inf(1),
+		TTC:             -1,
+	}
+
+	var closestAt, soonestAt time.Time
+	for _, s := range vehicle.samples {
+		x1, y1, vx1, vy1, ok := vehicle.positionAt(s.at)
+		if !ok {
+			continue
+		}
+		x2, y2, vx2, vy2, ok := vulnerable.positionAt(s.at)
+		if !ok {
+			continue
+		}
+
+		dx, dy := (x2-x1)*feetPerPixel, (y2-y1)*feetPerPixel
+		if distance := math.Hypot(dx, dy); distance < nm.Distance {
+			nm.Distance, closestAt = distance, s.at
+		}
+
+		// closest approach if both kept their course
+		rvx, rvy := (vx2-vx1)*feetPerPixel, (vy2-vy1)*feetPerPixel
+		closing := rvx*rvx + rvy*rvy
+		if p.cfg.NearMissTTC <= 0 || closing == 0 {
+			continue
+		}
+		tc := -(dx*rvx + dy*rvy) / closing
+		if tc <= 0 || math.Hypot(dx+rvx*tc, dy+rvy*tc) > p.nearMissCourse() {
+			continue
+		}
+		if ttc := time.Duration(tc * float64(time.Second)); ttc <= p.cfg.NearMissTTC && (nm.TTC < 0 || ttc < nm.TTC) {
+			nm.TTC, soonestAt = ttc, s.at
+		}
+	}
+	within := p.cfg.NearMissDistance > 0 && nm.Distance <= p.cfg.NearMissDistance
+
+	switch {
+	case within:
+		nm.TTC, nm.TimeStamp = 0, closestAt
+	case nm.TTC >= 0:
+		nm.TimeStamp = soonestAt
+	default:
+		return nm, false
+	}
+
+	ft := 0.0
+	for i := 1; i < len(vehicle.samples); i++ {
+		a, b := vehicle.samples[i-1].point, vehicle.samples[i].point
+		ft += math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y)) * feetPerPixel
+	}
+	nm.VehicleSpeed = speed.MPH(ft, vehicle.end().Sub(vehicle.start()))
+	return nm, true
+}
+
+// nearMissCourse is how close courses have to pass to count as colliding,
+// NearMissDistance or, without one, a car's width.
+func (p *Pipeline) nearMissCourse() float64 {
+	if p.cfg.NearMissDistance > 0 {
+		return p.cfg.NearMissDistance
+	}
+	return 6
+}
+
+// reportNearMiss records a near miss and hands it to Config.OnNearMiss.
+func (p *Pipeline) reportNearMiss(nm NearMiss) {
+	if p.ids != nil {
+		nm.ID = seededID(p.ids)
+	} else {
+		nm.ID = uuid.NewV4()
+	}
+	metrics.NearMisses.WithLabelValues(nm.Camera).Inc()
+	p.stats.add(&p.stats.stats.NearMisses, 1)
+	if p.cfg.OnNearMiss != nil {
+		p.cfg.OnNearMiss(nm)
+	}
+}
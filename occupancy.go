@@ -0,0 +1,74 @@
+package speedcam
+
+import (
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+// OccupancyInterval is how often Config.OnOccupancy is called.
+const OccupancyInterval = time.Minute
+
+// maxOccupancyGap is the longest gap between frames counted as observed,
+// longer ones are outages rather than a slow source.
+const maxOccupancyGap = 5 * time.Second
+
+// Occupancy is how busy a camera's view was over an interval, by the
+// source's clock.
+type Occupancy struct {
+	Camera      string
+	Start       time.Time
+	Observed    time.Duration // vehicles were being tracked for
+	Occupied    time.Duration // with at least one vehicle in view
+	VehicleTime time.Duration // summed over every vehicle in view
+	MaxVehicles int           // in view at once
+}
+
+// occupancyMeter accumulates Occupancy frame by frame.
+type occupancyMeter struct {
+	current Occupancy
+	last    time.Time // of the last frame observed, zero while paused
+}
+
+// measureOccupancy adds the time since the last frame to the current
+// interval, as occupied by the vehicles in view now, and hands the interval
+// on once it's over. It runs in the track stage.
+func (p *Pipeline) measureOccupancy(f *frame) {
+	m := &p.occupancy
+	if f.paused {
+		m.last = time.Time{}
+		return
+	}
+
+	if m.current.Start.IsZero() {
+		m.current = Occupancy{Camera: p.cfg.Camera, Start: f.at.Truncate(OccupancyInterval)}
+	}
+	n := len(p.cars)
+	if gap := f.at.Sub(m.last); !m.last.IsZero() && gap > 0 && gap <= maxOccupancyGap {
+		m.current.Observed += gap
+		if n > 0 {
+			m.current.Occupied += gap
+		}
+		m.current.VehicleTime += gap * time.Duration(n)
+	}
+	if n > m.current.MaxVehicles {
+		m.current.MaxVehicles = n
+	}
+	m.last = f.at
+
+	if f.at.Sub(m.current.Start) >= OccupancyInterval {
+		p.occupied(m.current)
+		m.current = Occupancy{Camera: p.cfg.Camera, Start: f.at.Truncate(OccupancyInterval)}
+	}
+}
+
+// occupied records an interval's occupancy and hands it to
+// Config.OnOccupancy.
+func (p *Pipeline) occupied(o Occupancy) {
+	if o.Observed > 0 {
+		metrics.Occupancy.WithLabelValues(o.Camera).Set(o.Occupied.Seconds() / o.Observed.Seconds())
+	}
+	if p.cfg.OnOccupancy != nil {
+		p.cfg.OnOccupancy(o)
+	}
+}
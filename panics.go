@@ -0,0 +1,59 @@
+package speedcam
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+const (
+	// maxStagePanics is how many frames or cars can panic within
+	// stagePanicWindow before the pipeline gives up and stops, leaving it to
+	// be restarted afresh, rather than dropping every one from then on.
+	maxStagePanics   = 5
+	stagePanicWindow = time.Minute
+)
+
+// heal runs handle on one frame or car in stage, recovering a panic: it's
+// logged, counted and passed to Config.OnPanic, cleanup puts back what the
+// item holds and the stage carries on with the next. It reports whether
+// handle returned. Panicking too often panics again, stopping the pipeline.
+func (p *Pipeline) heal(stage string, handle func(), cleanup func()) (ok bool) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		metrics.StagePanics.WithLabelValues(p.cfg.Camera, stage).Inc()
+		if p.cfg.OnPanic != nil {
+			p.cfg.OnPanic(stage, r)
+		}
+		if cleanup != nil {
+			cleanup()
+		}
+		if !p.allowPanic() {
+			panic(fmt.Sprintf("%v, after %d panics within %s", r, maxStagePanics, stagePanicWindow))
+		}
+		fmt.Printf("Recovered from panic in %s stage of %s, %v, carrying on without it\n%s", stage, p.cfg.Source, r, debug.Stack())
+	}()
+	handle()
+	return true
+}
+
+// allowPanic records a panic, reporting whether it's within
+// maxStagePanics of stagePanicWindow.
+func (p *Pipeline) allowPanic() bool {
+	p.panicMu.Lock()
+	defer p.panicMu.Unlock()
+	now := time.Now()
+	recent := p.panics[:0]
+	for _, at := range p.panics {
+		if now.Sub(at) < stagePanicWindow {
+			recent = append(recent, at)
+		}
+	}
+	p.panics = append(recent, now)
+	return len(p.panics) <= maxStagePanics
+}
@@ -0,0 +1,124 @@
+package speedcam
+
+import (
+	"image"
+	"math"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/speed"
+	"github.com/danhigham/speedcam/pkg/track"
+	uuid "github.com/satori/go.uuid"
+)
+
+// pathWindow is how long a finished path is kept to compare with paths
+// finishing after it, longer than anyone takes to cross the frame.
+const pathWindow = 30 * time.Second
+
+// pathSample is where a finished path was at a moment.
+type pathSample struct {
+	at    time.Time
+	point image.Point
+}
+
+// finishedPath is a tracked object's path kept after it left, with its class
+// and, if it was timed, its Event's ID.
+type finishedPath struct {
+	class      string
+	vulnerable bool
+	event      uuid.UUID
+	samples    []pathSample
+}
+
+func (f finishedPath) start() time.Time { return f.samples[0].at }
+func (f finishedPath) end() time.Time   { return f.samples[len(f.samples)-1].at }
+
+// positionAt interpolates where the path was at t and its velocity then, in
+// pixels per second, ok false outside it.
+func (f finishedPath) positionAt(t time.Time) (x, y, vx, vy float64, ok bool) {
+	if len(f.samples) < 2 || t.Before(f.start()) || t.After(f.end()) {
+		return 0, 0, 0, 0, false
+	}
+	for i := 1; i < len(f.samples); i++ {
+		a, b := f.samples[i-1], f.samples[i]
+		if t.After(b.at) {
+			continue
+		}
+		span := b.at.Sub(a.at).Seconds()
+		if span <= 0 {
+			return float64(b.point.X), float64(b.point.Y), 0, 0, true
+		}
+		vx = float64(b.point.X-a.point.X) / span
+		vy = float64(b.point.Y-a.point.Y) / span
+		elapsed := t.Sub(a.at).Seconds()
+		return float64(a.point.X) + vx*elapsed, float64(a.point.Y) + vy*elapsed, vx, vy, true
+	}
+	return 0, 0, 0, 0, false
+}
+
+// speedAt is the path's speed in mph around sample i, over at least half a
+// second so a jittery box doesn't read as a burst of speed.
+func (f finishedPath) speedAt(i int, feetPerPixel float64) float64 {
+	from, to := i, i
+	for f.samples[to].at.Sub(f.samples[from].at) < 500*time.Millisecond {
+		if from == 0 && to == len(f.samples)-1 {
+			break
+		}
+		if from > 0 {
+			from--
+		}
+		if to < len(f.samples)-1 {
+			to++
+		}
+	}
+	if !f.samples[to].at.After(f.samples[from].at) {
+		return 0
+	}
+	ft := 0.0
+	for j := from + 1; j <= to; j++ {
+		a, b := f.samples[j-1].point, f.samples[j].point
+		ft += math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y)) * feetPerPixel
+	}
+	return speed.MPH(ft, f.samples[to].at.Sub(f.samples[from].at))
+}
+
+// finishPath compares a car's finished path with the paths of the other
+// kind, vehicle or vulnerable road user, that finished recently, for near
+// misses and failures to yield at the crosswalk, then keeps it to compare
+// with those finishing later. event is the car's Event ID, uuid.Nil if it
+// wasn't timed. It runs in the measure stage.
+func (p *Pipeline) finishPath(car *track.Car, class string, event uuid.UUID) {
+	if !p.nearMissEnabled() && !p.crosswalkEnabled() || len(car.Track) < 2 {
+		return
+	}
+
+	path := finishedPath{class: class, vulnerable: p.isVulnerable(class), event: event}
+	for _, t := range car.Track {
+		path.samples = append(path.samples, pathSample{at: t.TrackPoint.Created, point: t.TrackPoint.Point})
+	}
+
+	kept := p.paths[:0]
+	for _, other := range p.paths {
+		if path.end().Sub(other.end()) > pathWindow {
+			continue
+		}
+		kept = append(kept, other)
+		if other.vulnerable == path.vulnerable {
+			continue
+		}
+		vehicle, vulnerable := path, other
+		if path.vulnerable {
+			vehicle, vulnerable = other, path
+		}
+		if p.nearMissEnabled() {
+			if nm, ok := p.nearMiss(vehicle, vulnerable); ok {
+				p.reportNearMiss(nm)
+			}
+		}
+		if p.crosswalkEnabled() {
+			if y, ok := p.yield(vehicle, vulnerable); ok {
+				p.reportYield(y)
+			}
+		}
+	}
+	p.paths = append(kept, path)
+}
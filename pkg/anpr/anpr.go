@@ -0,0 +1,218 @@
+// Package anpr reads number plates off the evidence of violations and keeps
+// a history of them to catch repeat offenders. Plates are personal data, so
+// nothing is read unless PLATE_TRACKING is set, only violations' plates are
+// kept, and only for PLATE_RETENTION.
+package anpr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/event"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+// queueSize is how many violations can wait for their plate to be read,
+// more are dropped rather than hold up recording.
+const queueSize = 32
+
+// Reader reads plates by running Command on a JPEG, given as its last
+// argument, which prints results as OpenALPR's alpr -j does.
+type Reader struct {
+	Command       []string
+	MinConfidence float64 // percent, plates read less surely are ignored
+}
+
+type alprOutput struct {
+	Results []struct {
+		Plate      string  `json:"plate"`
+		Confidence float64 `json:"confidence"`
+	} `json:"results"`
+}
+
+// Read returns the most confident plate in image and its confidence, or an
+// empty plate if none was read surely enough.
+func (r Reader) Read(image []byte) (string, float64, error) {
+	f, err := os.CreateTemp("", "speedcam-plate-*.jpg")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(image); err != nil {
+		f.Close()
+		return "", 0, err
+	}
+	if err := f.Close(); err != nil {
+		return "", 0, err
+	}
+
+	out, err := exec.Command(r.Command[0], append(r.Command[1:], f.Name())...).Output()
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %s", r.Command[0], err)
+	}
+	var results alprOutput
+	if err := json.Unmarshal(out, &results); err != nil {
+		return "", 0, fmt.Errorf("%s: invalid output, %s", r.Command[0], err)
+	}
+
+	plate, confidence := "", 0.0
+	for _, res := range results.Results {
+		if res.Confidence >= r.MinConfidence && res.Confidence > confidence {
+			plate, confidence = normalise(res.Plate), res.Confidence
+		}
+	}
+	return plate, confidence, nil
+}
+
+// normalise drops the spaces and dashes plates are written with either way.
+func normalise(plate string) string {
+	return strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(plate))
+}
+
+type violation struct {
+	msg   event.CarMessage
+	image []byte
+}
+
+// Tracker reads the plate of each violation added, journals it, and calls
+// OnRepeat when a plate reaches Threshold violations within Window.
+type Tracker struct {
+	Journal   *journal.Journal
+	Reader    Reader
+	Retention time.Duration // plates are deleted this long after they were read
+	Window    time.Duration // violations are counted over, up to each one
+	Threshold int           // 0 never calls OnRepeat
+	OnRepeat  func(plate string, camera string, violations int)
+
+	queue chan violation
+}
+
+// Enabled reports whether plate tracking is opted into with PLATE_TRACKING.
+func Enabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("PLATE_TRACKING"))
+	return enabled
+}
+
+// New configures plate tracking from the environment, returning nil unless
+// PLATE_TRACKING is set. ANPR_COMMAND is the plate reader, default "alpr -j",
+// ANPR_CONFIDENCE the least confidence in percent, default 80, and
+// PLATE_ALERT_VIOLATIONS violations within PLATE_ALERT_WINDOW, default 5
+// within a week, alert.
+func New(j *journal.Journal) (*Tracker, error) {
+	if !Enabled() {
+		return nil, nil
+	}
+
+	t := &Tracker{Journal: j, queue: make(chan violation, queueSize)}
+	t.Reader.Command = strings.Fields(config.Env("ANPR_COMMAND", "alpr -j"))
+	if len(t.Reader.Command) == 0 {
+		return nil, errors.New("ANPR_COMMAND: no command")
+	}
+	var err error
+	if t.Reader.MinConfidence, err = strconv.ParseFloat(config.Env("ANPR_CONFIDENCE", "80"), 64); err != nil || t.Reader.MinConfidence < 0 || t.Reader.MinConfidence > 100 {
+		return nil, fmt.Errorf("ANPR_CONFIDENCE: invalid confidence %q", os.Getenv("ANPR_CONFIDENCE"))
+	}
+	if t.Retention, err = time.ParseDuration(config.Env("PLATE_RETENTION", "720h")); err != nil || t.Retention <= 0 {
+		return nil, fmt.Errorf("PLATE_RETENTION: invalid duration %q", os.Getenv("PLATE_RETENTION"))
+	}
+	if t.Window, err = time.ParseDuration(config.Env("PLATE_ALERT_WINDOW", "168h")); err != nil || t.Window <= 0 {
+		return nil, fmt.Errorf("PLATE_ALERT_WINDOW: invalid duration %q", os.Getenv("PLATE_ALERT_WINDOW"))
+	}
+	if t.Window > t.Retention {
+		return nil, errors.New("PLATE_ALERT_WINDOW: longer than PLATE_RETENTION, plates would be gone before they were counted")
+	}
+	if t.Threshold, err = strconv.Atoi(config.Env("PLATE_ALERT_VIOLATIONS", "5")); err != nil || t.Threshold < 0 {
+		return nil, fmt.Errorf("PLATE_ALERT_VIOLATIONS: invalid count %q", os.Getenv("PLATE_ALERT_VIOLATIONS"))
+	}
+	return t, nil
+}
+
+// Add queues a timed vehicle's plate to be read if it was a violation. It
+// doesn't block, dropping the vehicle if the reader is behind.
+func (t *Tracker) Add(msg event.CarMessage, image []byte) {
+	if msg.SpeedLimit <= 0 || msg.Speed <= msg.SpeedLimit || image == nil {
+		return
+	}
+	select {
+	case t.queue <- violation{msg: msg, image: image}:
+	default:
+		fmt.Printf("Plate reader behind, not reading the plate of %s\n", msg.ID.String())
+		metrics.LoadShed.WithLabelValues("plate").Inc()
+	}
+}
+
+// Run reads the plates queued, deleting those older than Retention every
+// hour.
+func (t *Tracker) Run() {
+	purge := time.NewTicker(time.Hour)
+	defer purge.Stop()
+	t.purge()
+
+	for {
+		select {
+		case v := <-t.queue:
+			if err := t.track(v); err != nil {
+				fmt.Printf("Failed to track plate of %s, %s\n", v.msg.ID.String(), err)
+			}
+		case <-purge.C:
+			t.purge()
+		}
+	}
+}
+
+func (t *Tracker) track(v violation) error {
+	plate, confidence, err := t.Reader.Read(v.image)
+	if err != nil {
+		return err
+	}
+	if plate == "" {
+		metrics.PlateReads.WithLabelValues(v.msg.Camera, "unread").Inc()
+		return nil
+	}
+	metrics.PlateReads.WithLabelValues(v.msg.Camera, "read").Inc()
+
+	err = t.Journal.RecordPlateViolation(journal.PlateViolation{
+		EventID:    v.msg.ID,
+		Plate:      plate,
+		Camera:     v.msg.Camera,
+		TimeStamp:  v.msg.TimeStamp,
+		Speed:      v.msg.Speed,
+		SpeedLimit: v.msg.SpeedLimit,
+		Confidence: confidence,
+	})
+	if err != nil {
+		return err
+	}
+	if t.Threshold == 0 || t.OnRepeat == nil {
+		return nil
+	}
+
+	// only on reaching it, so a plate alerts once until its violations age out
+	n, err := t.Journal.PlateViolationCount(plate, v.msg.TimeStamp.Add(-t.Window), v.msg.TimeStamp.Add(time.Millisecond))
+	if err != nil {
+		return err
+	}
+	if n == t.Threshold {
+		t.OnRepeat(plate, v.msg.Camera, n)
+	}
+	return nil
+}
+
+func (t *Tracker) purge() {
+	n, err := t.Journal.PurgePlates(time.Now().Add(-t.Retention))
+	if err != nil {
+		fmt.Printf("Failed to delete old plates, %s\n", err)
+		return
+	}
+	if n > 0 {
+		fmt.Printf("Deleted %d plates older than %s\n", n, t.Retention)
+	}
+}
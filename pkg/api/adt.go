@@ -0,0 +1,58 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+// AverageDailyTraffic is each camera's ADT and AADT over a range.
+type AverageDailyTraffic struct {
+	From    time.Time
+	To      time.Time
+	Cameras []journal.ADT
+}
+
+// averageDailyTraffic handles GET /api/stats/adt, average daily traffic per
+// camera, the headline figure of a traffic calming application, from every
+// vehicle counted whether timed or not. It takes from, to and camera, the
+// range defaulting to the last year and taken in whole days, min_coverage,
+// the least percent of a day watched for it to count, default 95, and
+// exclude_tags, leaving out days overlapping ranges with those tags.
+func (a *API) averageDailyTraffic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 365*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	coverage := journal.DefaultADTCoverage
+	if v := r.URL.Query().Get("min_coverage"); v != "" {
+		percent, err := strconv.ParseFloat(v, 64)
+		if err != nil || percent < 0 || percent > 100 {
+			httpjson.Error(w, http.StatusBadRequest, "invalid min_coverage: "+v)
+			return
+		}
+		coverage = percent / 100
+	}
+	factors := journal.DefaultSeasonalFactors
+	if a.SeasonalFactors != nil {
+		factors = *a.SeasonalFactors
+	}
+
+	adts, err := a.journal.ADT(filter, coverage, factors)
+	if err != nil {
+		fmt.Printf("Failed to compute average daily traffic, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to compute average daily traffic")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, AverageDailyTraffic{From: filter.From, To: filter.To, Cameras: adts})
+}
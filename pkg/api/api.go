@@ -0,0 +1,1032 @@
+// Package api serves the REST API and the browser UI.
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/auth"
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/evidence"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/server"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+	liveStatsInterval = 5 * time.Second
+)
+
+// API serves the event journal over HTTP, every endpoint needs read scope
+// except the opt-in public leaderboard.
+type API struct {
+	journal  *journal.Journal
+	evidence *evidence.Store
+	controls *control.Controls
+	auth     *auth.Auth
+
+	// P85Windows are the windows of /api/stats/p85 when none are asked
+	// for, journal.DefaultP85Windows unless set.
+	P85Windows []time.Duration
+
+	// Cameras are what's known of each camera, with an ID of "" for a single
+	// camera, served on /api/cameras and labelling the Grafana dashboard's
+	// row for each.
+	Cameras []config.CameraInfo
+
+	// FHWAClassMap assigns the classifier's labels to FHWA classes in
+	// traffic studies, journal.DefaultFHWAClassMap unless set.
+	FHWAClassMap map[string]int
+
+	// Rolling serves /api/stats/rolling, which is unavailable while nil.
+	Rolling *journal.Rolling
+
+	// SeasonalFactors adjust daily counts to AADT in /api/stats/adt,
+	// journal.DefaultSeasonalFactors when nil.
+	SeasonalFactors *journal.SeasonalFactors
+}
+
+type EventResponse struct {
+	journal.Event
+	Violation bool
+	ImageURL  string
+	Tags      []string `json:",omitempty"`
+}
+
+type EventPage struct {
+	Events     []EventResponse
+	NextCursor string // empty on the last page
+}
+
+// LiveStats are the journal's live figures plus the pause and mute state, for
+// the dashboard.
+type LiveStats struct {
+	GeneratedAt      time.Time
+	State            control.State
+	VehiclesLastHour int
+	P85LastHour      float64
+	Histogram        []journal.HistogramBin // last hour
+	FastestToday     *EventResponse
+}
+
+func New(j *journal.Journal, store *evidence.Store, controls *control.Controls, a *auth.Auth) *API {
+	return &API{
+		journal:    j,
+		evidence:   store,
+		controls:   controls,
+		auth:       a,
+		P85Windows: journal.DefaultP85Windows,
+		Cameras:    []config.CameraInfo{{}},
+
+		FHWAClassMap: journal.DefaultFHWAClassMap,
+	}
+}
+
+func eventResponse(e journal.Event) EventResponse {
+	return EventResponse{Event: e, Violation: e.IsViolation(), ImageURL: eventImagePath(e.ID)}
+}
+
+func (a *API) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/api/events", a.auth.RequireFunc(auth.ScopeRead, a.listEvents))
+	mux.HandleFunc("/api/events/", a.auth.RequireFunc(auth.ScopeRead, a.eventImage))
+	mux.HandleFunc("/api/tags", a.tags)
+	mux.HandleFunc("/api/tags/", a.auth.RequireFunc(auth.ScopeAdmin, a.deleteTag))
+	mux.HandleFunc("/api/stats/live", a.auth.RequireFunc(auth.ScopeRead, a.liveStats))
+	mux.HandleFunc("/api/stats/live/events", a.auth.RequireFunc(auth.ScopeRead, a.liveStatsEvents))
+	mux.HandleFunc("/api/stats/aggregate", a.auth.RequireFunc(auth.ScopeRead, a.aggregateStats))
+	mux.HandleFunc("/api/stats/histogram", a.auth.RequireFunc(auth.ScopeRead, a.speedHistogram))
+	mux.HandleFunc("/api/stats/heatmap", a.auth.RequireFunc(auth.ScopeRead, a.heatmap))
+	mux.HandleFunc("/api/export/influx", a.auth.RequireFunc(auth.ScopeRead, a.influxExport))
+	mux.HandleFunc("/api/export/study", a.auth.RequireFunc(auth.ScopeRead, a.trafficStudy))
+	mux.HandleFunc("/api/export/events", a.auth.RequireFunc(auth.ScopeRead, a.exportEvents))
+	mux.HandleFunc("/api/cameras", a.auth.RequireFunc(auth.ScopeRead, a.listCameras))
+	mux.HandleFunc("/api/grafana/dashboard", a.auth.RequireFunc(auth.ScopeRead, a.grafanaDashboard))
+	mux.HandleFunc("/api/vehicles", a.auth.RequireFunc(auth.ScopeRead, a.repeatVehicles))
+	mux.HandleFunc("/api/vehicles/", a.auth.RequireFunc(auth.ScopeRead, a.vehicle))
+	mux.HandleFunc("/api/plates", a.auth.RequireFunc(auth.ScopeAdmin, a.repeatPlates))
+	mux.HandleFunc("/api/near-misses", a.auth.RequireFunc(auth.ScopeRead, a.nearMisses))
+	mux.HandleFunc("/api/crosswalk/yields", a.auth.RequireFunc(auth.ScopeRead, a.crosswalkYields))
+	mux.HandleFunc("/api/stats/classes", a.auth.RequireFunc(auth.ScopeRead, a.classBreakdown))
+	mux.HandleFunc("/api/stats/spatial", a.auth.RequireFunc(auth.ScopeRead, a.spatialHeatmap))
+	mux.HandleFunc("/api/stats/lanes", a.auth.RequireFunc(auth.ScopeRead, a.laneBreakdown))
+	mux.HandleFunc("/api/stats/trend", a.auth.RequireFunc(auth.ScopeRead, a.trend))
+	mux.HandleFunc("/api/stats/compare", a.auth.RequireFunc(auth.ScopeRead, a.compare))
+	mux.HandleFunc("/api/stats/occupancy", a.auth.RequireFunc(auth.ScopeRead, a.occupancy))
+	mux.HandleFunc("/api/stats/headway", a.auth.RequireFunc(auth.ScopeRead, a.headways))
+	mux.HandleFunc("/api/stats/p85", a.auth.RequireFunc(auth.ScopeRead, a.p85Speeds))
+	mux.HandleFunc("/api/stats/compliance", a.auth.RequireFunc(auth.ScopeRead, a.speedCompliance))
+	mux.HandleFunc("/api/stats/rolling", a.auth.RequireFunc(auth.ScopeRead, a.rollingStats))
+	mux.HandleFunc("/api/stats/adt", a.auth.RequireFunc(auth.ScopeRead, a.averageDailyTraffic))
+	mux.HandleFunc("/api/stats/volume", a.auth.RequireFunc(auth.ScopeRead, a.volume))
+	mux.HandleFunc("/api/stats/counts", a.auth.RequireFunc(auth.ScopeRead, a.vehicleCounts))
+	mux.HandleFunc("/api/leaderboard", a.auth.RequireFunc(auth.ScopeRead, a.leaderboard))
+	if publicLeaderboardEnabled() {
+		mux.HandleFunc("/public/leaderboard", a.publicLeaderboard)
+	}
+	RegisterControls(mux, a.controls, a.auth)
+}
+
+// listEvents handles GET /api/events. Supported query parameters are from and
+// to (RFC3339), min_speed, camera, direction, class, lane, violation
+// (true/false), sort (time, -time, speed, -speed), limit and cursor, the
+// NextCursor of the previous page.
+func (a *API) listEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// fetch one extra to know whether there's another page
+	limit := filter.Limit
+	filter.Limit++
+
+	events, err := a.journal.QueryEvents(filter)
+	if err != nil {
+		fmt.Printf("Failed to query events, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query events")
+		return
+	}
+
+	page := EventPage{Events: make([]EventResponse, 0, len(events))}
+	if len(events) > limit {
+		events = events[:limit]
+		page.NextCursor = encodeCursor(events[limit-1].CursorFor(filter.Sort))
+	}
+	tags, err := a.journal.EventTags(events)
+	if err != nil {
+		fmt.Printf("Failed to query event tags, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query events")
+		return
+	}
+	for _, e := range events {
+		resp := eventResponse(e)
+		resp.Tags = tags[e.ID]
+		page.Events = append(page.Events, resp)
+	}
+	httpjson.Write(w, http.StatusOK, page)
+}
+
+func parseEventFilter(r *http.Request) (journal.Filter, error) {
+	q := r.URL.Query()
+	filter := journal.Filter{
+		Camera:    q.Get("camera"),
+		Direction: q.Get("direction"),
+		Class:     q.Get("class"),
+		Lane:      q.Get("lane"),
+		Tag:       q.Get("tag"),
+		Sort:      q.Get("sort"),
+		Limit:     defaultEventLimit,
+	}
+
+	if filter.Sort == "" {
+		filter.Sort = "-time"
+	}
+	if _, ok := journal.Sorts[strings.TrimPrefix(filter.Sort, "-")]; !ok {
+		return filter, fmt.Errorf("invalid sort: %s", filter.Sort)
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid from: %s", err)
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid to: %s", err)
+		}
+	}
+	// echoed back and bucketed in the configured zone, whatever offset they
+	// were given with
+	filter.From, filter.To = filter.From.In(time.Local), filter.To.In(time.Local)
+	if v := q.Get("min_speed"); v != "" {
+		if filter.MinSpeed, err = strconv.ParseFloat(v, 64); err != nil {
+			return filter, fmt.Errorf("invalid min_speed: %s", err)
+		}
+	}
+	if v := q.Get("exclude_tags"); v != "" {
+		filter.ExcludeTags = strings.Split(v, ",")
+	}
+	if v := q.Get("violation"); v != "" {
+		violation, err := strconv.ParseBool(v)
+		if err != nil {
+			return filter, fmt.Errorf("invalid violation: %s", v)
+		}
+		filter.Violation = &violation
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		if filter.Limit > maxEventLimit {
+			filter.Limit = maxEventLimit
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := decodeCursor(v)
+		if err != nil {
+			return filter, errors.New("invalid cursor")
+		}
+		filter.After = &cursor
+	}
+	return filter, nil
+}
+
+// parseStatsRange reads from and to like parseEventFilter, from defaulting to
+// window before to.
+func parseStatsRange(r *http.Request, window time.Duration) (journal.Filter, error) {
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		return filter, err
+	}
+	if filter.To.IsZero() {
+		filter.To = time.Now()
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.Add(-window)
+	}
+	if !filter.From.Before(filter.To) {
+		return filter, errors.New("from must be before to")
+	}
+	return filter, nil
+}
+
+// aggregateStats handles GET /api/stats/aggregate. It takes the filters of
+// listEvents, the range defaulting to the last day, and group_by, a comma
+// separated list of hour, day, camera, direction, class and lane, or a
+// bucket size of 5m, 10m, 15m or 30m.
+func (a *API) aggregateStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var groupBy []string
+	if v := r.URL.Query().Get("group_by"); v != "" {
+		groupBy = strings.Split(v, ",")
+		for _, g := range groupBy {
+			if journal.AggregateGrouping(g) == nil {
+				httpjson.Error(w, http.StatusBadRequest, "invalid group_by: "+g)
+				return
+			}
+		}
+	}
+
+	report, err := a.journal.Aggregate(filter, groupBy)
+	if err != nil {
+		fmt.Printf("Failed to aggregate events, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to aggregate events")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, report)
+}
+
+// speedHistogram handles GET /api/stats/histogram. It takes the filters of
+// listEvents, the range defaulting to the last day, and bin_width in mph,
+// default 5.
+func (a *API) speedHistogram(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	binWidth := journal.LiveHistogramBinWidth
+	if v := r.URL.Query().Get("bin_width"); v != "" {
+		if binWidth, err = strconv.ParseFloat(v, 64); err != nil || binWidth <= 0 {
+			httpjson.Error(w, http.StatusBadRequest, "invalid bin_width: "+v)
+			return
+		}
+	}
+
+	h, err := a.journal.SpeedHistogram(filter, binWidth)
+	if err == journal.ErrTooManyBins {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to build speed histogram, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to build speed histogram")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, h)
+}
+
+// heatmap handles GET /api/stats/heatmap, volume and violation rate by day of
+// week and hour. It takes the filters of listEvents, the range defaulting to
+// the last four weeks.
+func (a *API) heatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 28*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h, err := a.journal.Heatmap(filter)
+	if err != nil {
+		fmt.Printf("Failed to build heatmap, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to build heatmap")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, h)
+}
+
+// headways handles GET /api/stats/headway, the gaps between successive
+// vehicles. It takes from, to, camera, direction and lane, the range
+// defaulting to the last day, bin_width in seconds, default 1, and close,
+// the gap under which a vehicle is tailgating, default 2s.
+func (a *API) headways(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	binWidth := 1.0
+	if v := r.URL.Query().Get("bin_width"); v != "" {
+		if binWidth, err = strconv.ParseFloat(v, 64); err != nil || binWidth <= 0 {
+			httpjson.Error(w, http.StatusBadRequest, "invalid bin_width: "+v)
+			return
+		}
+	}
+	closeGap := 2 * time.Second
+	if v := r.URL.Query().Get("close"); v != "" {
+		if closeGap, err = time.ParseDuration(v); err != nil || closeGap <= 0 {
+			httpjson.Error(w, http.StatusBadRequest, "invalid close: "+v)
+			return
+		}
+	}
+
+	h, err := a.journal.Headways(filter, binWidth, closeGap)
+	if err == journal.ErrTooManyBins {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to measure headways, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to measure headways")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, h)
+}
+
+// P85Speeds are 85th percentile speeds over windows ending now.
+type P85Speeds struct {
+	GeneratedAt time.Time
+	Windows     []journal.P85
+}
+
+// p85Speeds handles GET /api/stats/p85. It takes window, a comma separated
+// list of durations such as 15m,1h,24h, and camera.
+func (a *API) p85Speeds(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	windows := a.P85Windows
+	if v := r.URL.Query().Get("window"); v != "" {
+		var err error
+		if windows, err = journal.ParseWindows(v); err != nil {
+			httpjson.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	now := time.Now()
+	p85s, err := a.journal.P85Speeds(now, r.URL.Query().Get("camera"), windows)
+	if err != nil {
+		fmt.Printf("Failed to compute 85th percentile speeds, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to compute 85th percentile speeds")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, P85Speeds{GeneratedAt: now, Windows: p85s})
+}
+
+// ComplianceReport is how far vehicles kept to the speed limit over a range,
+// overall and per bucket.
+type ComplianceReport struct {
+	From    time.Time
+	To      time.Time
+	Bucket  string
+	Total   journal.Compliance
+	Buckets []ComplianceBucket // only those with traffic under a limit
+}
+
+type ComplianceBucket struct {
+	Start time.Time
+	journal.Compliance
+}
+
+// speedCompliance handles GET /api/stats/compliance. It takes the filters of
+// listEvents, the range defaulting to the last day, and bucket, one of 5m,
+// 10m, 15m, 30m, 1h or day, default 1h.
+func (a *API) speedCompliance(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bucket := "1h"
+	if v := r.URL.Query().Get("bucket"); v != "" {
+		bucket = v
+	}
+	if bucket != "day" {
+		if _, err := journal.ParseBucket(bucket); err != nil {
+			httpjson.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	agg, err := a.journal.Aggregate(filter, []string{bucket})
+	if err != nil {
+		fmt.Printf("Failed to aggregate events, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to aggregate events")
+		return
+	}
+
+	report := ComplianceReport{From: filter.From, To: filter.To, Bucket: bucket, Total: agg.Total.Compliance, Buckets: []ComplianceBucket{}}
+	for _, g := range agg.Groups {
+		if g.Compliance.Vehicles == 0 {
+			continue
+		}
+		layout := time.RFC3339
+		if bucket == "day" {
+			layout = "2006-01-02"
+		}
+		start, err := time.ParseInLocation(layout, g.Group[bucket], time.Local)
+		if err != nil {
+			continue
+		}
+		report.Buckets = append(report.Buckets, ComplianceBucket{Start: start, Compliance: g.Compliance})
+	}
+	httpjson.Write(w, http.StatusOK, report)
+}
+
+// RollingStats are traffic statistics over windows up to when they were
+// last updated.
+type RollingStats struct {
+	Windows []journal.RollingWindow
+}
+
+// rollingStats handles GET /api/stats/rolling.
+func (a *API) rollingStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	var windows []journal.RollingWindow
+	if a.Rolling != nil {
+		windows = a.Rolling.Latest()
+	}
+	if windows == nil {
+		httpjson.Error(w, http.StatusServiceUnavailable, "rolling statistics not computed yet")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, RollingStats{Windows: windows})
+}
+
+// Volume is traffic per hour or day over a range.
+type Volume struct {
+	Period  string
+	From    time.Time
+	To      time.Time
+	Buckets []journal.Rollup // per camera, only those with traffic
+}
+
+// volume handles GET /api/stats/volume. It takes period, hour or day, from,
+// to and camera, the range defaulting to the last week of hours or 90 days.
+func (a *API) volume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	window := 7 * 24 * time.Hour
+	switch period {
+	case "", journal.PeriodHour:
+		period = journal.PeriodHour
+	case journal.PeriodDay:
+		window = 90 * 24 * time.Hour
+	default:
+		httpjson.Error(w, http.StatusBadRequest, "invalid period: "+period)
+		return
+	}
+
+	filter, err := parseStatsRange(r, window)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	rollups, err := a.journal.Rollups(period, filter)
+	if err != nil {
+		fmt.Printf("Failed to read traffic volume, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to read traffic volume")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, Volume{Period: period, From: filter.From, To: filter.To, Buckets: rollups})
+}
+
+// NearMisses are the near misses between vehicles and pedestrians or
+// cyclists over a range.
+type NearMisses struct {
+	From       time.Time
+	To         time.Time
+	NearMisses []journal.NearMiss // newest first
+}
+
+// nearMisses handles GET /api/near-misses. It takes from, to and camera, the
+// range defaulting to the last week, and limit, at most 1000.
+func (a *API) nearMisses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 7*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Limit, err = parseLimit(r); err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	nearMisses, err := a.journal.NearMisses(filter)
+	if err != nil {
+		fmt.Printf("Failed to query near misses, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query near misses")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, NearMisses{From: filter.From, To: filter.To, NearMisses: nearMisses})
+}
+
+// parseLimit reads limit from r, at most 1000 and defaulting to 100.
+func parseLimit(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultEventLimit, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit: %s", v)
+	}
+	if limit > maxEventLimit {
+		limit = maxEventLimit
+	}
+	return limit, nil
+}
+
+// CrosswalkYields is how often vehicles reaching the crosswalk while someone
+// was on it yielded over a range, with the evidence of those that didn't.
+type CrosswalkYields struct {
+	From           time.Time
+	To             time.Time
+	Vehicles       int // reaching the crosswalk while someone was on it
+	Yielded        int
+	ComplianceRate float64         // percent of Vehicles that yielded
+	Failures       []journal.Yield // newest first
+}
+
+// crosswalkYields handles GET /api/crosswalk/yields. It takes from, to and
+// camera, the range defaulting to the last week, and limit on the failures
+// listed, at most 1000.
+func (a *API) crosswalkYields(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 7*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Limit, err = parseLimit(r); err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	yields := CrosswalkYields{From: filter.From, To: filter.To}
+	if yields.Vehicles, yields.Yielded, err = a.journal.YieldCounts(filter); err == nil {
+		yields.Failures, err = a.journal.FailuresToYield(filter)
+	}
+	if err != nil {
+		fmt.Printf("Failed to query crosswalk yields, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query crosswalk yields")
+		return
+	}
+	if yields.Vehicles > 0 {
+		yields.ComplianceRate = 100 * float64(yields.Yielded) / float64(yields.Vehicles)
+	}
+	httpjson.Write(w, http.StatusOK, yields)
+}
+
+// ClassBreakdown is each vehicle class's share of traffic and violations
+// over a range.
+type ClassBreakdown struct {
+	From    time.Time
+	To      time.Time
+	Classes []journal.ClassShare // largest first
+}
+
+// classBreakdown handles GET /api/stats/classes. It takes the filters of
+// listEvents bar class, the range defaulting to the last day.
+func (a *API) classBreakdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	classes, err := a.journal.ClassBreakdown(filter)
+	if err != nil {
+		fmt.Printf("Failed to break down events by class, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to break down events by class")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, ClassBreakdown{From: filter.From, To: filter.To, Classes: classes})
+}
+
+// LaneBreakdown is each lane's traffic and speeds over a range.
+type LaneBreakdown struct {
+	From  time.Time
+	To    time.Time
+	Lanes []journal.LaneStats
+}
+
+// laneBreakdown handles GET /api/stats/lanes. It takes the filters of
+// listEvents bar lane, the range defaulting to the last day.
+func (a *API) laneBreakdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	lanes, err := a.journal.LaneBreakdown(filter)
+	if err != nil {
+		fmt.Printf("Failed to break down events by lane, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to break down events by lane")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, LaneBreakdown{From: filter.From, To: filter.To, Lanes: lanes})
+}
+
+// Trend is week over week or month over month speeds.
+type Trend struct {
+	Period  string
+	Changes []journal.Comparison // each period against the one before, oldest first
+}
+
+// trend handles GET /api/stats/trend. It takes period, week or month, count,
+// how many of the last complete periods to compare with the one before,
+// default 8, and camera, direction and lane.
+func (a *API) trend(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	switch period {
+	case "":
+		period = journal.PeriodWeek
+	case journal.PeriodWeek, journal.PeriodMonth:
+	default:
+		httpjson.Error(w, http.StatusBadRequest, "invalid period: "+period)
+		return
+	}
+	count := 8
+	if v := r.URL.Query().Get("count"); v != "" {
+		var err error
+		if count, err = strconv.Atoi(v); err != nil || count < 1 || count > 104 {
+			httpjson.Error(w, http.StatusBadRequest, "invalid count: "+v)
+			return
+		}
+	}
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	changes, err := a.journal.Trend(period, count, time.Now(), filter)
+	if err != nil {
+		fmt.Printf("Failed to compute speed trend, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to compute speed trend")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, Trend{Period: period, Changes: changes})
+}
+
+// compare handles GET /api/stats/compare, the change in speeds between two
+// ranges, e.g. either side of traffic calming going in. It takes
+// before_from, before_to, after_from and after_to, all required, and the
+// filters of listEvents bar the range.
+func (a *API) compare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var times [4]time.Time
+	for i, name := range []string{"before_from", "before_to", "after_from", "after_to"} {
+		if times[i], err = time.Parse(time.RFC3339, r.URL.Query().Get(name)); err != nil {
+			httpjson.Error(w, http.StatusBadRequest, fmt.Sprintf("invalid %s: %s", name, err))
+			return
+		}
+		// in the configured zone, as parseEventFilter's
+		times[i] = times[i].In(time.Local)
+	}
+	if !times[0].Before(times[1]) || !times[2].Before(times[3]) {
+		httpjson.Error(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+
+	before, after := filter, filter
+	before.From, before.To = times[0], times[1]
+	after.From, after.To = times[2], times[3]
+	c, err := a.journal.Compare(before, after)
+	if err != nil {
+		fmt.Printf("Failed to compare speeds, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to compare speeds")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, c)
+}
+
+// Occupancy is how long vehicles were in view per hour or day over a range.
+type Occupancy struct {
+	Period  string
+	From    time.Time
+	To      time.Time
+	Buckets []journal.OccupancyBucket // per camera, only those observed
+}
+
+// occupancy handles GET /api/stats/occupancy. It takes period, hour or day,
+// from, to and camera, the range defaulting to the last day of hours or 30
+// days.
+func (a *API) occupancy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	window := 24 * time.Hour
+	switch period {
+	case "", journal.PeriodHour:
+		period = journal.PeriodHour
+	case journal.PeriodDay:
+		window = 30 * 24 * time.Hour
+	default:
+		httpjson.Error(w, http.StatusBadRequest, "invalid period: "+period)
+		return
+	}
+
+	filter, err := parseStatsRange(r, window)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	buckets, err := a.journal.Occupancy(period, filter)
+	if err != nil {
+		fmt.Printf("Failed to read occupancy, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to read occupancy")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, Occupancy{Period: period, From: filter.From, To: filter.To, Buckets: buckets})
+}
+
+// VehicleCounts are the vehicles counted over a range, timed or not.
+type VehicleCounts struct {
+	From   time.Time
+	To     time.Time
+	Total  int
+	Counts []journal.VehicleCount // per camera, direction and class
+}
+
+// vehicleCounts handles GET /api/stats/counts. It takes from and to, the
+// range defaulting to the last day and counted by the hour, camera,
+// direction and class.
+func (a *API) vehicleCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	counts, err := a.journal.VehicleCounts(filter)
+	if err != nil {
+		fmt.Printf("Failed to count vehicles, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to count vehicles")
+		return
+	}
+	resp := VehicleCounts{From: filter.From, To: filter.To, Counts: counts}
+	for _, c := range counts {
+		resp.Total += c.Count
+	}
+	httpjson.Write(w, http.StatusOK, resp)
+}
+
+// cursors are opaque to clients, they're only valid for the sort that
+// produced them
+func encodeCursor(c journal.Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (journal.Cursor, error) {
+	var c journal.Cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(b, &c)
+	return c, err
+}
+
+func eventImagePath(id uuid.UUID) string {
+	return fmt.Sprintf("%s/api/events/%s/image", server.BasePath, id.String())
+}
+
+// eventImage handles GET /api/events/{id}/image, every access is audited.
+func (a *API) eventImage(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/events/"), "/")
+	if len(parts) != 2 || parts[1] != "image" {
+		httpjson.Error(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, err := uuid.FromString(parts[0])
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, "invalid event id")
+		return
+	}
+
+	event, err := a.journal.GetEvent(id)
+	if err == sql.ErrNoRows {
+		httpjson.Error(w, http.StatusNotFound, "event not found")
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to load event %s, %s\n", id.String(), err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to load event")
+		return
+	}
+
+	img, err := a.evidence.Open(event.ImageURI)
+	if err != nil {
+		fmt.Printf("Failed to open evidence for %s, %s\n", id.String(), err)
+		httpjson.Error(w, http.StatusNotFound, "evidence not available")
+		return
+	}
+	defer img.Close()
+
+	if err := a.journal.Audit(journal.AuditAccess, apiActor(r), event.ImageURI, r.URL.Path); err != nil {
+		// never hand out evidence that can't be accounted for
+		fmt.Printf("Failed to audit access to %s, %s\n", event.ImageURI, err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to audit access")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	io.Copy(w, img)
+}
+
+func (a *API) currentStats() (LiveStats, error) {
+	stats, err := a.journal.LiveStats(time.Now())
+	live := LiveStats{
+		GeneratedAt:      stats.GeneratedAt,
+		State:            a.controls.State(),
+		VehiclesLastHour: stats.VehiclesLastHour,
+		P85LastHour:      stats.P85LastHour,
+		Histogram:        stats.Histogram,
+	}
+	if stats.FastestToday != nil {
+		fastest := eventResponse(*stats.FastestToday)
+		live.FastestToday = &fastest
+	}
+	return live, err
+}
+
+func (a *API) liveStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := a.currentStats()
+	if err != nil {
+		fmt.Printf("Failed to compute live stats, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to compute stats")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, stats)
+}
+
+// liveStatsEvents streams LiveStats as server-sent events every few seconds
+// until the client goes away.
+func (a *API) liveStatsEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpjson.Error(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(liveStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := a.currentStats()
+		if err != nil {
+			fmt.Printf("Failed to compute live stats, %s\n", err)
+			return
+		}
+		b, _ := json.Marshal(stats)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func apiActor(r *http.Request) string {
+	return fmt.Sprintf("%s@%s", auth.Identity(r), r.RemoteAddr)
+}
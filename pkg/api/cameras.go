@@ -0,0 +1,23 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+)
+
+// Cameras lists what's known of the cameras events are recorded by,
+// matched to events by their Camera.
+type Cameras struct {
+	Cameras []config.CameraInfo
+}
+
+// listCameras handles GET /api/cameras.
+func (a *API) listCameras(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, Cameras{Cameras: a.Cameras})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/auth"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+)
+
+// RegisterControls adds the admin endpoints POST /api/admin/pause, resume,
+// mute and unmute, pause and mute taking an optional for=<duration>, and
+// GET /api/state for anyone with read access.
+func RegisterControls(mux *http.ServeMux, controls *control.Controls, a *auth.Auth) {
+	action := func(apply func(d time.Duration), msg string) http.HandlerFunc {
+		return a.RequireFunc(auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+
+			var d time.Duration
+			if v := r.URL.Query().Get("for"); v != "" {
+				var err error
+				if d, err = time.ParseDuration(v); err != nil || d < 0 {
+					httpjson.Error(w, http.StatusBadRequest, "invalid for: "+v)
+					return
+				}
+			}
+
+			apply(d)
+			fmt.Printf("%s by %s\n", msg, auth.Identity(r))
+			httpjson.Write(w, http.StatusOK, controls.State())
+		})
+	}
+
+	mux.HandleFunc("/api/admin/pause", action(controls.Pause, "Detection paused"))
+	mux.HandleFunc("/api/admin/resume", action(func(time.Duration) { controls.Resume() }, "Detection resumed"))
+	mux.HandleFunc("/api/admin/mute", action(controls.Mute, "Alerts muted"))
+	mux.HandleFunc("/api/admin/unmute", action(func(time.Duration) { controls.Unmute() }, "Alerts unmuted"))
+
+	mux.HandleFunc("/api/state", a.RequireFunc(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		httpjson.Write(w, http.StatusOK, controls.State())
+	}))
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+	uuid "github.com/satori/go.uuid"
+)
+
+// exportPage is how many events are read from the journal at a time while
+// exporting.
+const exportPage = 1000
+
+// ExportedEvent is an event as exported, a line of NDJSON.
+type ExportedEvent struct {
+	EventResponse
+	Track []journal.TrackPoint `json:",omitempty"`
+}
+
+// exportHeader is the header row of an events CSV.
+func exportHeader(track bool) []string {
+	header := []string{"ID", "Time", "Camera", "Direction", "Lane", "Class", "Speed", "Distance", "Speed Limit", "Violation", "Time Suspect", "Image URL"}
+	if track {
+		header = append(header, "Track")
+	}
+	return header
+}
+
+// exportRow is an event as a row of an events CSV, its track as x,y,seconds
+// points separated by spaces.
+func exportRow(e journal.Event, track []journal.TrackPoint, withTrack bool) []string {
+	row := []string{
+		e.ID.String(), e.TimeStamp.In(time.Local).Format("2006-01-02T15:04:05.000Z07:00"), e.Camera, e.Direction, e.Lane, e.Class,
+		strconv.FormatFloat(e.Speed, 'f', 2, 64), strconv.FormatFloat(e.Distance, 'f', 2, 64), strconv.FormatFloat(e.SpeedLimit, 'f', -1, 64),
+		strconv.FormatBool(e.IsViolation()), strconv.FormatBool(e.TimeSuspect), eventImagePath(e.ID),
+	}
+	if withTrack {
+		points := make([]string, len(track))
+		for i, p := range track {
+			points[i] = fmt.Sprintf("%d,%d,%.3f", p.X, p.Y, p.Elapsed)
+		}
+		row = append(row, strings.Join(points, " "))
+	}
+	return row
+}
+
+// exportEvents handles GET /api/export/events, every event matching the
+// filters of listEvents, oldest first, streamed a page at a time so the
+// whole dataset can be pulled without the database. The range defaults to
+// all of it. format is csv, the default, or ndjson, and track=true adds each
+// vehicle's path across the frame where it was recorded. Exports are
+// audited.
+func (a *API) exportEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseEventFilter(r)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		httpjson.Error(w, http.StatusBadRequest, "from must be before to")
+		return
+	}
+	filter.Sort, filter.Limit, filter.After = "time", exportPage, nil
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "ndjson" {
+		httpjson.Error(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+	withTrack := false
+	if v := r.URL.Query().Get("track"); v != "" {
+		if withTrack, err = strconv.ParseBool(v); err != nil {
+			httpjson.Error(w, http.StatusBadRequest, "invalid track: "+v)
+			return
+		}
+	}
+
+	if err := a.journal.Audit(journal.AuditExport, apiActor(r), "events", r.URL.RequestURI()); err != nil {
+		fmt.Printf("Failed to audit export of events, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to audit export")
+		return
+	}
+
+	// the first page is read before anything is written, so a failing
+	// journal can still be reported as an error
+	events, err := a.journal.QueryEvents(filter)
+	if err != nil {
+		fmt.Printf("Failed to query events for export, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query events")
+		return
+	}
+
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	} else {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="events.%s"`, format))
+	flusher, _ := w.(http.Flusher)
+	cw := csv.NewWriter(w)
+	enc := json.NewEncoder(w)
+	if format == "csv" {
+		cw.Write(exportHeader(withTrack))
+	}
+
+	for {
+		var tracks map[uuid.UUID][]journal.TrackPoint
+		if withTrack {
+			if tracks, err = a.journal.EventTracks(events); err != nil {
+				// headers are sent, all that's left is to cut the export short
+				fmt.Printf("Failed to query tracks for export, %s\n", err)
+				return
+			}
+		}
+		for _, e := range events {
+			if format == "csv" {
+				cw.Write(exportRow(e, tracks[e.ID], withTrack))
+				continue
+			}
+			if err := enc.Encode(ExportedEvent{EventResponse: eventResponse(e), Track: tracks[e.ID]}); err != nil {
+				return
+			}
+		}
+		cw.Flush()
+		if cw.Error() != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if len(events) < exportPage {
+			return
+		}
+
+		cursor := events[len(events)-1].CursorFor(filter.Sort)
+		filter.After = &cursor
+		if events, err = a.journal.QueryEvents(filter); err != nil {
+			fmt.Printf("Failed to query events for export, %s\n", err)
+			return
+		}
+	}
+}
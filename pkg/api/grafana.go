@@ -0,0 +1,197 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+// grafanaDashboard is the part of Grafana's dashboard model generated, as
+// read from a provisioning directory or pasted into Import.
+type grafanaDashboard struct {
+	UID           string                 `json:"uid"`
+	Title         string                 `json:"title"`
+	Tags          []string               `json:"tags"`
+	Timezone      string                 `json:"timezone"`
+	SchemaVersion int                    `json:"schemaVersion"`
+	Refresh       string                 `json:"refresh"`
+	Time          map[string]string      `json:"time"`
+	Templating    map[string]interface{} `json:"templating"`
+	Panels        []grafanaPanel         `json:"panels"`
+}
+
+type grafanaPanel struct {
+	ID          int                    `json:"id"`
+	Type        string                 `json:"type"`
+	Title       string                 `json:"title"`
+	GridPos     grafanaGridPos         `json:"gridPos"`
+	Datasource  map[string]string      `json:"datasource,omitempty"`
+	Targets     []grafanaTarget        `json:"targets,omitempty"`
+	FieldConfig map[string]interface{} `json:"fieldConfig,omitempty"`
+}
+
+type grafanaGridPos struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+}
+
+// dashboardBuilder lays panels out two to a row.
+type dashboardBuilder struct {
+	panels []grafanaPanel
+	x, y   int
+}
+
+func (b *dashboardBuilder) row(title string) {
+	if b.x > 0 {
+		b.x, b.y = 0, b.y+8
+	}
+	b.panels = append(b.panels, grafanaPanel{ID: len(b.panels) + 1, Type: "row", Title: title, GridPos: grafanaGridPos{Y: b.y, W: 24, H: 1}})
+	b.y++
+}
+
+func (b *dashboardBuilder) timeseries(title string, unit string, expr string, legend string) {
+	b.panels = append(b.panels, grafanaPanel{
+		ID:          len(b.panels) + 1,
+		Type:        "timeseries",
+		Title:       title,
+		GridPos:     grafanaGridPos{X: b.x, Y: b.y, W: 12, H: 8},
+		Datasource:  map[string]string{"type": "prometheus", "uid": "${datasource}"},
+		Targets:     []grafanaTarget{{RefID: "A", Expr: expr, LegendFormat: legend}},
+		FieldConfig: map[string]interface{}{"defaults": map[string]interface{}{"unit": unit}},
+	})
+	if b.x == 0 {
+		b.x = 12
+	} else {
+		b.x, b.y = 0, b.y+8
+	}
+}
+
+// promLabel quotes a label value for a PromQL selector.
+func promLabel(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// dashboardFor builds a dashboard over the Prometheus metrics with a row
+// per camera, titled by its road, and per lane of the cameras lanes have
+// been seen on.
+func dashboardFor(cameras []config.CameraInfo, lanes map[string][]string) grafanaDashboard {
+	b := &dashboardBuilder{}
+	for _, info := range cameras {
+		camera := info.ID
+		sel := "camera=" + promLabel(camera)
+
+		b.row(info.Title())
+		b.timeseries("Vehicles per hour", "short",
+			fmt.Sprintf(`sum by (direction) (increase(speedcam_vehicles_counted_total{%s}[1h]))`, sel), "{{direction}}")
+		b.timeseries("85th percentile speed", "velocitymph",
+			fmt.Sprintf(`histogram_quantile(0.85, sum by (le, direction) (rate(speedcam_vehicle_speed_mph_bucket{%s}[1h])))`, sel), "{{direction}}")
+		b.timeseries("Violations per hour", "short",
+			fmt.Sprintf(`sum by (direction) (increase(speedcam_violations_total{%s}[1h]))`, sel), "{{direction}}")
+		b.timeseries("Occupancy", "percent",
+			fmt.Sprintf(`100 * speedcam_occupancy_ratio{%s}`, sel), "occupancy")
+
+		for _, lane := range lanes[camera] {
+			laneSel := sel + ",lane=" + promLabel(lane)
+			b.timeseries(fmt.Sprintf("Lane %s: 85th percentile speed", lane), "velocitymph",
+				fmt.Sprintf(`histogram_quantile(0.85, sum by (le) (rate(speedcam_vehicle_speed_mph_bucket{%s}[1h])))`, laneSel), "p85")
+			b.timeseries(fmt.Sprintf("Lane %s: vehicles timed per hour", lane), "short",
+				fmt.Sprintf(`sum(increase(speedcam_vehicle_speed_mph_count{%s}[1h]))`, laneSel), "vehicles")
+		}
+	}
+
+	return grafanaDashboard{
+		UID:           "speedcam",
+		Title:         "Speedcam",
+		Tags:          []string{"speedcam"},
+		Timezone:      "browser",
+		SchemaVersion: 36,
+		Refresh:       "1m",
+		Time:          map[string]string{"from": "now-24h", "to": "now"},
+		Templating: map[string]interface{}{"list": []interface{}{
+			map[string]interface{}{"name": "datasource", "type": "datasource", "query": "prometheus", "label": "Prometheus"},
+		}},
+		Panels: b.panels,
+	}
+}
+
+// grafanaDashboard handles GET /api/grafana/dashboard, a dashboard for this
+// installation's cameras and lanes to provision or import into Grafana.
+func (a *API) grafanaDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	lanes, err := a.journal.Lanes()
+	if err != nil {
+		fmt.Printf("Failed to read lanes, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to read lanes")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, dashboardFor(a.Cameras, lanes))
+}
+
+// influxEscape escapes a tag value for InfluxDB line protocol.
+var influxEscape = strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`)
+
+// influxExport handles GET /api/export/influx, bucketed aggregates as
+// InfluxDB line protocol, e.g. for Telegraf's http input. It takes from
+// and to, the range defaulting to the last day, and bucket, one of 5m, 10m,
+// 15m, 30m or 1h, default 5m.
+func (a *API) influxExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	bucket := "5m"
+	if v := r.URL.Query().Get("bucket"); v != "" {
+		if _, err := journal.ParseBucket(v); err != nil {
+			httpjson.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		bucket = v
+	}
+
+	report, err := a.journal.Aggregate(filter, []string{bucket, "camera", "direction", "lane"})
+	if err != nil {
+		fmt.Printf("Failed to aggregate events, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to aggregate events")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, g := range report.Groups {
+		at, err := time.Parse(time.RFC3339, g.Group[bucket])
+		if err != nil {
+			continue
+		}
+		line := "speedcam_traffic"
+		for _, tag := range []string{"camera", "direction", "lane"} {
+			// line protocol has no empty tag values
+			if v := g.Group[tag]; v != "" {
+				line += "," + tag + "=" + influxEscape.Replace(v)
+			}
+		}
+		fmt.Fprintf(w, "%s vehicles=%di,violations=%di,mean_speed=%g,median_speed=%g,p85_speed=%g %d\n",
+			line, g.Vehicles, g.Violations, g.MeanSpeed, g.MedianSpeed, g.P85Speed, at.UnixNano())
+	}
+}
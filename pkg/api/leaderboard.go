@@ -0,0 +1,149 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+const (
+	defaultLeaderboardSize = 10
+	maxLeaderboardSize     = 100
+)
+
+// PublicLeaderboardEntry is what the anonymized leaderboard shows, no image,
+// id or time of day that could tie a speed to a particular car.
+type PublicLeaderboardEntry struct {
+	Rank      int
+	Speed     float64
+	Direction string
+	Date      string
+}
+
+type Leaderboard struct {
+	From    time.Time
+	To      time.Time
+	Entries []EventResponse
+}
+
+type PublicLeaderboard struct {
+	From    time.Time
+	To      time.Time
+	Entries []PublicLeaderboardEntry
+}
+
+// leaderboardPeriod returns the range for a named period ending at now.
+func leaderboardPeriod(period string, now time.Time) (time.Time, error) {
+	switch period {
+	case "day":
+		return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()), nil
+	case "", "week":
+		return now.AddDate(0, 0, -7), nil
+	case "month":
+		return now.AddDate(0, -1, 0), nil
+	case "year":
+		return now.AddDate(-1, 0, 0), nil
+	case "all":
+		return time.Unix(0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid period: %s", period)
+}
+
+// parseLeaderboard reads period (day, week, month, year or all, default week)
+// or an explicit from and to, plus limit, the number of entries.
+func parseLeaderboard(r *http.Request) (journal.Filter, error) {
+	q := r.URL.Query()
+	filter := journal.Filter{To: time.Now(), Sort: "-speed", Limit: defaultLeaderboardSize}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if filter.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, fmt.Errorf("invalid from: %s", err)
+		}
+		if v := q.Get("to"); v != "" {
+			if filter.To, err = time.Parse(time.RFC3339, v); err != nil {
+				return filter, fmt.Errorf("invalid to: %s", err)
+			}
+		}
+	} else if filter.From, err = leaderboardPeriod(q.Get("period"), filter.To); err != nil {
+		return filter, err
+	}
+	// in the configured zone, as parseEventFilter's
+	filter.From, filter.To = filter.From.In(time.Local), filter.To.In(time.Local)
+
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 {
+			return filter, fmt.Errorf("invalid limit: %s", v)
+		}
+		if filter.Limit > maxLeaderboardSize {
+			filter.Limit = maxLeaderboardSize
+		}
+	}
+	return filter, nil
+}
+
+// leaderboard handles GET /api/leaderboard, the fastest events of a period.
+func (a *API) leaderboard(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseLeaderboard(r)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, err := a.journal.QueryEvents(filter)
+	if err != nil {
+		fmt.Printf("Failed to query leaderboard, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query leaderboard")
+		return
+	}
+
+	board := Leaderboard{From: filter.From, To: filter.To, Entries: make([]EventResponse, 0, len(events))}
+	for _, e := range events {
+		board.Entries = append(board.Entries, eventResponse(e))
+	}
+	httpjson.Write(w, http.StatusOK, board)
+}
+
+// publicLeaderboard handles GET /public/leaderboard, which needs no
+// credentials and is only served when PUBLIC_LEADERBOARD is set. It takes a
+// period only, an arbitrary from and to would narrow down when each speed
+// was recorded.
+func (a *API) publicLeaderboard(w http.ResponseWriter, r *http.Request) {
+	if q := r.URL.Query(); q.Has("from") || q.Has("to") {
+		httpjson.Error(w, http.StatusBadRequest, "from and to aren't supported, use period")
+		return
+	}
+	filter, err := parseLeaderboard(r)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, err := a.journal.QueryEvents(filter)
+	if err != nil {
+		fmt.Printf("Failed to query leaderboard, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query leaderboard")
+		return
+	}
+
+	board := PublicLeaderboard{From: filter.From, To: filter.To, Entries: make([]PublicLeaderboardEntry, 0, len(events))}
+	for i, e := range events {
+		board.Entries = append(board.Entries, PublicLeaderboardEntry{
+			Rank:      i + 1,
+			Speed:     e.Speed,
+			Direction: e.Direction,
+			Date:      e.TimeStamp.Format("2006-01-02"),
+		})
+	}
+	httpjson.Write(w, http.StatusOK, board)
+}
+
+func publicLeaderboardEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("PUBLIC_LEADERBOARD"))
+	return enabled
+}
@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublicLeaderboardRejectsRange(t *testing.T) {
+	a := &API{}
+	for _, query := range []string{
+		"from=2024-06-11T08:14:00Z",
+		"from=2024-06-11T08:14:00Z&to=2024-06-11T08:15:00Z",
+		"period=week&to=2024-06-11T08:15:00Z",
+	} {
+		w := httptest.NewRecorder()
+		a.publicLeaderboard(w, httptest.NewRequest(http.MethodGet, "/public/leaderboard?"+query, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestLeaderboardRangeInLocalZone(t *testing.T) {
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.FixedZone("BST", 60*60)
+
+	r := httptest.NewRequest(http.MethodGet, "/leaderboard?from=2024-06-11T08:14:00Z&to=2024-06-11T23:30:00Z", nil)
+	filter, err := parseLeaderboard(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.From.Location() != time.Local || filter.To.Location() != time.Local {
+		t.Errorf("range in %s to %s, want %s", filter.From.Location(), filter.To.Location(), time.Local)
+	}
+	if filter.To.Format("2006-01-02") != "2024-06-12" {
+		t.Errorf("to is on %s locally, want 2024-06-12", filter.To.Format("2006-01-02"))
+	}
+}
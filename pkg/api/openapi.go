@@ -0,0 +1,483 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/health"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/server"
+	"github.com/danhigham/speedcam/pkg/version"
+	uuid "github.com/satori/go.uuid"
+)
+
+// The OpenAPI document is built from the same Go types the handlers encode,
+// so response schemas can't drift from what's actually served. Paths and
+// parameters are listed by hand below and need updating with the routes.
+
+type oaParam struct {
+	Name        string                 `json:"name"`
+	In          string                 `json:"in"`
+	Description string                 `json:"description,omitempty"`
+	Required    bool                   `json:"required,omitempty"`
+	Schema      map[string]interface{} `json:"schema"`
+}
+
+type oaOperation struct {
+	Summary     string                 `json:"summary"`
+	Tags        []string               `json:"tags,omitempty"`
+	Parameters  []oaParam              `json:"parameters,omitempty"`
+	RequestBody map[string]interface{} `json:"requestBody,omitempty"`
+	Responses   map[string]interface{} `json:"responses"`
+	Security    []map[string][]string  `json:"security,omitempty"`
+}
+
+// openAPISchemas collects named component schemas while building the spec.
+type openAPISchemas map[string]interface{}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	uuidType = reflect.TypeOf(uuid.UUID{})
+)
+
+// schemaFor returns the schema of t, adding named structs to the components
+// and referring to them.
+func (s openAPISchemas) schemaFor(t reflect.Type) map[string]interface{} {
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case uuidType:
+		return map[string]interface{}{"type": "string", "format": "uuid"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := s.schemaFor(t.Elem())
+		return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := s[name]; !ok {
+			s[name] = nil // placeholder for recursive types
+			s[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{}
+}
+
+func (s openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	var allOf []interface{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		name, opts := f.Name, ""
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			parts := strings.SplitN(tag, ",", 2)
+			if parts[0] == "-" {
+				continue
+			}
+			if parts[0] != "" {
+				name = parts[0]
+			}
+			if len(parts) > 1 {
+				opts = parts[1]
+			}
+		}
+
+		// embedded structs are flattened by encoding/json
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			allOf = append(allOf, s.schemaFor(f.Type))
+			continue
+		}
+
+		schema := s.schemaFor(f.Type)
+		if strings.Contains(opts, "omitempty") {
+			schema = map[string]interface{}{"allOf": []interface{}{schema}, "description": "omitted when empty"}
+		}
+		props[name] = schema
+	}
+
+	schema := map[string]interface{}{"type": "object", "properties": props}
+	if len(allOf) > 0 {
+		return map[string]interface{}{"allOf": append(allOf, schema)}
+	}
+	return schema
+}
+
+func jsonResponse(s openAPISchemas, description string, v interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": s.schemaFor(reflect.TypeOf(v))},
+		},
+	}
+}
+
+func contentResponse(description string, contentType string, format string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			contentType: map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": format}},
+		},
+	}
+}
+
+func queryParam(name string, typ string, description string) oaParam {
+	schema := map[string]interface{}{"type": typ}
+	if typ == "date-time" {
+		schema = map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	return oaParam{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func requiredParam(p oaParam) oaParam {
+	p.Required = true
+	return p
+}
+
+var eventFilterParams = []oaParam{
+	queryParam("from", "date-time", "Start of the range, RFC3339"),
+	queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+	queryParam("min_speed", "number", "Only events at or above this speed"),
+	queryParam("camera", "string", "Camera ID, when there is more than one"),
+	queryParam("direction", "string", "left or right"),
+	queryParam("class", "string", "Vehicle class"),
+	queryParam("lane", "string", "Lane"),
+	queryParam("violation", "boolean", "Only events over, or not over, their speed limit"),
+	queryParam("tag", "string", "Only events with this tag"),
+	queryParam("exclude_tags", "string", "Comma separated tags, events with any are left out"),
+}
+
+// OpenAPISpec returns the OpenAPI 3 document for the REST API.
+func OpenAPISpec() map[string]interface{} {
+	s := openAPISchemas{}
+	errResp := jsonResponse(s, "Error", map[string]string{})
+	read := []map[string][]string{{"bearer": {}}, {"apiKey": {}}, {"basic": {}}, {"token": {}}}
+	open := []map[string][]string{{}}
+
+	op := func(tag string, summary string, params []oaParam, ok map[string]interface{}) oaOperation {
+		return oaOperation{
+			Summary:    summary,
+			Tags:       []string{tag},
+			Parameters: params,
+			Responses: map[string]interface{}{
+				"200": ok,
+				"400": errResp,
+				"401": errResp,
+			},
+		}
+	}
+	withParams := func(params ...[]oaParam) []oaParam {
+		var all []oaParam
+		for _, p := range params {
+			all = append(all, p...)
+		}
+		return all
+	}
+
+	cameraParam := oaParam{Name: "camera", In: "path", Required: true, Description: "Camera ID from CAMERAS", Schema: map[string]interface{}{"type": "string"}}
+	forParam := []oaParam{queryParam("for", "string", "How long for as a Go duration, e.g. 2h. Indefinitely if omitted")}
+	admin := func(summary string, params []oaParam) map[string]interface{} {
+		o := op("admin", summary+". Needs admin scope.", params, jsonResponse(s, "Current state", control.State{}))
+		o.Responses["403"] = errResp
+		return map[string]interface{}{"post": o}
+	}
+
+	tuningPatch := op("admin", "Change detection parameters. Needs admin scope.",
+		[]oaParam{queryParam("persist", "boolean", "Keep the change across restarts")},
+		jsonResponse(s, "The values now in effect", control.TuningValues{}))
+	tuningPatch.RequestBody = map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": s.schemaFor(reflect.TypeOf(control.TuningPatch{}))},
+		},
+	}
+	tuningPatch.Responses["403"] = errResp
+
+	addTag := op("events", "Tag an event or every event in a range. Needs admin scope.", nil,
+		jsonResponse(s, "The tag added", journal.Tag{}))
+	addTag.RequestBody = map[string]interface{}{
+		"required": true,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": s.schemaFor(reflect.TypeOf(NewTag{}))},
+		},
+	}
+	addTag.Responses["201"] = addTag.Responses["200"]
+	delete(addTag.Responses, "200")
+	addTag.Responses["403"] = errResp
+
+	deleteTag := op("events", "Remove a tag. Needs admin scope.", []oaParam{
+		{Name: "id", In: "path", Required: true, Description: "Tag ID", Schema: map[string]interface{}{"type": "string"}},
+	}, map[string]interface{}{"description": "Removed"})
+	deleteTag.Responses["204"] = deleteTag.Responses["200"]
+	delete(deleteTag.Responses, "200")
+	deleteTag.Responses["403"] = errResp
+	deleteTag.Responses["404"] = errResp
+
+	vehicle := op("events", "A vehicle recognised by its colours, with every sighting of it", []oaParam{
+		{Name: "id", In: "path", Required: true, Description: "Vehicle ID", Schema: map[string]interface{}{"type": "string", "format": "uuid"}},
+	}, jsonResponse(s, "Vehicle", VehicleResponse{}))
+	vehicle.Responses["404"] = errResp
+
+	plates := op("events", "Plates over the limit repeatedly, only read with PLATE_TRACKING set. Needs admin scope, every lookup is audited.", []oaParam{
+		queryParam("from", "date-time", "Start of the range, RFC3339"),
+		queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+		queryParam("camera", "string", "Camera ID, when there is more than one"),
+		queryParam("plate", "string", "Only this plate, whatever its violations"),
+		queryParam("min_violations", "integer", "Fewest violations in the range, default 2"),
+		queryParam("limit", "integer", "At most 1000, default 100"),
+	}, jsonResponse(s, "Repeat plates", RepeatPlates{}))
+	plates.Responses["403"] = errResp
+
+	heatmapParams := []oaParam{
+		queryParam("metric", "string", "detections, the default, or speed"),
+		queryParam("from", "date-time", "Start of the range, RFC3339, from the start of its day"),
+		queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+	}
+
+	publicBoard := op("stats", "Anonymized leaderboard, only served when PUBLIC_LEADERBOARD is set", []oaParam{
+		queryParam("period", "string", "day, week, month, year or all"),
+		queryParam("limit", "integer", "Number of entries, at most 100"),
+	}, jsonResponse(s, "Leaderboard", PublicLeaderboard{}))
+	publicBoard.Security = open
+
+	publicStats := op("stats", "Aggregate statistics safe to publish, only served when PUBLIC_STATS is set", nil,
+		jsonResponse(s, "Public stats", PublicStats{}))
+	publicStats.Security = open
+
+	healthOp := func(summary string, v interface{}) map[string]interface{} {
+		o := op("health", summary, nil, jsonResponse(s, "Healthy", v))
+		o.Responses = map[string]interface{}{"200": jsonResponse(s, "Healthy", v), "503": jsonResponse(s, "Unhealthy", v)}
+		o.Security = open
+		return map[string]interface{}{"get": o}
+	}
+
+	paths := map[string]interface{}{
+		"/api/events": map[string]interface{}{"get": op("events", "List events", withParams(eventFilterParams, []oaParam{
+			queryParam("sort", "string", "time, -time, speed or -speed"),
+			queryParam("limit", "integer", "Page size, at most 1000"),
+			queryParam("cursor", "string", "NextCursor of the previous page"),
+		}), jsonResponse(s, "A page of events", EventPage{}))},
+		"/api/events/{id}/image": map[string]interface{}{"get": op("events", "Evidence image of an event, every access is audited",
+			[]oaParam{{Name: "id", In: "path", Required: true, Schema: map[string]interface{}{"type": "string", "format": "uuid"}}},
+			contentResponse("JPEG image", "image/jpeg", "binary"))},
+		"/api/stats/live": map[string]interface{}{"get": op("stats", "Dashboard figures", nil, jsonResponse(s, "Live stats", LiveStats{}))},
+		"/api/stats/live/events": map[string]interface{}{"get": op("stats", "Dashboard figures as server-sent events", nil,
+			contentResponse("A LiveStats JSON document per event", "text/event-stream", ""))},
+		"/api/stats/aggregate": map[string]interface{}{"get": op("stats", "Counts and speed percentiles over a range", withParams(eventFilterParams, []oaParam{
+			queryParam("group_by", "string", "Comma separated hour, day, camera, direction, class, lane, or a bucket size of 5m, 10m, 15m or 30m"),
+		}), jsonResponse(s, "Aggregates", journal.AggregateReport{}))},
+		"/api/stats/histogram": map[string]interface{}{"get": op("stats", "Speed distribution", withParams(eventFilterParams, []oaParam{
+			queryParam("bin_width", "number", "Bin width in mph, default 5"),
+		}), jsonResponse(s, "Histogram", journal.SpeedHistogram{}))},
+		"/api/stats/compliance": map[string]interface{}{"get": op("stats", "Percent of vehicles at or under the speed limit, up to 5, under 10 and 10 or more mph over", withParams(eventFilterParams, []oaParam{
+			queryParam("bucket", "string", "5m, 10m, 15m, 30m, 1h or day, default 1h"),
+		}), jsonResponse(s, "Speed limit compliance", ComplianceReport{}))},
+		"/api/stats/rolling": map[string]interface{}{"get": op("stats", "Volume, median and 85th percentile speed and violation rate over the rolling windows, updated every minute", nil,
+			jsonResponse(s, "Rolling statistics", RollingStats{}))},
+		"/api/stats/heatmap": map[string]interface{}{"get": op("stats", "Volume and violation rate by day of week and hour", eventFilterParams,
+			jsonResponse(s, "Heatmap", journal.Heatmap{}))},
+		"/api/stats/headway": map[string]interface{}{"get": op("stats", "Time gaps between successive vehicles going the same way", []oaParam{
+			queryParam("from", "date-time", "Start of the range, RFC3339"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+			queryParam("direction", "string", "left or right"),
+			queryParam("lane", "string", "Lane"),
+			queryParam("bin_width", "number", "Bin width in seconds, default 1"),
+			queryParam("close", "string", "Gap under which a vehicle is tailgating, default 2s"),
+		}, jsonResponse(s, "Headways", journal.HeadwayReport{}))},
+		"/api/stats/p85": map[string]interface{}{"get": op("stats", "85th percentile speeds over windows ending now", []oaParam{
+			queryParam("window", "string", "Comma separated durations such as 15m,1h,24h, default the configured P85_WINDOWS"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+		}, jsonResponse(s, "85th percentile speeds", P85Speeds{}))},
+		"/api/stats/adt": map[string]interface{}{"get": op("stats", "Average daily traffic and AADT per camera, from complete days", []oaParam{
+			queryParam("from", "date-time", "Start of the range, RFC3339, from the start of its day, default a year ago"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+			queryParam("min_coverage", "number", "Least percent of a day watched for it to count, default 95"),
+			queryParam("exclude_tags", "string", "Comma separated tags, days overlapping ranges with any are left out"),
+		}, jsonResponse(s, "Average daily traffic", AverageDailyTraffic{}))},
+		"/api/stats/volume": map[string]interface{}{"get": op("stats", "Volume, speeds and violation rate per hour or day", []oaParam{
+			queryParam("period", "string", "hour or day, default hour"),
+			queryParam("from", "date-time", "Start of the range, RFC3339, from the start of its hour or day"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+		}, jsonResponse(s, "Volume", Volume{}))},
+		"/api/export/influx": map[string]interface{}{"get": op("stats", "Bucketed aggregates as InfluxDB line protocol", []oaParam{
+			queryParam("from", "date-time", "Start of the range, RFC3339"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("bucket", "string", "5m, 10m, 15m, 30m or 1h, default 5m"),
+		}, contentResponse("One speedcam_traffic point per bucket, camera, direction and lane", "text/plain", ""))},
+		"/api/export/study": map[string]interface{}{"get": op("stats", "Traffic study, counts by FHWA speed bin and vehicle class per interval and direction", withParams(eventFilterParams, []oaParam{
+			queryParam("interval", "string", "5m, 10m, 15m, 30m or 1h, default 15m"),
+			queryParam("format", "string", "csv, the default, or json for a TrafficStudy"),
+		}), contentResponse("A row per interval, camera and direction", "text/csv", ""))},
+		"/api/export/events": map[string]interface{}{"get": op("events", "Every event matching the filters, oldest first, streamed", withParams(eventFilterParams, []oaParam{
+			queryParam("format", "string", "csv, the default, or ndjson, an event per line"),
+			queryParam("track", "boolean", "Include each vehicle's path across the frame, x,y and seconds since its first point"),
+		}), contentResponse("A row per event", "text/csv", ""))},
+		"/api/cameras": map[string]interface{}{"get": op("stats", "The cameras, with their road, posted limit, direction labels and location", nil,
+			jsonResponse(s, "Cameras", Cameras{}))},
+		"/api/grafana/dashboard": map[string]interface{}{"get": op("stats", "Grafana dashboard for these cameras and lanes", nil,
+			contentResponse("Grafana dashboard model, to provision or import", "application/json", ""))},
+		"/api/tags": map[string]interface{}{
+			"get": op("events", "Tags on events and ranges", []oaParam{
+				queryParam("from", "date-time", "Start of the range, RFC3339"),
+				queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			}, jsonResponse(s, "Tags", Tags{})),
+			"post": addTag,
+		},
+		"/api/tags/{id}": map[string]interface{}{"delete": deleteTag},
+		"/api/vehicles": map[string]interface{}{"get": op("events", "Vehicles seen repeatedly, recognised by their colours, with their sightings", withParams(eventFilterParams, []oaParam{
+			queryParam("min_sightings", "integer", "Fewest sightings in the range, default 3"),
+			queryParam("limit", "integer", "At most 1000, default 100"),
+		}), jsonResponse(s, "Repeat vehicles", RepeatVehicles{}))},
+		"/api/vehicles/{id}": map[string]interface{}{"get": vehicle},
+		"/api/plates":        map[string]interface{}{"get": plates},
+		"/api/near-misses": map[string]interface{}{"get": op("events", "Near misses between vehicles and pedestrians or cyclists", []oaParam{
+			queryParam("from", "date-time", "Start of the range, RFC3339"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+			queryParam("limit", "integer", "At most 1000, default 100"),
+		}, jsonResponse(s, "Near misses", NearMisses{}))},
+		"/api/crosswalk/yields": map[string]interface{}{"get": op("stats", "How often vehicles yielded to people on the crosswalk, with evidence of those that didn't", []oaParam{
+			queryParam("from", "date-time", "Start of the range, RFC3339"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+			queryParam("limit", "integer", "Failures listed, at most 1000, default 100"),
+		}, jsonResponse(s, "Crosswalk yield compliance", CrosswalkYields{}))},
+		"/api/stats/classes": map[string]interface{}{"get": op("stats", "Share of traffic and violations by vehicle class", withParams(eventFilterParams[:5], eventFilterParams[6:]),
+			jsonResponse(s, "Class breakdown", ClassBreakdown{}))},
+		"/api/stats/spatial": map[string]interface{}{"get": op("stats", "Where in the frame vehicles were tracked and their mean speed there, per cell", []oaParam{
+			queryParam("from", "date-time", "Start of the range, RFC3339, from the start of its day"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+		}, jsonResponse(s, "Spatial heatmap", journal.SpatialGrid{}))},
+		"/api/stats/lanes": map[string]interface{}{"get": op("stats", "Traffic and speeds by lane", withParams(eventFilterParams[:6], eventFilterParams[7:]),
+			jsonResponse(s, "Lane breakdown", LaneBreakdown{}))},
+		"/api/stats/trend": map[string]interface{}{"get": op("stats", "Week over week or month over month speeds", []oaParam{
+			queryParam("period", "string", "week or month, default week"),
+			queryParam("count", "integer", "Complete periods to compare with the one before, default 8"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+			queryParam("direction", "string", "left or right"),
+			queryParam("lane", "string", "Lane"),
+		}, jsonResponse(s, "Trend", Trend{}))},
+		"/api/stats/compare": map[string]interface{}{"get": op("stats", "Change in speeds between two ranges", withParams([]oaParam{
+			requiredParam(queryParam("before_from", "date-time", "Start of the range before, RFC3339")),
+			requiredParam(queryParam("before_to", "date-time", "End of the range before, RFC3339, exclusive")),
+			requiredParam(queryParam("after_from", "date-time", "Start of the range after, RFC3339")),
+			requiredParam(queryParam("after_to", "date-time", "End of the range after, RFC3339, exclusive")),
+		}, eventFilterParams[2:]), jsonResponse(s, "Comparison", journal.Comparison{}))},
+		"/api/stats/occupancy": map[string]interface{}{"get": op("stats", "Occupancy and level of service per hour or day", []oaParam{
+			queryParam("period", "string", "hour or day, default hour"),
+			queryParam("from", "date-time", "Start of the range, RFC3339, from the start of its hour or day"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+		}, jsonResponse(s, "Occupancy", Occupancy{}))},
+		"/api/stats/counts": map[string]interface{}{"get": op("stats", "Vehicles counted by direction and class, timed or not", []oaParam{
+			queryParam("from", "date-time", "Start of the range, RFC3339, counted from the start of its hour"),
+			queryParam("to", "date-time", "End of the range, RFC3339, exclusive"),
+			queryParam("camera", "string", "Camera ID, when there is more than one"),
+			queryParam("direction", "string", "left or right"),
+			queryParam("class", "string", "Vehicle class"),
+		}, jsonResponse(s, "Counts", VehicleCounts{}))},
+		"/api/leaderboard": map[string]interface{}{"get": op("stats", "Fastest events of a period", []oaParam{
+			queryParam("period", "string", "day, week, month, year or all, default week"),
+			queryParam("from", "date-time", "Start of the range instead of a period"),
+			queryParam("to", "date-time", "End of the range"),
+			queryParam("limit", "integer", "Number of entries, at most 100"),
+		}, jsonResponse(s, "Leaderboard", Leaderboard{}))},
+		"/public/leaderboard": map[string]interface{}{"get": publicBoard},
+		"/public/stats":       map[string]interface{}{"get": publicStats},
+		"/api/state":          map[string]interface{}{"get": op("admin", "Pause and mute state", nil, jsonResponse(s, "Current state", control.State{}))},
+		"/api/admin/pause":    admin("Pause detection", forParam),
+		"/api/admin/resume":   admin("Resume detection", nil),
+		"/api/admin/mute":     admin("Mute alerts", forParam),
+		"/api/admin/unmute":   admin("Unmute alerts", nil),
+		"/api/tuning": map[string]interface{}{
+			"get":   op("admin", "Current detection parameters", nil, jsonResponse(s, "Tuning", control.TuningValues{})),
+			"patch": tuningPatch,
+		},
+		"/api/tuning/schema": map[string]interface{}{"get": op("admin", "Describes the tuning fields", nil, jsonResponse(s, "Fields", []control.TuningField{}))},
+		"/healthz":           healthOp("Component status", health.Report{}),
+		"/readyz":            healthOp("Readiness", health.ReadinessReport{}),
+		"/livez":             healthOp("Liveness", map[string]string{}),
+		"/version": map[string]interface{}{"get": op("health", "Build version and commit, gocv and OpenCV versions and the optional features enabled", nil,
+			jsonResponse(s, "Version", version.Info{}))},
+		"/snapshot.jpg": map[string]interface{}{"get": op("video", "Latest frame with overlays", []oaParam{
+			queryParam("boxes", "boolean", "Draw bounding boxes"),
+			queryParam("tracks", "boolean", "Draw tracks"),
+			queryParam("mask", "boolean", "Draw the detection mask"),
+			queryParam("fps", "boolean", "Draw the frame rate"),
+			queryParam("latency", "boolean", "Draw the time each pipeline stage spent on the frame"),
+		}, contentResponse("JPEG image", "image/jpeg", "binary"))},
+		"/stream": map[string]interface{}{"get": op("video", "MJPEG stream, takes the overlay parameters of /snapshot.jpg", []oaParam{
+			queryParam("maxfps", "number", "Most frames a second to send, capped at STREAM_MAX_FPS"),
+		},
+			contentResponse("Multipart JPEG frames", "multipart/x-mixed-replace", "binary"))},
+		"/heatmap.jpg": map[string]interface{}{"get": op("video", "Latest frame with the spatial heatmap shaded over it", heatmapParams,
+			contentResponse("JPEG image", "image/jpeg", "binary"))},
+		"/cameras/{camera}/heatmap.jpg": map[string]interface{}{"get": op("video", "Latest frame of one camera with its heatmap, takes the parameters of /heatmap.jpg",
+			withParams([]oaParam{cameraParam}, heatmapParams), contentResponse("JPEG image", "image/jpeg", "binary"))},
+		"/cameras/{camera}/snapshot.jpg": map[string]interface{}{"get": op("video", "Latest frame of one camera, takes the parameters of /snapshot.jpg",
+			[]oaParam{cameraParam}, contentResponse("JPEG image", "image/jpeg", "binary"))},
+		"/cameras/{camera}/stream": map[string]interface{}{"get": op("video", "MJPEG stream of one camera, takes the parameters of /stream",
+			[]oaParam{cameraParam}, contentResponse("Multipart JPEG frames", "multipart/x-mixed-replace", "binary"))},
+	}
+
+	url := server.BasePath
+	if url == "" {
+		url = "/"
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "speedcam",
+			"version": "1",
+		},
+		"servers":  []interface{}{map[string]string{"url": url}},
+		"security": read,
+		"paths":    paths,
+		"components": map[string]interface{}{
+			"schemas": s,
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]string{"type": "http", "scheme": "bearer"},
+				"basic":  map[string]string{"type": "http", "scheme": "basic"},
+				"apiKey": map[string]string{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+				"token":  map[string]string{"type": "apiKey", "in": "query", "name": "token"},
+			},
+		},
+	}
+}
+
+// RegisterOpenAPI serves the spec at /api/openapi.json without credentials,
+// it describes the API but holds no data.
+func RegisterOpenAPI(mux *http.ServeMux) {
+	spec := OpenAPISpec()
+	mux.HandleFunc("/api/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		httpjson.Write(w, http.StatusOK, spec)
+	})
+}
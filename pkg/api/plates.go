@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+// defaultMinPlateViolations is how many violations a plate needs to be
+// listed as a repeat offender.
+const defaultMinPlateViolations = 2
+
+// RepeatPlates are the plates over the limit repeatedly over a range.
+type RepeatPlates struct {
+	From   time.Time
+	To     time.Time
+	Plates []journal.PlateHistory // most violations first
+}
+
+// repeatPlates handles GET /api/plates, which needs admin scope and is
+// audited, the plates with at least min_violations, default 2, from, to and
+// camera, the range defaulting to the last week. plate looks up one plate
+// whatever its violations. Plates are only read with PLATE_TRACKING set,
+// without it there are none.
+func (a *API) repeatPlates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 7*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Limit, err = parseLimit(r); err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	minViolations := defaultMinPlateViolations
+	if v := r.URL.Query().Get("min_violations"); v != "" {
+		if minViolations, err = strconv.Atoi(v); err != nil || minViolations < 1 {
+			httpjson.Error(w, http.StatusBadRequest, "invalid min_violations: "+v)
+			return
+		}
+	}
+	plate := strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(r.URL.Query().Get("plate")))
+	if plate != "" {
+		minViolations = 1
+	}
+
+	subject := "plates"
+	if plate != "" {
+		subject = "plate " + plate
+	}
+	if err := a.journal.Audit(journal.AuditAccess, apiActor(r), subject, r.URL.RequestURI()); err != nil {
+		// plates are as personal as the evidence, looking them up is accounted for too
+		fmt.Printf("Failed to audit access to %s, %s\n", subject, err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to audit access")
+		return
+	}
+
+	plates, err := a.journal.RepeatPlates(filter, plate, minViolations)
+	if err != nil {
+		fmt.Printf("Failed to query plates, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query plates")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, RepeatPlates{From: filter.From, To: filter.To, Plates: plates})
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+// minPublicVehicles is the fewest vehicles a day needs for its speeds to be
+// published, fewer could single out a car.
+const minPublicVehicles = 5
+
+// PublicStats are aggregate figures safe to publish, e.g. on a neighbourhood
+// website: no images, plates, IDs or times finer than a day.
+type PublicStats struct {
+	GeneratedAt   time.Time
+	From          time.Time
+	To            time.Time
+	Vehicles      int
+	MeanSpeed     float64
+	P85Speed      float64
+	ViolationRate float64 // percent of vehicles over their limit
+	Days          []PublicDay
+}
+
+// PublicDay is a day's figures, speeds left 0 on days with too few vehicles
+// to publish them.
+type PublicDay struct {
+	Date          string
+	Vehicles      int
+	MeanSpeed     float64
+	P85Speed      float64
+	ViolationRate float64
+}
+
+func publicDay(date string, a journal.Aggregate) PublicDay {
+	d := PublicDay{Date: date, Vehicles: a.Vehicles}
+	if a.Vehicles >= minPublicVehicles {
+		d.MeanSpeed = a.MeanSpeed
+		d.P85Speed = a.P85Speed
+		d.ViolationRate = 100 * float64(a.Violations) / float64(a.Vehicles)
+	}
+	return d
+}
+
+// PublicStatsPublisher regenerates PublicStats every Interval, serving the
+// latest on /public/stats and writing them to File when set, so the journal
+// isn't queried for every visitor.
+type PublicStatsPublisher struct {
+	Journal  *journal.Journal
+	File     string        // empty to only serve them
+	Interval time.Duration // between updates
+	Window   time.Duration // covered, rounded up to whole days
+
+	mu     sync.Mutex
+	latest []byte
+}
+
+// NewPublicStats configures publishing from PUBLIC_STATS, returning nil
+// when it isn't set.
+func NewPublicStats(j *journal.Journal) (*PublicStatsPublisher, error) {
+	if enabled, _ := strconv.ParseBool(os.Getenv("PUBLIC_STATS")); !enabled {
+		return nil, nil
+	}
+
+	p := &PublicStatsPublisher{Journal: j, File: os.Getenv("PUBLIC_STATS_FILE")}
+	var err error
+	if p.Interval, err = time.ParseDuration(config.Env("PUBLIC_STATS_INTERVAL", "15m")); err != nil || p.Interval <= 0 {
+		return nil, fmt.Errorf("PUBLIC_STATS_INTERVAL: invalid duration %q", os.Getenv("PUBLIC_STATS_INTERVAL"))
+	}
+	if p.Window, err = time.ParseDuration(config.Env("PUBLIC_STATS_WINDOW", "168h")); err != nil || p.Window <= 0 {
+		return nil, fmt.Errorf("PUBLIC_STATS_WINDOW: invalid duration %q", os.Getenv("PUBLIC_STATS_WINDOW"))
+	}
+	return p, nil
+}
+
+// Build gathers the figures for the whole days of the window up to the last
+// local midnight. Today is left out until it's over, or comparing one update
+// with the next would give away each car as it passed.
+func (p *PublicStatsPublisher) Build(now time.Time) (PublicStats, error) {
+	now = now.In(time.Local)
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	days := int((p.Window + 24*time.Hour - 1) / (24 * time.Hour))
+	stats := PublicStats{GeneratedAt: now, From: to.AddDate(0, 0, -days), To: to, Days: []PublicDay{}}
+	agg, err := p.Journal.Aggregate(journal.Filter{From: stats.From, To: stats.To}, []string{"day"})
+	if err != nil {
+		return stats, err
+	}
+
+	total := publicDay("", agg.Total)
+	stats.Vehicles, stats.MeanSpeed, stats.P85Speed, stats.ViolationRate = total.Vehicles, total.MeanSpeed, total.P85Speed, total.ViolationRate
+	for _, g := range agg.Groups {
+		stats.Days = append(stats.Days, publicDay(g.Group["day"], g))
+	}
+	return stats, nil
+}
+
+// Run publishes every Interval, starting straight away.
+func (p *PublicStatsPublisher) Run() {
+	for {
+		if err := p.publish(time.Now()); err != nil {
+			fmt.Printf("Failed to publish public stats, %s\n", err)
+		}
+		time.Sleep(p.Interval)
+	}
+}
+
+func (p *PublicStatsPublisher) publish(now time.Time) error {
+	stats, err := p.Build(now)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.latest = b
+	p.mu.Unlock()
+
+	if p.File == "" {
+		return nil
+	}
+	// written aside and renamed, so a web server never serves half a file
+	tmp, err := os.CreateTemp(filepath.Dir(p.File), ".public-stats-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chmod(tmp.Name(), 0644)
+	return os.Rename(tmp.Name(), p.File)
+}
+
+// ServeHTTP handles GET /public/stats, which needs no credentials and can be
+// fetched from other sites' pages.
+func (p *PublicStatsPublisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	p.mu.Lock()
+	b := p.latest
+	p.mu.Unlock()
+	if b == nil {
+		httpjson.Error(w, http.StatusServiceUnavailable, "public stats not published yet")
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(p.Interval.Seconds())))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/event"
+	"github.com/danhigham/speedcam/pkg/journal"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPublicStatsLeaveOutToday(t *testing.T) {
+	j, err := journal.Open(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	now := time.Date(2024, 6, 12, 15, 30, 0, 0, time.Local)
+	record := func(at time.Time, speed float64) {
+		msg := event.CarMessage{ID: uuid.NewV4(), Speed: speed, SpeedLimit: 30, TimeStamp: at}
+		if err := j.RecordEvent(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < minPublicVehicles; i++ {
+		record(now.AddDate(0, 0, -1).Add(time.Duration(i)*time.Minute), 25)
+	}
+
+	p := &PublicStatsPublisher{Journal: j, Window: 7 * 24 * time.Hour}
+	before, err := p.Build(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record(now.Add(-time.Minute), 60)
+	after, err := p.Build(now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if after.Vehicles != before.Vehicles || after.MeanSpeed != before.MeanSpeed || after.ViolationRate != before.ViolationRate {
+		t.Errorf("totals changed by an event from today, %+v then %+v", before, after)
+	}
+	if len(after.Days) != 1 || after.Days[0].Date != "2024-06-11" || after.Days[0] != before.Days[0] {
+		t.Errorf("days changed by an event from today, %+v then %+v", before.Days, after.Days)
+	}
+	if !after.To.Equal(time.Date(2024, 6, 12, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("To = %s, want local midnight", after.To)
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/stream"
+	"gocv.io/x/gocv"
+)
+
+// heatmapOpacity is how strongly the heatmap is blended over the frame.
+const heatmapOpacity = 0.45
+
+// spatialHeatmap handles GET /api/stats/spatial, where in the frame of
+// camera vehicles were tracked and their mean speed there, per cell of the
+// frame. It takes from and to, the range defaulting to the last week and
+// taken in whole days.
+func (a *API) spatialHeatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 7*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	grid, err := a.journal.SpatialHeatmap(filter)
+	if err != nil {
+		fmt.Printf("Failed to query heatmap, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query heatmap")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, grid)
+}
+
+// HeatmapOverlay serves a camera's latest frame with its spatial heatmap
+// shaded over it, taking the from and to of GET /api/stats/spatial and
+// metric, detections, the default, or speed, the mean speed in each cell.
+// Cells reading much faster or slower than their neighbours on the same
+// lane point at a calibration that doesn't hold across the frame.
+type HeatmapOverlay struct {
+	Journal *journal.Journal
+	Camera  string
+	Frames  stream.FrameView
+}
+
+func (h HeatmapOverlay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	metric := r.URL.Query().Get("metric")
+	if metric == "" {
+		metric = "detections"
+	}
+	if metric != "detections" && metric != "speed" {
+		httpjson.Error(w, http.StatusBadRequest, "invalid metric: "+metric+", want detections or speed")
+		return
+	}
+	filter, err := parseStatsRange(r, 7*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter.Camera = h.Camera
+	grid, err := h.Journal.SpatialHeatmap(filter)
+	if err != nil {
+		fmt.Printf("Failed to query heatmap, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query heatmap")
+		return
+	}
+
+	buf, err := h.Frames.Hub.Render(false, stream.OverlayOptions{})
+	if err != nil {
+		httpjson.Error(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	img, err := gocv.IMDecode(buf, gocv.IMReadColor)
+	if err != nil {
+		httpjson.Error(w, http.StatusInternalServerError, "failed to decode frame")
+		return
+	}
+	defer img.Close()
+
+	var cells []stream.HeatCell
+	legend := "no vehicles tracked yet"
+	for _, c := range grid.Cells {
+		rect := image.Rect(c.X*grid.CellSize, c.Y*grid.CellSize, (c.X+1)*grid.CellSize, (c.Y+1)*grid.CellSize)
+		switch metric {
+		case "detections":
+			// square root, so quieter cells still show
+			cells = append(cells, stream.HeatCell{Rect: rect, Value: math.Sqrt(float64(c.Detections) / float64(grid.MaxDetections))})
+			legend = fmt.Sprintf("detections, up to %d per cell", grid.MaxDetections)
+		case "speed":
+			if c.Timed < journal.MinSpatialTimed {
+				continue
+			}
+			v := 0.5
+			if grid.MaxSpeed > grid.MinSpeed {
+				v = (c.MeanSpeed - grid.MinSpeed) / (grid.MaxSpeed - grid.MinSpeed)
+			}
+			cells = append(cells, stream.HeatCell{Rect: rect, Value: v})
+			legend = fmt.Sprintf("mean speed, %.0f to %.0f mph", grid.MinSpeed, grid.MaxSpeed)
+		}
+	}
+	stream.DrawHeatmap(&img, cells, heatmapOpacity, legend)
+
+	out, err := gocv.IMEncode(".jpg", img)
+	if err != nil {
+		httpjson.Error(w, http.StatusInternalServerError, "failed to encode heatmap")
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(out)
+}
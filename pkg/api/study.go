@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+// TrafficStudy is a range's traffic binned by speed and FHWA class.
+type TrafficStudy struct {
+	From      time.Time
+	To        time.Time
+	Interval  string
+	SpeedBins []float64 // lower bounds in mph
+	Intervals []journal.StudyInterval
+}
+
+// studyHeader is the header row of a traffic study CSV.
+func studyHeader() []string {
+	header := []string{"Date", "Time", "Camera", "Direction", "Total"}
+	bins := journal.StudySpeedBins
+	for i, from := range bins {
+		if i == len(bins)-1 {
+			header = append(header, fmt.Sprintf("%g+ mph", from))
+		} else {
+			header = append(header, fmt.Sprintf("%g-%g mph", from, bins[i+1]))
+		}
+	}
+	for class := 1; class <= journal.FHWAClasses; class++ {
+		header = append(header, fmt.Sprintf("Class %d", class))
+	}
+	return append(header, "Unclassified", "Mean Speed", "85th Percentile Speed")
+}
+
+// trafficStudy handles GET /api/export/study, counts binned by standard
+// speed bins and FHWA vehicle classes per interval and direction, as a
+// traffic counter's study. It takes the filters of listEvents, the range
+// defaulting to the last week, interval, one of 5m, 10m, 15m, 30m or 1h,
+// default 15m, and format, csv, the default, or json.
+func (a *API) trafficStudy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 7*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	interval := "15m"
+	if v := r.URL.Query().Get("interval"); v != "" {
+		interval = v
+	}
+	size, err := journal.ParseBucket(interval)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "csv" && format != "json" {
+		httpjson.Error(w, http.StatusBadRequest, "format must be csv or json")
+		return
+	}
+
+	intervals, err := a.journal.Study(filter, size, a.FHWAClassMap)
+	if err != nil {
+		fmt.Printf("Failed to bin traffic study, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to bin traffic study")
+		return
+	}
+	if format == "json" {
+		httpjson.Write(w, http.StatusOK, TrafficStudy{From: filter.From, To: filter.To, Interval: interval, SpeedBins: journal.StudySpeedBins, Intervals: intervals})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="traffic-study-%s.csv"`, filter.From.Format("2006-01-02")))
+	cw := csv.NewWriter(w)
+	cw.Write(studyHeader())
+	for _, s := range intervals {
+		row := []string{s.Start.Format("2006-01-02"), s.Start.Format("15:04"), s.Camera, s.Direction, strconv.Itoa(s.Vehicles)}
+		for _, n := range s.SpeedBins {
+			row = append(row, strconv.Itoa(n))
+		}
+		for _, n := range s.Classes[1:] {
+			row = append(row, strconv.Itoa(n))
+		}
+		row = append(row, strconv.Itoa(s.Classes[0]), strconv.FormatFloat(s.MeanSpeed, 'f', 1, 64), strconv.FormatFloat(s.P85Speed, 'f', 1, 64))
+		cw.Write(row)
+	}
+	cw.Flush()
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/auth"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+	uuid "github.com/satori/go.uuid"
+)
+
+// NewTag is the body of POST /api/tags, tagging either an event or every
+// event in a range.
+type NewTag struct {
+	Name    string
+	Camera  string    // a range's camera, empty for every camera
+	EventID uuid.UUID // the event to tag, omitted for a range
+	From    time.Time
+	To      time.Time
+	Note    string
+}
+
+// Tags are the tags over a range.
+type Tags struct {
+	From time.Time
+	To   time.Time
+	Tags []journal.Tag // oldest first
+}
+
+// tags handles /api/tags. GET lists the tags over from and to, the range
+// defaulting to the last 30 days, and POST, which needs admin scope, adds a
+// tag from a NewTag body.
+func (a *API) tags(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		a.auth.RequireFunc(auth.ScopeRead, a.listTags)(w, r)
+	case http.MethodPost:
+		a.auth.RequireFunc(auth.ScopeAdmin, a.addTag)(w, r)
+	default:
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *API) listTags(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseStatsRange(r, 30*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tags, err := a.journal.Tags(filter.From, filter.To)
+	if err != nil {
+		fmt.Printf("Failed to query tags, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query tags")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, Tags{From: filter.From, To: filter.To, Tags: tags})
+}
+
+func (a *API) addTag(w http.ResponseWriter, r *http.Request) {
+	var body NewTag
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		httpjson.Error(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+
+	tag, err := a.journal.AddTag(journal.Tag{Name: body.Name, Camera: body.Camera, EventID: body.EventID, From: body.From, To: body.To, Note: body.Note})
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	fmt.Printf("Tag %q added by %s\n", tag.Name, auth.Identity(r))
+	httpjson.Write(w, http.StatusCreated, tag)
+}
+
+// deleteTag handles DELETE /api/tags/{id}, which needs admin scope.
+func (a *API) deleteTag(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, err := uuid.FromString(strings.TrimPrefix(r.URL.Path, "/api/tags/"))
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, "invalid tag id")
+		return
+	}
+	ok, err := a.journal.DeleteTag(id)
+	if err != nil {
+		fmt.Printf("Failed to delete tag %s, %s\n", id.String(), err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to delete tag")
+		return
+	}
+	if !ok {
+		httpjson.Error(w, http.StatusNotFound, "tag not found")
+		return
+	}
+	fmt.Printf("Tag %s deleted by %s\n", id.String(), auth.Identity(r))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/danhigham/speedcam/pkg/auth"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+)
+
+// RegisterTuning adds /api/tuning, GET needs read scope and PATCH admin scope.
+// A PATCH body is a JSON TuningPatch, add persist=true to keep the change
+// across restarts. /api/tuning/schema describes the fields.
+func RegisterTuning(mux *http.ServeMux, t *control.Tuning, a *auth.Auth) {
+	get := a.RequireFunc(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		httpjson.Write(w, http.StatusOK, t.Values())
+	})
+
+	update := a.RequireFunc(auth.ScopeAdmin, func(w http.ResponseWriter, r *http.Request) {
+		var patch control.TuningPatch
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&patch); err != nil {
+			httpjson.Error(w, http.StatusBadRequest, "invalid body: "+err.Error())
+			return
+		}
+
+		persist, _ := strconv.ParseBool(r.URL.Query().Get("persist"))
+		v, err := t.Update(patch, persist)
+		if err != nil {
+			httpjson.Error(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		fmt.Printf("Tuning changed by %s: %+v\n", auth.Identity(r), v)
+		httpjson.Write(w, http.StatusOK, v)
+	})
+
+	mux.HandleFunc("/api/tuning/schema", a.RequireFunc(auth.ScopeRead, func(w http.ResponseWriter, r *http.Request) {
+		httpjson.Write(w, http.StatusOK, control.TuningSchema)
+	}))
+
+	mux.HandleFunc("/api/tuning", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			get(w, r)
+		case http.MethodPatch, http.MethodPut:
+			update(w, r)
+		default:
+			httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+}
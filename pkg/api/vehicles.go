@@ -0,0 +1,106 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+	uuid "github.com/satori/go.uuid"
+)
+
+// defaultMinSightings is how often a vehicle has to have been seen to be
+// listed as a repeat one.
+const defaultMinSightings = 3
+
+// VehicleResponse is a vehicle recognised by its colours, with its
+// sightings.
+type VehicleResponse struct {
+	journal.Vehicle
+	Events []EventResponse // newest first
+}
+
+// RepeatVehicles are the vehicles seen repeatedly over a range.
+type RepeatVehicles struct {
+	From     time.Time
+	To       time.Time
+	Vehicles []VehicleResponse // most violations first
+}
+
+func vehicleResponse(v journal.Vehicle) VehicleResponse {
+	resp := VehicleResponse{Vehicle: v, Events: make([]EventResponse, 0, len(v.Events))}
+	for _, e := range v.Events {
+		resp.Events = append(resp.Events, eventResponse(e))
+	}
+	return resp
+}
+
+// repeatVehicles handles GET /api/vehicles, the vehicles seen at least
+// min_sightings times, default 3, among the events matching the filters of
+// listEvents, the range defaulting to the last 30 days. violation=true
+// counts only their violations, finding e.g. the car speeding past every
+// weekday morning. limit caps the vehicles listed, at most 1000.
+func (a *API) repeatVehicles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	filter, err := parseStatsRange(r, 30*24*time.Hour)
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Limit, err = parseLimit(r); err != nil {
+		httpjson.Error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	minSightings := defaultMinSightings
+	if v := r.URL.Query().Get("min_sightings"); v != "" {
+		if minSightings, err = strconv.Atoi(v); err != nil || minSightings < 1 {
+			httpjson.Error(w, http.StatusBadRequest, "invalid min_sightings: "+v)
+			return
+		}
+	}
+
+	vehicles, err := a.journal.RepeatVehicles(filter, minSightings)
+	if err != nil {
+		fmt.Printf("Failed to query vehicles, %s\n", err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query vehicles")
+		return
+	}
+	resp := RepeatVehicles{From: filter.From, To: filter.To, Vehicles: make([]VehicleResponse, 0, len(vehicles))}
+	for _, v := range vehicles {
+		resp.Vehicles = append(resp.Vehicles, vehicleResponse(v))
+	}
+	httpjson.Write(w, http.StatusOK, resp)
+}
+
+// vehicle handles GET /api/vehicles/{id}, a vehicle with every sighting of
+// it.
+func (a *API) vehicle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	id, err := uuid.FromString(strings.TrimPrefix(r.URL.Path, "/api/vehicles/"))
+	if err != nil {
+		httpjson.Error(w, http.StatusBadRequest, "invalid vehicle id")
+		return
+	}
+	v, ok, err := a.journal.VehicleSightings(id)
+	if err != nil {
+		fmt.Printf("Failed to query vehicle %s, %s\n", id.String(), err)
+		httpjson.Error(w, http.StatusInternalServerError, "failed to query vehicle")
+		return
+	}
+	if !ok {
+		httpjson.Error(w, http.StatusNotFound, "vehicle not found")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, vehicleResponse(v))
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/danhigham/speedcam/pkg/server"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// overlayFS serves files from override when present there and from base
+// otherwise, so a customised page or stylesheet replaces just that file.
+// Directories always come from base.
+type overlayFS struct {
+	override fs.FS
+	base     fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	info, err := fs.Stat(o.override, name)
+	if err == nil && !info.IsDir() {
+		return o.override.Open(name)
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.base.Open(name)
+}
+
+// RegisterWeb serves the browser UI under /ui/. The pages are static and talk
+// to the API with the token the user enters, kept in localStorage. They only
+// use relative URLs so they work under a BASE_PATH. Files in WEB_DIR override
+// the built in ones of the same name.
+func RegisterWeb(mux *http.ServeMux) {
+	assets, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		panic(err)
+	}
+	if dir := os.Getenv("WEB_DIR"); dir != "" {
+		fmt.Printf("Serving UI overrides from %s\n", dir)
+		assets = overlayFS{override: os.DirFS(dir), base: assets}
+	}
+
+	mux.Handle("/ui/", http.StripPrefix("/ui/", http.FileServer(http.FS(assets))))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, server.BasePath+"/ui/dashboard.html", http.StatusFound)
+	})
+}
@@ -0,0 +1,74 @@
+// Shared helpers for the UI pages. An API key is only asked for when the
+// server rejects a request, and is then kept in localStorage. Basic auth is
+// handled by the browser.
+
+function apiToken() {
+  return localStorage.getItem("speedcamToken") || "";
+}
+
+function askForToken() {
+  const token = prompt("API key");
+  if (token) {
+    localStorage.setItem("speedcamToken", token);
+  } else {
+    localStorage.removeItem("speedcamToken");
+  }
+  return token;
+}
+
+// withToken adds the API key to a URL for elements that can't send headers,
+// like <img> and EventSource.
+function withToken(path) {
+  const token = apiToken();
+  if (!token) {
+    return path;
+  }
+  return path + (path.includes("?") ? "&" : "?") + "token=" + encodeURIComponent(token);
+}
+
+async function apiFetch(path, options = {}) {
+  for (let attempt = 0; attempt < 2; attempt++) {
+    const headers = Object.assign({}, options.headers);
+    if (apiToken()) {
+      headers.Authorization = "Bearer " + apiToken();
+    }
+
+    const resp = await fetch(path, Object.assign({}, options, { headers }));
+    if (resp.headers.get("X-Timezone")) {
+      localStorage.setItem("speedcamTimezone", resp.headers.get("X-Timezone"));
+    }
+    if (resp.status === 401 && attempt === 0 && askForToken()) {
+      continue;
+    }
+    if (!resp.ok) {
+      const body = await resp.json().catch(() => ({}));
+      throw new Error(body.Error || resp.statusText);
+    }
+    return resp;
+  }
+}
+
+async function apiJSON(path) {
+  const resp = await apiFetch(path);
+  return resp.json();
+}
+
+// images need the auth header too, so they're fetched and shown as blobs
+async function loadImage(img, path) {
+  const resp = await apiFetch(path);
+  img.src = URL.createObjectURL(await resp.blob());
+}
+
+// times are shown in the camera's timezone, as the server names it, rather
+// than the browser's, so they match reports and the camera's own clock
+function formatTime(ts) {
+  const timeZone = localStorage.getItem("speedcamTimezone");
+  if (timeZone) {
+    try {
+      return new Date(ts).toLocaleString(undefined, { timeZone, timeZoneName: "short" });
+    } catch (e) {
+      // a zone the browser doesn't know
+    }
+  }
+  return new Date(ts).toLocaleString();
+}
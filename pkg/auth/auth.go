@@ -0,0 +1,174 @@
+// Package auth protects HTTP handlers with API keys and basic auth users.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/danhigham/speedcam/pkg/httpjson"
+)
+
+type Scope int
+
+const (
+	ScopeRead Scope = iota + 1
+	ScopeAdmin
+)
+
+var scopeNames = map[string]Scope{
+	"read":  ScopeRead,
+	"admin": ScopeAdmin,
+}
+
+// Credential is an API key or basic auth user. Admin credentials can do
+// everything read credentials can.
+type Credential struct {
+	Name   string
+	Secret string
+	Scope  Scope
+}
+
+// Auth protects HTTP handlers with API keys, given as a bearer token, an
+// X-API-Key header or a token query parameter, or with basic auth users.
+type Auth struct {
+	keys     []Credential
+	users    []Credential
+	disabled bool // AUTH_DISABLED, every request is let through
+}
+
+type authContextKey struct{}
+
+// New reads credentials from API_KEYS and BASIC_AUTH_USERS, both comma
+// separated lists of name:secret:scope where scope is read or admin. The
+// older API_TOKEN is accepted as an admin key. With no credentials at all
+// every request is refused, unless AUTH_DISABLED=true opts out of
+// authentication, e.g. on a trusted network.
+func New() (*Auth, error) {
+	a := &Auth{}
+
+	var err error
+	if a.keys, err = parseCredentials(os.Getenv("API_KEYS")); err != nil {
+		return nil, fmt.Errorf("API_KEYS: %s", err)
+	}
+	if a.users, err = parseCredentials(os.Getenv("BASIC_AUTH_USERS")); err != nil {
+		return nil, fmt.Errorf("BASIC_AUTH_USERS: %s", err)
+	}
+	if token := os.Getenv("API_TOKEN"); token != "" {
+		a.keys = append(a.keys, Credential{Name: "api_token", Secret: token, Scope: ScopeAdmin})
+	}
+
+	if disabled, _ := strconv.ParseBool(os.Getenv("AUTH_DISABLED")); disabled {
+		a.disabled = true
+		fmt.Println("AUTH_DISABLED set, HTTP authentication is disabled")
+	} else if !a.Enabled() {
+		fmt.Println("No API_KEYS or BASIC_AUTH_USERS set, every request needing them is refused, set AUTH_DISABLED=true to serve without authentication")
+	}
+	return a, nil
+}
+
+func parseCredentials(s string) ([]Credential, error) {
+	var creds []Credential
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("expected name:secret:scope, got %q", parts[0])
+		}
+
+		scope := ScopeRead
+		if len(parts) == 3 {
+			var ok bool
+			if scope, ok = scopeNames[parts[2]]; !ok {
+				return nil, fmt.Errorf("unknown scope %q for %s", parts[2], parts[0])
+			}
+		}
+		creds = append(creds, Credential{Name: parts[0], Secret: parts[1], Scope: scope})
+	}
+	return creds, nil
+}
+
+// Enabled reports whether any credentials are configured.
+func (a *Auth) Enabled() bool {
+	return len(a.keys) > 0 || len(a.users) > 0
+}
+
+func matchSecret(creds []Credential, name string, secret string) (Credential, bool) {
+	for _, c := range creds {
+		if name != "" && name != c.Name {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) == 1 {
+			return c, true
+		}
+	}
+	return Credential{}, false
+}
+
+func (a *Auth) authenticate(r *http.Request) (Credential, bool) {
+	if user, pass, ok := r.BasicAuth(); ok {
+		return matchSecret(a.users, user, pass)
+	}
+
+	key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if key == "" {
+		key = r.Header.Get("X-API-Key")
+	}
+	if key == "" {
+		// EventSource, <img> and <video> can't set headers
+		key = r.URL.Query().Get("token")
+	}
+	if key == "" {
+		return Credential{}, false
+	}
+	return matchSecret(a.keys, "", key)
+}
+
+// Require only passes requests through to next if they carry a credential
+// with at least scope, or authentication was disabled with AUTH_DISABLED.
+func (a *Auth) Require(scope Scope, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.disabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cred, ok := a.authenticate(r)
+		if !ok {
+			if len(a.users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="speedcam"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			httpjson.Error(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if cred.Scope < scope {
+			httpjson.Error(w, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, cred)))
+	})
+}
+
+func (a *Auth) RequireFunc(scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return a.Require(scope, next).ServeHTTP
+}
+
+// Identity names whoever made an authenticated request, for the audit
+// log.
+func Identity(r *http.Request) string {
+	if cred, ok := r.Context().Value(authContextKey{}).(Credential); ok {
+		return cred.Name
+	}
+	return "anonymous"
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireUnconfigured(t *testing.T) {
+	for _, name := range []string{"API_KEYS", "BASIC_AUTH_USERS", "API_TOKEN", "AUTH_DISABLED"} {
+		t.Setenv(name, "")
+	}
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.Require(ScopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("reached the handler without credentials")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/controls", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestRequireDisabled(t *testing.T) {
+	t.Setenv("API_KEYS", "")
+	t.Setenv("BASIC_AUTH_USERS", "")
+	t.Setenv("API_TOKEN", "")
+	t.Setenv("AUTH_DISABLED", "true")
+	a, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reached := false
+	h := a.Require(ScopeAdmin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !reached {
+		t.Error("AUTH_DISABLED=true didn't let the request through")
+	}
+}
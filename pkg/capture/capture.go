@@ -0,0 +1,102 @@
+// Package capture reads frames from the camera, or from anything else
+// implementing Source.
+package capture
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// Source delivers frames from a camera, file or anything else. New kinds of
+// input only need to implement it.
+type Source interface {
+	// NextFrame reads the next frame into img, which the caller owns so
+	// frames can come from a pool, returning io.EOF once the source has
+	// ended. Streams can deliver empty frames, which should be skipped.
+	NextFrame(img *gocv.Mat) (Frame, error)
+	Close() error
+}
+
+// Frame is what a source knows about a frame besides its pixels.
+type Frame struct {
+	Time time.Time // when it was captured, by the source's clock
+}
+
+// Open opens url, decoding it with decode, one of the Decode constants:
+// a device index such as 0, a directory of still images, a video file,
+// played as fast as it can be read, an MJPEG stream over HTTP, a GStreamer
+// pipeline ending in appsink, or anything else OpenCV can open, like an RTSP
+// stream.
+func Open(url string, decode string) (Source, error) {
+	if index, err := strconv.Atoi(url); err == nil {
+		return OpenDevice(index)
+	}
+	if IsImageSequence(url) {
+		return OpenImages(url)
+	}
+	if IsRecording(url) {
+		return OpenReplay(url, decode, Replay{})
+	}
+	if IsPipeline(url) {
+		return OpenPipeline(url)
+	}
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		src, err := OpenMJPEG(url)
+		if err == nil {
+			return src, nil
+		}
+		if err != errNotMJPEG {
+			return nil, err
+		}
+		// e.g. HLS, left to OpenCV
+	}
+	return OpenVideo(url, decode)
+}
+
+// IsPipeline reports whether url is a GStreamer pipeline rather than a
+// URL, e.g. "libcamerasrc ! videoconvert ! appsink" for a CSI camera. Frames
+// are read from its appsink.
+func IsPipeline(url string) bool {
+	return strings.Contains(url, "!") && strings.Contains(url, "appsink")
+}
+
+// IsStream reports whether url is a network stream or live pipeline, which
+// can be reopened after it drops, rather than a file or device.
+func IsStream(rawURL string) bool {
+	if IsPipeline(rawURL) {
+		return !strings.Contains(rawURL, "filesrc")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "rtsp", "rtsps", "rtmp", "http", "https", "udp", "tcp", "srt":
+		return true
+	}
+	return false
+}
+
+// Rate is the smoothed rate frames arrive at.
+type Rate struct {
+	fps  float64
+	last time.Time
+}
+
+// Tick records a frame captured at t.
+func (r *Rate) Tick(t time.Time) {
+	if !r.last.IsZero() && t.After(r.last) {
+		r.fps = 0.9*r.fps + 0.1/t.Sub(r.last).Seconds()
+	}
+	r.last = t
+}
+
+// FPS is the rate frames have been arriving at, smoothed over roughly the
+// last ten.
+func (r *Rate) FPS() float64 {
+	return r.fps
+}
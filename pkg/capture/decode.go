@@ -0,0 +1,75 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// Decoders accepted by Open. Hardware decoders only handle H.264, which
+// nearly every IP camera sends.
+const (
+	DecodeSoftware = ""        // whatever OpenCV picks, usually FFmpeg on the CPU
+	DecodeV4L2M2M  = "v4l2m2m" // the Raspberry Pi's decoder, through FFmpeg
+	DecodeVAAPI    = "vaapi"   // Intel and AMD GPUs, through GStreamer
+)
+
+// ffmpegOptions is read by OpenCV whenever the FFmpeg backend opens a
+// source, so is shared by every camera. Set it only while opening.
+const ffmpegOptions = "OPENCV_FFMPEG_CAPTURE_OPTIONS"
+
+var ffmpegOptionsMu sync.Mutex
+
+func openDecoder(url string, decode string) (*gocv.VideoCapture, error) {
+	switch decode {
+	case DecodeSoftware:
+		return gocv.VideoCaptureFile(url)
+	case DecodeV4L2M2M:
+		return openFFmpeg(url, "video_codec;h264_v4l2m2m")
+	case DecodeVAAPI:
+		uri, err := sourceURI(url)
+		if err != nil {
+			return nil, err
+		}
+		pipeline := fmt.Sprintf("urisourcebin uri=%s ! parsebin ! vaapih264dec ! videoconvert ! video/x-raw,format=BGR ! appsink sync=false", uri)
+		return gocv.VideoCaptureFileWithAPI(pipeline, gocv.VideoCaptureGstreamer)
+	}
+	return nil, fmt.Errorf("unknown decoder %q", decode)
+}
+
+// openFFmpeg opens url with the FFmpeg backend given options, in its
+// key;value|key;value form.
+func openFFmpeg(url string, options string) (*gocv.VideoCapture, error) {
+	ffmpegOptionsMu.Lock()
+	defer ffmpegOptionsMu.Unlock()
+
+	prev, set := os.LookupEnv(ffmpegOptions)
+	if set && prev != "" {
+		options = prev + "|" + options
+	}
+	os.Setenv(ffmpegOptions, options)
+	defer func() {
+		if set {
+			os.Setenv(ffmpegOptions, prev)
+		} else {
+			os.Unsetenv(ffmpegOptions)
+		}
+	}()
+	return gocv.VideoCaptureFileWithAPI(url, gocv.VideoCaptureFFmpeg)
+}
+
+// sourceURI is url as GStreamer expects it, files given as file:// URIs.
+func sourceURI(url string) (string, error) {
+	if strings.Contains(url, "://") {
+		return url, nil
+	}
+	path, err := filepath.Abs(url)
+	if err != nil {
+		return "", err
+	}
+	return "file://" + path, nil
+}
@@ -0,0 +1,140 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errNoEXIF = errors.New("no EXIF date")
+
+const (
+	exifIFDTag      = 0x8769 // pointer from IFD0 to the EXIF IFD
+	dateOriginalTag = 0x9003 // DateTimeOriginal, "2006:01:02 15:04:05"
+	subSecTag       = 0x9291 // SubSecTimeOriginal, fractional seconds digits
+)
+
+// exifTime reads when a JPEG was taken from its EXIF DateTimeOriginal, with
+// SubSecTimeOriginal when the camera records it. EXIF has no time zone, the
+// camera's clock is taken to be local time.
+func exifTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	tiff, err := exifSegment(bufio.NewReader(f))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(tiff) < 8 {
+		return time.Time{}, errNoEXIF
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errNoEXIF
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:]))
+	exif, ok := ifd0[exifIFDTag]
+	if !ok {
+		return time.Time{}, errNoEXIF
+	}
+	tags := readIFD(tiff, order, order.Uint32(exif.value))
+	date := exifString(tiff, order, tags[dateOriginalTag])
+	at, err := time.ParseInLocation("2006:01:02 15:04:05", date, time.Local)
+	if err != nil {
+		return time.Time{}, errNoEXIF
+	}
+	if sub := exifString(tiff, order, tags[subSecTag]); sub != "" && len(sub) <= 9 {
+		if ns, err := strconv.Atoi(sub + strings.Repeat("0", 9-len(sub))); err == nil {
+			at = at.Add(time.Duration(ns))
+		}
+	}
+	return at, nil
+}
+
+// exifSegment returns the TIFF data of a JPEG's APP1 EXIF segment.
+func exifSegment(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		return nil, errNoEXIF
+	}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil || header[0] != 0xff {
+			return nil, errNoEXIF
+		}
+		marker := header[1]
+		if marker == 0xda || marker == 0xd9 {
+			// image data, EXIF comes before it
+			return nil, errNoEXIF
+		}
+		size := int(binary.BigEndian.Uint16(header[2:])) - 2
+		if size < 0 {
+			return nil, errNoEXIF
+		}
+		segment := make([]byte, size)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, errNoEXIF
+		}
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// ifdEntry is a tag's count and its value, or the offset of its value when
+// it doesn't fit in four bytes.
+type ifdEntry struct {
+	count uint32
+	value []byte
+}
+
+// readIFD returns the entries of the IFD at offset by tag.
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16]ifdEntry {
+	entries := make(map[uint16]ifdEntry)
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return entries
+	}
+	n := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < n; i++ {
+		at := int(offset) + 2 + 12*i
+		if at+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[at:])] = ifdEntry{count: order.Uint32(tiff[at+4:]), value: tiff[at+8 : at+12]}
+	}
+	return entries
+}
+
+// exifString reads an ASCII value, stored in the entry itself when short
+// enough, as SubSecTimeOriginal often is.
+func exifString(tiff []byte, order binary.ByteOrder, entry ifdEntry) string {
+	s := entry.value
+	if entry.count > 4 {
+		offset := uint64(order.Uint32(entry.value))
+		if offset+uint64(entry.count) > uint64(len(tiff)) {
+			return ""
+		}
+		s = tiff[offset : offset+uint64(entry.count)]
+	} else if entry.value != nil {
+		s = s[:entry.count]
+	}
+	if end := bytes.IndexByte(s, 0); end >= 0 {
+		s = s[:end]
+	}
+	return strings.TrimSpace(string(s))
+}
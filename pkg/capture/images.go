@@ -0,0 +1,128 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"gocv.io/x/gocv"
+)
+
+// imageExts are the still images an image sequence is read from.
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".bmp": true, ".tif": true, ".tiff": true}
+
+// still is one image of a sequence and when it was taken.
+type still struct {
+	path string
+	at   time.Time
+}
+
+// imageSource delivers a directory of still images in the order they were
+// taken, stamped with when they were taken rather than when they are read.
+type imageSource struct {
+	stills []still
+	next   int
+}
+
+// IsImageSequence reports whether url is a directory, read as a sequence of
+// still images by OpenImages.
+func IsImageSequence(url string) bool {
+	info, err := os.Stat(url)
+	return err == nil && info.IsDir()
+}
+
+// OpenImages reads the still images in dir, e.g. from a trail cam or frames
+// dumped from a recording, as a source. When each was taken comes from its
+// file name, either a date and time such as 20240601_143005_250.jpg or unix
+// seconds, milliseconds or microseconds, or failing that from its EXIF
+// DateTimeOriginal. Images are delivered as fast as they are read, timing is
+// only from their timestamps.
+func OpenImages(dir string) (Source, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var stills []still
+	for _, entry := range entries {
+		if entry.IsDir() || !imageExts[strings.ToLower(filepath.Ext(entry.Name()))] {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		at, ok := nameTime(entry.Name())
+		if !ok {
+			if at, err = exifTime(path); err != nil {
+				return nil, fmt.Errorf("no timestamp in the name or EXIF of %s: %s", path, err)
+			}
+		}
+		stills = append(stills, still{path: path, at: at})
+	}
+	if len(stills) == 0 {
+		return nil, fmt.Errorf("no images in %s", dir)
+	}
+
+	sort.SliceStable(stills, func(i, j int) bool {
+		return stills[i].at.Before(stills[j].at)
+	})
+	return &imageSource{stills: stills}, nil
+}
+
+// nameTime reads a timestamp from the digits of a file name, ignoring any
+// separators between them.
+func nameTime(name string) (time.Time, bool) {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, strings.TrimSuffix(name, filepath.Ext(name)))
+
+	if len(digits) >= 14 {
+		if at, err := time.ParseInLocation("20060102150405", digits[:14], time.Local); err == nil && at.Year() >= 1970 && at.Year() < 2100 {
+			if frac := digits[14:]; frac != "" && len(frac) <= 9 {
+				ns, _ := strconv.Atoi(frac + strings.Repeat("0", 9-len(frac)))
+				at = at.Add(time.Duration(ns))
+			}
+			return at, true
+		}
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch len(digits) {
+	case 10:
+		return time.Unix(n, 0), true
+	case 13:
+		return time.UnixMilli(n), true
+	case 16:
+		return time.UnixMicro(n), true
+	}
+	return time.Time{}, false
+}
+
+func (s *imageSource) NextFrame(img *gocv.Mat) (Frame, error) {
+	if s.next >= len(s.stills) {
+		return Frame{}, io.EOF
+	}
+	still := s.stills[s.next]
+	s.next++
+
+	// an unreadable image comes back empty and is skipped like any empty
+	// frame
+	read := gocv.IMRead(still.path, gocv.IMReadColor)
+	defer read.Close()
+	read.CopyTo(img)
+	return Frame{Time: still.at}, nil
+}
+
+func (s *imageSource) Close() error {
+	return nil
+}
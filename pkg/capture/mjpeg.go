@@ -0,0 +1,79 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+var errNotMJPEG = errors.New("not an MJPEG stream")
+
+// mjpegClient gives up on a camera that doesn't answer, but never on a
+// stream once it is flowing.
+var mjpegClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+		ResponseHeaderTimeout: 10 * time.Second,
+	},
+}
+
+// mjpegSource reads the JPEG parts of a multipart/x-mixed-replace response,
+// as many cheap IP cameras serve, without going through FFmpeg.
+type mjpegSource struct {
+	body  io.Closer
+	parts *multipart.Reader
+}
+
+// OpenMJPEG opens an MJPEG stream served over HTTP.
+func OpenMJPEG(url string) (Source, error) {
+	resp, err := mjpegClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/x-mixed-replace" || params["boundary"] == "" {
+		resp.Body.Close()
+		return nil, errNotMJPEG
+	}
+	// some cameras include the leading dashes in the boundary parameter
+	boundary := strings.TrimPrefix(params["boundary"], "--")
+	return &mjpegSource{body: resp.Body, parts: multipart.NewReader(resp.Body, boundary)}, nil
+}
+
+func (s *mjpegSource) NextFrame(img *gocv.Mat) (Frame, error) {
+	part, err := s.parts.NextPart()
+	if err != nil {
+		return Frame{}, io.EOF
+	}
+	buf, err := io.ReadAll(part)
+	if err != nil {
+		return Frame{}, io.EOF
+	}
+	at := time.Now()
+
+	// a corrupt part decodes empty, and is skipped like any empty frame
+	decoded, err := gocv.IMDecode(buf, gocv.IMReadColor)
+	if err != nil {
+		decoded = gocv.NewMat()
+	}
+	defer decoded.Close()
+	decoded.CopyTo(img)
+	return Frame{Time: at}, nil
+}
+
+func (s *mjpegSource) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,42 @@
+package capture
+
+import (
+	"io"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// Mock is a Source delivering Frames in order from memory, Interval apart
+// from Start, then io.EOF, so the pipeline can be tested without video IO.
+// An empty Mat in Frames is delivered as an empty frame.
+type Mock struct {
+	Frames   []gocv.Mat
+	Interval time.Duration // zero for 30fps
+	Start    time.Time     // of the first frame, zero for when it is read
+
+	next int
+}
+
+func (m *Mock) NextFrame(img *gocv.Mat) (Frame, error) {
+	if m.next >= len(m.Frames) {
+		return Frame{}, io.EOF
+	}
+	if m.Start.IsZero() {
+		m.Start = time.Now()
+	}
+	interval := m.Interval
+	if interval == 0 {
+		interval = time.Second / 30
+	}
+
+	m.Frames[m.next].CopyTo(img)
+	at := m.Start.Add(time.Duration(m.next) * interval)
+	m.next++
+	return Frame{Time: at}, nil
+}
+
+// Close leaves Frames open, they belong to whoever made the Mock.
+func (m *Mock) Close() error {
+	return nil
+}
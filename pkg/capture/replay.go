@@ -0,0 +1,83 @@
+package capture
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// Replay is how OpenReplay plays a recording back.
+type Replay struct {
+	Start time.Duration // into the recording to seek to
+	End   time.Duration // into the recording to stop at, zero for its end
+	Rate  float64       // times its native frame rate to play at, zero for as fast as frames can be read
+	Epoch time.Time     // the start of the recording is stamped as, zero for when it's opened less Start
+}
+
+// replaySource reads a recording, stamping frames with their position in it
+// rather than the time they are read, so speeds are right however fast it
+// is played.
+type replaySource struct {
+	cap    *gocv.VideoCapture
+	replay Replay
+	fps    float64 // native, zero when the container doesn't say
+	read   int     // frames read since Start
+	first  time.Duration
+	began  time.Time // when the first frame was delivered, for pacing
+}
+
+// IsRecording reports whether url is a video file.
+func IsRecording(url string) bool {
+	info, err := os.Stat(url)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// OpenReplay opens the recording at path, decoding it with decode, and plays
+// it back as replay says.
+func OpenReplay(path string, decode string, replay Replay) (Source, error) {
+	cap, err := openVideoCapture(path, decode)
+	if err != nil {
+		return nil, err
+	}
+	if replay.Epoch.IsZero() {
+		replay.Epoch = time.Now().Add(-replay.Start)
+	}
+	if replay.Start > 0 {
+		cap.Set(gocv.VideoCapturePosMsec, float64(replay.Start)/float64(time.Millisecond))
+	}
+	return &replaySource{cap: cap, replay: replay, fps: cap.Get(gocv.VideoCaptureFPS)}, nil
+}
+
+func (s *replaySource) NextFrame(img *gocv.Mat) (Frame, error) {
+	if ok := s.cap.Read(img); !ok {
+		return Frame{}, io.EOF
+	}
+
+	// some backends don't report a position, count frames at the native
+	// rate instead
+	pos := time.Duration(s.cap.Get(gocv.VideoCapturePosMsec) * float64(time.Millisecond))
+	if pos <= 0 && s.fps > 0 {
+		pos = s.replay.Start + time.Duration(float64(s.read)*float64(time.Second)/s.fps)
+	}
+	if s.replay.End > 0 && pos > s.replay.End {
+		return Frame{}, io.EOF
+	}
+
+	if s.read == 0 {
+		s.first = pos
+		s.began = time.Now()
+	} else if s.replay.Rate > 0 {
+		due := s.began.Add(time.Duration(float64(pos-s.first) / s.replay.Rate))
+		if wait := time.Until(due); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	s.read++
+	return Frame{Time: s.replay.Epoch.Add(pos)}, nil
+}
+
+func (s *replaySource) Close() error {
+	return closeCapture(s.cap)
+}
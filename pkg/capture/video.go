@@ -0,0 +1,75 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/resources"
+	"gocv.io/x/gocv"
+)
+
+// videoSource reads frames through OpenCV's video IO.
+type videoSource struct {
+	cap *gocv.VideoCapture
+}
+
+// OpenVideo opens a video file or stream URL with OpenCV, decoding it with
+// decode, one of the Decode constants. If a hardware decoder can't open it,
+// it is decoded in software instead.
+func OpenVideo(url string, decode string) (Source, error) {
+	cap, err := openVideoCapture(url, decode)
+	if err != nil {
+		return nil, err
+	}
+	return &videoSource{cap: cap}, nil
+}
+
+func openVideoCapture(url string, decode string) (*gocv.VideoCapture, error) {
+	cap, err := openDecoder(url, decode)
+	if err != nil && decode != DecodeSoftware {
+		fmt.Printf("Failed to open %s with %s decode, decoding in software - %s\n", url, decode, err)
+		cap, err = openDecoder(url, DecodeSoftware)
+	}
+	if err == nil {
+		resources.Captures.Open(cap)
+	}
+	return cap, err
+}
+
+func closeCapture(cap *gocv.VideoCapture) error {
+	resources.Captures.Close(cap)
+	return cap.Close()
+}
+
+// OpenDevice opens a local camera by index, e.g. 0 for /dev/video0.
+func OpenDevice(index int) (Source, error) {
+	cap, err := gocv.VideoCaptureDevice(index)
+	if err != nil {
+		return nil, err
+	}
+	resources.Captures.Open(cap)
+	return &videoSource{cap: cap}, nil
+}
+
+// OpenPipeline opens a GStreamer pipeline ending in appsink as it is, any
+// decoding is up to the pipeline.
+func OpenPipeline(pipeline string) (Source, error) {
+	cap, err := gocv.VideoCaptureFileWithAPI(pipeline, gocv.VideoCaptureGstreamer)
+	if err != nil {
+		return nil, err
+	}
+	resources.Captures.Open(cap)
+	return &videoSource{cap: cap}, nil
+}
+
+func (s *videoSource) NextFrame(img *gocv.Mat) (Frame, error) {
+	if ok := s.cap.Read(img); !ok {
+		return Frame{}, io.EOF
+	}
+	return Frame{Time: time.Now()}, nil
+}
+
+func (s *videoSource) Close() error {
+	return closeCapture(s.cap)
+}
@@ -0,0 +1,143 @@
+// Package classify labels vehicles with an SSD style detection network,
+// e.g. OpenVINO's vehicle-detection models or a MobileNet SSD, on the
+// inference backend and device configured.
+package classify
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"gocv.io/x/gocv"
+)
+
+// backends and targets are the DNN_BACKEND and DNN_TARGET values accepted.
+var backends = map[string]gocv.NetBackendType{
+	"default":  gocv.NetBackendDefault,
+	"opencv":   gocv.NetBackendOpenCV,
+	"openvino": gocv.NetBackendOpenVINO,
+	"cuda":     gocv.NetBackendCUDA,
+}
+
+var targets = map[string]gocv.NetTargetType{
+	"cpu":         gocv.NetTargetCPU,
+	"opencl":      gocv.NetTargetFP32, // Intel iGPU
+	"opencl_fp16": gocv.NetTargetFP16,
+	"myriad":      gocv.NetTargetVPU, // Neural Compute Stick
+	"cuda":        gocv.NetTargetCUDA,
+	"cuda_fp16":   gocv.NetTargetCUDAFP16,
+}
+
+// Classifier runs the network over a vehicle's evidence image and takes the
+// label of the most confident detection. It is safe for concurrent use, so
+// one network can serve every camera, but runs one image at a time.
+type Classifier struct {
+	Labels        []string // by class id, ids without one are labelled by number
+	MinConfidence float32
+	InputSize     image.Point
+
+	mu  sync.Mutex // the net holds each input until it's run forward
+	net gocv.Net
+}
+
+// New loads the network in CLASSIFY_MODEL, returning nil if it isn't set.
+// CLASSIFY_CONFIG is the network description for formats that keep it
+// separately, e.g. the .xml of an OpenVINO IR model, and CLASSIFY_LABELS a
+// file of class names one per line.
+func New() (*Classifier, error) {
+	model := os.Getenv("CLASSIFY_MODEL")
+	if model == "" {
+		return nil, nil
+	}
+
+	backend, ok := backends[config.Env("DNN_BACKEND", "default")]
+	if !ok {
+		return nil, fmt.Errorf("DNN_BACKEND: unknown backend %q", os.Getenv("DNN_BACKEND"))
+	}
+	target, ok := targets[config.Env("DNN_TARGET", "cpu")]
+	if !ok {
+		return nil, fmt.Errorf("DNN_TARGET: unknown target %q", os.Getenv("DNN_TARGET"))
+	}
+	size, err := strconv.Atoi(config.Env("CLASSIFY_INPUT_SIZE", "300"))
+	if err != nil || size <= 0 {
+		return nil, fmt.Errorf("CLASSIFY_INPUT_SIZE: invalid size %q", os.Getenv("CLASSIFY_INPUT_SIZE"))
+	}
+	confidence, err := strconv.ParseFloat(config.Env("CLASSIFY_CONFIDENCE", "0.5"), 32)
+	if err != nil || confidence < 0 || confidence > 1 {
+		return nil, fmt.Errorf("CLASSIFY_CONFIDENCE: invalid confidence %q", os.Getenv("CLASSIFY_CONFIDENCE"))
+	}
+
+	var labels []string
+	if path := os.Getenv("CLASSIFY_LABELS"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading labels: %s", err)
+		}
+		labels = strings.Split(strings.TrimSpace(string(b)), "\n")
+		for i := range labels {
+			labels[i] = strings.TrimSpace(labels[i])
+		}
+	}
+
+	net := gocv.ReadNet(model, os.Getenv("CLASSIFY_CONFIG"))
+	if net.Empty() {
+		return nil, fmt.Errorf("reading network from %s", model)
+	}
+	if err := net.SetPreferableBackend(backend); err != nil {
+		net.Close()
+		return nil, fmt.Errorf("setting DNN backend: %s", err)
+	}
+	if err := net.SetPreferableTarget(target); err != nil {
+		net.Close()
+		return nil, fmt.Errorf("setting DNN target: %s", err)
+	}
+
+	return &Classifier{
+		Labels:        labels,
+		MinConfidence: float32(confidence),
+		InputSize:     image.Pt(size, size),
+		net:           net,
+	}, nil
+}
+
+// Classify returns the label of the most confident detection in img, empty
+// if nothing reached MinConfidence.
+func (c *Classifier) Classify(img gocv.Mat) string {
+	blob := gocv.BlobFromImage(img, 1.0, c.InputSize, gocv.NewScalar(0, 0, 0, 0), false, false)
+	defer blob.Close()
+
+	c.mu.Lock()
+	c.net.SetInput(blob, "")
+	out := c.net.Forward("")
+	c.mu.Unlock()
+	defer out.Close()
+
+	// SSD output is 1x1xNx7, each row image id, class id, confidence and box
+	rows := out.Reshape(1, out.Total()/7)
+	defer rows.Close()
+
+	best, label := c.MinConfidence, -1
+	for i := 0; i < rows.Rows(); i++ {
+		if conf := rows.GetFloatAt(i, 2); conf >= best {
+			best, label = conf, int(rows.GetFloatAt(i, 1))
+		}
+	}
+
+	switch {
+	case label < 0:
+		return ""
+	case label < len(c.Labels):
+		return c.Labels[label]
+	}
+	return strconv.Itoa(label)
+}
+
+func (c *Classifier) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.net.Close()
+}
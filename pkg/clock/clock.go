@@ -0,0 +1,168 @@
+// Package clock checks the system clock against NTP. Every timestamp and
+// speed depends on it, and a Raspberry Pi without a real-time clock starts
+// from wherever it was when it last shut down and drifts from there.
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+// queryTimeout is how long an NTP server has to answer.
+const queryTimeout = 5 * time.Second
+
+// ntpEpoch is the seconds from NTP's epoch, 1900, to Unix's.
+const ntpEpoch = 2208988800
+
+// Query asks the NTP server, host or host:port, for the time, returning how
+// far the system clock is behind it, negative when it's ahead.
+func Query(server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	conn, err := net.DialTimeout("udp", server, queryTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	// an SNTP request, version 4 in client mode, everything else zero
+	req := make([]byte, 48)
+	req[0] = 4<<3 | 3
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	if n < len(resp) {
+		return 0, fmt.Errorf("short response from %s", server)
+	}
+	if mode := resp[0] & 7; mode != 4 {
+		return 0, fmt.Errorf("response from %s isn't from a server, mode %d", server, mode)
+	}
+	if stratum := resp[1]; stratum == 0 || stratum > 15 {
+		return 0, fmt.Errorf("%s is unsynchronised or refused the request, stratum %d", server, stratum)
+	}
+
+	// the server's receive and transmit times either side of the round trip
+	serverReceived, serverSent := ntpTime(resp[32:40]), ntpTime(resp[40:48])
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// ntpTime reads a 64 bit NTP timestamp, seconds and a binary fraction.
+func ntpTime(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint32(b[:4]))
+	frac := int64(binary.BigEndian.Uint32(b[4:]))
+	return time.Unix(sec-ntpEpoch, frac*int64(time.Second)>>32)
+}
+
+// Checker checks the clock against Server every Interval, and while it's
+// more than MaxDrift out events are flagged as time-suspect.
+type Checker struct {
+	Server   string
+	Interval time.Duration
+	MaxDrift time.Duration
+
+	suspect int32 // atomic, 1 while out
+	mu      sync.Mutex
+	offset  time.Duration
+	checked time.Time
+}
+
+// NewChecker returns a checker of NTP_SERVER, default pool.ntp.org, every
+// CLOCK_CHECK_INTERVAL, default 1h, allowing CLOCK_MAX_DRIFT, default 1s.
+// It returns nil when NTP_SERVER is off, e.g. on an isolated network.
+func NewChecker() (*Checker, error) {
+	c := &Checker{Server: config.Env("NTP_SERVER", "pool.ntp.org")}
+	if c.Server == "off" {
+		return nil, nil
+	}
+	var err error
+	if c.Interval, err = time.ParseDuration(config.Env("CLOCK_CHECK_INTERVAL", "1h")); err != nil || c.Interval <= 0 {
+		return nil, fmt.Errorf("CLOCK_CHECK_INTERVAL: invalid duration %q", config.Env("CLOCK_CHECK_INTERVAL", ""))
+	}
+	if c.MaxDrift, err = time.ParseDuration(config.Env("CLOCK_MAX_DRIFT", "1s")); err != nil || c.MaxDrift <= 0 {
+		return nil, fmt.Errorf("CLOCK_MAX_DRIFT: invalid duration %q", config.Env("CLOCK_MAX_DRIFT", ""))
+	}
+	return c, nil
+}
+
+// Suspect reports whether the clock was out by more than MaxDrift when last
+// checked. It's false for a nil Checker.
+func (c *Checker) Suspect() bool {
+	return c != nil && atomic.LoadInt32(&c.suspect) == 1
+}
+
+// Offset is how far the clock was behind Server when last checked, and
+// when that was, zero if it never has been.
+func (c *Checker) Offset() (time.Duration, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.offset, c.checked
+}
+
+// Check queries Server, calling onDrift when the clock has gone out by more
+// than MaxDrift. The flag stays as it was when Server can't be reached.
+func (c *Checker) Check(onDrift func(offset time.Duration)) error {
+	offset, err := Query(c.Server)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.offset, c.checked = offset, time.Now()
+	c.mu.Unlock()
+	metrics.ClockOffset.Set(offset.Seconds())
+
+	out := offset > c.MaxDrift || offset < -c.MaxDrift
+	var now int32
+	if out {
+		now = 1
+	}
+	was := atomic.SwapInt32(&c.suspect, now) == 1
+	switch {
+	case out && !was:
+		fmt.Printf("Clock is %s, events are flagged as time-suspect until it's corrected\n", c.Describe(offset))
+		onDrift(offset)
+	case !out && was:
+		fmt.Printf("Clock is back within %s of %s\n", c.MaxDrift, c.Server)
+	}
+	return nil
+}
+
+// Describe words offset, e.g. "2.5s behind pool.ntp.org".
+func (c *Checker) Describe(offset time.Duration) string {
+	if offset < 0 {
+		return fmt.Sprintf("%s ahead of %s", (-offset).Round(time.Millisecond), c.Server)
+	}
+	return fmt.Sprintf("%s behind %s", offset.Round(time.Millisecond), c.Server)
+}
+
+// Run checks the clock every Interval until ctx is done.
+func (c *Checker) Run(ctx context.Context, onDrift func(offset time.Duration)) {
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Check(onDrift); err != nil {
+				fmt.Printf("Failed to check the clock against %s, %s\n", c.Server, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CameraInfo is what's known about where a camera is installed, referenced
+// by its ID from events and used to label reports and dashboards.
+type CameraInfo struct {
+	ID          string  // empty when there is only one camera
+	Road        string  // e.g. "High Street"
+	PostedLimit float64 // mph, 0 if unknown
+	LeftLabel   string  // what vehicles heading left are, e.g. "northbound"
+	RightLabel  string
+	Latitude    float64 // 0, 0 if unknown
+	Longitude   float64
+}
+
+// Title names the camera for people, by its road where it's known.
+func (c CameraInfo) Title() string {
+	switch {
+	case c.Road != "" && c.ID != "":
+		return fmt.Sprintf("%s (%s)", c.Road, c.ID)
+	case c.Road != "":
+		return c.Road
+	case c.ID != "":
+		return "Camera " + c.ID
+	}
+	return "Traffic"
+}
+
+// DirectionLabel is how direction, "left" or "right", is known on the
+// camera's road, or direction itself without a label for it.
+func (c CameraInfo) DirectionLabel(direction string) string {
+	switch {
+	case strings.EqualFold(direction, "left") && c.LeftLabel != "":
+		return c.LeftLabel
+	case strings.EqualFold(direction, "right") && c.RightLabel != "":
+		return c.RightLabel
+	}
+	return direction
+}
+
+// HasLocation reports whether the camera's coordinates are known.
+func (c CameraInfo) HasLocation() bool {
+	return c.Latitude != 0 || c.Longitude != 0
+}
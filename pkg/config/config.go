@@ -0,0 +1,102 @@
+// Package config reads settings from the environment, every component is
+// configured through environment variables so the same binary runs unchanged
+// in a container or from env.sh.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	// zone data for images without /usr/share/zoneinfo
+	_ "time/tzdata"
+)
+
+// Profiles are named sets of defaults chosen with PROFILE, applied to
+// whatever the environment leaves unset.
+var Profiles = map[string]map[string]string{
+	// low-power keeps a Pi Zero 2 or Pi 3 running without overheating:
+	// hardware decoding, detection on a small frame at a capped rate, no
+	// correlation trackers, cheaper evidence JPEGs and few, slow stream
+	// viewers.
+	"low-power": {
+		"DECODE":             "v4l2m2m",
+		"DETECT_WIDTH":       "320",
+		"MAX_FPS":            "10",
+		"TRACKER":            "iou",
+		"JPEG_QUALITY":       "70",
+		"THERMAL_LIMIT":      "75",
+		"STREAM_MAX_FPS":     "2",
+		"MAX_STREAM_VIEWERS": "2",
+		"ADAPTIVE_QUALITY":   "true",
+	},
+}
+
+// Env returns the value of key, or the PROFILE default for it, or fallback
+// when neither is set.
+func Env(key string, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	if v, ok := Profiles[os.Getenv("PROFILE")][key]; ok {
+		return v
+	}
+	return fallback
+}
+
+// CheckProfile returns an error if PROFILE names no profile.
+func CheckProfile() error {
+	if name := os.Getenv("PROFILE"); name != "" {
+		if _, ok := Profiles[name]; !ok {
+			return fmt.Errorf("PROFILE: unknown profile %q", name)
+		}
+	}
+	return nil
+}
+
+// timezone is the name of the zone LoadTimezone set.
+var timezone string
+
+// LoadTimezone sets the local timezone from TIMEZONE, e.g. Europe/London,
+// when it's set. Stats are bucketed and reports run by local clock time,
+// daylight saving included, TZ is used when TIMEZONE isn't set.
+func LoadTimezone() error {
+	name := os.Getenv("TIMEZONE")
+	if name == "" {
+		return nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return fmt.Errorf("TIMEZONE: %s", err)
+	}
+	time.Local, timezone = loc, name
+	return nil
+}
+
+// Timezone names the local timezone times are given in, e.g. Europe/London,
+// from TIMEZONE, TZ or the system's /etc/localtime, so the UI can show
+// them as the camera sees them rather than in the browser's zone. It's
+// empty when the zone can't be named.
+func Timezone() string {
+	if timezone != "" {
+		return timezone
+	}
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" && !strings.HasPrefix(tz, "/") {
+		return tz
+	}
+	if target, err := os.Readlink("/etc/localtime"); err == nil {
+		if i := strings.Index(target, "zoneinfo/"); i >= 0 {
+			return target[i+len("zoneinfo/"):]
+		}
+	}
+	if b, err := os.ReadFile("/etc/timezone"); err == nil && len(bytes.TrimSpace(b)) > 0 {
+		return string(bytes.TrimSpace(b))
+	}
+	// without any zone information Go's local time is UTC
+	if _, offset := time.Now().Zone(); offset == 0 {
+		return "UTC"
+	}
+	return ""
+}
@@ -0,0 +1,19 @@
+package config
+
+import "os"
+
+// Device identifies this camera among a fleet, in every published event,
+// alert and heartbeat, on /metrics and in every API response.
+type Device struct {
+	ID   string // DEVICE_ID, the hostname by default
+	Name string // DEVICE_NAME, the ID by default
+	Site string // DEVICE_SITE, where it's installed
+}
+
+// LoadDevice reads the device's identity from the environment.
+func LoadDevice() Device {
+	hostname, _ := os.Hostname()
+	d := Device{ID: Env("DEVICE_ID", hostname), Site: Env("DEVICE_SITE", "")}
+	d.Name = Env("DEVICE_NAME", d.ID)
+	return d
+}
@@ -0,0 +1,331 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Remote configuration backends, chosen with CONFIG_BACKEND.
+const (
+	BackendHTTP   = "http"   // an env file, KEY=value lines as in env.sh, or a JSON object
+	BackendConsul = "consul" // Consul's KV store, a key per variable under CONFIG_PREFIX
+	BackendEtcd   = "etcd"   // etcd v3's KV store through its JSON gateway, likewise
+)
+
+// remoteTimeout is how long a fetch of the remote configuration can take.
+const remoteTimeout = 10 * time.Second
+
+var remoteKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// RemoteVariables are the only variables remote configuration can set, the
+// detection tuning. Anything else, commands run, credentials or where
+// configuration comes from, stays under local control, so whoever controls
+// or intercepts the remote source can't take over the camera.
+var RemoteVariables = map[string]bool{
+	"THRESHOLD":      true,
+	"MIN_AREA":       true,
+	"MIN_DISTANCE":   true,
+	"SPEED_LIMIT":    true,
+	"ALERT_COOLDOWN": true,
+	"DETECT_STRIDE":  true,
+}
+
+// Remote is configuration kept centrally for a fleet of cameras. Its
+// variables are set in the environment, over what's set locally, so they're
+// read like any others.
+type Remote struct {
+	URL      string
+	Backend  string
+	Prefix   string // of the keys in Consul or etcd, e.g. speedcam/front-street/
+	Token    string // sent as a bearer token, or Consul's ACL token
+	Interval time.Duration
+
+	client  *http.Client
+	mu      sync.Mutex
+	values  map[string]string
+	ignored map[string]bool // not RemoteVariables, only logged once
+}
+
+// NewRemote returns the remote configuration at CONFIG_URL, or nil when it
+// isn't set, fetched by CONFIG_BACKEND, default http, with CONFIG_PREFIX
+// and CONFIG_TOKEN, and polled for changes every CONFIG_INTERVAL, default
+// 1m. These are only read from the environment. CONFIG_URL must be https
+// unless it's a loopback address, e.g. a local Consul or etcd agent.
+func NewRemote() (*Remote, error) {
+	rawURL := os.Getenv("CONFIG_URL")
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("CONFIG_URL: %s", err)
+	}
+	if u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Hostname())) {
+		return nil, fmt.Errorf("CONFIG_URL: want https, or http to a loopback address, got %s", u.Redacted())
+	}
+	r := &Remote{
+		URL:     strings.TrimSuffix(rawURL, "/"),
+		Backend: os.Getenv("CONFIG_BACKEND"),
+		Prefix:  os.Getenv("CONFIG_PREFIX"),
+		Token:   os.Getenv("CONFIG_TOKEN"),
+		client:  &http.Client{Timeout: remoteTimeout},
+		ignored: map[string]bool{},
+	}
+	if r.Backend == "" {
+		r.Backend = BackendHTTP
+	}
+	if r.Backend != BackendHTTP && r.Backend != BackendConsul && r.Backend != BackendEtcd {
+		return nil, fmt.Errorf("CONFIG_BACKEND: want %s, %s or %s, got %q", BackendHTTP, BackendConsul, BackendEtcd, r.Backend)
+	}
+	interval := os.Getenv("CONFIG_INTERVAL")
+	if interval == "" {
+		interval = "1m"
+	}
+	if r.Interval, err = time.ParseDuration(interval); err != nil || r.Interval <= 0 {
+		return nil, fmt.Errorf("CONFIG_INTERVAL: invalid duration %q", interval)
+	}
+	return r, nil
+}
+
+// Apply fetches the configuration and sets it in the environment, returning
+// the names of the variables that changed since it was last applied.
+// Variables other than RemoteVariables are ignored.
+func (r *Remote) Apply() ([]string, error) {
+	values, err := r.fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for k := range values {
+		if RemoteVariables[k] {
+			continue
+		}
+		if !r.ignored[k] {
+			fmt.Printf("Ignoring %s from remote configuration, it can only be set locally\n", k)
+		}
+		r.ignored[k] = true
+		delete(values, k)
+	}
+	var changed []string
+	for k, v := range values {
+		if old, ok := r.values[k]; ok && old == v {
+			continue
+		}
+		if err := os.Setenv(k, v); err != nil {
+			return nil, err
+		}
+		changed = append(changed, k)
+	}
+	// a variable removed remotely keeps its value until the next start
+	for k := range r.values {
+		if _, ok := values[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	r.values = values
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// Watch applies the configuration every Interval, calling onChange with
+// the variables that changed. It never returns.
+func (r *Remote) Watch(onChange func(changed []string)) {
+	for range time.Tick(r.Interval) {
+		changed, err := r.Apply()
+		if err != nil {
+			fmt.Printf("Failed to fetch configuration from %s, %s\n", r.URL, err)
+			continue
+		}
+		if len(changed) > 0 {
+			onChange(changed)
+		}
+	}
+}
+
+func (r *Remote) fetch() (map[string]string, error) {
+	switch r.Backend {
+	case BackendConsul:
+		return r.fetchConsul()
+	case BackendEtcd:
+		return r.fetchEtcd()
+	}
+	body, err := r.get(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var raw map[string]interface{}
+		if err := json.Unmarshal(trimmed, &raw); err != nil {
+			return nil, fmt.Errorf("reading configuration: %s", err)
+		}
+		values := map[string]string{}
+		for k, v := range raw {
+			values[k] = fmt.Sprint(v)
+		}
+		return checkKeys(values)
+	}
+	return parseEnvFile(body)
+}
+
+func (r *Remote) fetchConsul() (map[string]string, error) {
+	body, err := r.get(r.URL + "/v1/kv/" + strings.TrimPrefix(r.Prefix, "/") + "?recurse")
+	if err != nil {
+		return nil, err
+	}
+	var kvs []struct {
+		Key   string
+		Value []byte // base64 in the JSON
+	}
+	if err := json.Unmarshal(body, &kvs); err != nil {
+		return nil, fmt.Errorf("reading Consul keys: %s", err)
+	}
+	values := map[string]string{}
+	for _, kv := range kvs {
+		if key := strings.TrimPrefix(kv.Key, strings.TrimPrefix(r.Prefix, "/")); remoteKeyPattern.MatchString(key) {
+			values[key] = string(kv.Value)
+		}
+	}
+	return values, nil
+}
+
+func (r *Remote) fetchEtcd() (map[string]string, error) {
+	// every key starting with the prefix, range_end being the prefix with
+	// its last byte incremented
+	end := []byte(r.Prefix)
+	if len(end) == 0 {
+		end = []byte{0}
+	} else {
+		end[len(end)-1]++
+	}
+	query, err := json.Marshal(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString([]byte(r.Prefix)),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, r.URL+"/v3/kv/range", bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	body, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Kvs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("reading etcd keys: %s", err)
+	}
+	values := map[string]string{}
+	for _, kv := range resp.Kvs {
+		if key := strings.TrimPrefix(string(kv.Key), r.Prefix); remoteKeyPattern.MatchString(key) {
+			values[key] = string(kv.Value)
+		}
+	}
+	return values, nil
+}
+
+func (r *Remote) get(u string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.do(req)
+}
+
+func (r *Remote) do(req *http.Request) ([]byte, error) {
+	if r.Token != "" {
+		switch r.Backend {
+		case BackendConsul:
+			req.Header.Set("X-Consul-Token", r.Token)
+		case BackendEtcd:
+			req.Header.Set("Authorization", r.Token)
+		default:
+			req.Header.Set("Authorization", "Bearer "+r.Token)
+		}
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// Consul answers 404 rather than an empty list when nothing is under the
+	// prefix
+	if resp.StatusCode == http.StatusNotFound && r.Backend == BackendConsul {
+		return []byte("[]"), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	return body, nil
+}
+
+// parseEnvFile reads KEY=value lines, ignoring blank lines and comments and
+// taking the export and quotes of a shell script like env.sh.
+func parseEnvFile(b []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		eq := strings.Index(line, "=")
+		if eq < 0 {
+			return nil, fmt.Errorf("line %d: want KEY=value", n)
+		}
+		k, v := line[:eq], strings.TrimSpace(line[eq+1:])
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		values[strings.TrimSpace(k)] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return checkKeys(values)
+}
+
+// isLoopback reports whether host is localhost or a loopback address.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func checkKeys(values map[string]string) (map[string]string, error) {
+	for k := range values {
+		if !remoteKeyPattern.MatchString(k) {
+			return nil, fmt.Errorf("invalid variable name %q", k)
+		}
+	}
+	return values, nil
+}
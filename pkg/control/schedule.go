@@ -0,0 +1,168 @@
+package control
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LimitSchedule is speed limits applying at times of day on some days of the
+// week, e.g. a school zone's, in place of the posted TuningValues.SpeedLimit.
+// The zero LimitSchedule always applies the posted limit.
+type LimitSchedule struct {
+	Rules  []LimitRule
+	Closed map[string]bool // dates, as 2006-01-02, no rule applies on, e.g. school holidays
+}
+
+// LimitRule is a speed limit over times of day on days of the week, in the
+// local timezone.
+type LimitRule struct {
+	Limit float64 // mph
+	Days  [7]bool // by time.Weekday
+	Times []TimeRange
+}
+
+// TimeRange is a time of day From up to To, as durations since midnight.
+type TimeRange struct {
+	From time.Duration
+	To   time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseLimitSchedule reads rules separated by semicolons, each a limit, the
+// days and the times it applies, e.g. "20 Mon-Fri 07:30-08:30 14:30-15:30",
+// and closed, dates and date ranges no rule applies on separated by commas,
+// e.g. "2026-12-21..2027-01-04,2027-02-15". Either can be empty.
+func ParseLimitSchedule(rules string, closed string) (LimitSchedule, error) {
+	var s LimitSchedule
+	for _, r := range strings.Split(rules, ";") {
+		if strings.TrimSpace(r) == "" {
+			continue
+		}
+		rule, err := parseLimitRule(r)
+		if err != nil {
+			return s, err
+		}
+		s.Rules = append(s.Rules, rule)
+	}
+
+	for _, d := range strings.Split(closed, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		first, last := d, d
+		if i := strings.Index(d, ".."); i >= 0 {
+			first, last = d[:i], d[i+2:]
+		}
+		from, err := time.Parse("2006-01-02", first)
+		if err != nil {
+			return s, fmt.Errorf("invalid date %q, want 2006-01-02", first)
+		}
+		to, err := time.Parse("2006-01-02", last)
+		if err != nil {
+			return s, fmt.Errorf("invalid date %q, want 2006-01-02", last)
+		}
+		if to.Before(from) {
+			return s, fmt.Errorf("invalid date range %q, ends before it starts", d)
+		}
+		if s.Closed == nil {
+			s.Closed = map[string]bool{}
+		}
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			s.Closed[day.Format("2006-01-02")] = true
+		}
+	}
+	return s, nil
+}
+
+func parseLimitRule(r string) (LimitRule, error) {
+	var rule LimitRule
+	fields := strings.Fields(r)
+	if len(fields) < 3 {
+		return rule, fmt.Errorf("invalid speed limit rule %q, want a limit, days and times, e.g. 20 Mon-Fri 07:30-08:30", strings.TrimSpace(r))
+	}
+
+	limit, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || limit <= 0 {
+		return rule, fmt.Errorf("invalid speed limit %q", fields[0])
+	}
+	rule.Limit = limit
+
+	for _, days := range strings.Split(fields[1], ",") {
+		first, last := days, days
+		if i := strings.Index(days, "-"); i >= 0 {
+			first, last = days[:i], days[i+1:]
+		}
+		from, ok := weekdays[strings.ToLower(first)]
+		to, ok2 := weekdays[strings.ToLower(last)]
+		if !ok || !ok2 {
+			return rule, fmt.Errorf("invalid days %q, want e.g. Mon-Fri or Sat,Sun", fields[1])
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			rule.Days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+
+	for _, times := range fields[2:] {
+		parts := strings.Split(times, "-")
+		if len(parts) != 2 {
+			return rule, fmt.Errorf("invalid times %q, want e.g. 07:30-08:30", times)
+		}
+		from, err := parseTimeOfDay(parts[0])
+		if err != nil {
+			return rule, err
+		}
+		to, err := parseTimeOfDay(parts[1])
+		if err != nil {
+			return rule, err
+		}
+		if to <= from {
+			return rule, fmt.Errorf("invalid times %q, ends before it starts", times)
+		}
+		rule.Times = append(rule.Times, TimeRange{From: from, To: to})
+	}
+	return rule, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want e.g. 07:30", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// LimitAt returns the speed limit at t, that of the first rule applying then
+// or posted if none does.
+func (s LimitSchedule) LimitAt(t time.Time, posted float64) float64 {
+	if len(s.Rules) == 0 {
+		return posted
+	}
+	t = t.In(time.Local)
+	if s.Closed[t.Format("2006-01-02")] {
+		return posted
+	}
+
+	// by the wall clock, so a rule keeps to its times across DST changes
+	tod := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	for _, rule := range s.Rules {
+		if !rule.Days[t.Weekday()] {
+			continue
+		}
+		for _, r := range rule.Times {
+			if tod >= r.From && tod < r.To {
+				return rule.Limit
+			}
+		}
+	}
+	return posted
+}
@@ -0,0 +1,194 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+)
+
+// TuningValues are the detection parameters that can be changed while
+// running. Defaults come from the environment, persisted changes in
+// TUNING_PATH override them on the next start.
+type TuningValues struct {
+	Threshold     float32 // foreground threshold, 0-255
+	MinArea       float64 // smallest contour area tracked, in pixels
+	MinDistance   float64 // shortest track timed, in feet
+	SpeedLimit    float64 // posted limit in mph, events above it are violations. 0 disables
+	AlertCooldown float64 // seconds after a speeding alert during which further ones are held back, 0 disables
+	DetectStride  int     // detect every Nth frame, trackers alone follow cars between
+}
+
+// DefaultTuning is used where the environment and TUNING_PATH set nothing.
+var DefaultTuning = TuningValues{Threshold: 25, MinArea: 3000, MinDistance: 60, DetectStride: 1}
+
+// TuningField describes a TuningValues field for the settings page, which
+// renders its form from GET /api/tuning/schema.
+type TuningField struct {
+	Name        string
+	Label       string
+	Unit        string
+	Description string
+	Min         float64
+	Max         float64 // 0 for no upper bound
+	Step        float64
+}
+
+var TuningSchema = []TuningField{
+	{Name: "Threshold", Label: "Foreground threshold", Description: "Difference from the background model needed to count a pixel as moving", Min: 0, Max: 255, Step: 1},
+	{Name: "MinArea", Label: "Minimum area", Unit: "px", Description: "Smaller moving blobs are ignored", Min: 0, Step: 100},
+	{Name: "MinDistance", Label: "Minimum distance", Unit: "ft", Description: "Shorter tracks are too noisy to time and are discarded", Min: 1, Step: 1},
+	{Name: "SpeedLimit", Label: "Speed limit", Unit: "mph", Description: "Events above this are violations, 0 disables", Min: 0, Step: 1},
+	{Name: "AlertCooldown", Label: "Alert cooldown", Unit: "s", Description: "Further speeding alerts are held back for this long after one is published, events still are, 0 disables", Min: 0, Step: 1},
+	{Name: "DetectStride", Label: "Detection stride", Unit: "frames", Description: "Run detection every this many frames, raise it when the camera outpaces the CPU", Min: 1, Step: 1},
+}
+
+// TuningPatch is a partial update, nil fields are left unchanged.
+type TuningPatch struct {
+	Threshold     *float32
+	MinArea       *float64
+	MinDistance   *float64
+	SpeedLimit    *float64
+	AlertCooldown *float64
+	DetectStride  *int
+}
+
+type Tuning struct {
+	Path string
+
+	mu     sync.RWMutex
+	values TuningValues
+}
+
+func NewTuning() (*Tuning, error) {
+	t := &Tuning{Path: config.Env("TUNING_PATH", "./tuning.json")}
+
+	envFloat := func(key string, fallback float64) (float64, error) {
+		v := config.Env(key, strconv.FormatFloat(fallback, 'f', -1, 64))
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid value %q", key, v)
+		}
+		return f, nil
+	}
+
+	threshold, err := envFloat("THRESHOLD", float64(DefaultTuning.Threshold))
+	if err != nil {
+		return nil, err
+	}
+	t.values.Threshold = float32(threshold)
+	if t.values.MinArea, err = envFloat("MIN_AREA", DefaultTuning.MinArea); err != nil {
+		return nil, err
+	}
+	if t.values.MinDistance, err = envFloat("MIN_DISTANCE", DefaultTuning.MinDistance); err != nil {
+		return nil, err
+	}
+	if t.values.SpeedLimit, err = envFloat("SPEED_LIMIT", DefaultTuning.SpeedLimit); err != nil {
+		return nil, err
+	}
+	if t.values.AlertCooldown, err = envFloat("ALERT_COOLDOWN", DefaultTuning.AlertCooldown); err != nil {
+		return nil, err
+	}
+	stride := config.Env("DETECT_STRIDE", strconv.Itoa(DefaultTuning.DetectStride))
+	if t.values.DetectStride, err = strconv.Atoi(stride); err != nil {
+		return nil, fmt.Errorf("DETECT_STRIDE: invalid value %q", stride)
+	}
+
+	b, err := os.ReadFile(t.Path)
+	if err == nil {
+		if err := json.Unmarshal(b, &t.values); err != nil {
+			return nil, fmt.Errorf("reading %s: %s", t.Path, err)
+		}
+		fmt.Printf("Loaded tuning from %s\n", t.Path)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err := t.values.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (v TuningValues) Validate() error {
+	switch {
+	case v.Threshold < 0 || v.Threshold > 255:
+		return errors.New("Threshold must be between 0 and 255")
+	case v.MinArea < 0:
+		return errors.New("MinArea must not be negative")
+	case v.MinDistance < 1:
+		return errors.New("MinDistance must be at least 1")
+	case v.SpeedLimit < 0:
+		return errors.New("SpeedLimit must not be negative")
+	case v.AlertCooldown < 0:
+		return errors.New("AlertCooldown must not be negative")
+	case v.DetectStride < 1:
+		return errors.New("DetectStride must be at least 1")
+	}
+	return nil
+}
+
+func (v TuningValues) Cooldown() time.Duration {
+	return time.Duration(v.AlertCooldown * float64(time.Second))
+}
+
+func (t *Tuning) Values() TuningValues {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.values
+}
+
+// Update applies patch immediately, writing the result to Path as well if
+// persist is set. Nothing changes if the result is invalid.
+func (t *Tuning) Update(patch TuningPatch, persist bool) (TuningValues, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v := t.values
+	if patch.Threshold != nil {
+		v.Threshold = *patch.Threshold
+	}
+	if patch.MinArea != nil {
+		v.MinArea = *patch.MinArea
+	}
+	if patch.MinDistance != nil {
+		v.MinDistance = *patch.MinDistance
+	}
+	if patch.SpeedLimit != nil {
+		v.SpeedLimit = *patch.SpeedLimit
+	}
+	if patch.AlertCooldown != nil {
+		v.AlertCooldown = *patch.AlertCooldown
+	}
+	if patch.DetectStride != nil {
+		v.DetectStride = *patch.DetectStride
+	}
+	if err := v.Validate(); err != nil {
+		return t.values, err
+	}
+
+	if persist {
+		if err := writeTuning(t.Path, v); err != nil {
+			return t.values, fmt.Errorf("persisting tuning: %s", err)
+		}
+	}
+	t.values = v
+	return v, nil
+}
+
+func writeTuning(path string, v TuningValues) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,78 @@
+package detect
+
+import (
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// Backends a Detector can run its per-frame image processing on.
+const (
+	BackendCPU  = "cpu"
+	BackendCUDA = "cuda" // needs a build with -tags cuda and a CUDA device
+)
+
+// backend is the background model and image operations a Detector runs
+// every frame.
+type backend interface {
+	name() string
+	subtract(img gocv.Mat, threshold float32, fg *gocv.Mat)
+	resize(src gocv.Mat, dst *gocv.Mat, size image.Point)
+	close()
+}
+
+func newBackend(name string) (backend, error) {
+	switch name {
+	case "", BackendCPU:
+		return newCPUBackend(), nil
+	case BackendCUDA:
+		b, err := newCUDABackend()
+		if err != nil {
+			fmt.Printf("CUDA unavailable, detecting on the CPU - %s\n", err)
+			return newCPUBackend(), nil
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown detection backend %q", name)
+}
+
+type cpuBackend struct {
+	mog2  gocv.BackgroundSubtractorMOG2
+	delta gocv.Mat
+}
+
+func newCPUBackend() *cpuBackend {
+	return &cpuBackend{
+		mog2:  gocv.NewBackgroundSubtractorMOG2(),
+		delta: gocv.NewMat(),
+	}
+}
+
+func (b *cpuBackend) name() string {
+	return BackendCPU
+}
+
+func (b *cpuBackend) subtract(img gocv.Mat, threshold float32, fg *gocv.Mat) {
+	// first phase of cleaning up image, obtain foreground only
+	b.mog2.Apply(img, &b.delta)
+
+	// remaining cleanup of the image to use for finding contours.
+	// first use threshold
+	gocv.Threshold(b.delta, fg, threshold, 255, gocv.ThresholdBinary)
+
+	gocv.MedianBlur(*fg, fg, 7)
+
+	// kernel := gocv.GetStructuringElement(gocv.MorphRect, image.Pt(10, 10))
+	// defer kernel.Close()
+	// gocv.Dilate(imgThresh, &imgThresh, kernel)
+}
+
+func (b *cpuBackend) resize(src gocv.Mat, dst *gocv.Mat, size image.Point) {
+	gocv.Resize(src, dst, size, 0, 0, gocv.InterpolationArea)
+}
+
+func (b *cpuBackend) close() {
+	b.mog2.Close()
+	b.delta.Close()
+}
@@ -0,0 +1,64 @@
+//go:build cuda
+
+package detect
+
+import (
+	"errors"
+	"image"
+
+	"gocv.io/x/gocv"
+	"gocv.io/x/gocv/cuda"
+)
+
+// cudaBackend keeps the background model on the GPU. Frames are uploaded
+// once per operation, the median blur still runs on the CPU as OpenCV has no
+// CUDA version of it.
+type cudaBackend struct {
+	mog2  cuda.BackgroundSubtractorMOG2
+	src   cuda.GpuMat
+	delta cuda.GpuMat
+	fg    cuda.GpuMat
+}
+
+func newCUDABackend() (backend, error) {
+	if cuda.GetCudaEnabledDeviceCount() == 0 {
+		return nil, errors.New("no CUDA device found")
+	}
+	return &cudaBackend{
+		mog2:  cuda.NewBackgroundSubtractorMOG2(),
+		src:   cuda.NewGpuMat(),
+		delta: cuda.NewGpuMat(),
+		fg:    cuda.NewGpuMat(),
+	}, nil
+}
+
+func (b *cudaBackend) name() string {
+	return BackendCUDA
+}
+
+func (b *cudaBackend) subtract(img gocv.Mat, threshold float32, fg *gocv.Mat) {
+	b.src.Upload(img)
+	b.mog2.Apply(b.src, &b.delta)
+	cuda.Threshold(b.delta, &b.fg, float64(threshold), 255, gocv.ThresholdBinary)
+	b.fg.Download(fg)
+
+	gocv.MedianBlur(*fg, fg, 7)
+}
+
+func (b *cudaBackend) resize(src gocv.Mat, dst *gocv.Mat, size image.Point) {
+	b.src.Upload(src)
+	cuda.Resize(b.src, &b.fg, size, 0, 0, cuda.InterpolationArea)
+	b.fg.Download(dst)
+}
+
+func (b *cudaBackend) close() {
+	b.mog2.Close()
+	b.src.Close()
+	b.delta.Close()
+	b.fg.Close()
+}
+
+// CUDADevices is the number of CUDA devices usable.
+func CUDADevices() int {
+	return cuda.GetCudaEnabledDeviceCount()
+}
@@ -0,0 +1,139 @@
+// Package detect finds moving vehicles in frames by background subtraction.
+package detect
+
+import (
+	"image"
+
+	"github.com/danhigham/speedcam/pkg/parallel"
+	"github.com/danhigham/speedcam/pkg/resources"
+	"gocv.io/x/gocv"
+)
+
+// RoadRegion is the part of the frame the road occupies, the tracking view
+// and evidence images are cropped to it.
+var RoadRegion = image.Rect(0, 0, 640, 190)
+
+// Detector keeps the background model between frames. Detect, Subtract and
+// Resize are not safe for concurrent use.
+type Detector struct {
+	Mask *BackgroundMask // nil to accept blobs anywhere in the frame
+
+	backend backend
+	thresh  gocv.Mat
+}
+
+// NewDetector prepares a detector running on the named backend, BackendCPU
+// or BackendCUDA. If the backend can't be used here it falls back to the
+// CPU, see Backend for which was chosen.
+func NewDetector(mask *BackgroundMask, backend string) (*Detector, error) {
+	b, err := newBackend(backend)
+	if err != nil {
+		return nil, err
+	}
+	return &Detector{
+		Mask:    mask,
+		backend: b,
+		thresh:  gocv.NewMat(),
+	}, nil
+}
+
+// Backend names the backend in use.
+func (d *Detector) Backend() string {
+	return d.backend.name()
+}
+
+// Detect adds img to the background model and returns the bounding boxes of
+// the moving blobs at least minArea in size that are on the road.
+func (d *Detector) Detect(img gocv.Mat, threshold float32, minArea float64) []image.Rectangle {
+	d.Subtract(img, threshold, &d.thresh)
+	return d.Find(d.thresh, minArea, 1)
+}
+
+// Subtract adds img to the background model and writes its thresholded
+// foreground mask to fg.
+func (d *Detector) Subtract(img gocv.Mat, threshold float32, fg *gocv.Mat) {
+	d.backend.subtract(img, threshold, fg)
+}
+
+// Resize scales src into dst at size.
+func (d *Detector) Resize(src gocv.Mat, dst *gocv.Mat, size image.Point) {
+	d.backend.resize(src, dst, size)
+}
+
+// Find returns the bounding boxes of the blobs in the foreground mask fg at
+// least minArea in size that are on the road. Unlike Subtract it keeps no
+// state, so can run alongside it on an earlier frame.
+//
+// scale is the size of fg relative to the full frame, minArea, the mask and
+// the boxes returned are all in full frame pixels.
+func (d *Detector) Find(fg gocv.Mat, minArea float64, scale float64) []image.Rectangle {
+	// now find contours
+	contours := gocv.FindContours(fg, gocv.RetrievalExternal, gocv.ChainApproxSimple)
+	defer contours.Close()
+	points := contours.ToPoints()
+
+	// filtered in parallel, a busy frame can have hundreds of specks
+	boxes := make([]image.Rectangle, len(points))
+	keep := make([]bool, len(points))
+	parallel.For(len(points), func(i int) {
+		pv := gocv.NewPointVectorFromPoints(scalePoints(points[i], 1/scale))
+		resources.PointVectors.Open(pv)
+		defer func() {
+			resources.PointVectors.Close(pv)
+			pv.Close()
+		}()
+
+		if gocv.ContourArea(pv) < minArea {
+			return
+		}
+		boxes[i] = gocv.BoundingRect(pv)
+		keep[i] = d.Mask == nil || d.Mask.ContainsBox(boxes[i])
+	})
+
+	var rects []image.Rectangle
+	for i, box := range boxes {
+		if keep[i] {
+			rects = append(rects, box)
+		}
+	}
+	return rects
+}
+
+func scalePoints(points []image.Point, s float64) []image.Point {
+	if s == 1 {
+		return points
+	}
+	scaled := make([]image.Point, len(points))
+	for i, pt := range points {
+		scaled[i] = image.Pt(int(float64(pt.X)*s), int(float64(pt.Y)*s))
+	}
+	return scaled
+}
+
+// Foreground is the thresholded foreground mask of the last frame detected,
+// empty before the first. It is overwritten by the next Detect.
+func (d *Detector) Foreground() gocv.Mat {
+	return d.thresh
+}
+
+func (d *Detector) Close() {
+	d.backend.close()
+	d.thresh.Close()
+}
+
+func IsTrackable(c []image.Point, minArea float64) bool {
+	pv := gocv.NewPointVectorFromPoints(c)
+	defer pv.Close()
+	area := gocv.ContourArea(pv)
+	return !(area < minArea)
+}
+
+func BoundingBoxes(contours [][]image.Point) []image.Rectangle {
+	var rects []image.Rectangle
+	for _, c := range contours {
+		pv := gocv.NewPointVectorFromPoints(c)
+		rects = append(rects, gocv.BoundingRect(pv))
+		pv.Close()
+	}
+	return rects
+}
@@ -0,0 +1,61 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// BackgroundMask is the calibration image marking where vehicles can be, any
+// non-black pixel is road.
+type BackgroundMask struct {
+	mask []gocv.Mat
+}
+
+func NewBackgroundMask(filename string) (*BackgroundMask, error) {
+	img := gocv.IMRead(filename, gocv.IMReadColor)
+	if img.Empty() {
+		return &BackgroundMask{}, errors.New(fmt.Sprintf("Error reading image from: %v", filename))
+	}
+
+	bm := &BackgroundMask{
+		mask: gocv.Split(img),
+	}
+	return bm, nil
+}
+
+// Loaded reports whether the mask image was read.
+func (bm BackgroundMask) Loaded() bool {
+	return len(bm.mask) > 0
+}
+
+// Size is the mask's width and height in pixels, which have to match the
+// frames', zero if it wasn't read.
+func (bm BackgroundMask) Size() image.Point {
+	if !bm.Loaded() {
+		return image.Point{}
+	}
+	return image.Pt(bm.mask[0].Cols(), bm.mask[0].Rows())
+}
+
+// Contains reports whether the centre of contour c is on the road.
+func (bm BackgroundMask) Contains(c []image.Point) bool {
+
+	pv := gocv.NewPointVectorFromPoints(c)
+	defer pv.Close()
+	return bm.ContainsBox(gocv.BoundingRect(pv))
+}
+
+// ContainsBox reports whether the centre of rect is on the road. It only
+// reads the mask, so is safe for concurrent use.
+func (bm BackgroundMask) ContainsBox(rect image.Rectangle) bool {
+	center := image.Pt((rect.Min.X*2+rect.Dx())/2, (rect.Min.Y*2+rect.Dy())/2)
+
+	maskR := bm.mask[0].GetUCharAt(center.Y, center.X)
+	maskG := bm.mask[1].GetUCharAt(center.Y, center.X)
+	maskB := bm.mask[2].GetUCharAt(center.Y, center.X)
+
+	return (maskR + maskG + maskB) > 0
+}
@@ -0,0 +1,15 @@
+//go:build !cuda
+
+package detect
+
+import "errors"
+
+func newCUDABackend() (backend, error) {
+	return nil, errors.New("not built with -tags cuda")
+}
+
+// CUDADevices is the number of CUDA devices usable, always 0 without
+// -tags cuda.
+func CUDADevices() int {
+	return 0
+}
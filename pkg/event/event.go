@@ -0,0 +1,64 @@
+// Package event holds the message produced for every timed vehicle, as
+// journalled and published.
+package event
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+type CarMessage struct {
+	ID          uuid.UUID
+	Camera      string // empty when there is only one camera
+	ImageURI    string
+	Speed       float64
+	Distance    float64
+	Direction   string
+	Class       string
+	Lane        string
+	SpeedLimit  float64
+	TimeStamp   time.Time
+	TimeSuspect bool   `json:",omitempty"` // the clock was off NTP by more than CLOCK_MAX_DRIFT when recorded
+	Device      string `json:",omitempty"` // the device's ID, set when published, see config.Device
+}
+
+// Alert reports a problem with the camera, or a vehicle needing attention,
+// published to the alerts queue.
+type Alert struct {
+	Camera    string // empty when there is only one camera
+	Kind      string // one of the Alert constants
+	Message   string
+	TimeStamp time.Time
+	Device    string // the device's ID, set when published
+}
+
+// Heartbeat is published to the heartbeats queue every HEARTBEAT_INTERVAL,
+// so a central consumer can tell which of a fleet of devices are up and
+// whether their cameras are delivering.
+type Heartbeat struct {
+	Device      string
+	Name        string
+	Site        string
+	TimeStamp   time.Time
+	Started     time.Time
+	Cameras     []CameraHeartbeat
+	Undelivered int // events more than a minute old not yet uploaded or published
+}
+
+// CameraHeartbeat is how a camera is doing at a heartbeat.
+type CameraHeartbeat struct {
+	Camera    string // empty when there is only one camera
+	FPS       float64
+	LastFrame time.Time // zero before the first frame
+}
+
+const (
+	AlertFeedFrozen         = "feed_frozen"         // no new frames, the source is being reopened
+	AlertSourceDisconnected = "source_disconnected" // the stream dropped and is being reopened
+	AlertSourceReconnected  = "source_reconnected"
+	AlertTrafficAnomaly     = "traffic_anomaly" // an hour's volume or speed strayed far from the usual for it
+	AlertRepeatOffender     = "repeat_offender" // a plate went over the limit too often, only with plate tracking opted into
+	AlertSpeeding           = "speeding"        // a vehicle went over its limit, at most one per ALERT_COOLDOWN
+	AlertClockDrift         = "clock_drift"     // the system clock is off NTP, events are flagged TimeSuspect
+)
@@ -0,0 +1,116 @@
+// Package evidence stores the image taken of each timed vehicle.
+package evidence
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/danhigham/speedcam/pkg/config"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Store keeps a local copy of every evidence image in the spool
+// directory until it has made it to S3, so uploads that fail during an outage
+// can be retried later by `speedcam repair`.
+type Store struct {
+	SpoolDir string
+	Bucket   string
+	client   *s3.S3
+}
+
+func New() (*Store, error) {
+	s3Config := &aws.Config{
+		Credentials:      credentials.NewStaticCredentials(os.Getenv("S3_KEY"), os.Getenv("S3_SECRET"), ""),
+		Endpoint:         aws.String(os.Getenv("S3_HOST")),
+		Region:           aws.String("us-east-1"),
+		DisableSSL:       aws.Bool(false),
+		S3ForcePathStyle: aws.Bool(true),
+	}
+	sess, err := session.NewSession(s3Config)
+	if err != nil {
+		return nil, err
+	}
+
+	es := &Store{
+		SpoolDir: config.Env("SPOOL_DIR", "./spool"),
+		Bucket:   os.Getenv("S3_BUCKET"),
+		client:   s3.New(sess),
+	}
+	if err := os.MkdirAll(es.SpoolDir, 0755); err != nil {
+		return nil, err
+	}
+	return es, nil
+}
+
+// Key is the name the image of event id is stored under.
+func Key(id uuid.UUID) string {
+	return fmt.Sprintf("%s.jpg", id.String())
+}
+
+func (es *Store) spoolPath(key string) string {
+	return filepath.Join(es.SpoolDir, key)
+}
+
+// Spool writes the encoded image to the local spool, returning its key.
+func (es *Store) Spool(id uuid.UUID, jpg []byte) (string, error) {
+	key := Key(id)
+	return key, os.WriteFile(es.spoolPath(key), jpg, 0644)
+}
+
+// Upload sends a spooled image to S3 and removes the local copy once it has
+// been accepted.
+func (es *Store) Upload(key string) error {
+	jpg, err := os.ReadFile(es.spoolPath(key))
+	if err != nil {
+		return err
+	}
+
+	_, err = es.client.PutObject(&s3.PutObjectInput{
+		Body:   bytes.NewReader(jpg),
+		Bucket: aws.String(es.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading to %s/%s: %s", es.Bucket, key, err)
+	}
+
+	return os.Remove(es.spoolPath(key))
+}
+
+// Spooled returns how many images are waiting in the spool for upload.
+func (es *Store) Spooled() (int, error) {
+	entries, err := os.ReadDir(es.SpoolDir)
+	return len(entries), err
+}
+
+// Check confirms the bucket is reachable with the configured credentials.
+func (es *Store) Check(ctx context.Context) error {
+	_, err := es.client.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(es.Bucket)})
+	return err
+}
+
+// Open returns the image for key, from the spool if it hasn't been uploaded
+// yet and from S3 otherwise.
+func (es *Store) Open(key string) (io.ReadCloser, error) {
+	f, err := os.Open(es.spoolPath(key))
+	if err == nil {
+		return f, nil
+	}
+
+	out, err := es.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(es.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
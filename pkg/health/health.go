@@ -0,0 +1,231 @@
+// Package health reports whether capture and the event sinks are working,
+// for uptime monitors and orchestrators.
+package health
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/evidence"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/publish"
+	"github.com/danhigham/speedcam/pkg/stream"
+)
+
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+	healthDown     = "down"
+
+	// frames older than this mean capture has stalled
+	maxFrameAge   = 10 * time.Second
+	healthTimeout = 5 * time.Second
+)
+
+type ComponentHealth struct {
+	Status string
+	Error  string `json:",omitempty"`
+}
+
+type CaptureHealth struct {
+	ComponentHealth
+	FPS          float64
+	LastFrameAge float64 // seconds, -1 before the first frame
+}
+
+type StorageHealth struct {
+	ComponentHealth
+	Spooled int // images waiting for upload
+}
+
+type PipelineHealth struct {
+	ComponentHealth
+	Backlog int // events more than a minute old not yet uploaded or published
+}
+
+type Report struct {
+	Status   string // the worst of the components
+	Capture  CaptureHealth
+	Cameras  map[string]CaptureHealth `json:",omitempty"` // capture of each camera when there is more than one, Capture being the worst
+	AMQP     ComponentHealth
+	Storage  StorageHealth
+	Pipeline PipelineHealth
+}
+
+// Camera is one capture source checked.
+type Camera struct {
+	ID   string
+	Mask *detect.BackgroundMask
+	Hub  *stream.FrameHub
+}
+
+// Checker serves /healthz for uptime monitors. It responds 503 when any
+// component is down and needs no credentials, so it reports status only and
+// never event data. Orchestrators should use /livez and /readyz instead.
+type Checker struct {
+	Cameras   []Camera
+	Publisher *publish.Publisher
+	Evidence  *evidence.Store
+	Journal   *journal.Journal
+}
+
+func captureHealth(hub *stream.FrameHub) CaptureHealth {
+	fps, last := hub.Stats()
+	capture := CaptureHealth{ComponentHealth: ComponentHealth{Status: healthOK}, FPS: fps, LastFrameAge: -1}
+	if last.IsZero() {
+		capture.Status, capture.Error = healthDown, "no frames received"
+	} else {
+		age := time.Since(last)
+		capture.LastFrameAge = age.Seconds()
+		if age > maxFrameAge {
+			capture.Status, capture.Error = healthDown, "capture has stalled"
+		}
+	}
+	return capture
+}
+
+func (h *Checker) Check(ctx context.Context) Report {
+	var report Report
+
+	report.Capture = CaptureHealth{ComponentHealth: ComponentHealth{Status: healthDown, Error: "no cameras"}, LastFrameAge: -1}
+	for i, c := range h.Cameras {
+		capture := captureHealth(c.Hub)
+		if i == 0 || worstHealth(report.Capture.Status, capture.Status) != report.Capture.Status {
+			report.Capture = capture
+		}
+		if len(h.Cameras) > 1 {
+			if report.Cameras == nil {
+				report.Cameras = make(map[string]CaptureHealth)
+			}
+			report.Cameras[c.ID] = capture
+		}
+	}
+
+	report.AMQP.Status = healthOK
+	if !h.Publisher.Connected() {
+		report.AMQP.Status, report.AMQP.Error = healthDown, "connection closed"
+	}
+
+	report.Storage.Status = healthOK
+	spooled, err := h.Evidence.Spooled()
+	report.Storage.Spooled = spooled
+	if err != nil {
+		report.Storage.Status, report.Storage.Error = healthDown, err.Error()
+	} else if err := h.Evidence.Check(ctx); err != nil {
+		// events are still spooled locally, so S3 being away isn't fatal
+		report.Storage.Status, report.Storage.Error = healthDegraded, err.Error()
+	}
+
+	report.Pipeline.Status = healthOK
+	backlog, err := h.Journal.UndeliveredCount(time.Minute)
+	report.Pipeline.Backlog = backlog
+	if err != nil {
+		report.Pipeline.Status, report.Pipeline.Error = healthDown, err.Error()
+	} else if backlog > 0 {
+		report.Pipeline.Status = healthDegraded
+	}
+
+	report.Status = worstHealth(report.Capture.Status, report.AMQP.Status, report.Storage.Status, report.Pipeline.Status)
+	return report
+}
+
+func worstHealth(statuses ...string) string {
+	worst := healthOK
+	for _, s := range statuses {
+		if s == healthDown {
+			return healthDown
+		}
+		if s == healthDegraded {
+			worst = healthDegraded
+		}
+	}
+	return worst
+}
+
+func (h *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	report := h.Check(ctx)
+	status := http.StatusOK
+	if report.Status == healthDown {
+		status = http.StatusServiceUnavailable
+	}
+	httpjson.Write(w, status, report)
+}
+
+type ReadinessReport struct {
+	Ready    bool
+	Checks   map[string]string // "ok" or why the component isn't ready
+	Degraded map[string]string `json:",omitempty"` // sinks not working, why, which don't affect readiness
+}
+
+// Ready reports whether the camera is delivering frames, calibration is
+// loaded and the journal can be read. Unlike liveness a failure here means
+// wait, restarting won't bring a camera back. The broker and S3 being away
+// are listed in Degraded but leave it ready, events are journalled and
+// delivered once they're back.
+func (h *Checker) Ready(ctx context.Context) ReadinessReport {
+	report := h.Check(ctx)
+	checks := map[string]string{
+		"capture":     report.Capture.Status,
+		"calibration": healthOK,
+		"journal":     healthOK,
+	}
+	if report.Capture.Error != "" {
+		checks["capture"] = report.Capture.Error
+	}
+	if report.Pipeline.Status == healthDown {
+		checks["journal"] = report.Pipeline.Error
+	}
+	for _, c := range h.Cameras {
+		if c.Mask == nil || !c.Mask.Loaded() {
+			checks["calibration"] = "background mask not loaded"
+			if c.ID != "" {
+				checks["calibration"] += " for camera " + c.ID
+			}
+		}
+	}
+
+	degraded := map[string]string{}
+	if report.AMQP.Status != healthOK {
+		degraded["amqp"] = report.AMQP.Error
+	}
+	if report.Storage.Status != healthOK {
+		degraded["storage"] = report.Storage.Error
+	}
+
+	ready := true
+	for _, c := range checks {
+		if c != healthOK {
+			ready = false
+		}
+	}
+	return ReadinessReport{Ready: ready, Checks: checks, Degraded: degraded}
+}
+
+// Register adds /healthz, /livez and /readyz. Liveness only shows the process
+// is still serving requests, so an orchestrator restarts it only when it is
+// truly wedged.
+func (h *Checker) Register(mux *http.ServeMux) {
+	mux.Handle("/healthz", h)
+
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		httpjson.Write(w, http.StatusOK, map[string]string{"Status": healthOK})
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+		defer cancel()
+
+		report := h.Ready(ctx)
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		httpjson.Write(w, status, report)
+	})
+}
@@ -0,0 +1,18 @@
+// Package httpjson writes the JSON responses shared by every HTTP endpoint.
+// Errors are always {"Error": "..."}.
+package httpjson
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+func Write(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func Error(w http.ResponseWriter, status int, msg string) {
+	Write(w, status, map[string]string{"Error": msg})
+}
@@ -0,0 +1,258 @@
+package journal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultADTCoverage is the least share of a day a camera has to have been
+// watching for the day to count towards average daily traffic.
+const DefaultADTCoverage = 0.95
+
+// Ways AADT is worked out.
+const (
+	AADTAASHTO  = "aashto"  // from a full year, averaging each month's day of week averages
+	AADTFactors = "factors" // from a shorter count, adjusted by SeasonalFactors
+)
+
+// SeasonalFactors adjust a day's count to an annual average, as published
+// by most state DOTs for their factor groups: a day in month m on weekday d
+// counts as its count times Month[m-1] times Weekday[d].
+type SeasonalFactors struct {
+	Month   [12]float64 // January first
+	Weekday [7]float64  // Sunday first
+}
+
+// DefaultSeasonalFactors leave counts as they are.
+var DefaultSeasonalFactors = SeasonalFactors{
+	Month:   [12]float64{1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1},
+	Weekday: [7]float64{1, 1, 1, 1, 1, 1, 1},
+}
+
+// ParseSeasonalFactors reads 12 monthly factors, January first, and 7 day of
+// week factors, Sunday first, each comma separated. Either can be empty to
+// leave those as 1.
+func ParseSeasonalFactors(month string, weekday string) (SeasonalFactors, error) {
+	f := DefaultSeasonalFactors
+	if err := parseFactors(month, f.Month[:], "monthly"); err != nil {
+		return f, err
+	}
+	if err := parseFactors(weekday, f.Weekday[:], "day of week"); err != nil {
+		return f, err
+	}
+	return f, nil
+}
+
+func parseFactors(s string, factors []float64, kind string) error {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != len(factors) {
+		return fmt.Errorf("want %d %s factors, got %d", len(factors), kind, len(parts))
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("invalid %s factor %q", kind, p)
+		}
+		factors[i] = v
+	}
+	return nil
+}
+
+// ADT is a camera's average daily traffic over a range, both ways, with the
+// annual average estimated from it.
+type ADT struct {
+	Camera      string
+	From        time.Time
+	To          time.Time
+	Days        int     // complete days averaged
+	ADT         float64 // vehicles a day
+	WeekdayADT  float64 // Monday to Friday, 0 without any
+	AADT        float64 // annual average daily traffic
+	AADTMethod  string  // AADTAASHTO or AADTFactors
+	DailyCounts []DayCount
+}
+
+// DayCount is a day's vehicles and how much of it the camera watched.
+// Excluded days, incomplete or tagged, don't count towards ADT.
+type DayCount struct {
+	Date     string // 2006-01-02
+	Vehicles int
+	Coverage float64 // share of the day watched
+	Excluded string  // why it was left out, empty if it wasn't
+}
+
+// ADT averages each camera's daily vehicle counts, timed or not, over the
+// days from the one filter.From falls in up to filter.To, leaving out days
+// the camera watched less than coverage of and days overlapping a range
+// tagged with any of filter.ExcludeTags, e.g. road works. Of the rest of the
+// filter only Camera applies. With a complete day in every month on every
+// day of the week AADT is worked out by AASHTO's method, otherwise by
+// adjusting each day with factors.
+func (j *Journal) ADT(filter Filter, coverage float64, factors SeasonalFactors) ([]ADT, error) {
+	from := periodStart(PeriodDay, filter.From)
+
+	type key struct {
+		camera string
+		day    int64
+	}
+	vehicles := map[key]int{}
+	observed := map[key]int64{}
+
+	query := `SELECT camera, hour, SUM(count) FROM vehicle_counts WHERE hour >= ? AND hour < ?`
+	args := []interface{}{toMillis(from), toMillis(filter.To)}
+	if filter.Camera != "" {
+		query += ` AND camera = ?`
+		args = append(args, filter.Camera)
+	}
+	rows, err := j.db.Query(query+` GROUP BY camera, hour`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var camera string
+		var hour int64
+		var n int
+		if err := rows.Scan(&camera, &hour, &n); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		vehicles[key{camera, toMillis(periodStart(PeriodDay, fromMillis(hour)))}] += n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT camera, hour, observed_ms FROM occupancy WHERE hour >= ? AND hour < ?`
+	if filter.Camera != "" {
+		query += ` AND camera = ?`
+	}
+	rows, err = j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var camera string
+		var hour, ms int64
+		if err := rows.Scan(&camera, &hour, &ms); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		observed[key{camera, toMillis(periodStart(PeriodDay, fromMillis(hour)))}] += ms
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var excluded []Tag
+	if len(filter.ExcludeTags) > 0 {
+		tags, err := j.Tags(from, filter.To)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tags {
+			for _, name := range filter.ExcludeTags {
+				if t.EventID == uuid.Nil && t.Name == name {
+					excluded = append(excluded, t)
+				}
+			}
+		}
+	}
+
+	cameras := map[string]bool{}
+	for k := range vehicles {
+		cameras[k.camera] = true
+	}
+	for k := range observed {
+		cameras[k.camera] = true
+	}
+	names := make([]string, 0, len(cameras))
+	for c := range cameras {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+
+	adts := []ADT{}
+	for _, camera := range names {
+		a := ADT{Camera: camera, From: from, To: filter.To, AADTMethod: AADTFactors, DailyCounts: []DayCount{}}
+		var total, weekdayTotal, adjusted float64
+		weekdays := 0
+		// AASHTO's month by day of week averages
+		var sums [12][7]float64
+		var counts [12][7]int
+
+		for day := from; day.Before(filter.To); day = nextPeriod(PeriodDay, day) {
+			end := nextPeriod(PeriodDay, day)
+			k := key{camera, toMillis(day)}
+			d := DayCount{
+				Date:     day.Format("2006-01-02"),
+				Vehicles: vehicles[k],
+				Coverage: float64(observed[k]) / float64(end.Sub(day).Milliseconds()),
+			}
+			switch {
+			case end.After(filter.To):
+				d.Excluded = "not over yet"
+			case d.Coverage < coverage:
+				d.Excluded = fmt.Sprintf("watched %.0f%% of the day", 100*d.Coverage)
+			default:
+				for _, t := range excluded {
+					if t.From.Before(end) && t.To.After(day) && (t.Camera == "" || t.Camera == camera) {
+						d.Excluded = "tagged " + t.Name
+						break
+					}
+				}
+			}
+			a.DailyCounts = append(a.DailyCounts, d)
+			if d.Excluded != "" {
+				continue
+			}
+
+			a.Days++
+			n := float64(d.Vehicles)
+			total += n
+			if wd := day.Weekday(); wd != time.Saturday && wd != time.Sunday {
+				weekdayTotal += n
+				weekdays++
+			}
+			adjusted += n * factors.Month[day.Month()-1] * factors.Weekday[day.Weekday()]
+			sums[day.Month()-1][day.Weekday()] += n
+			counts[day.Month()-1][day.Weekday()]++
+		}
+		if a.Days == 0 {
+			adts = append(adts, a)
+			continue
+		}
+
+		a.ADT = total / float64(a.Days)
+		if weekdays > 0 {
+			a.WeekdayADT = weekdayTotal / float64(weekdays)
+		}
+		a.AADT = adjusted / float64(a.Days)
+
+		full := true
+		aashto := 0.0
+		for m := range counts {
+			for d := range counts[m] {
+				if counts[m][d] == 0 {
+					full = false
+					continue
+				}
+				aashto += sums[m][d] / float64(counts[m][d])
+			}
+		}
+		if full {
+			a.AADT, a.AADTMethod = aashto/(12*7), AADTAASHTO
+		}
+		adts = append(adts, a)
+	}
+	return adts, nil
+}
@@ -0,0 +1,128 @@
+package journal
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// AnomalyBaselineWeeks is how many weeks before an hour its baseline is
+// taken from, the same hour of the same weekday in each.
+const AnomalyBaselineWeeks = 8
+
+// minBaselineWeeks is the fewest weeks of history a baseline needs to be
+// worth comparing with.
+const minBaselineWeeks = 3
+
+// minAnomalySpeedVehicles is the fewest vehicles an hour needs for its 85th
+// percentile speed to be compared.
+const minAnomalySpeedVehicles = 10
+
+// Anomaly is an hour's traffic past a camera straying from the same hour of
+// the week in the weeks before, e.g. a sudden drop in volume from a camera
+// problem or a spike from a detour.
+type Anomaly struct {
+	Camera   string
+	Start    time.Time // of the hour
+	Metric   string    // volume or p85_speed
+	Value    float64
+	Baseline float64 // mean of the same hour in earlier weeks
+	StdDev   float64
+	Z        float64 // standard deviations from Baseline, negative below it
+}
+
+func (a Anomaly) String() string {
+	what, unit := "volume", " vehicles"
+	if a.Metric == "p85_speed" {
+		what, unit = "85th percentile speed", " mph"
+	}
+	direction := "above"
+	if a.Z < 0 {
+		direction = "below"
+	}
+	return fmt.Sprintf("%s %s %.0f%s is %.1f standard deviations %s the usual %.0f%s",
+		a.Start.Format("Mon 15:04"), what, a.Value, unit, math.Abs(a.Z), direction, a.Baseline, unit)
+}
+
+// meanStdDev returns the mean and sample standard deviation of values.
+func meanStdDev(values []float64) (mean float64, stdDev float64) {
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		stdDev += (v - mean) * (v - mean)
+	}
+	if len(values) > 1 {
+		stdDev = math.Sqrt(stdDev / float64(len(values)-1))
+	}
+	return mean, stdDev
+}
+
+// hourRollup returns camera's rollup of the hour starting at start, with no
+// vehicles if there was no traffic.
+func (j *Journal) hourRollup(start time.Time, camera string) (Rollup, error) {
+	rollups, err := j.Rollups(PeriodHour, Filter{From: start, To: start.Add(time.Hour), Camera: camera})
+	if err != nil || len(rollups) == 0 {
+		return Rollup{Start: start, Camera: camera}, err
+	}
+	return rollups[0], nil
+}
+
+// Anomalies compares camera's traffic in the hour starting at start with the
+// same hour in the AnomalyBaselineWeeks before, returning its volume and
+// 85th percentile speed if either is more than z standard deviations from
+// the baseline. Weeks before camera's first event are left out of the
+// baseline, and without minBaselineWeeks of them nothing is compared.
+func (j *Journal) Anomalies(start time.Time, camera string, z float64) ([]Anomaly, error) {
+	var first sql.NullInt64
+	if err := j.db.QueryRow(`SELECT MIN(timestamp) FROM events WHERE camera = ?`, camera).Scan(&first); err != nil {
+		return nil, err
+	}
+	if !first.Valid {
+		return nil, nil
+	}
+
+	current, err := j.hourRollup(start, camera)
+	if err != nil {
+		return nil, err
+	}
+	var volumes, p85s []float64
+	for week := 1; week <= AnomalyBaselineWeeks; week++ {
+		hour := start.AddDate(0, 0, -7*week)
+		if hour.Before(fromMillis(first.Int64)) {
+			break
+		}
+		r, err := j.hourRollup(hour, camera)
+		if err != nil {
+			return nil, err
+		}
+		volumes = append(volumes, float64(r.Vehicles))
+		if r.Vehicles >= minAnomalySpeedVehicles {
+			p85s = append(p85s, r.P85Speed)
+		}
+	}
+	if len(volumes) < minBaselineWeeks {
+		return nil, nil
+	}
+
+	var anomalies []Anomaly
+	mean, stdDev := meanStdDev(volumes)
+	// counts vary by at least their square root, so a steady baseline
+	// doesn't make every small change an anomaly
+	spread := math.Max(stdDev, math.Sqrt(math.Max(mean, 1)))
+	if dz := (float64(current.Vehicles) - mean) / spread; math.Abs(dz) > z {
+		anomalies = append(anomalies, Anomaly{Camera: camera, Start: start, Metric: "volume", Value: float64(current.Vehicles), Baseline: mean, StdDev: stdDev, Z: dz})
+	}
+
+	if current.Vehicles >= minAnomalySpeedVehicles && len(p85s) >= minBaselineWeeks {
+		mean, stdDev := meanStdDev(p85s)
+		// and speeds by at least a mile an hour
+		spread := math.Max(stdDev, 1)
+		if dz := (current.P85Speed - mean) / spread; math.Abs(dz) > z {
+			anomalies = append(anomalies, Anomaly{Camera: camera, Start: start, Metric: "p85_speed", Value: current.P85Speed, Baseline: mean, StdDev: stdDev, Z: dz})
+		}
+	}
+	return anomalies, nil
+}
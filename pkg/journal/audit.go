@@ -0,0 +1,48 @@
+package journal
+
+import "time"
+
+// Audited actions, recorded whenever evidence leaves the device, is looked at
+// or is destroyed.
+const (
+	AuditDelete  = "delete"
+	AuditExport  = "export"
+	AuditAccess  = "access"
+	AuditRestore = "restore"
+)
+
+type AuditEntry struct {
+	Seq       int64
+	TimeStamp time.Time
+	Action    string
+	Actor     string
+	Subject   string
+	Detail    string
+}
+
+func (j *Journal) Audit(action string, actor string, subject string, detail string) error {
+	_, err := j.db.Exec(`INSERT INTO audit_log (timestamp, action, actor, subject, detail) VALUES (?, ?, ?, ?, ?)`,
+		toMillis(time.Now()), action, actor, subject, detail)
+	return err
+}
+
+func (j *Journal) AuditEntries(since time.Time) ([]AuditEntry, error) {
+	rows, err := j.db.Query(`SELECT seq, timestamp, action, actor, subject, detail FROM audit_log WHERE timestamp >= ? ORDER BY seq`,
+		toMillis(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var ts int64
+		if err := rows.Scan(&e.Seq, &ts, &e.Action, &e.Actor, &e.Subject, &e.Detail); err != nil {
+			return nil, err
+		}
+		e.TimeStamp = fromMillis(ts)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
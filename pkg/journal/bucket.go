@@ -0,0 +1,33 @@
+package journal
+
+import (
+	"fmt"
+	"time"
+)
+
+// BucketSizes are the bucket sizes offered for grouping by time, each
+// dividing a day evenly.
+var BucketSizes = []time.Duration{5 * time.Minute, 10 * time.Minute, 15 * time.Minute, 30 * time.Minute, time.Hour}
+
+// ParseBucket reads a bucket size such as 15m, one of BucketSizes.
+func ParseBucket(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		for _, size := range BucketSizes {
+			if d == size {
+				return d, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid bucket %q, one of 5m, 10m, 15m, 30m or 1h", s)
+}
+
+// BucketStart returns the start of the bucket of size t falls in, by t's
+// wall clock, sizes dividing the day from local midnight. So 07:00 starts a
+// bucket whatever the zone's offset from UTC, and the hour repeated when
+// the clocks go back gets buckets of its own rather than sharing the first.
+func BucketStart(t time.Time, size time.Duration) time.Time {
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second + time.Duration(t.Nanosecond())
+	return t.Add(-(sinceMidnight % size))
+}
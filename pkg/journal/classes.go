@@ -0,0 +1,58 @@
+package journal
+
+import "sort"
+
+// ClassShare is one vehicle class's share of traffic and of violations.
+type ClassShare struct {
+	Class          string // empty for vehicles that weren't classified
+	Vehicles       int
+	VolumeShare    float64 // percent of every class's vehicles
+	Violations     int
+	ViolationShare float64 // percent of every class's violations
+	MeanSpeed      float64
+	P85Speed       float64
+}
+
+// ClassBreakdown aggregates the events matching filter by class, largest
+// first, e.g. to show trucks are 12% of traffic but 40% of violations. The
+// filter's Class is ignored, sort and paging too.
+func (j *Journal) ClassBreakdown(filter Filter) ([]ClassShare, error) {
+	filter.Class, filter.Sort, filter.After, filter.Limit = "", "time", nil, 0
+	events, err := j.QueryEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	classes := map[string][]Event{}
+	violations := 0
+	for _, e := range events {
+		classes[e.Class] = append(classes[e.Class], e)
+		if e.IsViolation() {
+			violations++
+		}
+	}
+
+	shares := []ClassShare{}
+	for class, classEvents := range classes {
+		a := aggregate(classEvents)
+		s := ClassShare{
+			Class:       class,
+			Vehicles:    a.Vehicles,
+			VolumeShare: 100 * float64(a.Vehicles) / float64(len(events)),
+			Violations:  a.Violations,
+			MeanSpeed:   a.MeanSpeed,
+			P85Speed:    a.P85Speed,
+		}
+		if violations > 0 {
+			s.ViolationShare = 100 * float64(a.Violations) / float64(violations)
+		}
+		shares = append(shares, s)
+	}
+	sort.Slice(shares, func(a, b int) bool {
+		if shares[a].Vehicles != shares[b].Vehicles {
+			return shares[a].Vehicles > shares[b].Vehicles
+		}
+		return shares[a].Class < shares[b].Class
+	})
+	return shares, nil
+}
@@ -0,0 +1,58 @@
+package journal
+
+import "time"
+
+// VehicleCount is how many vehicles one camera saw going one way, of one
+// class, timed or not.
+type VehicleCount struct {
+	Camera    string
+	Direction string
+	Class     string
+	Count     int
+}
+
+// CountVehicle adds a vehicle seen by camera at at, going direction, to the
+// hourly counts.
+func (j *Journal) CountVehicle(camera string, at time.Time, direction string, class string) error {
+	_, err := j.db.Exec(`INSERT INTO vehicle_counts (camera, hour, direction, class, count) VALUES (?, ?, ?, ?, 1)
+		ON CONFLICT (camera, hour, direction, class) DO UPDATE SET count = count + 1`,
+		camera, toMillis(BucketStart(at, time.Hour)), direction, class)
+	return err
+}
+
+// VehicleCounts totals the vehicles counted in the hours starting from
+// filter.From up to filter.To, per camera, direction and class. Of the rest
+// of the filter only Camera, Direction and Class apply.
+func (j *Journal) VehicleCounts(filter Filter) ([]VehicleCount, error) {
+	query := `SELECT camera, direction, class, SUM(count) FROM vehicle_counts WHERE hour >= ? AND hour < ?`
+	args := []interface{}{toMillis(BucketStart(filter.From, time.Hour)), toMillis(filter.To)}
+	if filter.Camera != "" {
+		query += ` AND camera = ?`
+		args = append(args, filter.Camera)
+	}
+	if filter.Direction != "" {
+		query += ` AND direction = ?`
+		args = append(args, filter.Direction)
+	}
+	if filter.Class != "" {
+		query += ` AND class = ?`
+		args = append(args, filter.Class)
+	}
+	query += ` GROUP BY camera, direction, class ORDER BY camera, direction, class`
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := []VehicleCount{}
+	for rows.Next() {
+		var c VehicleCount
+		if err := rows.Scan(&c.Camera, &c.Direction, &c.Class, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
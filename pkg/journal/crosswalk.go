@@ -0,0 +1,96 @@
+package journal
+
+import (
+	"database/sql"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Yield is a vehicle reaching the crosswalk while a pedestrian was on it.
+type Yield struct {
+	ID              uuid.UUID
+	Camera          string
+	EventID         uuid.UUID // uuid.Nil if the vehicle wasn't timed
+	ImageURI        string    // the vehicle's evidence, empty without an event
+	TimeStamp       time.Time
+	VehicleClass    string
+	PedestrianClass string
+	ApproachSpeed   float64 // mph
+	MinSpeed        float64 // mph
+	Yielded         bool
+}
+
+// RecordYield journals a vehicle meeting a pedestrian at the crosswalk.
+func (j *Journal) RecordYield(y Yield) error {
+	eventID := ""
+	if y.EventID != uuid.Nil {
+		eventID = y.EventID.String()
+	}
+	_, err := j.db.Exec(`INSERT INTO crosswalk_yields (id, camera, event_id, timestamp, vehicle_class, pedestrian_class, approach_speed, min_speed, yielded)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		y.ID.String(), y.Camera, eventID, toMillis(y.TimeStamp), y.VehicleClass, y.PedestrianClass, y.ApproachSpeed, y.MinSpeed, y.Yielded)
+	return err
+}
+
+// YieldCounts returns how many vehicles met a pedestrian at the crosswalk in
+// filter's range and how many of them yielded. Of the rest of the filter
+// only Camera applies.
+func (j *Journal) YieldCounts(filter Filter) (met int, yielded int, err error) {
+	query := `SELECT COUNT(*), COALESCE(SUM(yielded), 0) FROM crosswalk_yields WHERE timestamp >= ? AND timestamp < ?`
+	args := []interface{}{toMillis(filter.From), toMillis(filter.To)}
+	if filter.Camera != "" {
+		query += ` AND camera = ?`
+		args = append(args, filter.Camera)
+	}
+	err = j.db.QueryRow(query, args...).Scan(&met, &yielded)
+	return met, yielded, err
+}
+
+// FailuresToYield returns the vehicles that didn't yield in filter's range,
+// newest first, with their evidence. Of the rest of the filter only Camera
+// and Limit apply.
+func (j *Journal) FailuresToYield(filter Filter) ([]Yield, error) {
+	query := `SELECT y.id, y.camera, y.event_id, e.image_uri, y.timestamp, y.vehicle_class, y.pedestrian_class, y.approach_speed, y.min_speed
+		FROM crosswalk_yields y LEFT JOIN events e ON e.id = y.event_id
+		WHERE NOT y.yielded AND y.timestamp >= ? AND y.timestamp < ?`
+	args := []interface{}{toMillis(filter.From), toMillis(filter.To)}
+	if filter.Camera != "" {
+		query += ` AND y.camera = ?`
+		args = append(args, filter.Camera)
+	}
+	query += ` ORDER BY y.timestamp DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failures := []Yield{}
+	for rows.Next() {
+		var y Yield
+		var id, eventID string
+		var imageURI sql.NullString
+		var at int64
+		if err := rows.Scan(&id, &y.Camera, &eventID, &imageURI, &at, &y.VehicleClass, &y.PedestrianClass, &y.ApproachSpeed, &y.MinSpeed); err != nil {
+			return nil, err
+		}
+		if y.ID, err = uuid.FromString(id); err != nil {
+			return nil, err
+		}
+		if eventID != "" {
+			if y.EventID, err = uuid.FromString(eventID); err != nil {
+				return nil, err
+			}
+		}
+		y.ImageURI = imageURI.String
+		y.TimeStamp = fromMillis(at)
+		failures = append(failures, y)
+	}
+	return failures, rows.Err()
+}
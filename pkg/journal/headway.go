@@ -0,0 +1,102 @@
+package journal
+
+import (
+	"sort"
+	"time"
+)
+
+// MaxHeadway is the longest gap between vehicles counted as one following
+// another, longer gaps are free flowing traffic.
+const MaxHeadway = 60 * time.Second
+
+// platoonSize is the fewest vehicles each following the one ahead closely
+// that make a platoon.
+const platoonSize = 3
+
+// HeadwayReport is the distribution of time gaps between successive vehicles
+// going the same way in the same lane.
+type HeadwayReport struct {
+	From      time.Time
+	To        time.Time
+	Direction string  `json:",omitempty"`
+	BinWidth  float64 // seconds
+	Close     float64 // seconds, gaps under it are tailgating
+	Gaps      int     // up to MaxHeadway
+	Mean      float64 // seconds
+	Median    float64 // seconds
+	Tailgates int     // gaps under Close
+	Platoons  int     // runs of 3 or more vehicles each under Close behind the one ahead
+	Bins      []HistogramBin
+}
+
+// Headways measures the gaps between successive vehicles in filter's range,
+// per camera, direction and lane, binned into binWidth second wide bins. Of
+// the rest of the filter only Camera, Direction and Lane apply, filtering
+// by anything else would leave gaps where vehicles were left out. Only
+// timed vehicles are journaled, so gaps behind vehicles that weren't are
+// overstated.
+func (j *Journal) Headways(filter Filter, binWidth float64, closeGap time.Duration) (HeadwayReport, error) {
+	h := HeadwayReport{From: filter.From, To: filter.To, Direction: filter.Direction, BinWidth: binWidth, Close: closeGap.Seconds()}
+	if MaxHeadway.Seconds()/binWidth > maxHistogramBins {
+		return h, ErrTooManyBins
+	}
+
+	events, err := j.QueryEvents(Filter{
+		From:        filter.From,
+		To:          filter.To,
+		Camera:      filter.Camera,
+		Direction:   filter.Direction,
+		Lane:        filter.Lane,
+		Tag:         filter.Tag,
+		ExcludeTags: filter.ExcludeTags,
+		Sort:        "time",
+	})
+	if err != nil {
+		return h, err
+	}
+
+	type stream struct{ camera, direction, lane string }
+	last := map[stream]time.Time{}
+	run := map[stream]int{} // vehicles in the current run of close followers
+	var gaps []float64
+	for _, e := range events {
+		s := stream{e.Camera, e.Direction, e.Lane}
+		prev, ok := last[s]
+		last[s] = e.TimeStamp
+		if !ok {
+			continue
+		}
+
+		gap := e.TimeStamp.Sub(prev)
+		if gap < closeGap {
+			h.Tailgates++
+			if run[s] == 0 {
+				run[s] = 1
+			}
+			run[s]++
+			if run[s] == platoonSize {
+				h.Platoons++
+			}
+		} else {
+			run[s] = 0
+		}
+		if gap <= MaxHeadway {
+			gaps = append(gaps, gap.Seconds())
+		}
+	}
+	if len(gaps) == 0 {
+		h.Bins = []HistogramBin{}
+		return h, nil
+	}
+
+	sort.Float64s(gaps)
+	sum := 0.0
+	for _, g := range gaps {
+		sum += g
+	}
+	h.Gaps = len(gaps)
+	h.Mean = sum / float64(len(gaps))
+	h.Median = percentile(gaps, 50)
+	h.Bins = histogram(gaps, binWidth)
+	return h, nil
+}
@@ -0,0 +1,458 @@
+// Package journal is the local SQLite record of every event and of the audit
+// log, the source of truth for stats and for retrying failed deliveries.
+package journal
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/event"
+	_ "github.com/mattn/go-sqlite3"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Migrations are applied in order of their numeric prefix, e.g.
+// 0002_add_upload_status.sql. Never edit a migration once it has shipped, add
+// a new one instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type Journal struct {
+	db *sql.DB
+}
+
+// Delivery states tracked separately for the evidence upload and the AMQP
+// publish of each event.
+const (
+	DeliveryPending = "pending"
+	DeliveryDone    = "done"
+	DeliveryFailed  = "failed"
+	DeliveryMuted   = "muted" // deliberately not published, never retried
+	DeliveryShed    = "shed"  // no evidence to upload, left out to keep up
+)
+
+type Event struct {
+	event.CarMessage
+	UploadStatus  string
+	PublishStatus string
+	LastError     string
+}
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func Open(filename string) (*Journal, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on", filename))
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only allows a single writer, serialise access here rather than
+	// handling SQLITE_BUSY everywhere
+	db.SetMaxOpenConns(1)
+
+	j := &Journal{db: db}
+	if err := j.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %s", filename, err)
+	}
+	return j, nil
+}
+
+// OpenExisting opens the journal in filename without migrating it, failing
+// if its schema isn't the one this build writes, for commands that mustn't
+// change a journal a running speedcam may be using.
+func OpenExisting(filename string) (*Journal, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on", filename))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		db.Close()
+		return nil, err
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	if latest := migrations[len(migrations)-1].Version; current != latest {
+		db.Close()
+		return nil, fmt.Errorf("%s is at schema version %d, this build expects %d", filename, current, latest)
+	}
+	return &Journal{db: db}, nil
+}
+
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, e := range entries {
+		prefix := strings.SplitN(e.Name(), "_", 2)[0]
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration name %s", e.Name())
+		}
+
+		b, err := migrationFiles.ReadFile(path.Join("migrations", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: e.Name(), SQL: string(b)})
+	}
+
+	sort.Slice(migrations, func(a, b int) bool { return migrations[a].Version < migrations[b].Version })
+	return migrations, nil
+}
+
+// migrate brings the schema up to date, applying each outstanding migration
+// in its own transaction so a failure leaves the journal at the last good
+// version.
+func (j *Journal) migrate() error {
+	_, err := j.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at INTEGER NOT NULL
+	)`)
+	if err != nil {
+		return err
+	}
+
+	var current int
+	err = j.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current)
+	if err != nil {
+		return err
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	if len(migrations) > 0 && current > migrations[len(migrations)-1].Version {
+		return fmt.Errorf("journal schema version %d is newer than this binary supports (%d)", current, migrations[len(migrations)-1].Version)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current {
+			continue
+		}
+
+		fmt.Printf("Applying journal migration %s\n", m.Name)
+
+		tx, err := j.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.SQL); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: %s", m.Name, err)
+		}
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`, m.Version, m.Name, time.Now().Unix())
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (j *Journal) RecordEvent(msg event.CarMessage) error {
+	_, err := j.db.Exec(`INSERT INTO events (id, camera, timestamp, speed, distance, direction, class, lane, speed_limit, time_suspect, image_uri, upload_status, publish_status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.ID.String(), msg.Camera, toMillis(msg.TimeStamp), msg.Speed, msg.Distance, msg.Direction, msg.Class, msg.Lane, msg.SpeedLimit, msg.TimeSuspect, msg.ImageURI, DeliveryPending, DeliveryPending)
+	return err
+}
+
+func (j *Journal) SetUploadStatus(id uuid.UUID, uploadErr error) error {
+	return j.setDeliveryStatus("upload_status", id, uploadErr)
+}
+
+func (j *Journal) SetPublishStatus(id uuid.UUID, publishErr error) error {
+	return j.setDeliveryStatus("publish_status", id, publishErr)
+}
+
+// SetUploadShed records that an event has no evidence to upload, it was
+// left out while catching up on a backlog.
+func (j *Journal) SetUploadShed(id uuid.UUID) error {
+	_, err := j.db.Exec(`UPDATE events SET upload_status = ? WHERE id = ?`, DeliveryShed, id.String())
+	return err
+}
+
+// SetUploadPending marks an event's upload as queued again after failing,
+// so it isn't retried twice while it waits.
+func (j *Journal) SetUploadPending(id uuid.UUID) error {
+	_, err := j.db.Exec(`UPDATE events SET upload_status = ? WHERE id = ?`, DeliveryPending, id.String())
+	return err
+}
+
+// SetPublishPending is SetUploadPending for the publish.
+func (j *Journal) SetPublishPending(id uuid.UUID) error {
+	_, err := j.db.Exec(`UPDATE events SET publish_status = ? WHERE id = ?`, DeliveryPending, id.String())
+	return err
+}
+
+func (j *Journal) SetPublishMuted(id uuid.UUID) error {
+	_, err := j.db.Exec(`UPDATE events SET publish_status = ? WHERE id = ?`, DeliveryMuted, id.String())
+	return err
+}
+
+func (j *Journal) setDeliveryStatus(column string, id uuid.UUID, deliveryErr error) error {
+	status := DeliveryDone
+	var lastError sql.NullString
+	if deliveryErr != nil {
+		status = DeliveryFailed
+		lastError = sql.NullString{String: deliveryErr.Error(), Valid: true}
+	}
+
+	// last_error is only overwritten on failure so a later successful
+	// publish doesn't hide why the upload failed
+	_, err := j.db.Exec(fmt.Sprintf(`UPDATE events SET %s = ?, last_error = COALESCE(?, last_error) WHERE id = ?`, column),
+		status, lastError, id.String())
+	return err
+}
+
+const eventColumns = `id, camera, timestamp, speed, distance, direction, class, lane, speed_limit, time_suspect, image_uri, upload_status, publish_status, COALESCE(last_error, '')`
+
+func scanEvents(rows *sql.Rows) ([]Event, error) {
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		var id string
+		var ts int64
+		err := rows.Scan(&id, &e.Camera, &ts, &e.Speed, &e.Distance, &e.Direction, &e.Class, &e.Lane, &e.SpeedLimit, &e.TimeSuspect, &e.ImageURI, &e.UploadStatus, &e.PublishStatus, &e.LastError)
+		if err != nil {
+			return nil, err
+		}
+		e.ID, err = uuid.FromString(id)
+		if err != nil {
+			return nil, err
+		}
+		e.TimeStamp = fromMillis(ts)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// UndeliveredEvents returns events older than minAge whose evidence upload or
+// publish is still pending or has failed, oldest first.
+func (j *Journal) UndeliveredEvents(minAge time.Duration) ([]Event, error) {
+	rows, err := j.db.Query(`SELECT `+eventColumns+`
+		FROM events
+		WHERE (upload_status IN (?, ?) OR publish_status IN (?, ?)) AND timestamp <= ?
+		ORDER BY timestamp`,
+		DeliveryPending, DeliveryFailed, DeliveryPending, DeliveryFailed, toMillis(time.Now().Add(-minAge)))
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// UndeliveredCount is the number of events UndeliveredEvents would return.
+func (j *Journal) UndeliveredCount(minAge time.Duration) (int, error) {
+	var n int
+	err := j.db.QueryRow(`SELECT COUNT(*)
+		FROM events
+		WHERE (upload_status IN (?, ?) OR publish_status IN (?, ?)) AND timestamp <= ?`,
+		DeliveryPending, DeliveryFailed, DeliveryPending, DeliveryFailed, toMillis(time.Now().Add(-minAge))).Scan(&n)
+	return n, err
+}
+
+// Sort orders accepted by QueryEvents, a leading "-" means descending.
+var Sorts = map[string]string{
+	"time":  "timestamp",
+	"speed": "speed",
+}
+
+type Filter struct {
+	From        time.Time
+	To          time.Time
+	MinSpeed    float64
+	Camera      string
+	Direction   string
+	Class       string
+	Lane        string
+	Violation   *bool    // nil for either
+	Tag         string   // only events with this tag
+	ExcludeTags []string // leave out events with any of these tags
+	Sort        string
+	After       *Cursor
+	Limit       int
+}
+
+// Cursor marks the last event of a page, the next page starts after it
+// in the filter's sort order.
+type Cursor struct {
+	Value float64
+	ID    string
+}
+
+func (e Event) IsViolation() bool {
+	return e.SpeedLimit > 0 && e.Speed > e.SpeedLimit
+}
+
+// QueryEvents returns events matching filter, newest first unless a sort is
+// given. Zero valued fields of the filter are ignored.
+func (j *Journal) QueryEvents(filter Filter) ([]Event, error) {
+	where := []string{"1 = 1"}
+	var args []interface{}
+
+	if !filter.From.IsZero() {
+		where = append(where, "timestamp >= ?")
+		args = append(args, toMillis(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "timestamp < ?")
+		args = append(args, toMillis(filter.To))
+	}
+	if filter.MinSpeed > 0 {
+		where = append(where, "speed >= ?")
+		args = append(args, filter.MinSpeed)
+	}
+	if filter.Camera != "" {
+		where = append(where, "camera = ?")
+		args = append(args, filter.Camera)
+	}
+	if filter.Direction != "" {
+		where = append(where, "direction = ?")
+		args = append(args, filter.Direction)
+	}
+	if filter.Class != "" {
+		where = append(where, "class = ?")
+		args = append(args, filter.Class)
+	}
+	if filter.Lane != "" {
+		where = append(where, "lane = ?")
+		args = append(args, filter.Lane)
+	}
+	if filter.Violation != nil {
+		if *filter.Violation {
+			where = append(where, "(speed_limit > 0 AND speed > speed_limit)")
+		} else {
+			where = append(where, "NOT (speed_limit > 0 AND speed > speed_limit)")
+		}
+	}
+
+	where, args = tagFilter(filter, where, args)
+
+	sort := filter.Sort
+	if sort == "" {
+		sort = "-time"
+	}
+	column, ok := Sorts[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		return nil, fmt.Errorf("unknown sort %s", sort)
+	}
+	direction, op := "ASC", ">"
+	if strings.HasPrefix(sort, "-") {
+		direction, op = "DESC", "<"
+	}
+
+	// keyset pagination, id breaks ties between equal sort values
+	if filter.After != nil {
+		where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND id %[2]s ?))", column, op))
+		args = append(args, filter.After.Value, filter.After.Value, filter.After.ID)
+	}
+
+	query := `SELECT ` + eventColumns + ` FROM events WHERE ` + strings.Join(where, " AND ") +
+		fmt.Sprintf(` ORDER BY %[1]s %[2]s, id %[2]s`, column, direction)
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanEvents(rows)
+}
+
+// CursorFor returns the cursor continuing after e in the given sort order.
+func (e Event) CursorFor(sort string) Cursor {
+	if strings.TrimPrefix(sort, "-") == "speed" {
+		return Cursor{Value: e.Speed, ID: e.ID.String()}
+	}
+	return Cursor{Value: float64(toMillis(e.TimeStamp)), ID: e.ID.String()}
+}
+
+func (j *Journal) GetEvent(id uuid.UUID) (Event, error) {
+	rows, err := j.db.Query(`SELECT `+eventColumns+` FROM events WHERE id = ?`, id.String())
+	if err != nil {
+		return Event{}, err
+	}
+	events, err := scanEvents(rows)
+	if err != nil {
+		return Event{}, err
+	}
+	if len(events) == 0 {
+		return Event{}, sql.ErrNoRows
+	}
+	return events[0], nil
+}
+
+// Snapshot writes a consistent copy of the journal in path to filename, safe
+// to take while detection is still writing events. The journal is opened
+// read only and isn't migrated, so a backup never changes what it copies.
+func Snapshot(path string, filename string) error {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", path))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`VACUUM INTO ?`, filename)
+	return err
+}
+
+// Lanes returns the lanes events have been journaled in by camera.
+func (j *Journal) Lanes() (map[string][]string, error) {
+	rows, err := j.db.Query(`SELECT DISTINCT camera, lane FROM events WHERE lane != '' ORDER BY camera, lane`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lanes := map[string][]string{}
+	for rows.Next() {
+		var camera, lane string
+		if err := rows.Scan(&camera, &lane); err != nil {
+			return nil, err
+		}
+		lanes[camera] = append(lanes[camera], lane)
+	}
+	return lanes, rows.Err()
+}
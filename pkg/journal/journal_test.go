@@ -0,0 +1,52 @@
+package journal
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotLeavesSourceUnmigrated(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "old.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE events (id TEXT PRIMARY KEY)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO events (id) VALUES ('a')`); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := filepath.Join(dir, "snapshot.db")
+	if err := Snapshot(path, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'schema_migrations'`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("snapshot migrated the journal it copied")
+	}
+	if _, err := OpenExisting(path); err == nil {
+		t.Error("OpenExisting opened a journal without migrations")
+	}
+
+	copied, err := sql.Open("sqlite3", snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	var n int
+	if err := copied.QueryRow(`SELECT COUNT(*) FROM events`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("snapshot holds %d events, want 1", n)
+	}
+}
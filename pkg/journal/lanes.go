@@ -0,0 +1,61 @@
+package journal
+
+import "sort"
+
+// LaneStats is one lane's traffic, with how much faster its 85th percentile
+// speed is than the slowest lane's.
+type LaneStats struct {
+	Lane          string // empty for vehicles outside every lane
+	Vehicles      int
+	MeanSpeed     float64
+	MedianSpeed   float64
+	P85Speed      float64
+	Violations    int
+	ViolationRate float64 // percent of Vehicles
+	P85Difference float64 // mph over the slowest lane's 85th percentile, 0 outside every lane
+	Compliance    Compliance
+}
+
+// LaneBreakdown aggregates the events matching filter by lane, ordered by
+// lane, e.g. to show the downhill lane's 85th percentile speed is 8 mph
+// higher than the uphill lane's. The filter's Lane is ignored, sort and
+// paging too.
+func (j *Journal) LaneBreakdown(filter Filter) ([]LaneStats, error) {
+	filter.Lane, filter.Sort, filter.After, filter.Limit = "", "time", nil, 0
+	events, err := j.QueryEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	lanes := map[string][]Event{}
+	for _, e := range events {
+		lanes[e.Lane] = append(lanes[e.Lane], e)
+	}
+
+	stats := []LaneStats{}
+	slowest := -1.0
+	for lane, laneEvents := range lanes {
+		a := aggregate(laneEvents)
+		s := LaneStats{
+			Lane:          lane,
+			Vehicles:      a.Vehicles,
+			MeanSpeed:     a.MeanSpeed,
+			MedianSpeed:   a.MedianSpeed,
+			P85Speed:      a.P85Speed,
+			Violations:    a.Violations,
+			ViolationRate: 100 * float64(a.Violations) / float64(a.Vehicles),
+			Compliance:    a.Compliance,
+		}
+		if lane != "" && (slowest < 0 || s.P85Speed < slowest) {
+			slowest = s.P85Speed
+		}
+		stats = append(stats, s)
+	}
+	for i := range stats {
+		if stats[i].Lane != "" {
+			stats[i].P85Difference = stats[i].P85Speed - slowest
+		}
+	}
+	sort.Slice(stats, func(a, b int) bool { return stats[a].Lane < stats[b].Lane })
+	return stats, nil
+}
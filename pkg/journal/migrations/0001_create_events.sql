@@ -0,0 +1,9 @@
+CREATE TABLE events (
+    id         TEXT PRIMARY KEY,
+    timestamp  INTEGER NOT NULL, -- unix milliseconds
+    speed      REAL NOT NULL,    -- mph
+    distance   REAL NOT NULL,    -- ft
+    image_uri  TEXT NOT NULL
+);
+
+CREATE INDEX events_timestamp ON events (timestamp);
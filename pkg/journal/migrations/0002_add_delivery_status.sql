@@ -0,0 +1,7 @@
+-- events recorded before delivery was tracked are left as 'unknown' and are
+-- never retried, their evidence was not spooled
+ALTER TABLE events ADD COLUMN upload_status TEXT NOT NULL DEFAULT 'unknown';
+ALTER TABLE events ADD COLUMN publish_status TEXT NOT NULL DEFAULT 'unknown';
+ALTER TABLE events ADD COLUMN last_error TEXT;
+
+CREATE INDEX events_undelivered ON events (upload_status, publish_status);
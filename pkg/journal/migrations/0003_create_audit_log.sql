@@ -0,0 +1,21 @@
+CREATE TABLE audit_log (
+    seq        INTEGER PRIMARY KEY AUTOINCREMENT,
+    timestamp  INTEGER NOT NULL, -- unix milliseconds
+    action     TEXT NOT NULL,
+    actor      TEXT NOT NULL,
+    subject    TEXT NOT NULL,
+    detail     TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_log_timestamp ON audit_log (timestamp);
+
+-- entries can never be changed or removed once written
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,3 @@
+ALTER TABLE events ADD COLUMN direction TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX events_direction_timestamp ON events (direction, timestamp);
@@ -0,0 +1,7 @@
+-- class and lane stay empty until classification and lane attribution exist,
+-- speed_limit is the limit in force when the event was recorded (0 if none)
+ALTER TABLE events ADD COLUMN class TEXT NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN lane TEXT NOT NULL DEFAULT '';
+ALTER TABLE events ADD COLUMN speed_limit REAL NOT NULL DEFAULT 0;
+
+CREATE INDEX events_speed ON events (speed, id);
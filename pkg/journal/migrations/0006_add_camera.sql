@@ -0,0 +1,4 @@
+-- camera stays empty for events from a single camera setup
+ALTER TABLE events ADD COLUMN camera TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX events_camera_timestamp ON events (camera, timestamp);
@@ -0,0 +1,11 @@
+-- every vehicle tracked across the frame, timed or not, counted per hour
+CREATE TABLE vehicle_counts (
+    camera     TEXT NOT NULL DEFAULT '',
+    hour       INTEGER NOT NULL, -- unix milliseconds of the start of the hour
+    direction  TEXT NOT NULL,
+    class      TEXT NOT NULL DEFAULT '',
+    count      INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (camera, hour, direction, class)
+);
+
+CREATE INDEX vehicle_counts_hour ON vehicle_counts (hour);
@@ -0,0 +1,12 @@
+-- events rolled up per camera into hourly and daily buckets, so stats over
+-- long ranges don't scan every event
+CREATE TABLE rollups (
+    period      TEXT NOT NULL,    -- hour or day
+    start       INTEGER NOT NULL, -- unix milliseconds of the start of the bucket
+    camera      TEXT NOT NULL DEFAULT '',
+    vehicles    INTEGER NOT NULL,
+    mean_speed  REAL NOT NULL,
+    p85_speed   REAL NOT NULL,
+    violations  INTEGER NOT NULL,
+    PRIMARY KEY (period, start, camera)
+);
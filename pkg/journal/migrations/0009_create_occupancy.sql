@@ -0,0 +1,10 @@
+-- how long each camera had vehicles in view, per hour
+CREATE TABLE occupancy (
+    camera        TEXT NOT NULL DEFAULT '',
+    hour          INTEGER NOT NULL, -- unix milliseconds of the start of the hour
+    observed_ms   INTEGER NOT NULL DEFAULT 0,
+    occupied_ms   INTEGER NOT NULL DEFAULT 0,
+    vehicle_ms    INTEGER NOT NULL DEFAULT 0, -- summed over every vehicle in view
+    max_vehicles  INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (camera, hour)
+);
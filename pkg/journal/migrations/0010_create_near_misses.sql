@@ -0,0 +1,13 @@
+-- vehicles coming close to, or on course to hit, pedestrians and cyclists
+CREATE TABLE near_misses (
+    id                TEXT PRIMARY KEY,
+    camera            TEXT NOT NULL DEFAULT '',
+    timestamp         INTEGER NOT NULL, -- unix milliseconds
+    vehicle_class     TEXT NOT NULL DEFAULT '',
+    vulnerable_class  TEXT NOT NULL,
+    vehicle_speed     REAL NOT NULL,
+    distance          REAL NOT NULL, -- feet
+    ttc_ms            INTEGER NOT NULL
+);
+
+CREATE INDEX near_misses_timestamp ON near_misses (timestamp);
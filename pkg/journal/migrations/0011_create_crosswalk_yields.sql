@@ -0,0 +1,14 @@
+-- vehicles reaching the crosswalk while someone was on it
+CREATE TABLE crosswalk_yields (
+    id                TEXT PRIMARY KEY,
+    camera            TEXT NOT NULL DEFAULT '',
+    event_id          TEXT NOT NULL DEFAULT '', -- empty when the vehicle wasn't timed
+    timestamp         INTEGER NOT NULL, -- unix milliseconds
+    vehicle_class     TEXT NOT NULL DEFAULT '',
+    pedestrian_class  TEXT NOT NULL,
+    approach_speed    REAL NOT NULL,
+    min_speed         REAL NOT NULL,
+    yielded           INTEGER NOT NULL
+);
+
+CREATE INDEX crosswalk_yields_timestamp ON crosswalk_yields (timestamp);
@@ -0,0 +1,14 @@
+-- tags on single events or on every event in a time range, e.g. roadworks
+CREATE TABLE tags (
+    id        TEXT PRIMARY KEY,
+    name      TEXT NOT NULL,
+    camera    TEXT NOT NULL DEFAULT '', -- empty for every camera
+    event_id  TEXT NOT NULL DEFAULT '', -- empty for a range
+    from_ms   INTEGER NOT NULL DEFAULT 0,
+    to_ms     INTEGER NOT NULL DEFAULT 0,
+    note      TEXT NOT NULL DEFAULT '',
+    created   INTEGER NOT NULL
+);
+
+CREATE INDEX tags_event_id ON tags (event_id);
+CREATE INDEX tags_range ON tags (from_ms, to_ms);
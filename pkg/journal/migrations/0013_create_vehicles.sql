@@ -0,0 +1,22 @@
+-- vehicles recognised by their colours, each timed event with a signature a
+-- sighting of one
+CREATE TABLE vehicles (
+    id          TEXT PRIMARY KEY,
+    camera      TEXT NOT NULL DEFAULT '',
+    class       TEXT NOT NULL DEFAULT '',
+    color       TEXT NOT NULL DEFAULT '',
+    signature   TEXT NOT NULL, -- mean of its sightings' signatures, comma separated
+    first_seen  INTEGER NOT NULL, -- unix milliseconds
+    last_seen   INTEGER NOT NULL,
+    sightings   INTEGER NOT NULL
+);
+
+CREATE INDEX vehicles_last_seen ON vehicles (camera, class, last_seen);
+
+CREATE TABLE sightings (
+    event_id    TEXT PRIMARY KEY,
+    vehicle_id  TEXT NOT NULL,
+    distance    REAL NOT NULL -- from the vehicle's signature when matched, 0 for its first
+);
+
+CREATE INDEX sightings_vehicle_id ON sightings (vehicle_id);
@@ -0,0 +1,14 @@
+-- plates read off violations, only with PLATE_TRACKING opted into and only
+-- kept for PLATE_RETENTION
+CREATE TABLE plate_violations (
+    event_id     TEXT PRIMARY KEY,
+    plate        TEXT NOT NULL,
+    camera       TEXT NOT NULL DEFAULT '',
+    timestamp    INTEGER NOT NULL, -- unix milliseconds
+    speed        REAL NOT NULL,
+    speed_limit  REAL NOT NULL,
+    confidence   REAL NOT NULL -- percent, as the plate reader gave it
+);
+
+CREATE INDEX plate_violations_plate ON plate_violations (plate, timestamp);
+CREATE INDEX plate_violations_timestamp ON plate_violations (timestamp);
@@ -0,0 +1,13 @@
+-- where in the frame vehicles were tracked and how fast, per camera, day
+-- and cell
+CREATE TABLE spatial_cells (
+    camera      TEXT NOT NULL DEFAULT '',
+    day         INTEGER NOT NULL, -- unix milliseconds of the start of the day
+    cell_size   INTEGER NOT NULL, -- pixels
+    x           INTEGER NOT NULL, -- column of cells
+    y           INTEGER NOT NULL, -- row of cells
+    detections  INTEGER NOT NULL DEFAULT 0,
+    timed       INTEGER NOT NULL DEFAULT 0,
+    speed_sum   REAL NOT NULL DEFAULT 0, -- mph, over timed
+    PRIMARY KEY (camera, day, cell_size, x, y)
+);
@@ -0,0 +1,5 @@
+-- the path each timed vehicle took across the frame
+CREATE TABLE event_tracks (
+    event_id  TEXT PRIMARY KEY,
+    points    TEXT NOT NULL -- x,y,ms since the first point, separated by ;
+);
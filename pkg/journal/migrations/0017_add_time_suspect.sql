@@ -0,0 +1,3 @@
+-- time_suspect is set on events recorded while the clock was off NTP by
+-- more than CLOCK_MAX_DRIFT
+ALTER TABLE events ADD COLUMN time_suspect INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,67 @@
+package journal
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// NearMiss is a vehicle coming close to, or on course to hit, a pedestrian
+// or cyclist.
+type NearMiss struct {
+	ID              uuid.UUID
+	Camera          string
+	TimeStamp       time.Time
+	VehicleClass    string
+	VulnerableClass string
+	VehicleSpeed    float64 // mph
+	Distance        float64 // feet, the closest they came
+	TTC             float64 // seconds to collision on their courses, 0 when they came within the near miss distance
+}
+
+// RecordNearMiss journals a near miss.
+func (j *Journal) RecordNearMiss(nm NearMiss) error {
+	_, err := j.db.Exec(`INSERT INTO near_misses (id, camera, timestamp, vehicle_class, vulnerable_class, vehicle_speed, distance, ttc_ms)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		nm.ID.String(), nm.Camera, toMillis(nm.TimeStamp), nm.VehicleClass, nm.VulnerableClass, nm.VehicleSpeed, nm.Distance, int64(nm.TTC*1000))
+	return err
+}
+
+// NearMisses returns the near misses in filter's range, newest first. Of the
+// rest of the filter only Camera and Limit apply.
+func (j *Journal) NearMisses(filter Filter) ([]NearMiss, error) {
+	query := `SELECT id, camera, timestamp, vehicle_class, vulnerable_class, vehicle_speed, distance, ttc_ms FROM near_misses WHERE timestamp >= ? AND timestamp < ?`
+	args := []interface{}{toMillis(filter.From), toMillis(filter.To)}
+	if filter.Camera != "" {
+		query += ` AND camera = ?`
+		args = append(args, filter.Camera)
+	}
+	query += ` ORDER BY timestamp DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	nearMisses := []NearMiss{}
+	for rows.Next() {
+		var nm NearMiss
+		var id string
+		var at, ttc int64
+		if err := rows.Scan(&id, &nm.Camera, &at, &nm.VehicleClass, &nm.VulnerableClass, &nm.VehicleSpeed, &nm.Distance, &ttc); err != nil {
+			return nil, err
+		}
+		if nm.ID, err = uuid.FromString(id); err != nil {
+			return nil, err
+		}
+		nm.TimeStamp = fromMillis(at)
+		nm.TTC = float64(ttc) / 1000
+		nearMisses = append(nearMisses, nm)
+	}
+	return nearMisses, rows.Err()
+}
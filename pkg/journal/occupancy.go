@@ -0,0 +1,137 @@
+package journal
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// OccupancyBucket is how busy a camera's view was over an hour or day.
+type OccupancyBucket struct {
+	Start          time.Time
+	Camera         string
+	Observed       float64 // seconds vehicles were being tracked for
+	Occupancy      float64 // percent of Observed with a vehicle in view
+	MeanVehicles   float64 // in view, over Observed
+	MaxVehicles    int     // in view at once
+	LevelOfService string  // see LevelOfService
+}
+
+// AddOccupancy adds an interval of camera's occupancy to the hour it started
+// in.
+func (j *Journal) AddOccupancy(camera string, start time.Time, observed time.Duration, occupied time.Duration, vehicleTime time.Duration, maxVehicles int) error {
+	_, err := j.db.Exec(`INSERT INTO occupancy (camera, hour, observed_ms, occupied_ms, vehicle_ms, max_vehicles) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (camera, hour) DO UPDATE SET
+			observed_ms = observed_ms + excluded.observed_ms,
+			occupied_ms = occupied_ms + excluded.occupied_ms,
+			vehicle_ms = vehicle_ms + excluded.vehicle_ms,
+			max_vehicles = MAX(max_vehicles, excluded.max_vehicles)`,
+		camera, toMillis(BucketStart(start, time.Hour)), observed.Milliseconds(), occupied.Milliseconds(), vehicleTime.Milliseconds(), maxVehicles)
+	return err
+}
+
+// LevelOfService grades occupancy, the percent of time a vehicle was in
+// view, from A, free flow, to F, breakdown. The thresholds roughly follow
+// the loop detector occupancies of the Highway Capacity Manual's density
+// grades, a guide rather than a survey grade measure since a camera's view
+// is longer than a loop.
+func LevelOfService(occupancy float64) string {
+	switch {
+	case occupancy < 7:
+		return "A"
+	case occupancy < 11:
+		return "B"
+	case occupancy < 16:
+		return "C"
+	case occupancy < 22:
+		return "D"
+	case occupancy < 28:
+		return "E"
+	}
+	return "F"
+}
+
+// Occupancy returns the hours or days from the one filter.From falls in up to
+// filter.To per camera, leaving out those never observed. Of the rest of the
+// filter only Camera applies.
+func (j *Journal) Occupancy(period string, filter Filter) ([]OccupancyBucket, error) {
+	if period != PeriodHour && period != PeriodDay {
+		return nil, fmt.Errorf("unknown period %s", period)
+	}
+
+	query := `SELECT camera, hour, observed_ms, occupied_ms, vehicle_ms, max_vehicles FROM occupancy WHERE hour >= ? AND hour < ?`
+	args := []interface{}{toMillis(periodStart(period, filter.From)), toMillis(filter.To)}
+	if filter.Camera != "" {
+		query += ` AND camera = ?`
+		args = append(args, filter.Camera)
+	}
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type key struct {
+		start  int64
+		camera string
+	}
+	type totals struct {
+		observed, occupied, vehicles int64
+		max                          int
+	}
+	buckets := map[key]*totals{}
+	for rows.Next() {
+		var camera string
+		var hour, observed, occupied, vehicles int64
+		var max int
+		if err := rows.Scan(&camera, &hour, &observed, &occupied, &vehicles, &max); err != nil {
+			return nil, err
+		}
+		k := key{toMillis(periodStart(period, fromMillis(hour))), camera}
+		t := buckets[k]
+		if t == nil {
+			t = &totals{}
+			buckets[k] = t
+		}
+		t.observed += observed
+		t.occupied += occupied
+		t.vehicles += vehicles
+		if max > t.max {
+			t.max = max
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	keys := make([]key, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].start != keys[b].start {
+			return keys[a].start < keys[b].start
+		}
+		return keys[a].camera < keys[b].camera
+	})
+
+	occupancy := []OccupancyBucket{}
+	for _, k := range keys {
+		t := buckets[k]
+		if t.observed == 0 {
+			continue
+		}
+		b := OccupancyBucket{
+			Start:        fromMillis(k.start),
+			Camera:       k.camera,
+			Observed:     float64(t.observed) / 1000,
+			Occupancy:    100 * float64(t.occupied) / float64(t.observed),
+			MeanVehicles: float64(t.vehicles) / float64(t.observed),
+			MaxVehicles:  t.max,
+		}
+		b.LevelOfService = LevelOfService(b.Occupancy)
+		occupancy = append(occupancy, b)
+	}
+	return occupancy, nil
+}
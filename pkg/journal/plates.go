@@ -0,0 +1,108 @@
+package journal
+
+import (
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// PlateViolation is a violation whose plate was read, see pkg/anpr.
+type PlateViolation struct {
+	EventID    uuid.UUID
+	Plate      string
+	Camera     string
+	TimeStamp  time.Time
+	Speed      float64 // mph
+	SpeedLimit float64 // mph
+	Confidence float64 // percent
+}
+
+// PlateHistory is a plate's violations over a range.
+type PlateHistory struct {
+	Plate      string
+	Violations []PlateViolation // newest first
+}
+
+// RecordPlateViolation journals a violation's plate.
+func (j *Journal) RecordPlateViolation(v PlateViolation) error {
+	_, err := j.db.Exec(`INSERT OR REPLACE INTO plate_violations (event_id, plate, camera, timestamp, speed, speed_limit, confidence) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		v.EventID.String(), v.Plate, v.Camera, toMillis(v.TimeStamp), v.Speed, v.SpeedLimit, v.Confidence)
+	return err
+}
+
+// PlateViolationCount returns how many violations plate has from from up to
+// to.
+func (j *Journal) PlateViolationCount(plate string, from time.Time, to time.Time) (int, error) {
+	var n int
+	err := j.db.QueryRow(`SELECT COUNT(*) FROM plate_violations WHERE plate = ? AND timestamp >= ? AND timestamp < ?`,
+		plate, toMillis(from), toMillis(to)).Scan(&n)
+	return n, err
+}
+
+// RepeatPlates returns the plates with at least minViolations in filter's
+// range, those with the most first, or only filter's Plate when set. Of the
+// rest of the filter only Camera and Limit apply, Limit to the plates.
+func (j *Journal) RepeatPlates(filter Filter, plate string, minViolations int) ([]PlateHistory, error) {
+	query := `SELECT event_id, plate, camera, timestamp, speed, speed_limit, confidence FROM plate_violations WHERE timestamp >= ? AND timestamp < ?`
+	args := []interface{}{toMillis(filter.From), toMillis(filter.To)}
+	if filter.Camera != "" {
+		query += ` AND camera = ?`
+		args = append(args, filter.Camera)
+	}
+	if plate != "" {
+		query += ` AND plate = ?`
+		args = append(args, plate)
+	}
+	query += ` ORDER BY timestamp DESC`
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plates []string
+	byPlate := map[string][]PlateViolation{}
+	for rows.Next() {
+		var v PlateViolation
+		var eventID string
+		var at int64
+		if err := rows.Scan(&eventID, &v.Plate, &v.Camera, &at, &v.Speed, &v.SpeedLimit, &v.Confidence); err != nil {
+			return nil, err
+		}
+		if v.EventID, err = uuid.FromString(eventID); err != nil {
+			return nil, err
+		}
+		v.TimeStamp = fromMillis(at)
+		if _, ok := byPlate[v.Plate]; !ok {
+			plates = append(plates, v.Plate)
+		}
+		byPlate[v.Plate] = append(byPlate[v.Plate], v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	histories := []PlateHistory{}
+	for _, p := range plates {
+		if len(byPlate[p]) >= minViolations {
+			histories = append(histories, PlateHistory{Plate: p, Violations: byPlate[p]})
+		}
+	}
+	// stable, so plates tied keep the most recent first
+	sort.SliceStable(histories, func(a, b int) bool { return len(histories[a].Violations) > len(histories[b].Violations) })
+	if filter.Limit > 0 && len(histories) > filter.Limit {
+		histories = histories[:filter.Limit]
+	}
+	return histories, nil
+}
+
+// PurgePlates deletes the plates read before before, returning how many.
+func (j *Journal) PurgePlates(before time.Time) (int64, error) {
+	res, err := j.db.Exec(`DELETE FROM plate_violations WHERE timestamp < ?`, toMillis(before))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
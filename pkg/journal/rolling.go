@@ -0,0 +1,99 @@
+package journal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultRollingWindows are the windows Rolling keeps statistics over
+// unless others are set.
+var DefaultRollingWindows = []time.Duration{24 * time.Hour, 7 * 24 * time.Hour}
+
+// RollingWindow is traffic over a window up to when it was updated.
+type RollingWindow struct {
+	Window          string
+	From            time.Time
+	To              time.Time
+	Vehicles        int
+	VehiclesPerHour float64
+	MedianSpeed     float64
+	P85Speed        float64
+	ViolationRate   float64 // percent of Vehicles over their limit
+}
+
+// Rolling keeps statistics over Windows up to now, updated every Interval so
+// the API and Prometheus scrapes read them rather than query a week of
+// events each time.
+type Rolling struct {
+	Journal  *Journal
+	Windows  []time.Duration
+	Interval time.Duration
+
+	mu     sync.Mutex
+	latest []RollingWindow
+}
+
+// NewRolling returns a Rolling over windows, updated every interval once
+// Run.
+func NewRolling(j *Journal, windows []time.Duration, interval time.Duration) *Rolling {
+	return &Rolling{Journal: j, Windows: windows, Interval: interval}
+}
+
+// Update computes every window up to now.
+func (r *Rolling) Update(now time.Time) error {
+	var latest []RollingWindow
+	for _, w := range r.Windows {
+		events, err := r.Journal.QueryEvents(Filter{From: now.Add(-w), To: now, Sort: "time"})
+		if err != nil {
+			return err
+		}
+		a := aggregate(events)
+		rw := RollingWindow{
+			Window:          WindowName(w),
+			From:            now.Add(-w),
+			To:              now,
+			Vehicles:        a.Vehicles,
+			VehiclesPerHour: float64(a.Vehicles) / w.Hours(),
+			MedianSpeed:     a.MedianSpeed,
+			P85Speed:        a.P85Speed,
+		}
+		if a.Vehicles > 0 {
+			rw.ViolationRate = 100 * float64(a.Violations) / float64(a.Vehicles)
+		}
+		latest = append(latest, rw)
+	}
+
+	r.mu.Lock()
+	r.latest = latest
+	r.mu.Unlock()
+	return nil
+}
+
+// Run updates every Interval, starting straight away.
+func (r *Rolling) Run() {
+	for {
+		if err := r.Update(time.Now()); err != nil {
+			fmt.Printf("Failed to update rolling statistics, %s\n", err)
+		}
+		time.Sleep(r.Interval)
+	}
+}
+
+// Latest returns the windows as last updated, nil before the first update.
+func (r *Rolling) Latest() []RollingWindow {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latest
+}
+
+// Window returns the latest statistics over the window named name, ok false
+// if there are none.
+func (r *Rolling) Window(name string) (RollingWindow, bool) {
+	for _, rw := range r.Latest() {
+		if rw.Window == name {
+			return rw, true
+		}
+	}
+	return RollingWindow{}, false
+}
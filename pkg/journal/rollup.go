@@ -0,0 +1,213 @@
+package journal
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Periods events are rolled up over.
+const (
+	PeriodHour = "hour"
+	PeriodDay  = "day"
+)
+
+// Rollup summarises one camera's events over an hour or a day.
+type Rollup struct {
+	Start         time.Time
+	Camera        string
+	Vehicles      int
+	MeanSpeed     float64
+	P85Speed      float64
+	Violations    int
+	ViolationRate float64 // percent of Vehicles
+}
+
+// periodStart returns the start of the hour or day t falls in, days
+// starting at local midnight.
+func periodStart(period string, t time.Time) time.Time {
+	if period == PeriodDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	}
+	return BucketStart(t, time.Hour)
+}
+
+func nextPeriod(period string, t time.Time) time.Time {
+	if period == PeriodDay {
+		return t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Hour)
+}
+
+// rollUpEvents buckets events by period and camera, ordered by start then
+// camera.
+func rollUpEvents(period string, events []Event) []Rollup {
+	type key struct {
+		start  int64
+		camera string
+	}
+	buckets := map[key][]Event{}
+	for _, e := range events {
+		k := key{toMillis(periodStart(period, e.TimeStamp)), e.Camera}
+		buckets[k] = append(buckets[k], e)
+	}
+
+	keys := make([]key, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].start != keys[b].start {
+			return keys[a].start < keys[b].start
+		}
+		return keys[a].camera < keys[b].camera
+	})
+
+	rollups := make([]Rollup, 0, len(keys))
+	for _, k := range keys {
+		a := aggregate(buckets[k])
+		rollups = append(rollups, newRollup(fromMillis(k.start), k.camera, a.Vehicles, a.MeanSpeed, a.P85Speed, a.Violations))
+	}
+	return rollups
+}
+
+func newRollup(start time.Time, camera string, vehicles int, meanSpeed float64, p85Speed float64, violations int) Rollup {
+	r := Rollup{Start: start, Camera: camera, Vehicles: vehicles, MeanSpeed: meanSpeed, P85Speed: p85Speed, Violations: violations}
+	if vehicles > 0 {
+		r.ViolationRate = 100 * float64(violations) / float64(vehicles)
+	}
+	return r
+}
+
+// rolledUp returns the start of the last bucket of period rolled up, ok
+// false when none has been.
+func (j *Journal) rolledUp(period string) (start time.Time, ok bool, err error) {
+	var last sql.NullInt64
+	if err := j.db.QueryRow(`SELECT MAX(start) FROM rollups WHERE period = ?`, period).Scan(&last); err != nil {
+		return start, false, err
+	}
+	if !last.Valid {
+		return start, false, nil
+	}
+	return fromMillis(last.Int64), true, nil
+}
+
+// RollUp rolls events up into every complete hour and day before now that
+// hasn't been. The last bucket of each is rolled up again, picking up events
+// journaled after it was, e.g. by repair.
+func (j *Journal) RollUp(now time.Time) error {
+	for _, period := range []string{PeriodHour, PeriodDay} {
+		from, ok, err := j.rolledUp(period)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			var first sql.NullInt64
+			if err := j.db.QueryRow(`SELECT MIN(timestamp) FROM events`).Scan(&first); err != nil {
+				return err
+			}
+			if !first.Valid {
+				continue
+			}
+			from = periodStart(period, fromMillis(first.Int64))
+		}
+
+		// a week at a time, so the first roll up of a long journal doesn't
+		// hold every event in memory
+		end := periodStart(period, now)
+		for from.Before(end) {
+			to := from.AddDate(0, 0, 7)
+			if to.After(end) {
+				to = end
+			}
+			if err := j.rollUp(period, from, to); err != nil {
+				return fmt.Errorf("rolling up %ss from %s: %s", period, from.Format(time.RFC3339), err)
+			}
+			from = to
+		}
+	}
+	return nil
+}
+
+// rollUp replaces the rollups of period starting from from up to to.
+func (j *Journal) rollUp(period string, from time.Time, to time.Time) error {
+	events, err := j.QueryEvents(Filter{From: from, To: to, Sort: "time"})
+	if err != nil {
+		return err
+	}
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM rollups WHERE period = ? AND start >= ? AND start < ?`, period, toMillis(from), toMillis(to)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, r := range rollUpEvents(period, events) {
+		_, err := tx.Exec(`INSERT INTO rollups (period, start, camera, vehicles, mean_speed, p85_speed, violations) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			period, toMillis(r.Start), r.Camera, r.Vehicles, r.MeanSpeed, r.P85Speed, r.Violations)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Rollups returns the hours or days from the one filter.From falls in up to
+// filter.To per camera, leaving out those without traffic. Buckets already
+// rolled up are read from the journal, later ones are summarised from their
+// events. Of the rest of the filter only Camera applies.
+func (j *Journal) Rollups(period string, filter Filter) ([]Rollup, error) {
+	if period != PeriodHour && period != PeriodDay {
+		return nil, fmt.Errorf("unknown period %s", period)
+	}
+	from := periodStart(period, filter.From)
+
+	query := `SELECT start, camera, vehicles, mean_speed, p85_speed, violations FROM rollups WHERE period = ? AND start >= ? AND start < ?`
+	args := []interface{}{period, toMillis(from), toMillis(filter.To)}
+	if filter.Camera != "" {
+		query += ` AND camera = ?`
+		args = append(args, filter.Camera)
+	}
+	query += ` ORDER BY start, camera`
+
+	rows, err := j.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rollups := []Rollup{}
+	for rows.Next() {
+		var start int64
+		var camera string
+		var vehicles, violations int
+		var meanSpeed, p85Speed float64
+		if err := rows.Scan(&start, &camera, &vehicles, &meanSpeed, &p85Speed, &violations); err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, newRollup(fromMillis(start), camera, vehicles, meanSpeed, p85Speed, violations))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	last, ok, err := j.rolledUp(period)
+	if err != nil {
+		return nil, err
+	}
+	if ok && !nextPeriod(period, last).Before(from) {
+		from = nextPeriod(period, last)
+	}
+	if !from.Before(filter.To) {
+		return rollups, nil
+	}
+	events, err := j.QueryEvents(Filter{From: from, To: filter.To, Camera: filter.Camera, Sort: "time"})
+	if err != nil {
+		return nil, err
+	}
+	return append(rollups, rollUpEvents(period, events)...), nil
+}
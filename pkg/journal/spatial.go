@@ -0,0 +1,107 @@
+package journal
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MinSpatialTimed is the fewest timed track points a cell needs for its mean
+// speed to count towards SpatialGrid's speed range.
+const MinSpatialTimed = 10
+
+// SpatialCell is a square of a camera's frame, column X and row Y of the
+// grid's CellSize pixels.
+type SpatialCell struct {
+	X          int
+	Y          int
+	Detections int     // track points of every vehicle
+	Timed      int     // track points of timed vehicles
+	MeanSpeed  float64 // mph, over Timed
+}
+
+// SpatialGrid is where in a camera's frame vehicles were tracked over a
+// range of days, and how fast.
+type SpatialGrid struct {
+	Camera        string
+	From          time.Time // start of the first day
+	To            time.Time
+	CellSize      int           // pixels, 0 with no cells
+	Cells         []SpatialCell // rows top to bottom, each left to right
+	MaxDetections int
+	MinSpeed      float64 // of the cells with at least MinSpatialTimed
+	MaxSpeed      float64
+}
+
+// AddSpatial adds an interval's cells of camera to the day it started in.
+// Cells whose size differs from the day's are kept apart.
+func (j *Journal) AddSpatial(camera string, start time.Time, cellSize int, cells []SpatialCell) error {
+	day := toMillis(periodStart(PeriodDay, start))
+	tx, err := j.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, c := range cells {
+		_, err := tx.Exec(`INSERT INTO spatial_cells (camera, day, cell_size, x, y, detections, timed, speed_sum) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT (camera, day, cell_size, x, y) DO UPDATE SET
+				detections = detections + excluded.detections,
+				timed = timed + excluded.timed,
+				speed_sum = speed_sum + excluded.speed_sum`,
+			camera, day, cellSize, c.X, c.Y, c.Detections, c.Timed, c.MeanSpeed*float64(c.Timed))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// SpatialHeatmap sums filter.Camera's cells over the days from the one
+// filter.From falls in up to filter.To, by the cell size most recently
+// used. Of the rest of the filter nothing applies.
+func (j *Journal) SpatialHeatmap(filter Filter) (SpatialGrid, error) {
+	grid := SpatialGrid{Camera: filter.Camera, From: periodStart(PeriodDay, filter.From), To: filter.To, Cells: []SpatialCell{}}
+	from, to := toMillis(grid.From), toMillis(grid.To)
+
+	err := j.db.QueryRow(`SELECT cell_size FROM spatial_cells WHERE camera = ? AND day >= ? AND day < ? ORDER BY day DESC LIMIT 1`,
+		filter.Camera, from, to).Scan(&grid.CellSize)
+	if err == sql.ErrNoRows {
+		return grid, nil
+	}
+	if err != nil {
+		return grid, err
+	}
+
+	rows, err := j.db.Query(`SELECT x, y, SUM(detections), SUM(timed), SUM(speed_sum) FROM spatial_cells
+		WHERE camera = ? AND cell_size = ? AND day >= ? AND day < ? GROUP BY y, x ORDER BY y, x`,
+		filter.Camera, grid.CellSize, from, to)
+	if err != nil {
+		return grid, err
+	}
+	defer rows.Close()
+
+	speeds := 0
+	for rows.Next() {
+		var c SpatialCell
+		var speedSum float64
+		if err := rows.Scan(&c.X, &c.Y, &c.Detections, &c.Timed, &speedSum); err != nil {
+			return grid, err
+		}
+		if c.Timed > 0 {
+			c.MeanSpeed = speedSum / float64(c.Timed)
+		}
+		if c.Detections > grid.MaxDetections {
+			grid.MaxDetections = c.Detections
+		}
+		if c.Timed >= MinSpatialTimed {
+			if speeds == 0 || c.MeanSpeed < grid.MinSpeed {
+				grid.MinSpeed = c.MeanSpeed
+			}
+			if c.MeanSpeed > grid.MaxSpeed {
+				grid.MaxSpeed = c.MeanSpeed
+			}
+			speeds++
+		}
+		grid.Cells = append(grid.Cells, c)
+	}
+	return grid, rows.Err()
+}
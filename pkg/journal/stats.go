@@ -0,0 +1,405 @@
+package journal
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const LiveHistogramBinWidth = 5.0 // mph
+
+type HistogramBin struct {
+	From  float64
+	To    float64
+	Count int
+}
+
+type LiveStats struct {
+	GeneratedAt      time.Time
+	VehiclesLastHour int
+	P85LastHour      float64
+	Histogram        []HistogramBin // last hour
+	FastestToday     *Event
+}
+
+// percentile returns the p-th percentile (0-100) of speeds using linear
+// interpolation between closest ranks. speeds must be sorted.
+func percentile(speeds []float64, p float64) float64 {
+	if len(speeds) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(speeds)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return speeds[lower] + (speeds[upper]-speeds[lower])*(rank-float64(lower))
+}
+
+func histogram(speeds []float64, binWidth float64) []HistogramBin {
+	bins := []HistogramBin{}
+	for _, s := range speeds {
+		i := int(s / binWidth)
+		for len(bins) <= i {
+			from := float64(len(bins)) * binWidth
+			bins = append(bins, HistogramBin{From: from, To: from + binWidth})
+		}
+		bins[i].Count++
+	}
+	return bins
+}
+
+func (j *Journal) SpeedsBetween(from time.Time, to time.Time) ([]float64, error) {
+	rows, err := j.db.Query(`SELECT speed FROM events WHERE timestamp >= ? AND timestamp < ? ORDER BY speed`, toMillis(from), toMillis(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var speeds []float64
+	for rows.Next() {
+		var s float64
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		speeds = append(speeds, s)
+	}
+	return speeds, rows.Err()
+}
+
+func (j *Journal) LiveStats(now time.Time) (LiveStats, error) {
+	stats := LiveStats{GeneratedAt: now}
+
+	speeds, err := j.SpeedsBetween(now.Add(-time.Hour), now)
+	if err != nil {
+		return stats, err
+	}
+	sort.Float64s(speeds)
+
+	stats.VehiclesLastHour = len(speeds)
+	stats.P85LastHour = percentile(speeds, 85)
+	stats.Histogram = histogram(speeds, LiveHistogramBinWidth)
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	fastest, err := j.QueryEvents(Filter{From: midnight, To: now, Sort: "-speed", Limit: 1})
+	if err != nil {
+		return stats, err
+	}
+	if len(fastest) > 0 {
+		stats.FastestToday = &fastest[0]
+	}
+
+	return stats, nil
+}
+
+// DefaultP85Windows are the windows 85th percentile speeds are given over
+// unless others are asked for.
+var DefaultP85Windows = []time.Duration{15 * time.Minute, time.Hour, 24 * time.Hour, 7 * 24 * time.Hour}
+
+// P85 is the 85th percentile speed over a window, the speed traffic
+// engineers set limits by.
+type P85 struct {
+	Window   string
+	From     time.Time
+	To       time.Time
+	Vehicles int
+	P85Speed float64
+}
+
+// ParseWindows reads a comma separated list of durations such as
+// "15m,1h,24h".
+func ParseWindows(s string) ([]time.Duration, error) {
+	var windows []time.Duration
+	for _, v := range strings.Split(s, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid window %q", v)
+		}
+		windows = append(windows, d)
+	}
+	return windows, nil
+}
+
+// WindowName formats d without trailing zero units, e.g. 1h rather than
+// 1h0m0s.
+func WindowName(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
+
+// P85Speeds returns the 85th percentile speed over each window up to now of
+// camera's vehicles, or every camera's when camera is empty.
+func (j *Journal) P85Speeds(now time.Time, camera string, windows []time.Duration) ([]P85, error) {
+	var p85s []P85
+	for _, w := range windows {
+		events, err := j.QueryEvents(Filter{From: now.Add(-w), To: now, Camera: camera, Sort: "speed"})
+		if err != nil {
+			return nil, err
+		}
+		speeds := make([]float64, 0, len(events))
+		for _, e := range events {
+			speeds = append(speeds, e.Speed)
+		}
+		p85s = append(p85s, P85{Window: WindowName(w), From: now.Add(-w), To: now, Vehicles: len(speeds), P85Speed: percentile(speeds, 85)})
+	}
+	return p85s, nil
+}
+
+const maxHistogramBins = 200
+
+var ErrTooManyBins = fmt.Errorf("bin width gives more than %d bins", maxHistogramBins)
+
+type SpeedHistogram struct {
+	From      time.Time
+	To        time.Time
+	Direction string `json:",omitempty"`
+	BinWidth  float64
+	Vehicles  int
+	Bins      []HistogramBin
+}
+
+// SpeedHistogram bins the speeds of events matching filter into binWidth mph
+// wide bins starting from 0.
+func (j *Journal) SpeedHistogram(filter Filter, binWidth float64) (SpeedHistogram, error) {
+	h := SpeedHistogram{From: filter.From, To: filter.To, Direction: filter.Direction, BinWidth: binWidth}
+
+	filter.Sort, filter.After, filter.Limit = "speed", nil, 0
+	events, err := j.QueryEvents(filter)
+	if err != nil {
+		return h, err
+	}
+
+	speeds := make([]float64, 0, len(events))
+	for _, e := range events {
+		speeds = append(speeds, e.Speed)
+	}
+	if len(speeds) > 0 && speeds[len(speeds)-1]/binWidth > maxHistogramBins {
+		return h, ErrTooManyBins
+	}
+
+	h.Vehicles = len(speeds)
+	h.Bins = histogram(speeds, binWidth)
+	return h, nil
+}
+
+// Groupings accepted by Aggregate, each maps an event to its group key.
+// Besides these any of BucketSizes, e.g. 15m, groups by the start of the
+// bucket.
+var AggregateGroupings = map[string]func(e Event) string{
+	"hour":      func(e Event) string { return BucketStart(e.TimeStamp, time.Hour).Format(time.RFC3339) },
+	"day":       func(e Event) string { return e.TimeStamp.Format("2006-01-02") },
+	"camera":    func(e Event) string { return e.Camera },
+	"direction": func(e Event) string { return e.Direction },
+	"class":     func(e Event) string { return e.Class },
+	"lane":      func(e Event) string { return e.Lane },
+}
+
+// AggregateGrouping returns the grouping named g, one of
+// AggregateGroupings or a bucket size, nil if there's none.
+func AggregateGrouping(g string) func(e Event) string {
+	if group, ok := AggregateGroupings[g]; ok {
+		return group
+	}
+	size, err := ParseBucket(g)
+	if err != nil {
+		return nil
+	}
+	return func(e Event) string { return BucketStart(e.TimeStamp, size).Format(time.RFC3339) }
+}
+
+type Aggregate struct {
+	Group       map[string]string `json:",omitempty"`
+	Vehicles    int
+	MeanSpeed   float64
+	MedianSpeed float64
+	P85Speed    float64
+	Violations  int
+	Compliance  Compliance
+}
+
+// Compliance splits the vehicles timed under a speed limit by how far over
+// it they went, in percent of them.
+type Compliance struct {
+	Vehicles  int     // timed with a limit set
+	Compliant float64 // at or under the limit
+	Within5   float64 // up to 5 mph over
+	Within10  float64 // more than 5 and under 10 mph over
+	Over10    float64 // 10 mph or more over
+}
+
+func compliance(events []Event) Compliance {
+	var c Compliance
+	var within5, within10, over10 int
+	for _, e := range events {
+		if e.SpeedLimit <= 0 {
+			continue
+		}
+		c.Vehicles++
+		switch over := e.Speed - e.SpeedLimit; {
+		case over <= 0:
+		case over <= 5:
+			within5++
+		case over < 10:
+			within10++
+		default:
+			over10++
+		}
+	}
+	if c.Vehicles == 0 {
+		return c
+	}
+	n := float64(c.Vehicles)
+	c.Within5 = 100 * float64(within5) / n
+	c.Within10 = 100 * float64(within10) / n
+	c.Over10 = 100 * float64(over10) / n
+	c.Compliant = 100 * float64(c.Vehicles-within5-within10-over10) / n
+	return c
+}
+
+type AggregateReport struct {
+	From            time.Time
+	To              time.Time
+	GroupBy         []string
+	VehiclesPerHour float64
+	VehiclesPerDay  float64
+	Total           Aggregate
+	Groups          []Aggregate `json:",omitempty"`
+}
+
+func aggregate(events []Event) Aggregate {
+	a := Aggregate{Vehicles: len(events)}
+	if len(events) == 0 {
+		return a
+	}
+
+	speeds := make([]float64, 0, len(events))
+	sum := 0.0
+	for _, e := range events {
+		speeds = append(speeds, e.Speed)
+		sum += e.Speed
+		if e.IsViolation() {
+			a.Violations++
+		}
+	}
+	sort.Float64s(speeds)
+
+	a.Compliance = compliance(events)
+	a.MeanSpeed = sum / float64(len(speeds))
+	a.MedianSpeed = percentile(speeds, 50)
+	a.P85Speed = percentile(speeds, 85)
+	return a
+}
+
+// Aggregate summarises the events matching filter, overall and per group for
+// any of the groupings named in groupBy, see AggregateGroupings. The filter's range must be
+// set, sort and paging are ignored.
+func (j *Journal) Aggregate(filter Filter, groupBy []string) (AggregateReport, error) {
+	report := AggregateReport{From: filter.From, To: filter.To, GroupBy: groupBy}
+	for _, g := range groupBy {
+		if AggregateGrouping(g) == nil {
+			return report, fmt.Errorf("unknown grouping %s", g)
+		}
+	}
+
+	filter.Sort, filter.After, filter.Limit = "time", nil, 0
+	events, err := j.QueryEvents(filter)
+	if err != nil {
+		return report, err
+	}
+
+	report.Total = aggregate(events)
+	if hours := filter.To.Sub(filter.From).Hours(); hours > 0 {
+		report.VehiclesPerHour = float64(len(events)) / hours
+		report.VehiclesPerDay = report.VehiclesPerHour * 24
+	}
+	if len(groupBy) == 0 {
+		return report, nil
+	}
+
+	groups := map[string][]Event{}
+	keys := map[string]map[string]string{}
+	for _, e := range events {
+		key := map[string]string{}
+		var parts []string
+		for _, g := range groupBy {
+			key[g] = AggregateGrouping(g)(e)
+			parts = append(parts, key[g])
+		}
+		id := strings.Join(parts, "\x00")
+		groups[id] = append(groups[id], e)
+		keys[id] = key
+	}
+
+	ids := make([]string, 0, len(groups))
+	for id := range groups {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		a := aggregate(groups[id])
+		a.Group = keys[id]
+		report.Groups = append(report.Groups, a)
+	}
+	return report, nil
+}
+
+// Heatmap holds day of week by hour of day matrices, indexed
+// [time.Weekday][hour] in local time.
+type Heatmap struct {
+	From          time.Time
+	To            time.Time
+	Days          []string
+	Volume        [7][24]int
+	Violations    [7][24]int
+	ViolationRate [7][24]float64 // percent of Volume, 0 where there's no traffic
+}
+
+// Heatmap counts the events matching filter by day of week and hour. Filtered
+// only by range and camera it's built from hourly rollups, counting whole
+// hours either end of the range.
+func (j *Journal) Heatmap(filter Filter) (Heatmap, error) {
+	h := Heatmap{From: filter.From, To: filter.To}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		h.Days = append(h.Days, d.String())
+	}
+
+	if filter.MinSpeed == 0 && filter.Direction == "" && filter.Class == "" && filter.Lane == "" && filter.Violation == nil && filter.Tag == "" && len(filter.ExcludeTags) == 0 {
+		rollups, err := j.Rollups(PeriodHour, filter)
+		if err != nil {
+			return h, err
+		}
+		for _, r := range rollups {
+			day, hour := r.Start.Weekday(), r.Start.Hour()
+			h.Volume[day][hour] += r.Vehicles
+			h.Violations[day][hour] += r.Violations
+		}
+	} else {
+		filter.Sort, filter.After, filter.Limit = "time", nil, 0
+		events, err := j.QueryEvents(filter)
+		if err != nil {
+			return h, err
+		}
+		for _, e := range events {
+			day, hour := e.TimeStamp.Weekday(), e.TimeStamp.Hour()
+			h.Volume[day][hour]++
+			if e.IsViolation() {
+				h.Violations[day][hour]++
+			}
+		}
+	}
+	for d := range h.Volume {
+		for hour, n := range h.Volume[d] {
+			if n > 0 {
+				h.ViolationRate[d][hour] = 100 * float64(h.Violations[d][hour]) / float64(n)
+			}
+		}
+	}
+	return h, nil
+}
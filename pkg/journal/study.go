@@ -0,0 +1,130 @@
+package journal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StudySpeedBins are the lower bounds, in mph, of the speed bins of a
+// traffic study, as set up on most counters for the FHWA Traffic Monitoring
+// Guide's speed data: under 20, 5 mph bins up to 85, then 85 and over.
+var StudySpeedBins = []float64{0, 20, 25, 30, 35, 40, 45, 50, 55, 60, 65, 70, 75, 80, 85}
+
+// FHWAClasses is the FHWA 13 class scheme's vehicle classes, 1 motorcycles
+// to 13 seven or more axle multi-trailer trucks.
+const FHWAClasses = 13
+
+// DefaultFHWAClassMap assigns the classifier's labels to FHWA classes. A
+// camera can't count axles, so trucks go to class 5, two axle single unit
+// trucks, and articulated ones to class 9, five axle single trailer trucks,
+// the most common of each.
+var DefaultFHWAClassMap = map[string]int{
+	"motorcycle": 1, "motorbike": 1,
+	"car":    2,
+	"pickup": 3, "van": 3, "suv": 3,
+	"bus":   4,
+	"truck": 5, "lorry": 5,
+	"semi": 9, "trailer": 9, "articulated": 9,
+}
+
+// ParseFHWAClassMap reads assignments of labels to FHWA classes such as
+// "truck=6,semi=9" over DefaultFHWAClassMap.
+func ParseFHWAClassMap(s string) (map[string]int, error) {
+	m := map[string]int{}
+	for label, class := range DefaultFHWAClassMap {
+		m[label] = class
+	}
+	for _, a := range strings.Split(s, ",") {
+		if strings.TrimSpace(a) == "" {
+			continue
+		}
+		parts := strings.SplitN(a, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid class assignment %q, want label=class", a)
+		}
+		class, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || class < 1 || class > FHWAClasses {
+			return nil, fmt.Errorf("invalid FHWA class %q, want 1 to %d", parts[1], FHWAClasses)
+		}
+		m[strings.TrimSpace(parts[0])] = class
+	}
+	return m, nil
+}
+
+// StudyInterval is one interval's traffic in one direction past a camera,
+// binned as a traffic study reports it.
+type StudyInterval struct {
+	Start     time.Time
+	Camera    string
+	Direction string
+	Vehicles  int
+	SpeedBins []int // vehicles by StudySpeedBins
+	Classes   []int // vehicles by FHWA class, 0 for unclassified
+	MeanSpeed float64
+	P85Speed  float64
+}
+
+// Study bins the events matching filter by interval, one of BucketSizes,
+// camera and direction, ordered by start, camera then direction. classes
+// assigns the classifier's labels to FHWA classes, labels it doesn't have
+// counting as unclassified. Sort and paging are ignored.
+func (j *Journal) Study(filter Filter, interval time.Duration, classes map[string]int) ([]StudyInterval, error) {
+	filter.Sort, filter.After, filter.Limit = "time", nil, 0
+	events, err := j.QueryEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	type key struct {
+		start     int64
+		camera    string
+		direction string
+	}
+	buckets := map[key][]Event{}
+	for _, e := range events {
+		k := key{toMillis(BucketStart(e.TimeStamp, interval)), e.Camera, e.Direction}
+		buckets[k] = append(buckets[k], e)
+	}
+
+	keys := make([]key, 0, len(buckets))
+	for k := range buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		switch {
+		case keys[a].start != keys[b].start:
+			return keys[a].start < keys[b].start
+		case keys[a].camera != keys[b].camera:
+			return keys[a].camera < keys[b].camera
+		}
+		return keys[a].direction < keys[b].direction
+	})
+
+	study := make([]StudyInterval, 0, len(keys))
+	for _, k := range keys {
+		a := aggregate(buckets[k])
+		s := StudyInterval{
+			Start:     fromMillis(k.start),
+			Camera:    k.camera,
+			Direction: k.direction,
+			Vehicles:  a.Vehicles,
+			SpeedBins: make([]int, len(StudySpeedBins)),
+			Classes:   make([]int, FHWAClasses+1),
+			MeanSpeed: a.MeanSpeed,
+			P85Speed:  a.P85Speed,
+		}
+		for _, e := range buckets[k] {
+			bin := 0
+			for bin < len(StudySpeedBins)-1 && e.Speed >= StudySpeedBins[bin+1] {
+				bin++
+			}
+			s.SpeedBins[bin]++
+			s.Classes[classes[e.Class]]++
+		}
+		study = append(study, s)
+	}
+	return study, nil
+}
@@ -0,0 +1,151 @@
+package journal
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Tag labels an event, or every event in a time range, e.g. "road
+// construction", "false positive" or "school holiday", so aggregates and
+// reports can leave them out or point them out.
+type Tag struct {
+	ID      uuid.UUID
+	Name    string
+	Camera  string    // a range's camera, empty for every camera
+	EventID uuid.UUID // the event tagged, uuid.Nil for a range
+	From    time.Time // the range tagged, zero for an event
+	To      time.Time
+	Note    string
+	Created time.Time
+}
+
+// tagged is the SQL condition for an event in events having a tag t.
+const tagged = `(t.event_id = events.id OR (t.event_id = '' AND events.timestamp >= t.from_ms AND events.timestamp < t.to_ms AND (t.camera = '' OR t.camera = events.camera)))`
+
+// tagFilter adds the conditions for filter's Tag and ExcludeTags.
+func tagFilter(filter Filter, where []string, args []interface{}) ([]string, []interface{}) {
+	if filter.Tag != "" {
+		where = append(where, `EXISTS (SELECT 1 FROM tags t WHERE t.name = ? AND `+tagged+`)`)
+		args = append(args, filter.Tag)
+	}
+	if len(filter.ExcludeTags) > 0 {
+		where = append(where, `NOT EXISTS (SELECT 1 FROM tags t WHERE t.name IN (?`+strings.Repeat(", ?", len(filter.ExcludeTags)-1)+`) AND `+tagged+`)`)
+		for _, name := range filter.ExcludeTags {
+			args = append(args, name)
+		}
+	}
+	return where, args
+}
+
+// AddTag journals a tag, giving it an ID and creation time.
+func (j *Journal) AddTag(t Tag) (Tag, error) {
+	t.Name = strings.TrimSpace(t.Name)
+	switch {
+	case t.Name == "":
+		return t, errors.New("tag needs a name")
+	case t.EventID == uuid.Nil && !t.From.Before(t.To):
+		return t, errors.New("tag needs an event or a range from before to")
+	case t.EventID != uuid.Nil && !(t.From.IsZero() && t.To.IsZero()):
+		return t, errors.New("tag can't have both an event and a range")
+	}
+
+	t.ID = uuid.NewV4()
+	t.Created = time.Now()
+	eventID := ""
+	var from, to int64
+	if t.EventID != uuid.Nil {
+		eventID = t.EventID.String()
+	} else {
+		from, to = toMillis(t.From), toMillis(t.To)
+	}
+	_, err := j.db.Exec(`INSERT INTO tags (id, name, camera, event_id, from_ms, to_ms, note, created) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID.String(), t.Name, t.Camera, eventID, from, to, t.Note, toMillis(t.Created))
+	return t, err
+}
+
+// DeleteTag removes a tag, ok false if there was none with id.
+func (j *Journal) DeleteTag(id uuid.UUID) (ok bool, err error) {
+	res, err := j.db.Exec(`DELETE FROM tags WHERE id = ?`, id.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Tags returns the ranges tagged overlapping from up to to and the tags of
+// events in it, oldest first.
+func (j *Journal) Tags(from time.Time, to time.Time) ([]Tag, error) {
+	rows, err := j.db.Query(`SELECT t.id, t.name, t.camera, t.event_id, t.from_ms, t.to_ms, t.note, t.created
+		FROM tags t LEFT JOIN events e ON e.id = t.event_id
+		WHERE (t.event_id = '' AND t.from_ms < ? AND t.to_ms > ?) OR (e.timestamp >= ? AND e.timestamp < ?)
+		ORDER BY COALESCE(e.timestamp, t.from_ms), t.created`,
+		toMillis(to), toMillis(from), toMillis(from), toMillis(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []Tag{}
+	for rows.Next() {
+		var t Tag
+		var id, eventID string
+		var fromMs, toMs, created int64
+		if err := rows.Scan(&id, &t.Name, &t.Camera, &eventID, &fromMs, &toMs, &t.Note, &created); err != nil {
+			return nil, err
+		}
+		if t.ID, err = uuid.FromString(id); err != nil {
+			return nil, err
+		}
+		if eventID != "" {
+			if t.EventID, err = uuid.FromString(eventID); err != nil {
+				return nil, err
+			}
+		} else {
+			t.From, t.To = fromMillis(fromMs), fromMillis(toMs)
+		}
+		t.Created = fromMillis(created)
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// EventTags returns the names of the tags on each of events, directly or by
+// range, by event ID. Events without tags are left out.
+func (j *Journal) EventTags(events []Event) (map[uuid.UUID][]string, error) {
+	names := map[uuid.UUID][]string{}
+	if len(events) == 0 {
+		return names, nil
+	}
+	from, to := events[0].TimeStamp, events[0].TimeStamp
+	for _, e := range events {
+		if e.TimeStamp.Before(from) {
+			from = e.TimeStamp
+		}
+		if e.TimeStamp.After(to) {
+			to = e.TimeStamp
+		}
+	}
+	tags, err := j.Tags(from, to.Add(time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range events {
+		for _, t := range tags {
+			var match bool
+			if t.EventID != uuid.Nil {
+				match = t.EventID == e.ID
+			} else {
+				match = !e.TimeStamp.Before(t.From) && e.TimeStamp.Before(t.To) && (t.Camera == "" || t.Camera == e.Camera)
+			}
+			if match {
+				names[e.ID] = append(names[e.ID], t.Name)
+			}
+		}
+	}
+	return names, nil
+}
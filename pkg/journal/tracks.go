@@ -0,0 +1,86 @@
+package journal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// TrackPoint is where in the frame, in full frame pixels, an event's vehicle
+// was seen and when.
+type TrackPoint struct {
+	X       int
+	Y       int
+	Elapsed float64 // seconds since the first point
+}
+
+func formatTrack(points []TrackPoint) string {
+	parts := make([]string, len(points))
+	for i, p := range points {
+		parts[i] = fmt.Sprintf("%d,%d,%d", p.X, p.Y, int64(p.Elapsed*1000))
+	}
+	return strings.Join(parts, ";")
+}
+
+func parseTrack(s string) ([]TrackPoint, error) {
+	parts := strings.Split(s, ";")
+	points := make([]TrackPoint, len(parts))
+	for i, p := range parts {
+		f := strings.Split(p, ",")
+		if len(f) != 3 {
+			return nil, fmt.Errorf("invalid track point %q", p)
+		}
+		x, errX := strconv.Atoi(f[0])
+		y, errY := strconv.Atoi(f[1])
+		ms, errMs := strconv.ParseInt(f[2], 10, 64)
+		if errX != nil || errY != nil || errMs != nil {
+			return nil, fmt.Errorf("invalid track point %q", p)
+		}
+		points[i] = TrackPoint{X: x, Y: y, Elapsed: float64(ms) / 1000}
+	}
+	return points, nil
+}
+
+// RecordTrack journals the path of event id's vehicle.
+func (j *Journal) RecordTrack(id uuid.UUID, points []TrackPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+	_, err := j.db.Exec(`INSERT OR REPLACE INTO event_tracks (event_id, points) VALUES (?, ?)`, id.String(), formatTrack(points))
+	return err
+}
+
+// EventTracks returns the journaled paths of events by ID. Events recorded
+// without one are left out.
+func (j *Journal) EventTracks(events []Event) (map[uuid.UUID][]TrackPoint, error) {
+	tracks := map[uuid.UUID][]TrackPoint{}
+	if len(events) == 0 {
+		return tracks, nil
+	}
+	args := make([]interface{}, len(events))
+	for i, e := range events {
+		args[i] = e.ID.String()
+	}
+	rows, err := j.db.Query(`SELECT event_id, points FROM event_tracks WHERE event_id IN (?`+strings.Repeat(", ?", len(events)-1)+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, s string
+		if err := rows.Scan(&id, &s); err != nil {
+			return nil, err
+		}
+		eventID, err := uuid.FromString(id)
+		if err != nil {
+			return nil, err
+		}
+		if tracks[eventID], err = parseTrack(s); err != nil {
+			return nil, err
+		}
+	}
+	return tracks, rows.Err()
+}
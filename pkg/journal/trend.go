@@ -0,0 +1,172 @@
+package journal
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// Trend periods, see Trend.
+const (
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// significantZ is the z score, or t for the large samples compared, beyond
+// which a change is unlikely to be chance, 95% two sided.
+const significantZ = 1.96
+
+// minSignificantSample is the fewest vehicles either side of a comparison
+// for it to be called significant, the normal approximations need it.
+const minSignificantSample = 30
+
+// SpeedSummary is the speeds of the vehicles timed over a range.
+type SpeedSummary struct {
+	From      time.Time
+	To        time.Time
+	Vehicles  int
+	MeanSpeed float64
+	StdDev    float64
+	P85Speed  float64
+	P85Low    float64 // of P85Speed's 95% confidence interval
+	P85High   float64
+}
+
+// Comparison is the change in speeds from Before to After, e.g. either side
+// of a speed bump going in.
+type Comparison struct {
+	Before          SpeedSummary
+	After           SpeedSummary
+	MeanChange      float64 // mph, After less Before
+	P85Change       float64 // mph
+	MeanSignificant bool    // by Welch's t test
+	P85Significant  bool    // the confidence intervals don't overlap
+}
+
+// SpeedSummary summarises the speeds of the events matching filter.
+func (j *Journal) SpeedSummary(filter Filter) (SpeedSummary, error) {
+	s := SpeedSummary{From: filter.From, To: filter.To}
+
+	filter.Sort, filter.After, filter.Limit = "speed", nil, 0
+	events, err := j.QueryEvents(filter)
+	if err != nil {
+		return s, err
+	}
+	if len(events) == 0 {
+		return s, nil
+	}
+
+	speeds := make([]float64, 0, len(events))
+	sum := 0.0
+	for _, e := range events {
+		speeds = append(speeds, e.Speed)
+		sum += e.Speed
+	}
+	n := float64(len(speeds))
+	s.Vehicles = len(speeds)
+	s.MeanSpeed = sum / n
+	if len(speeds) > 1 {
+		ss := 0.0
+		for _, v := range speeds {
+			ss += (v - s.MeanSpeed) * (v - s.MeanSpeed)
+		}
+		s.StdDev = math.Sqrt(ss / (n - 1))
+	}
+	s.P85Speed = percentile(speeds, 85)
+
+	// the rank of the 85th percentile is binomial, its interval gives
+	// the interval of the percentile
+	spread := significantZ * math.Sqrt(n*0.85*0.15)
+	s.P85Low = speeds[clampRank(int(math.Floor(n*0.85-spread)), len(speeds))]
+	s.P85High = speeds[clampRank(int(math.Ceil(n*0.85+spread)), len(speeds))]
+	return s, nil
+}
+
+func clampRank(rank int, n int) int {
+	if rank < 0 {
+		return 0
+	}
+	if rank >= n {
+		return n - 1
+	}
+	return rank
+}
+
+// Compare compares the speeds of the events matching before with those
+// matching after.
+func (j *Journal) Compare(before Filter, after Filter) (Comparison, error) {
+	var c Comparison
+	var err error
+	if c.Before, err = j.SpeedSummary(before); err != nil {
+		return c, err
+	}
+	if c.After, err = j.SpeedSummary(after); err != nil {
+		return c, err
+	}
+	return compare(c.Before, c.After), nil
+}
+
+func compare(before SpeedSummary, after SpeedSummary) Comparison {
+	c := Comparison{Before: before, After: after}
+	if before.Vehicles == 0 || after.Vehicles == 0 {
+		return c
+	}
+	c.MeanChange = after.MeanSpeed - before.MeanSpeed
+	c.P85Change = after.P85Speed - before.P85Speed
+
+	if before.Vehicles < minSignificantSample || after.Vehicles < minSignificantSample {
+		return c
+	}
+	se := math.Sqrt(before.StdDev*before.StdDev/float64(before.Vehicles) + after.StdDev*after.StdDev/float64(after.Vehicles))
+	c.MeanSignificant = se > 0 && math.Abs(c.MeanChange)/se > significantZ
+	c.P85Significant = after.P85Low > before.P85High || after.P85High < before.P85Low
+	return c
+}
+
+// trendStart returns the start of the week, from Monday, or month t falls in.
+func trendStart(period string, t time.Time) (time.Time, error) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch period {
+	case PeriodWeek:
+		return midnight.AddDate(0, 0, -(int(t.Weekday())+6)%7), nil
+	case PeriodMonth:
+		return midnight.AddDate(0, 0, 1-t.Day()), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown period %s", period)
+}
+
+func nextTrendPeriod(period string, t time.Time) time.Time {
+	if period == PeriodMonth {
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 7)
+}
+
+// Trend compares each of the last count complete weeks or months before now
+// with the one before it, oldest first. Of the filter only Camera, Direction
+// and Lane apply.
+func (j *Journal) Trend(period string, count int, now time.Time, filter Filter) ([]Comparison, error) {
+	end, err := trendStart(period, now)
+	if err != nil {
+		return nil, err
+	}
+	start := end
+	for i := 0; i <= count; i++ {
+		start, _ = trendStart(period, start.Add(-time.Second))
+	}
+
+	var summaries []SpeedSummary
+	for from := start; from.Before(end); from = nextTrendPeriod(period, from) {
+		s, err := j.SpeedSummary(Filter{From: from, To: nextTrendPeriod(period, from), Camera: filter.Camera, Direction: filter.Direction, Lane: filter.Lane, Tag: filter.Tag, ExcludeTags: filter.ExcludeTags})
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+
+	trend := []Comparison{}
+	for i := 1; i < len(summaries); i++ {
+		trend = append(trend, compare(summaries[i-1], summaries[i]))
+	}
+	return trend, nil
+}
@@ -0,0 +1,321 @@
+package journal
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// DefaultSightingDistance is how close, by SignatureDistance, a signature
+// has to come to a vehicle's to count as another sighting of it.
+const DefaultSightingDistance = 0.2
+
+// SightingWindow is how long a vehicle can go unseen and still be matched,
+// after which it comes back as a new one.
+const SightingWindow = 90 * 24 * time.Hour
+
+// Sighting is a timed event's vehicle with its signature, see
+// speedcam.Config.Signatures.
+type Sighting struct {
+	EventID   uuid.UUID
+	Camera    string
+	Class     string
+	TimeStamp time.Time
+	Signature []float64
+}
+
+// Vehicle is a vehicle seen more than once, as far as its colours tell. Two
+// cars of the same class and colour can't be told apart, so a vehicle is a
+// lead to follow up, not proof it's the same one.
+type Vehicle struct {
+	ID         uuid.UUID
+	Camera     string
+	Class      string
+	Color      string // its main colour, e.g. "silver"
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	Sightings  int // in the range asked for
+	Violations int
+	MeanSpeed  float64
+	MaxSpeed   float64
+	Weekdays   [7]int  // sightings by day of the week, Sunday first
+	UsualTime  string  // median local time of day seen, e.g. "08:05"
+	TimeSpread float64 // minutes, the median difference from UsualTime
+	Events     []Event // newest first
+}
+
+// SignatureDistance is the Hellinger distance between two signatures, 0 for
+// the same colours to 1 for none in common.
+func SignatureDistance(a []float64, b []float64) float64 {
+	if len(a) != len(b) {
+		return 1
+	}
+	bc := 0.0
+	for i := range a {
+		bc += math.Sqrt(a[i] * b[i])
+	}
+	return math.Sqrt(math.Max(0, 1-bc))
+}
+
+var (
+	hueNames  = []string{"red", "orange", "yellow", "green", "green", "green", "teal", "blue", "blue", "purple", "purple", "red"}
+	greyNames = []string{"black", "dark grey", "grey", "silver", "white", "white"}
+)
+
+// SignatureColor names the main colour of a signature, grey shades unless a
+// third of it has a hue. Tyres and windows are dark on every vehicle, so
+// black is only named when it's most of it.
+func SignatureColor(sig []float64) string {
+	hues := (len(sig) - len(greyNames)) / 2
+	if hues != len(hueNames) {
+		return ""
+	}
+
+	chroma, best := 0.0, 0
+	for h := 0; h < hues; h++ {
+		chroma += sig[2*h] + sig[2*h+1]
+		if sig[2*h]+sig[2*h+1] > sig[2*best]+sig[2*best+1] {
+			best = h
+		}
+	}
+	if chroma >= 1.0/3 {
+		if sig[2*best] > sig[2*best+1] {
+			return "dark " + hueNames[best]
+		}
+		return hueNames[best]
+	}
+
+	greys := sig[2*hues:]
+	if greys[0] >= 0.5 {
+		return greyNames[0]
+	}
+	best = 1
+	for g := 2; g < len(greys); g++ {
+		if greys[g] > greys[best] {
+			best = g
+		}
+	}
+	return greyNames[best]
+}
+
+func formatSignature(sig []float64) string {
+	parts := make([]string, len(sig))
+	for i, v := range sig {
+		parts[i] = strconv.FormatFloat(v, 'f', 4, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseSignature(s string) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	sig := make([]float64, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature %q", s)
+		}
+		sig[i] = v
+	}
+	return sig, nil
+}
+
+// RecordSighting journals s as a sighting of the vehicle of the same camera
+// and class seen within SightingWindow whose signature is closest to it, if
+// within distance, moving that vehicle's signature towards s's, or else of a
+// new vehicle. It returns the vehicle's ID.
+func (j *Journal) RecordSighting(s Sighting, distance float64) (uuid.UUID, error) {
+	if len(s.Signature) == 0 {
+		return uuid.Nil, fmt.Errorf("sighting of %s has no signature", s.EventID.String())
+	}
+	at := toMillis(s.TimeStamp)
+
+	tx, err := j.db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT id, signature, sightings FROM vehicles WHERE camera = ? AND class = ? AND last_seen >= ?`,
+		s.Camera, s.Class, toMillis(s.TimeStamp.Add(-SightingWindow)))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	var match string
+	var matchSig []float64
+	var matchSightings int
+	closest := math.Inf(1)
+	for rows.Next() {
+		var id, signature string
+		var sightings int
+		if err := rows.Scan(&id, &signature, &sightings); err != nil {
+			rows.Close()
+			return uuid.Nil, err
+		}
+		sig, err := parseSignature(signature)
+		if err != nil {
+			rows.Close()
+			return uuid.Nil, err
+		}
+		if d := SignatureDistance(sig, s.Signature); d < closest {
+			closest, match, matchSig, matchSightings = d, id, sig, sightings
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return uuid.Nil, err
+	}
+
+	var id uuid.UUID
+	if closest <= distance {
+		if id, err = uuid.FromString(match); err != nil {
+			return uuid.Nil, err
+		}
+		// the mean of every sighting, so one badly lit one can't drag it off
+		n := float64(matchSightings)
+		for i := range matchSig {
+			matchSig[i] = (matchSig[i]*n + s.Signature[i]) / (n + 1)
+		}
+		_, err = tx.Exec(`UPDATE vehicles SET signature = ?, color = ?, first_seen = MIN(first_seen, ?), last_seen = MAX(last_seen, ?), sightings = sightings + 1 WHERE id = ?`,
+			formatSignature(matchSig), SignatureColor(matchSig), at, at, match)
+	} else {
+		id, closest = uuid.NewV4(), 0
+		_, err = tx.Exec(`INSERT INTO vehicles (id, camera, class, color, signature, first_seen, last_seen, sightings) VALUES (?, ?, ?, ?, ?, ?, ?, 1)`,
+			id.String(), s.Camera, s.Class, SignatureColor(s.Signature), formatSignature(s.Signature), at, at)
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := tx.Exec(`INSERT INTO sightings (event_id, vehicle_id, distance) VALUES (?, ?, ?)`, s.EventID.String(), id.String(), closest); err != nil {
+		return uuid.Nil, err
+	}
+	return id, tx.Commit()
+}
+
+// RepeatVehicles returns the vehicles with at least minSightings of the
+// events matching filter, those with the most violations then sightings
+// first, with their events. Sort and paging are ignored, Limit caps the
+// vehicles returned.
+func (j *Journal) RepeatVehicles(filter Filter, minSightings int) ([]Vehicle, error) {
+	limit := filter.Limit
+	filter.Sort, filter.After, filter.Limit = "-time", nil, 0
+	events, err := j.QueryEvents(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := j.db.Query(`SELECT s.event_id, s.vehicle_id FROM sightings s JOIN events ON events.id = s.event_id
+		WHERE events.timestamp >= ? AND events.timestamp < ?`, toMillis(filter.From), toMillis(filter.To))
+	if err != nil {
+		return nil, err
+	}
+	vehicleOf := map[string]string{}
+	for rows.Next() {
+		var eventID, vehicleID string
+		if err := rows.Scan(&eventID, &vehicleID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		vehicleOf[eventID] = vehicleID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sightings := map[string][]Event{}
+	for _, e := range events {
+		if v, ok := vehicleOf[e.ID.String()]; ok {
+			sightings[v] = append(sightings[v], e)
+		}
+	}
+
+	vehicles := []Vehicle{}
+	for id, events := range sightings {
+		if len(events) < minSightings {
+			continue
+		}
+		v, err := j.vehicle(id, events)
+		if err != nil {
+			return nil, err
+		}
+		vehicles = append(vehicles, v)
+	}
+	sort.Slice(vehicles, func(a, b int) bool {
+		switch {
+		case vehicles[a].Violations != vehicles[b].Violations:
+			return vehicles[a].Violations > vehicles[b].Violations
+		case vehicles[a].Sightings != vehicles[b].Sightings:
+			return vehicles[a].Sightings > vehicles[b].Sightings
+		}
+		return vehicles[a].ID.String() < vehicles[b].ID.String()
+	})
+	if limit > 0 && len(vehicles) > limit {
+		vehicles = vehicles[:limit]
+	}
+	return vehicles, nil
+}
+
+// VehicleSightings returns a vehicle with every sighting of it, false if
+// there's no such vehicle.
+func (j *Journal) VehicleSightings(id uuid.UUID) (Vehicle, bool, error) {
+	rows, err := j.db.Query(`SELECT `+eventColumns+` FROM events WHERE id IN (SELECT event_id FROM sightings WHERE vehicle_id = ?) ORDER BY timestamp DESC`, id.String())
+	if err != nil {
+		return Vehicle{}, false, err
+	}
+	events, err := scanEvents(rows)
+	if err != nil {
+		return Vehicle{}, false, err
+	}
+
+	v, err := j.vehicle(id.String(), events)
+	if err == sql.ErrNoRows {
+		return v, false, nil
+	}
+	return v, err == nil, err
+}
+
+// vehicle describes vehicle id from its sightings, newest first.
+func (j *Journal) vehicle(id string, events []Event) (Vehicle, error) {
+	v := Vehicle{Events: events, Sightings: len(events)}
+	var firstSeen, lastSeen int64
+	err := j.db.QueryRow(`SELECT camera, class, color, first_seen, last_seen FROM vehicles WHERE id = ?`, id).
+		Scan(&v.Camera, &v.Class, &v.Color, &firstSeen, &lastSeen)
+	if err != nil {
+		return v, err
+	}
+	if v.ID, err = uuid.FromString(id); err != nil {
+		return v, err
+	}
+	v.FirstSeen, v.LastSeen = fromMillis(firstSeen), fromMillis(lastSeen)
+	if len(events) == 0 {
+		return v, nil
+	}
+
+	a := aggregate(events)
+	v.Violations, v.MeanSpeed = a.Violations, a.MeanSpeed
+	minutes := make([]float64, len(events))
+	for i, e := range events {
+		v.MaxSpeed = math.Max(v.MaxSpeed, e.Speed)
+		at := e.TimeStamp.In(time.Local)
+		v.Weekdays[at.Weekday()]++
+		minutes[i] = float64(at.Hour()*60 + at.Minute())
+	}
+
+	// around midnight the median is off, but regulars are seldom seen then
+	sort.Float64s(minutes)
+	usual := percentile(minutes, 50)
+	v.UsualTime = fmt.Sprintf("%02d:%02d", int(usual)/60, int(usual)%60)
+	spread := make([]float64, len(minutes))
+	for i, m := range minutes {
+		spread[i] = math.Abs(m - usual)
+	}
+	sort.Float64s(spread)
+	v.TimeSpread = percentile(spread, 50)
+	return v, nil
+}
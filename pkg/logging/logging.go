@@ -0,0 +1,247 @@
+// Package logging sends what the process prints to a log file, rotated by
+// size and age, for the minimal images journald isn't on, and optionally
+// as JSON for log shippers.
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+)
+
+// rotatedFormat is appended to the name of a rotated log file.
+const rotatedFormat = "20060102-150405.000"
+
+// File is a log file, moved aside once it reaches MaxSize or MaxAge and
+// started afresh, keeping Keep of the moved files for up to Retention.
+type File struct {
+	Path      string
+	MaxSize   int64         // bytes, 0 for no limit
+	MaxAge    time.Duration // since it was opened, 0 for no limit
+	Keep      int           // rotated files kept, 0 keeps every one
+	Retention time.Duration // rotated files older are removed, 0 keeps them
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+// New returns the log file named by LOG_FILE, or nil when it isn't set. It's
+// rotated at LOG_MAX_SIZE megabytes, default 10, or LOG_MAX_AGE, default
+// 24h, keeping LOG_KEEP rotated files, default 7, for LOG_RETENTION,
+// default 168h.
+func New() (*File, error) {
+	path := config.Env("LOG_FILE", "")
+	if path == "" {
+		return nil, nil
+	}
+	f := &File{Path: path}
+
+	maxSize, err := strconv.ParseFloat(config.Env("LOG_MAX_SIZE", "10"), 64)
+	if err != nil || maxSize < 0 {
+		return nil, fmt.Errorf("LOG_MAX_SIZE: invalid size %q", config.Env("LOG_MAX_SIZE", ""))
+	}
+	f.MaxSize = int64(maxSize * 1024 * 1024)
+	if f.MaxAge, err = time.ParseDuration(config.Env("LOG_MAX_AGE", "24h")); err != nil || f.MaxAge < 0 {
+		return nil, fmt.Errorf("LOG_MAX_AGE: invalid duration %q", config.Env("LOG_MAX_AGE", ""))
+	}
+	if f.Keep, err = strconv.Atoi(config.Env("LOG_KEEP", "7")); err != nil || f.Keep < 0 {
+		return nil, fmt.Errorf("LOG_KEEP: invalid count %q", config.Env("LOG_KEEP", ""))
+	}
+	if f.Retention, err = time.ParseDuration(config.Env("LOG_RETENTION", "168h")); err != nil || f.Retention < 0 {
+		return nil, fmt.Errorf("LOG_RETENTION: invalid duration %q", config.Env("LOG_RETENTION", ""))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if it's due.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.f == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	full := f.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.MaxSize
+	old := f.MaxAge > 0 && time.Since(f.opened) >= f.MaxAge
+	if full || old {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside, opens a new one and prunes the old ones.
+func (f *File) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+	if err := os.Rename(f.Path, f.Path+"."+time.Now().Format(rotatedFormat)); err != nil {
+		return err
+	}
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.prune()
+	return nil
+}
+
+// prune removes rotated files beyond Keep or older than Retention.
+func (f *File) prune() {
+	rotated, err := filepath.Glob(f.Path + ".*")
+	if err != nil {
+		return
+	}
+	// newest first, the rotation time sorts by name
+	sort.Sort(sort.Reverse(sort.StringSlice(rotated)))
+	for i, name := range rotated {
+		at, err := time.ParseInLocation(rotatedFormat, strings.TrimPrefix(name, f.Path+"."), time.Local)
+		if err != nil {
+			continue
+		}
+		if (f.Keep > 0 && i >= f.Keep) || (f.Retention > 0 && time.Since(at) > f.Retention) {
+			os.Remove(name)
+		}
+	}
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+// Capture sends everything printed to os.Stdout, os.Stderr and the log
+// package to w a line at a time, until the returned stop is called. Output
+// written straight to the process's file descriptors, e.g. by OpenCV or a
+// panic, isn't captured.
+func Capture(w io.Writer) (stop func(), err error) {
+	r, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = pw, pw
+	log.SetOutput(pw)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lines := bufio.NewReader(r)
+		for {
+			line, err := lines.ReadString('\n')
+			if line != "" {
+				if !strings.HasSuffix(line, "\n") {
+					line += "\n"
+				}
+				if _, werr := io.WriteString(w, line); werr != nil {
+					fmt.Fprintf(stderr, "Failed to write log, %s\n", werr)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		os.Stdout, os.Stderr = stdout, stderr
+		log.SetOutput(stderr)
+		pw.Close()
+		<-done
+		r.Close()
+	}, nil
+}
+
+// Log formats, chosen with LOG_FORMAT.
+const (
+	FormatText = "text" // lines as printed
+	FormatJSON = "json" // a JSON object per line, see JSONLine
+)
+
+// JSONLine is a line of output as written by JSON.
+type JSONLine struct {
+	Time  time.Time
+	Level string // error or info, as worded
+	Msg   string
+}
+
+// JSON writes each line written to it to W as a JSONLine, so logs shipped
+// to Loki or Elasticsearch can be parsed without matching the wording. It
+// expects whole lines, as Capture writes them.
+type JSON struct {
+	W io.Writer
+}
+
+func (j JSON) Write(p []byte) (int, error) {
+	var buf []byte
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" {
+			continue
+		}
+		b, err := json.Marshal(JSONLine{Time: time.Now(), Level: LineLevel(line), Msg: line})
+		if err != nil {
+			return 0, err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	if _, err := j.W.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// LineLevel is the level of a line by its wording, "Error reading ..." and
+// "Failed to ..." are errors.
+func LineLevel(line string) string {
+	if strings.HasPrefix(line, "Error") || strings.HasPrefix(line, "Failed") {
+		return "error"
+	}
+	return "info"
+}
@@ -0,0 +1,90 @@
+// Package matpool recycles the Mats frames are copied into, so the pipeline
+// isn't allocating and freeing images every frame, and counts those in use so
+// leaks show up on /metrics.
+package matpool
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/danhigham/speedcam/pkg/resources"
+	"gocv.io/x/gocv"
+)
+
+// live is the number of Mats taken from any pool and not yet put back.
+var live int64
+
+// Live returns how many Mats are out of every pool. It should hold steady
+// while running, growing means something isn't putting its Mats back.
+func Live() int64 {
+	return atomic.LoadInt64(&live)
+}
+
+// Pool keeps up to Max Mats that have been put back for reuse. Mats keep
+// their buffers while pooled, so a frame the same size as the last reuses
+// its memory. A Pool is safe for concurrent use.
+type Pool struct {
+	Max int
+
+	mu   sync.Mutex
+	free []gocv.Mat
+}
+
+func New(max int) *Pool {
+	return &Pool{Max: max}
+}
+
+// Get returns an empty Mat, or a pooled one, which the caller owns until it
+// is Put back.
+func (p *Pool) Get() gocv.Mat {
+	atomic.AddInt64(&live, 1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.free); n > 0 {
+		m := p.free[n-1]
+		p.free = p.free[:n-1]
+		return m
+	}
+	m := gocv.NewMat()
+	resources.Mats.Open(m.Ptr())
+	return m
+}
+
+// Clone returns a copy of src in a Mat from the pool.
+func (p *Pool) Clone(src gocv.Mat) gocv.Mat {
+	m := p.Get()
+	src.CopyTo(&m)
+	return m
+}
+
+// Put gives m back to the pool, closing it if the pool is full. m must not
+// be used afterwards.
+func (p *Pool) Put(m gocv.Mat) {
+	atomic.AddInt64(&live, -1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.free) < p.Max {
+		p.free = append(p.free, m)
+		return
+	}
+	closeMat(m)
+}
+
+// Close closes the pooled Mats. Mats still out are unaffected and closed if
+// Put afterwards.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.free {
+		closeMat(m)
+	}
+	p.free = nil
+	p.Max = 0
+}
+
+func closeMat(m gocv.Mat) {
+	resources.Mats.Close(m.Ptr())
+	m.Close()
+}
@@ -0,0 +1,250 @@
+// Package metrics holds the Prometheus metrics exported on /metrics.
+package metrics
+
+import (
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/matpool"
+	"github.com/danhigham/speedcam/pkg/resources"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	FramesProcessed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "speedcam_frames_processed_total",
+		Help: "Frames read from the capture source and run through detection.",
+	})
+	FramesDropped = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "speedcam_frames_dropped_total",
+		Help: "Frames read from the capture source but not processed.",
+	})
+	CaptureFPS = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "speedcam_capture_fps",
+		Help: "Smoothed rate frames are read from the capture source.",
+	})
+	SourceConnected = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedcam_source_connected",
+		Help: "0 while a camera's dropped stream is being reopened.",
+	}, []string{"camera"})
+	SourceReconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_source_reconnects_total",
+		Help: "Times a camera's stream has been reopened after dropping.",
+	}, []string{"camera"})
+	FrameAge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedcam_frame_age_seconds",
+		Help: "Time since a camera last delivered a new frame, when the watchdog is on.",
+	}, []string{"camera"})
+	DetectionSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "speedcam_detection_seconds",
+		Help:    "Time spent on background subtraction and blob detection for a frame.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+	})
+	StageSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "speedcam_stage_seconds",
+		Help:    "Time each pipeline stage spends on a frame, or on a car for the measure stage.",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 2, 14),
+	}, []string{"stage"})
+	ActiveTracks = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "speedcam_active_tracks",
+		Help: "Vehicles currently being tracked.",
+	})
+	LiveMats = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "speedcam_mats_live",
+		Help: "Frame and evidence Mats in use, steady unless they are leaking.",
+	}, func() float64 { return float64(matpool.Live()) })
+	QualityDegradation = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "speedcam_quality_degradation",
+		Help: "How far detection quality has been lowered to keep up, 0 for not at all.",
+	})
+	CPUTemperature = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "speedcam_cpu_temperature_celsius",
+		Help: "SoC temperature, when a thermal limit is set.",
+	})
+	ThermalThrottled = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "speedcam_thermal_throttled",
+		Help: "1 while the frame rate is halved because the SoC is too hot.",
+	})
+	ClockOffset = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "speedcam_clock_offset_seconds",
+		Help: "How far the system clock was behind NTP when last checked, negative when ahead.",
+	})
+	PipelineRestarts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_pipeline_restarts_total",
+		Help: "Times a camera's pipeline was restarted after failing or panicking.",
+	}, []string{"camera"})
+	StagePanics = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_stage_panics_total",
+		Help: "Frames or cars a pipeline stage panicked on, dropped while the stage carried on.",
+	}, []string{"camera", "stage"})
+	VehiclesCounted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_vehicles_counted_total",
+		Help: "Vehicles tracked across the frame, timed or not, by direction and class.",
+	}, []string{"camera", "direction", "class"})
+	Occupancy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "speedcam_occupancy_ratio",
+		Help: "Fraction of the last minute a camera had a vehicle in view.",
+	}, []string{"camera"})
+	NearMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_near_misses_total",
+		Help: "Vehicles coming close to, or on course to hit, a pedestrian or cyclist.",
+	}, []string{"camera"})
+	CrosswalkYields = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_crosswalk_yields_total",
+		Help: "Vehicles reaching the crosswalk while someone was on it, by whether they yielded.",
+	}, []string{"camera", "yielded"})
+	TrafficAnomalies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_traffic_anomalies_total",
+		Help: "Hours whose volume or 85th percentile speed strayed far from the same hour in earlier weeks.",
+	}, []string{"camera", "metric"})
+	PlateReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_plate_reads_total",
+		Help: "Violations whose plate was looked for, by whether one was read.",
+	}, []string{"camera", "result"})
+	EventsRecorded = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "speedcam_events_recorded_total",
+		Help: "Vehicles timed and recorded in the journal.",
+	})
+	VehicleSpeed = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "speedcam_vehicle_speed_mph",
+		Help:    "Speeds of vehicles timed, for percentiles over any window.",
+		Buckets: prometheus.LinearBuckets(5, 5, 16),
+	}, []string{"camera", "direction", "lane"})
+	Violations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_violations_total",
+		Help: "Vehicles timed over their speed limit.",
+	}, []string{"camera", "direction", "lane"})
+	EventsPublished = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "speedcam_events_published_total",
+		Help: "Events published to AMQP.",
+	})
+	EventsMuted = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "speedcam_events_muted_total",
+		Help: "Events not published because alerts were muted or cooling down.",
+	})
+	PublishFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "speedcam_publish_failures_total",
+		Help: "Events that failed to publish to AMQP.",
+	})
+	UploadSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "speedcam_upload_seconds",
+		Help:    "Time taken to upload an evidence image.",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 10),
+	})
+	LoadShed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "speedcam_load_shed_total",
+		Help: "Work skipped to keep up, evidence left out of events or uploads left for repair.",
+	}, []string{"what"})
+	UploadFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "speedcam_upload_failures_total",
+		Help: "Evidence images that failed to upload and were left in the spool.",
+	})
+)
+
+func init() {
+	for _, k := range resources.Kinds() {
+		k := k
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "speedcam_resources_open",
+			Help:        "OpenCV objects open outside the Go heap by kind, steady unless they are leaking.",
+			ConstLabels: prometheus.Labels{"kind": k.Name},
+		}, func() float64 { return float64(k.Count()) })
+	}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// RegisterControls exposes the pause and mute switches.
+// RegisterP85 exports the 85th percentile speed over each window, computed
+// by p85 when scraped.
+func RegisterP85(windows []time.Duration, p85 func(window time.Duration) float64) {
+	for _, w := range windows {
+		w := w
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "speedcam_speed_p85_mph",
+			Help:        "85th percentile speed of vehicles timed over the window up to now.",
+			ConstLabels: prometheus.Labels{"window": journal.WindowName(w)},
+		}, func() float64 { return p85(w) })
+	}
+}
+
+// RegisterRolling exports the rolling statistics over each window as last
+// updated, e.g. to alert when volume or speeds stray from the usual.
+func RegisterRolling(rolling *journal.Rolling) {
+	for _, w := range rolling.Windows {
+		name := journal.WindowName(w)
+		labels := prometheus.Labels{"window": name}
+		stat := func(f func(journal.RollingWindow) float64) func() float64 {
+			return func() float64 {
+				rw, _ := rolling.Window(name)
+				return f(rw)
+			}
+		}
+
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "speedcam_rolling_vehicles",
+			Help:        "Vehicles timed over the window up to the last update.",
+			ConstLabels: labels,
+		}, stat(func(rw journal.RollingWindow) float64 { return float64(rw.Vehicles) }))
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "speedcam_rolling_speed_mph",
+			Help:        "Median and 85th percentile speed over the window up to the last update.",
+			ConstLabels: prometheus.Labels{"window": name, "quantile": "0.5"},
+		}, stat(func(rw journal.RollingWindow) float64 { return rw.MedianSpeed }))
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "speedcam_rolling_speed_mph",
+			Help:        "Median and 85th percentile speed over the window up to the last update.",
+			ConstLabels: prometheus.Labels{"window": name, "quantile": "0.85"},
+		}, stat(func(rw journal.RollingWindow) float64 { return rw.P85Speed }))
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "speedcam_rolling_violation_ratio",
+			Help:        "Fraction of vehicles over their limit over the window up to the last update.",
+			ConstLabels: labels,
+		}, stat(func(rw journal.RollingWindow) float64 { return rw.ViolationRate / 100 }))
+	}
+}
+
+// RegisterDevice exports the device's name and site, see config.Device.
+func RegisterDevice(name string, site string) {
+	promauto.NewGauge(prometheus.GaugeOpts{
+		Name:        "speedcam_device_info",
+		Help:        "Always 1, labelled with the device's name and site.",
+		ConstLabels: prometheus.Labels{"name": name, "site": site},
+	}).Set(1)
+}
+
+// DeviceGatherer gathers the default registry's metrics with a device label
+// added to every one, so a fleet's can be told apart however they're
+// scraped.
+func DeviceGatherer(id string) prometheus.Gatherer {
+	label := "device"
+	return prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) {
+		families, err := prometheus.DefaultGatherer.Gather()
+		for _, f := range families {
+			for _, m := range f.Metric {
+				m.Label = append(m.Label, &dto.LabelPair{Name: &label, Value: &id})
+			}
+		}
+		return families, err
+	})
+}
+
+func RegisterControls(controls *control.Controls) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "speedcam_detection_paused",
+		Help: "1 while detection is paused.",
+	}, func() float64 { return boolGauge(controls.Paused()) })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "speedcam_alerts_muted",
+		Help: "1 while alerts are muted.",
+	}, func() float64 { return boolGauge(controls.Muted()) })
+}
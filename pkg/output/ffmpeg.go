@@ -0,0 +1,59 @@
+// Package output encodes stream views for HLS, RTMP, WebRTC and local
+// recording with ffmpeg.
+package output
+
+import (
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/danhigham/speedcam/pkg/stream"
+)
+
+// ffmpegInput are the arguments for reading the JPEGs written by pipeFrames
+// from stdin, timestamped as they arrive.
+var ffmpegInput = []string{
+	"-loglevel", "error",
+	"-f", "mjpeg",
+	"-use_wallclock_as_timestamps", "1",
+	"-i", "-",
+}
+
+// x264 needs even dimensions
+var ffmpegEvenScale = []string{"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2"}
+
+// runFFmpeg feeds view into an ffmpeg process run with args until either
+// side fails.
+func runFFmpeg(view stream.FrameView, args ...string) error {
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	err = pipeFrames(view, stdin)
+	stdin.Close()
+	cmd.Wait()
+	return err
+}
+
+// pipeFrames writes every frame of view to w as a JPEG, the mjpeg input
+// format ffmpeg expects, until a write fails.
+func pipeFrames(view stream.FrameView, w io.Writer) error {
+	for {
+		<-view.Hub.Updated()
+
+		buf, err := view.Hub.Render(view.UseMask, view.Defaults)
+		if err != nil {
+			continue
+		}
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+}
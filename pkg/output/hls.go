@@ -0,0 +1,71 @@
+package output
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/stream"
+)
+
+const hlsPlaylist = "live.m3u8"
+
+// HLSOutput encodes a view to H.264 with ffmpeg and writes it as a rolling HLS
+// playlist in Dir, far cheaper to watch over cellular than MJPEG.
+type HLSOutput struct {
+	Dir     string
+	Bitrate string
+	View    stream.FrameView
+}
+
+func NewHLSOutput(dir string, view stream.FrameView) (*HLSOutput, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &HLSOutput{Dir: dir, Bitrate: config.Env("HLS_BITRATE", "500k"), View: view}, nil
+}
+
+// Run keeps an ffmpeg encoder fed with frames, restarting it if it exits.
+func (h *HLSOutput) Run() {
+	for {
+		err := h.encode()
+		fmt.Printf("HLS encoder stopped, %v, restarting\n", err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+func (h *HLSOutput) encode() error {
+	args := append([]string{}, ffmpegInput...)
+	args = append(args, ffmpegEvenScale...)
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-tune", "zerolatency",
+		"-pix_fmt", "yuv420p",
+		"-b:v", h.Bitrate,
+		"-g", "30",
+		"-f", "hls",
+		"-hls_time", "2",
+		"-hls_list_size", "5",
+		"-hls_flags", "delete_segments+omit_endlist",
+		filepath.Join(h.Dir, hlsPlaylist),
+	)
+	return runFFmpeg(h.View, args...)
+}
+
+func (h *HLSOutput) Handler() http.Handler {
+	files := http.FileServer(http.Dir(h.Dir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".m3u8") {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Content-Type", "video/mp2t")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
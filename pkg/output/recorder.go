@@ -0,0 +1,154 @@
+package output
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/journal"
+	"github.com/danhigham/speedcam/pkg/stream"
+)
+
+// Recorder writes the raw feed to a rolling set of MP4 segments, each closed
+// once it reaches SegmentDuration or SegmentSize, and prunes old segments to
+// stay within Retention and MaxTotal.
+type Recorder struct {
+	Dir             string
+	SegmentDuration time.Duration
+	SegmentSize     int64 // bytes
+	Retention       time.Duration
+	MaxTotal        int64 // bytes, 0 for no limit
+	View            stream.FrameView
+	Journal         *journal.Journal // deletions are audited when set
+}
+
+// NewRecorder configures a recorder into dir from the RECORD_* environment
+// variables.
+func NewRecorder(dir string, view stream.FrameView, j *journal.Journal) (*Recorder, error) {
+	r := &Recorder{Dir: dir, View: view, Journal: j}
+
+	var err error
+	if r.SegmentDuration, err = time.ParseDuration(config.Env("RECORD_SEGMENT", "10m")); err != nil {
+		return nil, fmt.Errorf("RECORD_SEGMENT: %s", err)
+	}
+	if r.Retention, err = time.ParseDuration(config.Env("RECORD_RETENTION", "72h")); err != nil {
+		return nil, fmt.Errorf("RECORD_RETENTION: %s", err)
+	}
+	segmentMB, err := strconv.ParseInt(config.Env("RECORD_SEGMENT_MB", "100"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("RECORD_SEGMENT_MB: %s", err)
+	}
+	totalMB, err := strconv.ParseInt(config.Env("RECORD_MAX_TOTAL_MB", "0"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("RECORD_MAX_TOTAL_MB: %s", err)
+	}
+	r.SegmentSize = segmentMB << 20
+	r.MaxTotal = totalMB << 20
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *Recorder) Run() {
+	go func() {
+		for {
+			if err := r.prune(); err != nil {
+				fmt.Printf("Failed to prune recordings, %s\n", err)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+
+	for {
+		started := time.Now()
+		err := r.record()
+
+		// ffmpeg exiting at the segment limits is the normal rollover, only
+		// back off if it's failing straight away
+		if time.Since(started) < 5*time.Second {
+			fmt.Printf("Recording failed, %v\n", err)
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+func (r *Recorder) record() error {
+	filename := filepath.Join(r.Dir, time.Now().Format("20060102-150405")+".mp4")
+
+	args := append([]string{}, ffmpegInput...)
+	args = append(args, ffmpegEvenScale...)
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-pix_fmt", "yuv420p",
+		// fragmented so a segment cut short by a crash or power loss still plays
+		"-movflags", "+frag_keyframe+empty_moov+default_base_moof",
+		"-t", strconv.FormatFloat(r.SegmentDuration.Seconds(), 'f', 0, 64),
+		"-fs", strconv.FormatInt(r.SegmentSize, 10),
+		filename,
+	)
+	return runFFmpeg(r.View, args...)
+}
+
+func (r *Recorder) prune() error {
+	segments, err := filepath.Glob(filepath.Join(r.Dir, "*.mp4"))
+	if err != nil {
+		return err
+	}
+	// names are timestamps, newest last. The newest is still being written.
+	sort.Strings(segments)
+	if len(segments) < 2 {
+		return nil
+	}
+	segments = segments[:len(segments)-1]
+
+	var total int64
+	sizes := map[string]int64{}
+	for _, s := range segments {
+		info, err := os.Stat(s)
+		if err != nil {
+			continue
+		}
+		sizes[s] = info.Size()
+		total += info.Size()
+
+		if time.Since(info.ModTime()) > r.Retention {
+			if err := r.remove(s, "older than retention"); err != nil {
+				return err
+			}
+			total -= info.Size()
+			delete(sizes, s)
+		}
+	}
+
+	for _, s := range segments {
+		if r.MaxTotal == 0 || total <= r.MaxTotal {
+			break
+		}
+		size, ok := sizes[s]
+		if !ok {
+			continue
+		}
+		if err := r.remove(s, "recording size limit"); err != nil {
+			return err
+		}
+		total -= size
+	}
+	return nil
+}
+
+func (r *Recorder) remove(filename string, reason string) error {
+	if err := os.Remove(filename); err != nil {
+		return err
+	}
+	if r.Journal != nil {
+		return r.Journal.Audit(journal.AuditDelete, "retention", filename, reason)
+	}
+	return nil
+}
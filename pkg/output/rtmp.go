@@ -0,0 +1,53 @@
+package output
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/stream"
+)
+
+// RTMPOutput pushes a view to an RTMP ingest, a local media server or a
+// platform such as YouTube, so a public stream never touches the device.
+type RTMPOutput struct {
+	URL     string
+	Bitrate string
+	View    stream.FrameView
+}
+
+func NewRTMPOutput(url string, view stream.FrameView) *RTMPOutput {
+	return &RTMPOutput{URL: url, Bitrate: config.Env("RTMP_BITRATE", "1000k"), View: view}
+}
+
+// Run keeps pushing, reconnecting after the ingest drops us.
+func (o *RTMPOutput) Run() {
+	for {
+		err := o.push()
+		fmt.Printf("RTMP push stopped, %v, reconnecting\n", err)
+		time.Sleep(10 * time.Second)
+	}
+}
+
+func (o *RTMPOutput) push() error {
+	args := append([]string{}, ffmpegInput...)
+	args = append(args,
+		// most ingests reject streams without audio, send silence
+		"-f", "lavfi",
+		"-i", "anullsrc=channel_layout=stereo:sample_rate=44100",
+	)
+	args = append(args, ffmpegEvenScale...)
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-tune", "zerolatency",
+		"-pix_fmt", "yuv420p",
+		"-b:v", o.Bitrate,
+		"-g", "60",
+		"-c:a", "aac",
+		"-b:a", "64k",
+		"-f", "flv",
+		o.URL,
+	)
+	return runFFmpeg(o.View, args...)
+}
@@ -0,0 +1,276 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/stream"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+	"github.com/pion/webrtc/v3/pkg/media/h264reader"
+)
+
+// WebRTCOutput serves a view over WebRTC for sub-second latency. A single
+// ffmpeg H.264 encoder, started with the first viewer and stopped once the
+// last has gone, feeds one track shared by every peer connection.
+type WebRTCOutput struct {
+	View       stream.FrameView
+	ICEServers []webrtc.ICEServer
+	track      *webrtc.TrackLocalStaticSample
+
+	mu     sync.Mutex
+	peers  map[*webrtc.PeerConnection]bool
+	stop   chan struct{} // closed to stop the encoder, nil while it isn't running
+	closed bool
+}
+
+func NewWebRTCOutput(view stream.FrameView) (*WebRTCOutput, error) {
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264}, "video", "speedcam")
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []webrtc.ICEServer
+	if urls := os.Getenv("WEBRTC_ICE_SERVERS"); urls != "" {
+		servers = append(servers, webrtc.ICEServer{URLs: strings.Split(urls, ",")})
+	}
+
+	return &WebRTCOutput{View: view, ICEServers: servers, track: track, peers: make(map[*webrtc.PeerConnection]bool)}, nil
+}
+
+// join counts pc as viewing, starting the encoder for the first viewer.
+func (o *WebRTCOutput) join(pc *webrtc.PeerConnection) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return false
+	}
+	o.peers[pc] = true
+	if o.stop == nil {
+		o.stop = make(chan struct{})
+		go o.run(o.stop)
+	}
+	return true
+}
+
+// leave stops counting pc, stopping the encoder once nobody is viewing.
+func (o *WebRTCOutput) leave(pc *webrtc.PeerConnection) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.peers[pc] {
+		return
+	}
+	delete(o.peers, pc)
+	if len(o.peers) == 0 && o.stop != nil {
+		close(o.stop)
+		o.stop = nil
+	}
+}
+
+// Close stops the encoder and closes every peer connection, turning away
+// any more, e.g. when shutting down.
+func (o *WebRTCOutput) Close() error {
+	o.mu.Lock()
+	o.closed = true
+	peers := make([]*webrtc.PeerConnection, 0, len(o.peers))
+	for pc := range o.peers {
+		peers = append(peers, pc)
+	}
+	o.peers = make(map[*webrtc.PeerConnection]bool)
+	if o.stop != nil {
+		close(o.stop)
+		o.stop = nil
+	}
+	o.mu.Unlock()
+
+	for _, pc := range peers {
+		pc.Close()
+	}
+	return nil
+}
+
+// run keeps the encoder going until stop is closed.
+func (o *WebRTCOutput) run(stop <-chan struct{}) {
+	for {
+		err := o.encode(stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		fmt.Printf("WebRTC encoder stopped, %v, restarting\n", err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (o *WebRTCOutput) encode(stop <-chan struct{}) error {
+	args := append([]string{}, ffmpegInput...)
+	args = append(args, ffmpegEvenScale...)
+	args = append(args,
+		"-c:v", "libx264",
+		"-preset", "ultrafast",
+		"-tune", "zerolatency",
+		"-profile:v", "baseline",
+		"-pix_fmt", "yuv420p",
+		"-g", "30",
+		"-f", "h264",
+		"-",
+	)
+	cmd := exec.Command("ffmpeg", args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-stop:
+			cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	go func() {
+		pipeFrames(o.View, stdin)
+		stdin.Close()
+	}()
+
+	err = o.writeSamples(stdout)
+	stdin.Close()
+	cmd.Wait()
+	return err
+}
+
+func (o *WebRTCOutput) writeSamples(r io.Reader) error {
+	reader, err := h264reader.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	lastFrame := time.Now()
+	for {
+		nal, err := reader.NextNAL()
+		if err != nil {
+			return err
+		}
+
+		// parameter sets and the like belong to the following picture, only
+		// slices advance the clock
+		var duration time.Duration
+		switch nal.UnitType {
+		case h264reader.NalUnitTypeCodedSliceIdr, h264reader.NalUnitTypeCodedSliceNonIdr:
+			now := time.Now()
+			duration = now.Sub(lastFrame)
+			lastFrame = now
+		}
+
+		if err := o.track.WriteSample(media.Sample{Data: nal.Data, Duration: duration}); err != nil {
+			return err
+		}
+	}
+}
+
+// ServeHTTP accepts an SDP offer POSTed as application/sdp and answers with
+// the SDP of a send-only peer connection, WHEP style. ICE candidates are
+// gathered before answering so no trickle signalling is needed.
+func (o *WebRTCOutput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	offer, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: o.ICEServers})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !o.join(pc) {
+		pc.Close()
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	answer, err := o.negotiate(pc, string(offer))
+	if err != nil {
+		pc.Close()
+		o.leave(pc)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+func (o *WebRTCOutput) negotiate(pc *webrtc.PeerConnection, offer string) (string, error) {
+	sender, err := pc.AddTrack(o.track)
+	if err != nil {
+		return "", err
+	}
+
+	// RTCP has to be read for the interceptors (NACK etc.) to work
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := sender.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateDisconnected:
+			pc.Close()
+			o.leave(pc)
+		case webrtc.PeerConnectionStateClosed:
+			o.leave(pc)
+		}
+	})
+
+	err = pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer})
+	if err != nil {
+		return "", err
+	}
+
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gathered
+
+	return pc.LocalDescription().SDP, nil
+}
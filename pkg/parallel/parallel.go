@@ -0,0 +1,45 @@
+// Package parallel spreads independent per-item work, like filtering
+// contours or updating each car's tracker, across the CPUs.
+package parallel
+
+import (
+	"runtime"
+	"sync"
+)
+
+// minItems is the fewest items worth starting goroutines for, below it the
+// work runs inline.
+const minItems = 4
+
+// For calls fn for every i from 0 to n-1 across up to one worker per CPU,
+// returning once all have. Calls must not depend on each other's order.
+func For(n int, fn func(i int)) {
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	if n < minItems || workers < 2 {
+		for i := 0; i < n; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	next := make(chan int, n)
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				fn(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
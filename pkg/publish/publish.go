@@ -0,0 +1,122 @@
+// Package publish sends events to the cars queue on RabbitMQ, alerts about
+// the camera itself to the alerts queue and heartbeats to the heartbeats
+// queue.
+package publish
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/event"
+	"github.com/streadway/amqp"
+)
+
+type Publisher struct {
+	Device string // stamped on every car and alert, DEVICE_ID, see config.Device
+
+	conn       *amqp.Connection
+	ch         *amqp.Channel
+	queue      string
+	alerts     string
+	heartbeats string
+}
+
+func New() (*Publisher, error) {
+	rabbitURL := fmt.Sprintf("amqp://%s:%s@%s:%s/", os.Getenv("RABBIT_USER"), os.Getenv("RABBIT_PASS"), os.Getenv("RABBIT_HOST"), os.Getenv("RABBIT_PORT"))
+	fmt.Printf("Connecting to AMPQ at %s\n", rabbitURL)
+
+	conn, err := amqp.Dial(rabbitURL)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to connect to RabbitMQ: %s", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Failed to open a channel: %s", err)
+	}
+
+	p := &Publisher{Device: config.LoadDevice().ID, conn: conn, ch: ch}
+	for _, q := range []struct {
+		name  string
+		queue *string
+	}{{"cars", &p.queue}, {"alerts", &p.alerts}, {"heartbeats", &p.heartbeats}} {
+		declared, err := ch.QueueDeclare(
+			q.name, // name
+			false,  // durable
+			false,  // delete when unused
+			false,  // exclusive
+			false,  // no-wait
+			nil,    // arguments
+		)
+		if err != nil {
+			ch.Close()
+			conn.Close()
+			return nil, fmt.Errorf("Failed to declare a queue: %s", err)
+		}
+		*q.queue = declared.Name
+	}
+
+	return p, nil
+}
+
+func (p *Publisher) Publish(carMessage event.CarMessage) error {
+	carMessage.Device = p.Device
+	jsonMsg, err := json.Marshal(carMessage)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Publishing message %s\n", string(jsonMsg))
+
+	return p.ch.Publish(
+		"",      // exchange
+		p.queue, // routing key
+		false,   // mandatory
+		false,   // immediate
+		amqp.Publishing{
+			ContentType: "application/json",
+			Body:        jsonMsg,
+		})
+}
+
+func (p *Publisher) PublishAlert(alert event.Alert) error {
+	alert.Device = p.Device
+	jsonMsg, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Publishing alert %s\n", string(jsonMsg))
+
+	return p.ch.Publish("", p.alerts, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        jsonMsg,
+	})
+}
+
+// PublishHeartbeat publishes hb to the heartbeats queue, quietly as there
+// is one every interval.
+func (p *Publisher) PublishHeartbeat(hb event.Heartbeat) error {
+	jsonMsg, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	return p.ch.Publish("", p.heartbeats, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Body:        jsonMsg,
+	})
+}
+
+// Connected reports whether the broker connection is still open, it is not
+// re-established once lost.
+func (p *Publisher) Connected() bool {
+	return !p.conn.IsClosed()
+}
+
+func (p *Publisher) Close() {
+	p.ch.Close()
+	p.conn.Close()
+}
@@ -0,0 +1,428 @@
+// Package report renders traffic reports as HTML and PDF and sends them on
+// a schedule.
+package report
+
+import (
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/evidence"
+	"github.com/danhigham/speedcam/pkg/journal"
+)
+
+//go:embed templates/report.html
+var reportTemplateFile embed.FS
+
+var reportTemplate = template.Must(template.ParseFS(reportTemplateFile, "templates/report.html"))
+
+const reportEvidenceCount = 3
+
+type ReportEvidence struct {
+	Event journal.Event
+	Image template.URL // data URI, empty if the image couldn't be read
+}
+
+type Report struct {
+	Title         string
+	Period        string
+	From          time.Time
+	To            time.Time
+	GeneratedAt   time.Time
+	Timezone      string // times are in, e.g. Europe/London, empty if unknown
+	Summary       journal.Aggregate
+	ViolationRate float64 // percent of vehicles over their limit
+	VolumeChart   template.HTML
+	P85Chart      template.HTML // 85th percentile speed per hour or day
+	SpeedChart    template.HTML
+	Classes       []journal.ClassShare // empty without a classifier
+	Lanes         []journal.LaneStats  // empty without lanes
+	Tags          []journal.Tag        // on events and ranges in the period
+	Excluded      []string             // tags whose events the figures leave out
+	Change        journal.Comparison   // with a week before, the same weekday for daily reports
+	Fastest       []ReportEvidence
+	Cameras       []config.CameraInfo // those with anything known about them
+}
+
+// Direction is the way e's vehicle was heading as known on its camera's
+// road, e.g. northbound.
+func (r Report) Direction(e journal.Event) string {
+	for _, c := range r.Cameras {
+		if c.ID == e.Camera {
+			return c.DirectionLabel(e.Direction)
+		}
+	}
+	return e.Direction
+}
+
+// reportRange returns the period a report run at now covers, the previous
+// day or the previous seven days, both ending at midnight.
+func reportRange(period string, now time.Time) (time.Time, time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch period {
+	case "daily":
+		return midnight.AddDate(0, 0, -1), midnight, nil
+	case "weekly":
+		return midnight.AddDate(0, 0, -7), midnight, nil
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unknown report period %s", period)
+}
+
+// BuildReport gathers the figures, charts and evidence for a report, headed
+// with what's known of cameras. Evidence images are embedded so the HTML
+// stands alone when emailed.
+func BuildReport(j *journal.Journal, store *evidence.Store, cameras []config.CameraInfo, period string, from time.Time, to time.Time, excludeTags []string) (Report, error) {
+	report := Report{
+		Title:       fmt.Sprintf("Traffic report %s to %s", from.Format("2 Jan 2006"), to.Add(-time.Second).Format("2 Jan 2006")),
+		Period:      period,
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now(),
+		Timezone:    config.Timezone(),
+		Excluded:    excludeTags,
+	}
+	for _, c := range cameras {
+		if c.Road != "" || c.PostedLimit > 0 || c.HasLocation() {
+			report.Cameras = append(report.Cameras, c)
+		}
+	}
+	if len(cameras) == 1 && cameras[0].Road != "" {
+		report.Title = fmt.Sprintf("Traffic report for %s, %s to %s", cameras[0].Road, from.Format("2 Jan 2006"), to.Add(-time.Second).Format("2 Jan 2006"))
+	}
+	// every figure leaves out the events tagged with excludeTags
+	filter := func(from time.Time, to time.Time) journal.Filter {
+		return journal.Filter{From: from, To: to, ExcludeTags: excludeTags}
+	}
+
+	// hourly volume for a day, daily for anything longer
+	grouping, labelFormat := "day", "Mon 2"
+	if to.Sub(from) <= 24*time.Hour {
+		grouping, labelFormat = "hour", "15"
+	}
+	agg, err := j.Aggregate(filter(from, to), []string{grouping})
+	if err != nil {
+		return report, err
+	}
+	report.Summary = agg.Total
+	if agg.Total.Vehicles > 0 {
+		report.ViolationRate = 100 * float64(agg.Total.Violations) / float64(agg.Total.Vehicles)
+	}
+
+	var labels []string
+	var counts, p85s []float64
+	for _, g := range agg.Groups {
+		label := g.Group[grouping]
+		if t, err := time.ParseInLocation(map[string]string{"day": "2006-01-02", "hour": time.RFC3339}[grouping], label, from.Location()); err == nil {
+			label = t.Format(labelFormat)
+		}
+		labels = append(labels, label)
+		counts = append(counts, float64(g.Vehicles))
+		p85s = append(p85s, math.Round(g.P85Speed))
+	}
+	report.VolumeChart = barChartSVG(labels, counts)
+	report.P85Chart = barChartSVG(labels, p85s)
+
+	classes, err := j.ClassBreakdown(filter(from, to))
+	if err != nil {
+		return report, err
+	}
+	if len(classes) > 1 || len(classes) == 1 && classes[0].Class != "" {
+		report.Classes = classes
+	}
+
+	lanes, err := j.LaneBreakdown(filter(from, to))
+	if err != nil {
+		return report, err
+	}
+	if len(lanes) > 1 || len(lanes) == 1 && lanes[0].Lane != "" {
+		report.Lanes = lanes
+	}
+
+	report.Change, err = j.Compare(filter(from.AddDate(0, 0, -7), to.AddDate(0, 0, -7)), filter(from, to))
+	if err != nil {
+		return report, err
+	}
+
+	h, err := j.SpeedHistogram(filter(from, to), journal.LiveHistogramBinWidth)
+	if err != nil {
+		return report, err
+	}
+	labels, counts = nil, nil
+	for _, b := range h.Bins {
+		labels = append(labels, strconv.FormatFloat(b.From, 'f', -1, 64))
+		counts = append(counts, float64(b.Count))
+	}
+	report.SpeedChart = barChartSVG(labels, counts)
+
+	if report.Tags, err = j.Tags(from, to); err != nil {
+		return report, err
+	}
+
+	fastest, err := j.QueryEvents(journal.Filter{From: from, To: to, ExcludeTags: excludeTags, Sort: "-speed", Limit: reportEvidenceCount})
+	if err != nil {
+		return report, err
+	}
+	for _, e := range fastest {
+		re := ReportEvidence{Event: e}
+		if store != nil {
+			if img, err := readEvidence(store, e.ImageURI); err == nil {
+				re.Image = template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(img))
+			} else {
+				fmt.Printf("Failed to read evidence for %s, %s\n", e.ID.String(), err)
+			}
+		}
+		report.Fastest = append(report.Fastest, re)
+	}
+
+	return report, nil
+}
+
+func readEvidence(store *evidence.Store, key string) ([]byte, error) {
+	r, err := store.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// barChartSVG draws a minimal bar chart, inline SVG so it needs no scripts
+// and survives email clients and PDF conversion.
+func barChartSVG(labels []string, values []float64) template.HTML {
+	const width, height, axis = 640.0, 200.0, 20.0
+	if len(values) == 0 {
+		return template.HTML(`<p>No data</p>`)
+	}
+
+	max := 0.0
+	for _, v := range values {
+		max = math.Max(max, v)
+	}
+	barWidth := width / float64(len(values))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" font-family="sans-serif" font-size="10">`, width, height)
+	for i, v := range values {
+		h := 0.0
+		if max > 0 {
+			h = v / max * (height - axis - 12)
+		}
+		x := float64(i) * barWidth
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="#3a7"/>`, x+1, height-axis-h, math.Max(barWidth-2, 1), h)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%g">%s</text>`, x+2, height-6, template.HTMLEscapeString(labels[i]))
+		if v > 0 {
+			fmt.Fprintf(&b, `<text x="%.1f" y="%.1f">%g</text>`, x+2, height-axis-h-2, v)
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func (r Report) HTML() ([]byte, error) {
+	var buf bytes.Buffer
+	err := reportTemplate.Execute(&buf, r)
+	return buf.Bytes(), err
+}
+
+// htmlToPDF converts with wkhtmltopdf, which must be installed.
+func htmlToPDF(htmlFile string, pdfFile string) error {
+	out, err := exec.Command("wkhtmltopdf", "--quiet", "--enable-local-file-access", htmlFile, pdfFile).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wkhtmltopdf: %s: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Reporter writes scheduled reports to Dir, as PDF as well when PDF is set,
+// and emails them when SMTP is configured.
+type Reporter struct {
+	Dir         string
+	Periods     []string // daily, weekly
+	PDF         bool
+	Email       *ReportMailer // nil to not email
+	ExcludeTags []string      // events tagged with any are left out
+	Cameras     []config.CameraInfo
+	Journal     *journal.Journal
+	Evidence    *evidence.Store
+}
+
+func NewReporter(j *journal.Journal, store *evidence.Store) (*Reporter, error) {
+	r := &Reporter{
+		Dir:      config.Env("REPORT_DIR", "./reports"),
+		Periods:  strings.Split(config.Env("REPORT_SCHEDULE", "daily"), ","),
+		Journal:  j,
+		Evidence: store,
+	}
+	for _, p := range r.Periods {
+		if _, _, err := reportRange(p, time.Now()); err != nil {
+			return nil, fmt.Errorf("REPORT_SCHEDULE: %s", err)
+		}
+	}
+	r.PDF, _ = strconv.ParseBool(os.Getenv("REPORT_PDF"))
+	if v := config.Env("REPORT_EXCLUDE_TAGS", "false positive"); v != "" {
+		r.ExcludeTags = strings.Split(v, ",")
+	}
+
+	if to := os.Getenv("REPORT_EMAIL_TO"); to != "" {
+		r.Email = &ReportMailer{
+			Host: os.Getenv("SMTP_HOST"),
+			Port: config.Env("SMTP_PORT", "587"),
+			User: os.Getenv("SMTP_USER"),
+			Pass: os.Getenv("SMTP_PASS"),
+			From: config.Env("SMTP_FROM", os.Getenv("SMTP_USER")),
+			To:   strings.Split(to, ","),
+		}
+		if r.Email.Host == "" {
+			return nil, errors.New("REPORT_EMAIL_TO needs SMTP_HOST")
+		}
+	}
+
+	return r, os.MkdirAll(r.Dir, 0755)
+}
+
+// Run generates each report just after the midnight that ends its period,
+// weekly reports on Monday mornings.
+func (r *Reporter) Run() {
+	for {
+		now := time.Now()
+		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 5, 0, 0, now.Location())
+		time.Sleep(time.Until(next))
+
+		for _, p := range r.Periods {
+			if p == "weekly" && next.Weekday() != time.Monday {
+				continue
+			}
+			if _, err := r.Generate(p, next); err != nil {
+				fmt.Printf("Failed to generate %s report, %s\n", p, err)
+			}
+		}
+	}
+}
+
+// Generate builds the report for period as of now, writes it out and emails
+// it, returning the files written.
+func (r *Reporter) Generate(period string, now time.Time) ([]string, error) {
+	from, to, err := reportRange(period, now)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := BuildReport(r.Journal, r.Evidence, r.Cameras, period, from, to, r.ExcludeTags)
+	if err != nil {
+		return nil, err
+	}
+	html, err := report.HTML()
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Join(r.Dir, fmt.Sprintf("speedcam-%s-%s", period, from.Format("2006-01-02")))
+	files := []string{base + ".html"}
+	if err := os.WriteFile(files[0], html, 0644); err != nil {
+		return nil, err
+	}
+	if r.PDF {
+		if err := htmlToPDF(files[0], base+".pdf"); err != nil {
+			return files, err
+		}
+		files = append(files, base+".pdf")
+	}
+	fmt.Printf("Wrote %s report to %s\n", period, strings.Join(files, ", "))
+
+	if len(report.Fastest) > 0 {
+		detail := fmt.Sprintf("%s report with %d evidence images", period, len(report.Fastest))
+		if err := r.Journal.Audit(journal.AuditExport, "report", files[0], detail); err != nil {
+			fmt.Printf("Failed to record report in audit log, %s\n", err)
+		}
+	}
+
+	if r.Email != nil {
+		if err := r.Email.Send(report.Title, html, files[1:]); err != nil {
+			return files, fmt.Errorf("emailing report: %s", err)
+		}
+		if len(report.Fastest) > 0 {
+			r.Journal.Audit(journal.AuditExport, "report", strings.Join(r.Email.To, ","), fmt.Sprintf("emailed %s report", period))
+		}
+		fmt.Printf("Emailed %s report to %s\n", period, strings.Join(r.Email.To, ", "))
+	}
+
+	return files, nil
+}
+
+type ReportMailer struct {
+	Host string
+	Port string
+	User string
+	Pass string
+	From string
+	To   []string
+}
+
+// Send mails html as the body with attachments, PDFs of the same report.
+func (m *ReportMailer) Send(subject string, html []byte, attachments []string) error {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		m.From, strings.Join(m.To, ", "), subject, mw.Boundary())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+	writeBase64Lines(part, html)
+
+	for _, file := range attachments {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"application/pdf"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf(`attachment; filename="%s"`, filepath.Base(file))},
+		})
+		if err != nil {
+			return err
+		}
+		writeBase64Lines(part, b)
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.User != "" {
+		auth = smtp.PlainAuth("", m.User, m.Pass, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, m.To, body.Bytes())
+}
+
+// writeBase64Lines encodes b in 76 character lines, SMTP rejects lines
+// longer than 998.
+func writeBase64Lines(w io.Writer, b []byte) {
+	enc := base64.StdEncoding.EncodeToString(b)
+	for len(enc) > 76 {
+		io.WriteString(w, enc[:76]+"\r\n")
+		enc = enc[76:]
+	}
+	io.WriteString(w, enc+"\r\n")
+}
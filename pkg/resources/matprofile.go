@@ -0,0 +1,20 @@
+//go:build matprofile
+
+package resources
+
+import (
+	"io"
+
+	"gocv.io/x/gocv"
+)
+
+// MatsProfiled is whether gocv counts every Mat it creates, built with
+// -tags matprofile.
+const MatsProfiled = true
+
+// with gocv counting, Mats covers every Mat, not only the pooled ones, and
+// where each was created is always known
+func init() {
+	Mats.count = func() int64 { return int64(gocv.MatProfile.Count()) }
+	Mats.report = func(w io.Writer) { gocv.MatProfile.WriteTo(w, 1) }
+}
@@ -0,0 +1,7 @@
+//go:build !matprofile
+
+package resources
+
+// MatsProfiled is whether gocv counts every Mat it creates, always false
+// without -tags matprofile, when Mats only counts the pooled ones.
+const MatsProfiled = false
@@ -0,0 +1,149 @@
+// Package resources counts the OpenCV objects held outside the Go heap, by
+// kind, so a leak shows up on /metrics and /debug/resources long before the
+// Pi runs out of memory. With Stacks set, where each one still open was
+// created is recorded too.
+package resources
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Stacks records the creation stack of every object opened from then on,
+// for Report. It costs a stack walk per object so is off by default, set it
+// before the pipeline starts.
+var Stacks bool
+
+// Kind is one kind of object counted, opened and closed in pairs.
+type Kind struct {
+	Name string
+
+	open   int64
+	mu     sync.Mutex
+	stacks map[interface{}][]uintptr // by key, of those opened with Stacks set
+	count  func() int64              // counted elsewhere rather than by Open and Close
+	report func(io.Writer)           // where those open were created, in place of stacks
+}
+
+var (
+	Mats         = newKind("mat")          // pooled frame and evidence Mats, every Mat with -tags matprofile
+	Trackers     = newKind("tracker")      // per car correlation trackers
+	Captures     = newKind("capture")      // OpenCV video captures
+	PointVectors = newKind("point_vector") // contours during detection
+)
+
+var kinds []*Kind
+
+func newKind(name string) *Kind {
+	k := &Kind{Name: name, stacks: make(map[interface{}][]uintptr)}
+	kinds = append(kinds, k)
+	return k
+}
+
+// Kinds returns every kind counted.
+func Kinds() []*Kind {
+	return kinds
+}
+
+// Open counts an object opened, key identifying it to Close, e.g. a Mat's
+// Ptr().
+func (k *Kind) Open(key interface{}) {
+	atomic.AddInt64(&k.open, 1)
+	if !Stacks {
+		return
+	}
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	k.mu.Lock()
+	k.stacks[key] = pcs
+	k.mu.Unlock()
+}
+
+// Close counts the object opened with key closed.
+func (k *Kind) Close(key interface{}) {
+	atomic.AddInt64(&k.open, -1)
+	if !Stacks {
+		return
+	}
+	k.mu.Lock()
+	delete(k.stacks, key)
+	k.mu.Unlock()
+}
+
+// Count is how many are open. It should hold steady while running, growing
+// means something isn't closing them.
+func (k *Kind) Count() int64 {
+	if k.count != nil {
+		return k.count()
+	}
+	return atomic.LoadInt64(&k.open)
+}
+
+// Report writes the count of each kind and, with Stacks set, where those
+// still open were created, the most common first. Kinds counted elsewhere
+// report where theirs were created whether or not Stacks is set.
+func Report(w io.Writer) {
+	for _, k := range kinds {
+		fmt.Fprintf(w, "%s: %d open\n", k.Name, k.Count())
+	}
+	for _, k := range kinds {
+		if k.report != nil {
+			fmt.Fprintf(w, "\n%s opened at\n", k.Name)
+			k.report(w)
+		}
+	}
+	if !Stacks {
+		fmt.Fprintln(w, "\nset RESOURCE_STACKS=true to record where they were created")
+		return
+	}
+
+	for _, k := range kinds {
+		if k.report != nil {
+			continue
+		}
+		k.mu.Lock()
+		counts := make(map[string]int)
+		for _, pcs := range k.stacks {
+			counts[formatStack(pcs)]++
+		}
+		k.mu.Unlock()
+
+		stacks := make([]string, 0, len(counts))
+		for stack := range counts {
+			stacks = append(stacks, stack)
+		}
+		sort.Slice(stacks, func(i, j int) bool {
+			return counts[stacks[i]] > counts[stacks[j]]
+		})
+		for _, stack := range stacks {
+			fmt.Fprintf(w, "\n%d %s opened at\n%s", counts[stack], k.Name, stack)
+		}
+	}
+}
+
+func formatStack(pcs []uintptr) string {
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		fmt.Fprintf(&b, "\t%s\n\t\t%s:%d\n", f.Function, f.File, f.Line)
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
+
+// Handler serves Report as plain text.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		Report(w)
+	})
+}
@@ -0,0 +1,358 @@
+// Package sentry reports errors and panics to Sentry, or anything accepting
+// its envelopes like GlitchTip, so failures on devices out in the field
+// surface without reading through their logs.
+package sentry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/logging"
+	"github.com/danhigham/speedcam/pkg/version"
+)
+
+const (
+	sendTimeout  = 10 * time.Second
+	queueSize    = 32
+	maxPerMinute = 10 // reports sent, the rest are dropped, so a failure repeating every frame doesn't flood the link
+)
+
+// eventIDPattern finds a vehicle's event ID in a log line, added to its
+// report so it can be looked up in the journal.
+var eventIDPattern = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// Levels of a report.
+const (
+	LevelError = "error"
+	LevelFatal = "fatal"
+)
+
+// Client sends reports in the background. A nil Client reports nothing, so
+// it can be used unchecked when SENTRY_DSN isn't set.
+type Client struct {
+	Environment string
+	Release     string
+	Device      config.Device
+
+	dsn      string
+	endpoint string // the project's envelope URL
+	auth     string // X-Sentry-Auth
+	client   *http.Client
+	queue    chan report
+	pending  sync.WaitGroup
+
+	mu      sync.Mutex
+	sent    int
+	resetAt time.Time
+}
+
+type report struct {
+	ID    string
+	Event event
+}
+
+// event is the part of Sentry's event payload filled in.
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   float64           `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger,omitempty"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Exception   *exceptions       `json:"exception,omitempty"`
+}
+
+type exceptions struct {
+	Values []exception `json:"values"`
+}
+
+type exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *stacktrace `json:"stacktrace,omitempty"`
+}
+
+type stacktrace struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// New returns a client reporting to the project SENTRY_DSN names, or nil
+// when it isn't set, tagging reports with SENTRY_ENVIRONMENT, default
+// production, SENTRY_RELEASE, default the version built, and the device.
+func New() (*Client, error) {
+	dsn := config.Env("SENTRY_DSN", "")
+	if dsn == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("SENTRY_DSN: want https://key@host/project, got %q", dsn)
+	}
+	slash := strings.LastIndex(u.Path, "/")
+	project := u.Path[slash+1:]
+	if project == "" {
+		return nil, fmt.Errorf("SENTRY_DSN: no project in %q", dsn)
+	}
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=speedcam/1.0, sentry_key=%s", u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	c := &Client{
+		Environment: config.Env("SENTRY_ENVIRONMENT", "production"),
+		Release:     config.Env("SENTRY_RELEASE", version.Version),
+		Device:      config.LoadDevice(),
+		dsn:         dsn,
+		endpoint:    fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, u.Path[:slash], project),
+		auth:        auth,
+		client:      &http.Client{Timeout: sendTimeout},
+		queue:       make(chan report, queueSize),
+	}
+	go c.send()
+	return c, nil
+}
+
+// CaptureMessage reports message at level, returning the report's ID, or
+// "" when it wasn't sent.
+func (c *Client) CaptureMessage(level string, message string, tags map[string]string) string {
+	if c == nil {
+		return ""
+	}
+	e := c.event(level, tags)
+	e.Message = message
+	if id := eventIDPattern.FindString(message); id != "" {
+		e.Tags["speedcam_event"] = id
+	}
+	return c.enqueue(e)
+}
+
+// CaptureError reports err with where it was captured, returning the
+// report's ID, or "" when it wasn't sent.
+func (c *Client) CaptureError(err error, tags map[string]string) string {
+	if c == nil || err == nil {
+		return ""
+	}
+	e := c.event(LevelError, tags)
+	e.Exception = &exceptions{Values: []exception{{Type: fmt.Sprintf("%T", err), Value: err.Error(), Stacktrace: callers(1, false)}}}
+	return c.enqueue(e)
+}
+
+// CapturePanic reports v, recovered from a panic, with the stack that
+// panicked. It must be called from the deferred function that recovered.
+func (c *Client) CapturePanic(v interface{}, tags map[string]string) string {
+	if c == nil {
+		return ""
+	}
+	e := c.event(LevelFatal, tags)
+	e.Exception = &exceptions{Values: []exception{{Type: "panic", Value: fmt.Sprint(v), Stacktrace: callers(1, true)}}}
+	return c.enqueueAlways(e)
+}
+
+// Recover reports a panic, panicking again once what's been reported is
+// sent, for the goroutine whose return or panic ends the process. Use it as
+// defer c.Recover(nil).
+func (c *Client) Recover(tags map[string]string) {
+	if c == nil {
+		return
+	}
+	r := recover()
+	if r != nil {
+		e := c.event(LevelFatal, tags)
+		e.Exception = &exceptions{Values: []exception{{Type: "panic", Value: fmt.Sprint(r), Stacktrace: callers(1, true)}}}
+		if id := c.enqueueAlways(e); id != "" {
+			fmt.Fprintf(os.Stderr, "Reported panic to Sentry as %s\n", id)
+		}
+	}
+	c.Flush(sendTimeout)
+	if r != nil {
+		panic(r)
+	}
+}
+
+// Write reports each error line written to it, as worded for
+// logging.LineLevel, so it can be given logging.Capture's output.
+func (c *Client) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		if line = strings.TrimRight(line, "\r"); logging.LineLevel(line) == LevelError {
+			c.CaptureMessage(LevelError, line, nil)
+		}
+	}
+	return len(p), nil
+}
+
+// Flush waits up to timeout for queued reports to be sent.
+func (c *Client) Flush(timeout time.Duration) {
+	if c == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		c.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+func (c *Client) event(level string, tags map[string]string) event {
+	e := event{
+		EventID:     newEventID(),
+		Timestamp:   float64(time.Now().UnixNano()) / float64(time.Second),
+		Level:       level,
+		Platform:    "go",
+		Logger:      "speedcam",
+		ServerName:  c.Device.ID,
+		Release:     c.Release,
+		Environment: c.Environment,
+		Tags:        map[string]string{"device": c.Device.ID},
+	}
+	if c.Device.Site != "" {
+		e.Tags["site"] = c.Device.Site
+	}
+	for k, v := range tags {
+		e.Tags[k] = v
+	}
+	return e
+}
+
+// enqueue queues e unless maxPerMinute have been sent in the last minute.
+func (c *Client) enqueue(e event) string {
+	c.mu.Lock()
+	if now := time.Now(); now.After(c.resetAt) {
+		c.sent, c.resetAt = 0, now.Add(time.Minute)
+	}
+	over := c.sent >= maxPerMinute
+	c.sent++
+	c.mu.Unlock()
+	if over {
+		return ""
+	}
+	return c.enqueueAlways(e)
+}
+
+// enqueueAlways queues e, whatever has been sent, unless the queue is full.
+func (c *Client) enqueueAlways(e event) string {
+	c.pending.Add(1)
+	select {
+	case c.queue <- report{ID: e.EventID, Event: e}:
+		return e.EventID
+	default:
+		c.pending.Done()
+		return ""
+	}
+}
+
+func (c *Client) send() {
+	for r := range c.queue {
+		if err := c.post(r); err != nil {
+			// not worded as an error, or it would be reported in turn
+			fmt.Fprintf(os.Stderr, "Couldn't report to Sentry, %s\n", err)
+		}
+		c.pending.Done()
+	}
+}
+
+// post sends r as an envelope, a header, an item header and the event, a
+// line each.
+func (c *Client) post(r report) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]string{"event_id": r.ID, "dsn": c.dsn, "sent_at": time.Now().UTC().Format(time.RFC3339)})
+	enc.Encode(map[string]string{"type": "event"})
+	if err := enc.Encode(r.Event); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", c.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// callers is the stack from skip frames above its caller, oldest first as
+// Sentry wants it. When panicked it starts where the panic was raised,
+// rather than in the function recovering it.
+func callers(skip int, panicked bool) *stacktrace {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip+2, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var st []frame
+	for {
+		f, more := frames.Next()
+		if panicked && f.Function == "runtime.gopanic" {
+			st = nil
+			continue
+		}
+		module, function := splitFunction(f.Function)
+		st = append(st, frame{
+			Function: function,
+			Module:   module,
+			AbsPath:  f.File,
+			Lineno:   f.Line,
+			InApp:    strings.HasPrefix(module, "github.com/danhigham/speedcam"),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(st)-1; i < j; i, j = i+1, j-1 {
+		st[i], st[j] = st[j], st[i]
+	}
+	return &stacktrace{Frames: st}
+}
+
+// splitFunction splits a qualified function name like
+// github.com/danhigham/speedcam/pkg/api.(*API).listEvents into its package
+// and the rest.
+func splitFunction(name string) (string, string) {
+	lastSlash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[lastSlash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:lastSlash+1+dot], name[lastSlash+1+dot+1:]
+}
+
+// newEventID is a random ID as Sentry wants it, 32 hex digits.
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/danhigham/speedcam/pkg/auth"
+	"github.com/danhigham/speedcam/pkg/resources"
+)
+
+// RegisterPprof mounts the runtime profiles under /debug/pprof/ for admins,
+// e.g. go tool pprof -http=: "http://admin:secret@pi:8080/debug/pprof/heap",
+// and the count of OpenCV objects open on /debug/resources.
+func RegisterPprof(mux *http.ServeMux, a *auth.Auth) {
+	mux.HandleFunc("/debug/pprof/", a.RequireFunc(auth.ScopeAdmin, pprof.Index))
+	mux.HandleFunc("/debug/pprof/cmdline", a.RequireFunc(auth.ScopeAdmin, pprof.Cmdline))
+	mux.HandleFunc("/debug/pprof/profile", a.RequireFunc(auth.ScopeAdmin, pprof.Profile))
+	mux.HandleFunc("/debug/pprof/symbol", a.RequireFunc(auth.ScopeAdmin, pprof.Symbol))
+	mux.HandleFunc("/debug/pprof/trace", a.RequireFunc(auth.ScopeAdmin, pprof.Trace))
+	mux.Handle("/debug/resources", a.Require(auth.ScopeAdmin, resources.Handler()))
+}
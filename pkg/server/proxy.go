@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/danhigham/speedcam/pkg/config"
+)
+
+// BasePath is the path prefix the UI and API are published under when behind
+// a reverse proxy, e.g. /speedcam. Requests are accepted with or without it
+// so it works whether or not the proxy strips it.
+var BasePath = strings.TrimSuffix(os.Getenv("BASE_PATH"), "/")
+
+// Middleware wraps the whole server with trusted proxy handling, rate
+// limiting, CORS, base path stripping and the device's identity headers.
+func Middleware(next http.Handler) (http.Handler, error) {
+	proxies, err := parseCIDRs(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %s", err)
+	}
+
+	h := deviceHeaders(config.LoadDevice(), stripBasePath(next))
+	if origins := os.Getenv("CORS_ORIGINS"); origins != "" {
+		h = cors(strings.Split(origins, ","), h)
+	}
+	if h, err = rateLimit(h); err != nil {
+		return nil, err
+	}
+	if len(proxies) > 0 {
+		h = trustProxies(proxies, h)
+	}
+	return h, nil
+}
+
+// deviceHeaders identifies the device in every response, so responses from
+// a fleet behind one proxy or dashboard can be told apart, and names the
+// timezone its times are in.
+func deviceHeaders(d config.Device, next http.Handler) http.Handler {
+	timezone := config.Timezone()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Device-ID", d.ID)
+		w.Header().Set("X-Device-Name", d.Name)
+		if d.Site != "" {
+			w.Header().Set("X-Device-Site", d.Site)
+		}
+		if timezone != "" {
+			w.Header().Set("X-Timezone", timezone)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func stripBasePath(next http.Handler) http.Handler {
+	if BasePath == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == BasePath {
+			http.Redirect(w, r, BasePath+"/", http.StatusFound)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, BasePath+"/") {
+			r.URL.Path = strings.TrimPrefix(r.URL.Path, BasePath)
+			r.URL.RawPath = ""
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors answers preflight requests and sets the CORS headers for requests from
+// any of origins, "*" allows every origin but only without credentials.
+func cors(origins []string, next http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, o := range origins {
+		allowed[strings.TrimSpace(o)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin != "" && (allowed["*"] || allowed[origin]) {
+			// only origins listed by name may send credentials, any site
+			// could otherwise call the API as whoever is logged in
+			if allowed[origin] {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+				w.Header().Add("Vary", "Origin")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, X-API-Key, Content-Type")
+				w.Header().Set("Access-Control-Max-Age", "600")
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if strings.Contains(c, ":") {
+				c += "/128"
+			} else {
+				c += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func ipIn(ip net.IP, nets []*net.IPNet) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustProxies takes the client address, scheme and host from X-Forwarded-*
+// headers, but only for requests arriving from one of the trusted proxies.
+func trustProxies(proxies []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil || !ipIn(net.ParseIP(host), proxies) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// walk back from the nearest hop, the first untrusted address is the
+		// client, anything before it could be spoofed
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			hops := strings.Split(xff, ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				ip := net.ParseIP(strings.TrimSpace(hops[i]))
+				if ip == nil {
+					break
+				}
+				r.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+				if !ipIn(ip, proxies) {
+					break
+				}
+			}
+		}
+		if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+			r.URL.Scheme = proto
+		}
+		if fwdHost := r.Header.Get("X-Forwarded-Host"); fwdHost != "" {
+			r.Host = fwdHost
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSWildcardWithoutCredentials(t *testing.T) {
+	h := cors([]string{"*", "https://dash.example.com"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for _, c := range []struct {
+		origin      string
+		allow       string
+		credentials string
+	}{
+		{"https://evil.example.com", "*", ""},
+		{"https://dash.example.com", "https://dash.example.com", "true"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/events", nil)
+		r.Header.Set("Origin", c.origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != c.allow {
+			t.Errorf("%s: Access-Control-Allow-Origin %q, want %q", c.origin, got, c.allow)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != c.credentials {
+			t.Errorf("%s: Access-Control-Allow-Credentials %q, want %q", c.origin, got, c.credentials)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+)
+
+// ipRateLimiter is a token bucket per client address.
+type ipRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newIPRateLimiter(rate float64, burst float64) *ipRateLimiter {
+	l := &ipRateLimiter{rate: rate, burst: burst, buckets: map[string]*bucket{}}
+	go l.cleanup()
+	return l
+}
+
+func (l *ipRateLimiter) Allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// cleanup forgets clients whose buckets have refilled, they're
+// indistinguishable from new ones.
+func (l *ipRateLimiter) cleanup() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for ip, b := range l.buckets {
+			if time.Since(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// rateLimit rejects clients making more than RATE_LIMIT requests per second,
+// averaged over RATE_BURST requests. Disabled unless RATE_LIMIT is set.
+func rateLimit(next http.Handler) (http.Handler, error) {
+	v := os.Getenv("RATE_LIMIT")
+	if v == "" {
+		return next, nil
+	}
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || rate <= 0 {
+		return nil, fmt.Errorf("RATE_LIMIT: invalid rate %q", v)
+	}
+	burst, err := strconv.ParseFloat(config.Env("RATE_BURST", "20"), 64)
+	if err != nil || burst < 1 {
+		return nil, fmt.Errorf("RATE_BURST: invalid burst %q", os.Getenv("RATE_BURST"))
+	}
+
+	limiter := newIPRateLimiter(rate, burst)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !limiter.Allow(ip) {
+			w.Header().Set("Retry-After", "1")
+			httpjson.Error(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), nil
+}
@@ -0,0 +1,124 @@
+// Package server runs the HTTP server and the middleware around it.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// shutdownTimeout is how long requests in flight are given to finish once
+// the server is stopped.
+const shutdownTimeout = 5 * time.Second
+
+// ListenAndServe serves handler on addr over plain HTTP, or over TLS when
+// TLS_CERT/TLS_KEY or ACME_DOMAINS are set, until ctx is done, then shuts
+// down gracefully and returns nil.
+func ListenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	err := listen(srv)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func listen(srv *http.Server) error {
+	addr := srv.Addr
+	if domains := os.Getenv("ACME_DOMAINS"); domains != "" {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(strings.Split(domains, ",")...),
+			Cache:      autocert.DirCache(config.Env("ACME_CACHE_DIR", "./acme")),
+			Email:      os.Getenv("ACME_EMAIL"),
+		}
+		// HTTP-01 challenges are answered on port 80, TLS-ALPN-01 works
+		// directly if addr is on 443
+		go func() {
+			err := http.ListenAndServe(":80", m.HTTPHandler(nil))
+			fmt.Printf("ACME HTTP challenge listener stopped, %s\n", err)
+		}()
+
+		srv.TLSConfig = m.TLSConfig()
+		fmt.Printf("Serving HTTPS on %s for %s\n", addr, domains)
+		return srv.ListenAndServeTLS("", "")
+	}
+
+	if certFile := os.Getenv("TLS_CERT"); certFile != "" {
+		kp, err := newKeypairReloader(certFile, os.Getenv("TLS_KEY"))
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = &tls.Config{GetCertificate: kp.GetCertificate}
+		fmt.Printf("Serving HTTPS on %s\n", addr)
+		return srv.ListenAndServeTLS("", "")
+	}
+
+	fmt.Printf("Serving HTTP on %s\n", addr)
+	return srv.ListenAndServe()
+}
+
+// keypairReloader picks up renewed certificates (e.g. from certbot) without a
+// restart by checking the certificate file's mtime at most once a minute.
+type keypairReloader struct {
+	mu       sync.Mutex
+	certFile string
+	keyFile  string
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+func newKeypairReloader(certFile string, keyFile string) (*keypairReloader, error) {
+	kp := &keypairReloader{certFile: certFile, keyFile: keyFile}
+	if err := kp.load(); err != nil {
+		return nil, err
+	}
+	return kp, nil
+}
+
+func (kp *keypairReloader) load() error {
+	info, err := os.Stat(kp.certFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return err
+	}
+	kp.cert = &cert
+	kp.modTime = info.ModTime()
+	return nil
+}
+
+func (kp *keypairReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+
+	if time.Since(kp.checked) > time.Minute {
+		kp.checked = time.Now()
+		if info, err := os.Stat(kp.certFile); err == nil && info.ModTime().After(kp.modTime) {
+			if err := kp.load(); err != nil {
+				// keep serving the old certificate rather than failing handshakes
+				fmt.Printf("Failed to reload TLS certificate, %s\n", err)
+			}
+		}
+	}
+	return kp.cert, nil
+}
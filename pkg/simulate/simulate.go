@@ -0,0 +1,139 @@
+// Package simulate renders synthetic traffic at known speeds, to test the
+// pipeline and to check a calibration end to end before going out to the
+// road.
+package simulate
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/capture"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/speed"
+	"gocv.io/x/gocv"
+)
+
+// Vehicle is one synthetic vehicle driven across the frame.
+type Vehicle struct {
+	Speed     float64 // mph
+	Direction string  // "left" or "right", the way track.Car reports it
+}
+
+// Simulator is a capture.Source delivering frames of Vehicles driven one at
+// a time across Lane at their speeds under Calibration, stamped FPS apart.
+// Frames are paced at FPS too, so the pipeline keeps up as it would with a
+// camera.
+type Simulator struct {
+	FPS         float64
+	Calibration speed.Calibration
+	Lane        image.Rectangle // vehicles drive along its middle
+	Size        image.Point     // of a vehicle in pixels
+	Gap         time.Duration   // of empty road before each vehicle, for the background model to settle
+	Vehicles    []Vehicle
+
+	background gocv.Mat
+	frame      int
+	start      time.Time // of the first frame
+	next       time.Time
+	vehicle    int     // index of the vehicle on the road
+	x          float64 // of its left edge
+	empty      int     // frames of empty road left before it enters
+}
+
+var vehicleColor = color.RGBA{40, 40, 160, 0}
+
+// New returns a Simulator driving vehicles over the image in background, or
+// a plain grey road when it is empty, with the defaults of a small car
+// crossing detect.RoadRegion at 30fps.
+func New(background string, cal speed.Calibration, vehicles []Vehicle) (*Simulator, error) {
+	var img gocv.Mat
+	if background == "" {
+		img = gocv.NewMatWithSizeFromScalar(gocv.NewScalar(90, 90, 90, 0), 480, int(cal.ImageWidth), gocv.MatTypeCV8UC3)
+	} else if img = gocv.IMRead(background, gocv.IMReadColor); img.Empty() {
+		return nil, fmt.Errorf("reading background image %s", background)
+	}
+
+	s := &Simulator{
+		FPS:         30,
+		Calibration: cal,
+		Lane:        detect.RoadRegion.Intersect(image.Rect(0, 0, img.Cols(), img.Rows())),
+		Size:        image.Pt(120, 50),
+		Gap:         2 * time.Second,
+		Vehicles:    vehicles,
+		background:  img,
+		vehicle:     -1,
+	}
+	return s, nil
+}
+
+// NextFrame renders the next frame into img, returning io.EOF once every
+// vehicle has crossed.
+func (s *Simulator) NextFrame(img *gocv.Mat) (capture.Frame, error) {
+	if s.vehicle == -1 {
+		s.nextVehicle()
+		s.start = time.Now()
+	}
+	if s.vehicle >= len(s.Vehicles) {
+		return capture.Frame{}, io.EOF
+	}
+
+	if wait := time.Until(s.next); wait > 0 {
+		time.Sleep(wait)
+	}
+	s.next = time.Now().Add(time.Duration(float64(time.Second) / s.FPS))
+
+	s.background.CopyTo(img)
+	if s.empty > 0 {
+		s.empty--
+	} else {
+		s.drawVehicle(img)
+	}
+
+	// a frame counter clear of the road keeps every frame distinct, as sensor
+	// noise does, or the watchdog would find an empty road frozen
+	gocv.PutText(img, fmt.Sprintf("sim %d", s.frame), image.Pt(4, img.Rows()-6), gocv.FontHersheyPlain, 1, color.RGBA{255, 255, 255, 0}, 1)
+	at := s.start.Add(time.Duration(float64(s.frame) * float64(time.Second) / s.FPS))
+	s.frame++
+	return capture.Frame{Time: at}, nil
+}
+
+// drawVehicle draws the vehicle on the road and moves it on a frame, on to
+// the next once it has left the lane.
+func (s *Simulator) drawVehicle(img *gocv.Mat) {
+	v := s.Vehicles[s.vehicle]
+	left := int(math.Round(s.x))
+	top := (s.Lane.Min.Y+s.Lane.Max.Y)/2 - s.Size.Y/2
+	if body := image.Rect(left, top, left+s.Size.X, top+s.Size.Y).Intersect(s.Lane); !body.Empty() {
+		gocv.Rectangle(img, body, vehicleColor, -1)
+	}
+
+	step := speed.FeetPerSecond(v.Speed) / s.FPS / s.Calibration.FeetPerPixel()
+	if v.Direction == "left" {
+		s.x -= step
+	} else {
+		s.x += step
+	}
+	if s.x > float64(s.Lane.Max.X) || s.x+float64(s.Size.X) < float64(s.Lane.Min.X) {
+		s.nextVehicle()
+	}
+}
+
+// nextVehicle puts the next vehicle just off the end of the lane it enters
+// from, after Gap.
+func (s *Simulator) nextVehicle() {
+	s.vehicle++
+	s.empty = int(s.Gap.Seconds() * s.FPS)
+	if s.vehicle < len(s.Vehicles) && s.Vehicles[s.vehicle].Direction == "left" {
+		s.x = float64(s.Lane.Max.X)
+	} else {
+		s.x = float64(s.Lane.Min.X - s.Size.X)
+	}
+}
+
+func (s *Simulator) Close() error {
+	return s.background.Close()
+}
@@ -0,0 +1,40 @@
+// Package speed converts tracks measured in pixels to road speeds.
+package speed
+
+import (
+	"math"
+	"time"
+)
+
+// Calibration relates pixels to feet for a camera looking straight across
+// the road, assuming vehicles pass at a fixed distance from it.
+type Calibration struct {
+	FOV            float64 // horizontal field of view, degrees
+	DistanceToRoad float64 // feet
+	ImageWidth     float64 // pixels
+}
+
+// DefaultCalibration is the original installation's camera.
+var DefaultCalibration = Calibration{FOV: 112, DistanceToRoad: 49.5, ImageWidth: 640}
+
+func degToRad(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// FeetPerPixel is how much of the road one pixel covers horizontally.
+func (c Calibration) FeetPerPixel() float64 {
+	frameWidth := 2 * (math.Tan(degToRad(c.FOV*0.5)) * c.DistanceToRoad)
+	return frameWidth / c.ImageWidth
+}
+
+const mphPerFootPerSecond = 0.681818
+
+// MPH is the speed of covering feet in d.
+func MPH(feet float64, d time.Duration) float64 {
+	return (feet / d.Seconds()) * mphPerFootPerSecond
+}
+
+// FeetPerSecond is mph in feet a second.
+func FeetPerSecond(mph float64) float64 {
+	return mph / mphPerFootPerSecond
+}
@@ -0,0 +1,384 @@
+// Package stream renders the latest frame, mask and overlays for the MJPEG
+// streams, snapshots and encoded outputs.
+package stream
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/matpool"
+	"gocv.io/x/gocv"
+)
+
+// Overlay is everything drawn over a frame, collected by the main loop and
+// only rendered when a viewer asks for it.
+type Overlay struct {
+	Boxes   []image.Rectangle
+	Tracks  [][]image.Point
+	FPS     float64
+	Latency []Latency
+}
+
+// Latency is the time one pipeline stage spent on the frame.
+type Latency struct {
+	Stage    string
+	Duration time.Duration
+}
+
+// Translate returns the overlay moved by d, e.g. to draw it on a crop.
+func (o Overlay) Translate(d image.Point) Overlay {
+	moved := Overlay{FPS: o.FPS, Latency: o.Latency}
+	for _, box := range o.Boxes {
+		moved.Boxes = append(moved.Boxes, box.Add(d))
+	}
+	for _, track := range o.Tracks {
+		points := make([]image.Point, len(track))
+		for i, pt := range track {
+			points[i] = pt.Add(d)
+		}
+		moved.Tracks = append(moved.Tracks, points)
+	}
+	return moved
+}
+
+// OverlayOptions select which overlays are drawn, set per request from the
+// boxes, tracks, mask, fps and latency query parameters.
+type OverlayOptions struct {
+	Boxes   bool
+	Tracks  bool
+	Mask    bool // tint the foreground mask over the frame
+	FPS     bool
+	Latency bool            // time spent on the frame by each stage
+	Crop    image.Rectangle // empty for the whole frame
+}
+
+var overlayColor = color.RGBA{255, 0, 0, 0}
+
+// DrawOverlay draws the overlays selected by opts onto img.
+func DrawOverlay(img *gocv.Mat, overlay Overlay, opts OverlayOptions) {
+	if opts.Boxes {
+		for _, rect := range overlay.Boxes {
+			gocv.Rectangle(img, rect, overlayColor, 1)
+		}
+	}
+	if opts.Tracks {
+		for _, track := range overlay.Tracks {
+			for i := 0; i < len(track)-1; i++ {
+				gocv.Line(img, track[i], track[i+1], overlayColor, 1)
+			}
+		}
+	}
+	if opts.FPS {
+		gocv.PutText(img, fmt.Sprintf("%.1f fps", overlay.FPS), image.Pt(8, 20), gocv.FontHersheySimplex, 0.5, color.RGBA{255, 255, 255, 0}, 1)
+	}
+	if opts.Latency {
+		for i, l := range overlay.Latency {
+			text := fmt.Sprintf("%s %.1fms", l.Stage, float64(l.Duration)/float64(time.Millisecond))
+			gocv.PutText(img, text, image.Pt(8, 40+16*i), gocv.FontHersheySimplex, 0.4, color.RGBA{255, 255, 255, 0}, 1)
+		}
+	}
+}
+
+// HeatCell is a region shaded by DrawHeatmap, Value from 0 for the coolest
+// to 1 for the hottest.
+type HeatCell struct {
+	Rect  image.Rectangle
+	Value float64
+}
+
+// heatColor ramps from blue through green to red.
+func heatColor(v float64) color.RGBA {
+	v = math.Max(0, math.Min(1, v))
+	if v < 0.5 {
+		return color.RGBA{0, uint8(510 * v), uint8(255 - 510*v), 0}
+	}
+	return color.RGBA{uint8(510 * (v - 0.5)), uint8(255 - 510*(v-0.5)), 0, 0}
+}
+
+// DrawHeatmap shades cells over img, blended at opacity, and writes legend
+// in the top left corner.
+func DrawHeatmap(img *gocv.Mat, cells []HeatCell, opacity float64, legend string) {
+	shaded := img.Clone()
+	defer shaded.Close()
+	for _, c := range cells {
+		gocv.Rectangle(&shaded, c.Rect, heatColor(c.Value), -1)
+	}
+	gocv.AddWeighted(shaded, opacity, *img, 1-opacity, 0, img)
+	if legend != "" {
+		gocv.PutText(img, legend, image.Pt(8, 20), gocv.FontHersheySimplex, 0.5, color.RGBA{255, 255, 255, 0}, 1)
+	}
+}
+
+// parseOverlayOptions applies any of boxes, tracks, mask, fps and latency
+// given as query parameters over the view's defaults.
+func parseOverlayOptions(r *http.Request, defaults OverlayOptions) OverlayOptions {
+	opts := defaults
+	q := r.URL.Query()
+	toggles := map[string]*bool{
+		"boxes":   &opts.Boxes,
+		"tracks":  &opts.Tracks,
+		"mask":    &opts.Mask,
+		"fps":     &opts.FPS,
+		"latency": &opts.Latency,
+	}
+	for name, toggle := range toggles {
+		if v, err := strconv.ParseBool(q.Get(name)); err == nil {
+			*toggle = v
+		}
+	}
+	return opts
+}
+
+// FrameHub holds the latest frame, foreground mask and overlay from the main
+// loop and renders them on demand for stream and snapshot viewers. Frames
+// are only copied in while someone has asked for one recently, and each is
+// encoded once per set of overlay options however many viewers share it.
+type FrameHub struct {
+	Viewers *ViewerLimit // shared by every stream of the hub, nil for no limit
+
+	mu      sync.Mutex
+	frame   gocv.Mat
+	mask    gocv.Mat
+	overlay Overlay
+	updated chan struct{} // closed and replaced on every Publish
+	last    time.Time
+	wanted  time.Time // of the last Render
+	stale   bool      // the last frame published wasn't copied in
+	encoded map[renderKey]*encoding
+	mats    *matpool.Pool
+}
+
+// renderKey is everything a rendered frame depends on besides the frame.
+type renderKey struct {
+	useMask bool
+	opts    OverlayOptions
+}
+
+// encoding is a frame rendered once and shared by every viewer asking for
+// it with the same options.
+type encoding struct {
+	once sync.Once
+	buf  []byte
+	err  error
+}
+
+const (
+	idleAfter = 5 * time.Second // without a Render before frames stop being copied
+	staleWait = time.Second     // for a fresh frame when Render finds none
+)
+
+func NewFrameHub() *FrameHub {
+	return &FrameHub{
+		frame:   gocv.NewMat(),
+		mask:    gocv.NewMat(),
+		updated: make(chan struct{}),
+		mats:    matpool.New(4),
+	}
+}
+
+// Publish copies frame and mask, the caller keeps ownership of both. An
+// empty mask, from a frame detection skipped, keeps the last. Nothing is
+// copied while no one is rendering frames.
+//
+// Publishing is best effort, if a viewer is mid render the frame is skipped
+// rather than keep the pipeline waiting.
+func (h *FrameHub) Publish(frame gocv.Mat, mask gocv.Mat, overlay Overlay) {
+	if !h.mu.TryLock() {
+		return
+	}
+	h.stale = time.Since(h.wanted) > idleAfter
+	if !h.stale {
+		frame.CopyTo(&h.frame)
+		if !mask.Empty() {
+			mask.CopyTo(&h.mask)
+		}
+	}
+	h.overlay = overlay
+	h.last = time.Now()
+	h.encoded = nil
+	close(h.updated)
+	h.updated = make(chan struct{})
+	h.mu.Unlock()
+}
+
+// Stats returns the capture rate reported with the latest frame and when it
+// was published, zero before the first frame.
+func (h *FrameHub) Stats() (fps float64, last time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.overlay.FPS, h.last
+}
+
+// Updated returns a channel closed when the next frame is published.
+func (h *FrameHub) Updated() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.updated
+}
+
+// Render draws the requested overlays over a copy of the latest frame, or of
+// the mask when useMask is set, and encodes it to JPEG. Viewers rendering
+// the same frame with the same options share one encode, the returned
+// buffer must not be modified.
+func (h *FrameHub) Render(useMask bool, opts OverlayOptions) ([]byte, error) {
+	h.mu.Lock()
+	h.wanted = time.Now()
+	if h.stale {
+		// no one was watching, wait for a frame to be copied in
+		updated := h.updated
+		h.mu.Unlock()
+		select {
+		case <-updated:
+		case <-time.After(staleWait):
+		}
+		h.mu.Lock()
+	}
+
+	key := renderKey{useMask: useMask, opts: opts}
+	if h.encoded == nil {
+		h.encoded = make(map[renderKey]*encoding)
+	}
+	e, ok := h.encoded[key]
+	if !ok {
+		e = &encoding{}
+		h.encoded[key] = e
+	}
+	h.mu.Unlock()
+
+	e.once.Do(func() {
+		e.buf, e.err = h.render(useMask, opts)
+	})
+	return e.buf, e.err
+}
+
+func (h *FrameHub) render(useMask bool, opts OverlayOptions) ([]byte, error) {
+	h.mu.Lock()
+	src := h.frame
+	if useMask {
+		src = h.mask
+	}
+	if src.Empty() {
+		h.mu.Unlock()
+		return nil, fmt.Errorf("no frame yet")
+	}
+
+	out := h.mats.Get()
+	defer h.mats.Put(out)
+	if useMask {
+		gocv.CvtColor(src, &out, gocv.ColorGrayToBGR)
+	} else if opts.Mask && !h.mask.Empty() {
+		tint := h.mats.Get()
+		gocv.CvtColor(h.mask, &tint, gocv.ColorGrayToBGR)
+		if tint.Cols() != src.Cols() || tint.Rows() != src.Rows() {
+			// detection ran on a downscaled frame
+			gocv.Resize(tint, &tint, image.Pt(src.Cols(), src.Rows()), 0, 0, gocv.InterpolationNearestNeighbor)
+		}
+		gocv.AddWeighted(src, 1, tint, 0.5, 0, &out)
+		h.mats.Put(tint)
+	} else {
+		src.CopyTo(&out)
+	}
+	DrawOverlay(&out, h.overlay, opts)
+	h.mu.Unlock()
+
+	if !opts.Crop.Empty() {
+		region := out.Region(opts.Crop.Intersect(image.Rect(0, 0, out.Cols(), out.Rows())))
+		defer region.Close()
+		return gocv.IMEncode(".jpg", region)
+	}
+	return gocv.IMEncode(".jpg", out)
+}
+
+// FrameView serves one named view of the hub, as a single JPEG or an MJPEG
+// stream, with overlays defaulting to Defaults unless overridden per request.
+type FrameView struct {
+	Hub      *FrameHub
+	UseMask  bool
+	Defaults OverlayOptions
+}
+
+func (v FrameView) Snapshot() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, err := v.Hub.Render(v.UseMask, parseOverlayOptions(r, v.Defaults))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(buf)
+	})
+}
+
+const mjpegBoundary = "speedcamframe"
+
+func (v FrameView) Stream() http.Handler {
+	snapshot := v.Snapshot()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if v.Hub.Viewers != nil {
+			viewerCtx, release, ok := v.Hub.Viewers.Acquire(ctx)
+			if !ok {
+				if v.Hub.Viewers.Policy() == overflowSnapshot {
+					snapshot.ServeHTTP(w, r)
+					return
+				}
+				http.Error(w, "too many stream viewers", http.StatusServiceUnavailable)
+				return
+			}
+			defer release()
+			ctx = viewerCtx
+		}
+
+		opts := parseOverlayOptions(r, v.Defaults)
+		interval := v.Hub.Viewers.FrameInterval(r)
+
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace;boundary="+mjpegBoundary)
+		w.Header().Set("Cache-Control", "no-store")
+
+		var sent time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-v.Hub.Updated():
+			}
+
+			// frames published while waiting are skipped, the latest is sent
+			if wait := interval - time.Since(sent); interval > 0 && wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+
+			buf, err := v.Hub.Render(v.UseMask, opts)
+			if err != nil {
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", mjpegBoundary, len(buf))
+			if err == nil {
+				_, err = w.Write(buf)
+			}
+			if err == nil {
+				_, err = w.Write([]byte("\r\n"))
+			}
+			if err != nil {
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			sent = time.Now()
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package stream
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/config"
+)
+
+// What happens to a new stream viewer when MAX_STREAM_VIEWERS are already
+// connected.
+const (
+	overflowReject      = "reject"       // 503
+	overflowSnapshot    = "snapshot"     // send a single frame instead
+	overflowEvictOldest = "evict-oldest" // disconnect the longest connected viewer
+)
+
+// ViewerLimit caps concurrent MJPEG viewers across all streams, each one
+// costs an encode per frame, and how many frames a second each is sent.
+type ViewerLimit struct {
+	mu      sync.Mutex
+	max     int
+	policy  string
+	maxFPS  float64         // 0 for every frame
+	viewers []*streamViewer // oldest first
+}
+
+type streamViewer struct {
+	cancel context.CancelFunc
+}
+
+func NewViewerLimit() (*ViewerLimit, error) {
+	max, err := strconv.Atoi(config.Env("MAX_STREAM_VIEWERS", "0"))
+	if err != nil || max < 0 {
+		return nil, fmt.Errorf("MAX_STREAM_VIEWERS: invalid limit %q", os.Getenv("MAX_STREAM_VIEWERS"))
+	}
+	policy := config.Env("STREAM_OVERFLOW", overflowReject)
+	switch policy {
+	case overflowReject, overflowSnapshot, overflowEvictOldest:
+	default:
+		return nil, fmt.Errorf("STREAM_OVERFLOW: unknown policy %q", policy)
+	}
+	maxFPS, err := strconv.ParseFloat(config.Env("STREAM_MAX_FPS", "0"), 64)
+	if err != nil || maxFPS < 0 {
+		return nil, fmt.Errorf("STREAM_MAX_FPS: invalid rate %q", os.Getenv("STREAM_MAX_FPS"))
+	}
+	return &ViewerLimit{max: max, policy: policy, maxFPS: maxFPS}, nil
+}
+
+// FrameInterval is the least time between frames sent to the viewer making
+// r, from its maxfps query parameter capped at STREAM_MAX_FPS. Zero sends
+// every frame. A nil ViewerLimit only applies the query parameter.
+func (l *ViewerLimit) FrameInterval(r *http.Request) time.Duration {
+	fps, err := strconv.ParseFloat(r.URL.Query().Get("maxfps"), 64)
+	if err != nil || fps <= 0 {
+		fps = 0
+	}
+	if l != nil && l.maxFPS > 0 && (fps == 0 || fps > l.maxFPS) {
+		fps = l.maxFPS
+	}
+	if fps == 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / fps)
+}
+
+// Acquire registers a viewer, returning a context cancelled if it's later
+// evicted and a release func to call when it disconnects. If there's no room
+// ok is false and the caller should apply Policy.
+func (l *ViewerLimit) Acquire(ctx context.Context) (viewerCtx context.Context, release func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.max > 0 && len(l.viewers) >= l.max {
+		if l.policy != overflowEvictOldest {
+			return ctx, func() {}, false
+		}
+		l.viewers[0].cancel()
+		l.viewers = l.viewers[1:]
+	}
+
+	viewerCtx, cancel := context.WithCancel(ctx)
+	v := &streamViewer{cancel: cancel}
+	l.viewers = append(l.viewers, v)
+
+	release = func() {
+		cancel()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		for i, other := range l.viewers {
+			if other == v {
+				l.viewers = append(l.viewers[:i], l.viewers[i+1:]...)
+				break
+			}
+		}
+	}
+	return viewerCtx, release, true
+}
+
+func (l *ViewerLimit) Policy() string {
+	return l.policy
+}
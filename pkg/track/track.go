@@ -0,0 +1,88 @@
+// Package track follows detected vehicles from frame to frame, recording the
+// path each one takes.
+package track
+
+import (
+	"errors"
+	"image"
+	"math"
+	"time"
+
+	"github.com/danhigham/gocv-blob/blob"
+	uuid "github.com/satori/go.uuid"
+	"gocv.io/x/gocv"
+)
+
+// Register is every car currently tracked, by the id the blob tracker gave it.
+type Register map[uuid.UUID]*Car
+
+type Car struct {
+	Track   []CarTrack
+	Tracker gocv.Tracker // nil when following detections alone
+}
+
+// CarTrack is one sighting of a car, with the frame it was seen in.
+type CarTrack struct {
+	TrackPoint blob.TrackPoint
+	Box        image.Rectangle // the car's bounding box, in full frame pixels
+	Mat        *gocv.Mat
+}
+
+func (c *Car) MiddleMat() (*gocv.Mat, error) {
+	if len(c.Track) == 0 {
+		return nil, errors.New("Track length is zero!")
+	}
+
+	midPoint := c.Track[(len(c.Track) / 2)]
+	return midPoint.Mat, nil
+}
+
+// SpaceTimeTravelled returns the distance along the track in pixels and the
+// time between its first and last points.
+func (c *Car) SpaceTimeTravelled() (float64, time.Duration, error) {
+
+	if c.Track == nil {
+		return 0, 0, errors.New("Track is null!")
+	}
+
+	if len(c.Track) == 0 {
+		return 0, 0, errors.New("Track length is zero!")
+	}
+
+	lastPoint := c.Track[len(c.Track)-1].TrackPoint
+	firstPoint := c.Track[0].TrackPoint
+
+	distance := 0.0
+
+	for i := 0; i < len(c.Track)-2; i++ {
+		distance += distanceBetweenPoints(c.Track[i].TrackPoint.Point, c.Track[i+1].TrackPoint.Point)
+	}
+
+	timeTaken := lastPoint.Created.Sub(firstPoint.Created)
+	return distance, timeTaken, nil
+}
+
+func (c *Car) TrackPoints() []image.Point {
+	points := make([]image.Point, 0, len(c.Track))
+	for _, t := range c.Track {
+		points = append(points, t.TrackPoint.Point)
+	}
+	return points
+}
+
+// Direction reports which way the car crossed the frame, "left" or "right".
+func (c *Car) Direction() string {
+	if len(c.Track) < 2 {
+		return ""
+	}
+	if c.Track[len(c.Track)-1].TrackPoint.Point.X < c.Track[0].TrackPoint.Point.X {
+		return "left"
+	}
+	return "right"
+}
+
+func distanceBetweenPoints(p1 image.Point, p2 image.Point) float64 {
+	intX := math.Abs(float64(p1.X - p2.X))
+	intY := math.Abs(float64(p1.Y - p2.Y))
+	return math.Sqrt(math.Pow(intX, 2) + math.Pow(intY, 2))
+}
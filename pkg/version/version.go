@@ -0,0 +1,98 @@
+// Package version reports which build is running and what it can do, on
+// /version and with --version, so support questions start from known
+// ground.
+package version
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/danhigham/speedcam/pkg/anpr"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/httpjson"
+	"github.com/danhigham/speedcam/pkg/resources"
+	"gocv.io/x/gocv"
+)
+
+// Version and Commit are set when building a release, with
+//
+//	go build -ldflags "-X github.com/danhigham/speedcam/pkg/version.Version=v1.2.0 -X github.com/danhigham/speedcam/pkg/version.Commit=$(git rev-parse HEAD)"
+//
+// Without them Version is the module version go install recorded, if any.
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// Info is the build running and its optional features.
+type Info struct {
+	Version       string
+	Commit        string // empty when not set at build time
+	GoVersion     string
+	GocvVersion   string
+	OpenCVVersion string
+	Features      map[string]bool // cuda, built with -tags cuda and a device found, matprofile, every Mat counted, and anpr, plate tracking enabled
+}
+
+// Get describes the build running.
+func Get() Info {
+	return Info{
+		Version:       current(),
+		Commit:        Commit,
+		GoVersion:     runtime.Version(),
+		GocvVersion:   gocv.Version(),
+		OpenCVVersion: gocv.OpenCVVersion(),
+		Features: map[string]bool{
+			"cuda":       detect.CUDADevices() > 0,
+			"matprofile": resources.MatsProfiled,
+			"anpr":       anpr.Enabled(),
+		},
+	}
+}
+
+// current is Version, or the module's version when it isn't set.
+func current() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// String is the Info as printed by --version, a line each.
+func (i Info) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "speedcam %s\n", i.Version)
+	if i.Commit != "" {
+		fmt.Fprintf(&b, "commit %s\n", i.Commit)
+	}
+	fmt.Fprintf(&b, "go %s, gocv %s, OpenCV %s\n", i.GoVersion, i.GocvVersion, i.OpenCVVersion)
+	names := make([]string, 0, len(i.Features))
+	for name := range i.Features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		state := "disabled"
+		if i.Features[name] {
+			state = "enabled"
+		}
+		fmt.Fprintf(&b, "%s %s\n", name, state)
+	}
+	return b.String()
+}
+
+// Serve handles GET /version.
+func Serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpjson.Error(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	httpjson.Write(w, http.StatusOK, Get())
+}
@@ -0,0 +1,66 @@
+package speedcam
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/capture"
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+)
+
+// reconnect reopens a stream that has dropped, waiting longer between each
+// attempt up to maxReconnectDelay, until it opens or ctx is cancelled. The
+// rest of the pipeline, the background model included, carries on from
+// where it was, only cars in flight are abandoned.
+func (p *Pipeline) reconnect(ctx context.Context) bool {
+	fmt.Printf("Lost %s, reconnecting\n", p.cfg.Source)
+	p.source.Close()
+	p.source = nil
+	p.setConnected(false)
+
+	delay := minReconnectDelay
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(delay):
+		}
+
+		source, err := capture.Open(p.cfg.Source, p.cfg.Decode)
+		if err == nil {
+			fmt.Printf("Reconnected to %s\n", p.cfg.Source)
+			p.source = source
+			p.session++
+			metrics.SourceReconnects.WithLabelValues(p.cfg.Camera).Inc()
+			atomic.StoreInt64(&p.fresh, time.Now().UnixNano())
+			p.setConnected(true)
+			return true
+		}
+
+		fmt.Printf("Failed to reconnect to %s, %s, retrying in %s\n", p.cfg.Source, err, delay)
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// setConnected records whether the source is connected, calling
+// Config.OnConnection.
+func (p *Pipeline) setConnected(connected bool) {
+	var v int32
+	if connected {
+		v = 1
+	}
+	atomic.StoreInt32(&p.connected, v)
+	metrics.SourceConnected.WithLabelValues(p.cfg.Camera).Set(float64(v))
+	if p.cfg.OnConnection != nil {
+		p.cfg.OnConnection(connected)
+	}
+}
@@ -0,0 +1,90 @@
+package speedcam
+
+import (
+	"image"
+
+	"github.com/danhigham/speedcam/pkg/track"
+	"gocv.io/x/gocv"
+)
+
+// Signature bins, each chromatic hue split into dark and light, then greys
+// black through white by brightness.
+const (
+	signatureHues  = 12
+	signatureGreys = 6
+
+	// SignatureSize is the length of an Event's Signature.
+	SignatureSize = 2*signatureHues + signatureGreys
+)
+
+// below these, on OpenCV's 0-255 scale, a pixel counts as grey whatever
+// its hue
+const (
+	signatureMinSaturation = 48
+	signatureMinValue      = 40
+)
+
+// signature is the colour histogram of car mid track, the share of its
+// pixels in each bin, or nil if it can't be taken. Only the middle of its box
+// is used, leaving out most of the road around it and the overlay drawn on
+// the box's edges.
+func (p *Pipeline) signature(car *track.Car) []float64 {
+	if len(car.Track) == 0 {
+		return nil
+	}
+	mid := car.Track[len(car.Track)/2]
+	if mid.Mat == nil || mid.Mat.Empty() || mid.Box.Empty() {
+		return nil
+	}
+
+	// evidence is cut from the road region of the frame
+	road := p.cfg.RoadRegion
+	if road.Min.X < 0 {
+		road.Min.X = 0
+	}
+	if road.Min.Y < 0 {
+		road.Min.Y = 0
+	}
+	box := mid.Box.Sub(road.Min)
+	inset := box.Dx()
+	if box.Dy() < inset {
+		inset = box.Dy()
+	}
+	box = box.Inset(inset / 5).Intersect(image.Rect(0, 0, mid.Mat.Cols(), mid.Mat.Rows()))
+	if box.Empty() {
+		return nil
+	}
+
+	region := mid.Mat.Region(box)
+	defer region.Close()
+	hsv := gocv.NewMat()
+	defer hsv.Close()
+	gocv.CvtColor(region, &hsv, gocv.ColorBGRToHSV)
+	data, err := hsv.DataPtrUint8()
+	if err != nil {
+		return nil
+	}
+
+	sig := make([]float64, SignatureSize)
+	pixels := 0
+	for i := 0; i+2 < len(data); i += 3 {
+		h, s, v := int(data[i]), int(data[i+1]), int(data[i+2])
+		if s < signatureMinSaturation || v < signatureMinValue {
+			sig[2*signatureHues+v*signatureGreys/256]++
+		} else {
+			bin := 2 * (h * signatureHues / 180 % signatureHues)
+			if v >= 128 {
+				bin++
+			}
+			sig[bin]++
+		}
+		pixels++
+	}
+	if pixels == 0 {
+		return nil
+	}
+	for i := range sig {
+		sig[i] /= float64(pixels)
+	}
+	return sig
+}
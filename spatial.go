@@ -0,0 +1,113 @@
+package speedcam
+
+import (
+	"image"
+	"sort"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/track"
+)
+
+// SpatialInterval is how often Config.OnSpatial is called.
+const SpatialInterval = time.Minute
+
+// DefaultHeatmapCell is the side, in full frame pixels, of the cells
+// detections and speeds are gathered in.
+const DefaultHeatmapCell = 32
+
+// Spatial is where in the frame vehicles were tracked over an interval, by
+// the source's clock, and how fast they were going there.
+type Spatial struct {
+	Camera   string
+	Start    time.Time
+	CellSize int           // pixels
+	Cells    []SpatialCell // those with any detections
+}
+
+// SpatialCell is a square of the frame, column X and row Y of CellSize
+// pixels.
+type SpatialCell struct {
+	X          int
+	Y          int
+	Detections int     // track points of every vehicle, timed or not
+	Timed      int     // track points of timed vehicles, their speeds summed in SpeedSum
+	SpeedSum   float64 // mph
+}
+
+// spatialMeter accumulates Spatial car by car.
+type spatialMeter struct {
+	start time.Time
+	cells map[image.Point]*SpatialCell
+}
+
+func (p *Pipeline) heatmapCell() int {
+	if p.cfg.HeatmapCell > 0 {
+		return p.cfg.HeatmapCell
+	}
+	return DefaultHeatmapCell
+}
+
+// measureSpatial adds a removed car's track to the current interval, with
+// the speed at each point if it was timed, handing the interval on once
+// it's over. Speeds are taken by the calibration's one scale across the
+// frame, so cells reading faster or slower than their neighbours point at a
+// calibration that doesn't hold everywhere. It runs in the measure stage.
+func (p *Pipeline) measureSpatial(car *track.Car, timed bool) {
+	if p.cfg.OnSpatial == nil || len(car.Track) == 0 {
+		return
+	}
+	m := &p.spatial
+	at := car.Track[len(car.Track)-1].TrackPoint.Created
+	if m.start.IsZero() {
+		m.start = at.Truncate(SpatialInterval)
+	}
+	if at.Sub(m.start) >= SpatialInterval {
+		p.spatialDone()
+		m.start = at.Truncate(SpatialInterval)
+	}
+	if m.cells == nil {
+		m.cells = map[image.Point]*SpatialCell{}
+	}
+
+	var path finishedPath
+	if timed {
+		for _, t := range car.Track {
+			path.samples = append(path.samples, pathSample{at: t.TrackPoint.Created, point: t.TrackPoint.Point})
+		}
+	}
+	size := p.heatmapCell()
+	feetPerPixel := p.cfg.Calibration.FeetPerPixel()
+	for i, t := range car.Track {
+		key := image.Pt(t.TrackPoint.Point.X/size, t.TrackPoint.Point.Y/size)
+		cell := m.cells[key]
+		if cell == nil {
+			cell = &SpatialCell{X: key.X, Y: key.Y}
+			m.cells[key] = cell
+		}
+		cell.Detections++
+		if timed && len(path.samples) > 1 {
+			cell.Timed++
+			cell.SpeedSum += path.speedAt(i, feetPerPixel)
+		}
+	}
+}
+
+// spatialDone hands the current interval to Config.OnSpatial.
+func (p *Pipeline) spatialDone() {
+	m := &p.spatial
+	if len(m.cells) == 0 {
+		return
+	}
+	s := Spatial{Camera: p.cfg.Camera, Start: m.start, CellSize: p.heatmapCell(), Cells: make([]SpatialCell, 0, len(m.cells))}
+	for _, c := range m.cells {
+		s.Cells = append(s.Cells, *c)
+	}
+	sort.Slice(s.Cells, func(a, b int) bool {
+		if s.Cells[a].Y != s.Cells[b].Y {
+			return s.Cells[a].Y < s.Cells[b].Y
+		}
+		return s.Cells[a].X < s.Cells[b].X
+	})
+	m.cells = nil
+	p.cfg.OnSpatial(s)
+}
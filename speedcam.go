@@ -0,0 +1,499 @@
+// Package speedcam measures the speed of vehicles passing a camera, for
+// embedding in other programs. New builds a Pipeline from a Config and Run
+// reads the source until it closes or the context is cancelled, delivering an
+// Event for every vehicle timed:
+//
+//	p, err := speedcam.New(speedcam.Config{Source: "rtsp://camera/stream"})
+//	if err != nil {
+//		return err
+//	}
+//	go p.Run(ctx)
+//	for e := range p.Events() {
+//		fmt.Printf("%s %.1f mph\n", e.Direction, e.Speed)
+//	}
+//
+// Journalling, evidence storage and publishing are left to the caller, see
+// cmd/speedcam for how the standalone binary does them.
+package speedcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math/rand"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/danhigham/gocv-blob/blob"
+	"github.com/danhigham/speedcam/pkg/capture"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/detect"
+	"github.com/danhigham/speedcam/pkg/event"
+	"github.com/danhigham/speedcam/pkg/matpool"
+	"github.com/danhigham/speedcam/pkg/speed"
+	"github.com/danhigham/speedcam/pkg/stream"
+	"github.com/danhigham/speedcam/pkg/track"
+	"gocv.io/x/gocv"
+)
+
+// ErrSourceClosed is returned by Run when the capture source ends, e.g. at
+// the end of a file or when a stream drops.
+var ErrSourceClosed = errors.New("capture source closed")
+
+// DefaultEventBuffer and DefaultStageBuffer are used when Config leaves the
+// buffer sizes zero.
+const (
+	DefaultEventBuffer = 16
+	DefaultStageBuffer = 4
+)
+
+// Trackers following cars between detections.
+const (
+	TrackerCSRT = "csrt" // a correlation tracker per car, smooth tracks at a CPU cost
+	TrackerIOU  = "iou"  // the detected boxes alone, cars only move on detected frames
+)
+
+// matPoolSize is enough free Mats to cover every frame queued between stages
+// with some to spare for evidence frames.
+const matPoolSize = 32
+
+// Event is a timed vehicle. ImageURI is left for the caller to fill in once
+// it has stored Image.
+type Event struct {
+	event.CarMessage
+	Image     []byte            // JPEG of the vehicle mid track, cropped to the road
+	Shed      bool              // sent without Image to catch up, see Config.ShedDepth
+	Signature []float64         // the vehicle's colours, nil unless Config.Signatures
+	Track     []blob.TrackPoint // the vehicle's path in full frame pixels, by the source's clock
+}
+
+// Count is a vehicle that crossed the frame, whether or not its track was
+// long enough to time.
+type Count struct {
+	Camera    string
+	Direction string
+	Class     string    // empty without a Classifier
+	TimeStamp time.Time // last seen, by the source's clock
+	Timed     bool      // an Event was sent for it too
+}
+
+// Tuner supplies the detection parameters, read every frame so they can be
+// changed while running. *control.Tuning is one.
+type Tuner interface {
+	Values() control.TuningValues
+}
+
+// Classifier labels a vehicle from its evidence image, empty if it can't
+// tell. *classify.Classifier is one. Each pipeline calls it from one
+// goroutine, but one Classifier may be shared by several pipelines, so it
+// must be safe for concurrent use.
+type Classifier interface {
+	Classify(img gocv.Mat) string
+}
+
+// StaticTuning is a Tuner that never changes.
+type StaticTuning control.TuningValues
+
+func (t StaticTuning) Values() control.TuningValues {
+	return control.TuningValues(t)
+}
+
+type Config struct {
+	Camera      string                 // ID set on every event, empty when there is only one camera
+	Source      string                 // video file, directory of images, stream URL, device or GStreamer pipeline ending in appsink
+	Input       capture.Source         // read instead of opening Source, which then only names it, e.g. a capture.Mock
+	Replay      capture.Replay         // how a video file Source is played, zero for as fast as it can be read
+	Mask        *detect.BackgroundMask // nil to detect anywhere in the frame
+	Calibration speed.Calibration      // zero for speed.DefaultCalibration
+	RoadRegion  image.Rectangle        // cut from frames as evidence, empty for detect.RoadRegion
+	Tuning      Tuner                  // nil for control.DefaultTuning
+	Controls    *control.Controls      // detection stops while paused, nil to never pause
+
+	// Lanes sets each event's Lane to the one most of its track was in, nil
+	// to leave it empty.
+	Lanes []Lane
+
+	// LimitSchedule sets the speed limit at the times it covers, e.g. a
+	// school zone's, Tuning's SpeedLimit applying otherwise.
+	LimitSchedule control.LimitSchedule
+
+	// Signatures sets each event's Signature, a histogram of the vehicle's
+	// colours mid track of SignatureSize bins, for recognising it when it
+	// comes past again.
+	Signatures bool
+
+	// DetectWidth scales wider frames down to this many pixels for
+	// detection and tracking, which cost far less on a small frame. Evidence
+	// is still cut from the full frame and all geometry, including the mask,
+	// calibration and overlays, stays in full frame pixels. 0 detects at full
+	// resolution.
+	DetectWidth int
+
+	// Backend is where background subtraction and resizing run,
+	// detect.BackendCPU or detect.BackendCUDA, falling back to the CPU when
+	// CUDA isn't available. Empty for the CPU.
+	Backend string
+
+	// Decode is how the source is decoded, capture.DecodeV4L2M2M or
+	// capture.DecodeVAAPI to offload H.264 decoding, which alone can take
+	// most of a Pi core, to hardware. Empty to decode in software.
+	Decode string
+
+	// Classifier sets the Class of each event, nil to leave it empty.
+	Classifier Classifier
+
+	MaxFPS   float64 // frames a second processed, the rest dropped. 0 for all
+	Lossless bool    // capture waits on the stages instead of dropping frames, to process a file in full
+	Adaptive bool    // lower the detection stride, resolution and annotation when falling behind
+
+	// StageBuffer is how many frames can queue between stages before the
+	// oldest are dropped, EventBuffer how many cars can wait to be measured
+	// and events to be delivered. 0 for the defaults.
+	StageBuffer int
+	EventBuffer int
+
+	// ShedDepth is how many events can be waiting on OnEvent or Events
+	// before the next are sent stats only, without an evidence image, so a
+	// slow consumer costs evidence rather than ever more memory. 0 never
+	// sheds.
+	ShedDepth   int
+	Tracker     string // TrackerCSRT or TrackerIOU, empty for TrackerCSRT
+	JPEGQuality int    // of evidence images, 1-100. 0 for OpenCV's default of 95
+
+	// ThermalLimit is the SoC temperature in degrees C at which the frame
+	// rate is halved until it cools, read from ThermalZone. 0 disables.
+	ThermalLimit float64
+	ThermalZone  string // empty for DefaultThermalZone
+
+	// OnConnection is called when a stream source drops, with false, and
+	// once it has been reopened, with true. Files and devices aren't reopened,
+	// Run returns ErrSourceClosed when they end.
+	OnConnection func(connected bool)
+
+	// StallTimeout is how long the source can go without delivering a new
+	// frame, or deliver only repeats of the last, before Run gives up on it
+	// with ErrFeedFrozen. 0 disables the watchdog.
+	StallTimeout time.Duration
+
+	// OnFrozen is called when the watchdog finds the feed frozen, with how
+	// long since the last new frame, e.g. to alert someone.
+	OnFrozen func(age time.Duration)
+
+	// Deterministic runs every stage for each frame in turn on the goroutine
+	// calling Run, never dropping frames, with event IDs drawn from Seed and
+	// frames the source doesn't stamp stamped by Clock, so replaying a
+	// recording twice produces identical events, e.g. to compare tracking
+	// changes. Adaptive, ThermalLimit and StallTimeout are ignored.
+	Deterministic bool
+	Seed          int64
+	Clock         func() time.Time // nil for time.Now
+
+	// OnCount is called from the measure stage for every vehicle tracked far
+	// enough to tell which way it went, timed or not. It must be quick.
+	OnCount func(Count)
+
+	// NearMissDistance, in feet, and NearMissTTC flag a NearMiss whenever a
+	// vehicle and one of NearMissClasses come within NearMissDistance of
+	// each other, or are on course to collide within NearMissTTC. Both need
+	// a Classifier, 0 for both disables.
+	NearMissDistance float64
+	NearMissTTC      time.Duration
+	NearMissClasses  []string // nil for DefaultNearMissClasses
+
+	// OnNearMiss is called from the measure stage for every near miss. It
+	// must be quick.
+	OnNearMiss func(NearMiss)
+
+	// Crosswalk is where pedestrians cross, in full frame pixels. Vehicles
+	// reaching it while someone is on it are checked for yielding, slowing
+	// to CrosswalkYieldSpeed, in mph, or waiting for them to clear it. Needs
+	// a Classifier, empty disables.
+	Crosswalk           image.Rectangle
+	CrosswalkYieldSpeed float64 // 0 for DefaultYieldSpeed
+
+	// OnYield is called from the measure stage for every vehicle that
+	// reached the crosswalk while someone was on it, yielding or not. It
+	// must be quick.
+	OnYield func(Yield)
+
+	// OnOccupancy is called from the track stage every OccupancyInterval of
+	// the source's clock with how long vehicles were in view. It must be
+	// quick.
+	OnOccupancy func(Occupancy)
+
+	// OnSpatial is called from the measure stage every SpatialInterval of
+	// the source's clock, as cars leave, with where in the frame they were
+	// and how fast, gathered in cells of HeatmapCell pixels, 0 for
+	// DefaultHeatmapCell. It must be quick.
+	OnSpatial   func(Spatial)
+	HeatmapCell int
+
+	// OnEvent is called for every vehicle timed, from a goroutine of its own
+	// so slow storage doesn't hold up detection. When nil events are sent to
+	// Events instead.
+	OnEvent func(Event)
+
+	// OnFrame is called from the output stage with every frame processed,
+	// its foreground mask and overlay, e.g. to publish them to a
+	// stream.FrameHub. The Mats must not be modified or kept after it returns.
+	OnFrame func(frame gocv.Mat, foreground gocv.Mat, overlay stream.Overlay)
+
+	// OnPanic is called with a panic recovered while a stage handled a frame
+	// or car, from the deferred call recovering it so the stack is still the
+	// panic's, e.g. to report it.
+	OnPanic func(stage string, v interface{})
+}
+
+// recovered is the error Run returns for a panic in stage.
+func recovered(stage string, v interface{}) error {
+	return fmt.Errorf("%s stage panicked: %v\n%s", stage, v, debug.Stack())
+}
+
+// Pipeline reads frames from a source, detects and tracks vehicles and times
+// them. A Pipeline can only be Run once.
+type Pipeline struct {
+	cfg          Config
+	source       capture.Source
+	rate         capture.Rate
+	detector     *detect.Detector
+	tracker      *blob.CentroidTracker
+	cars         track.Register
+	events       chan Event
+	mats         *matpool.Pool
+	throttle     int32 // 1 while the SoC is too hot, see watchThermal
+	stats        statsRecorder
+	degrade      int32      // quality level lowered to, see adapt
+	fresh        int64      // unix nanoseconds of the last new frame, see watchFeed
+	frozen       int32      // 1 once watchFeed has given up on the source
+	connected    int32      // 0 while a dropped stream is being reopened
+	session      int        // times the source has been reopened, see reconnect
+	trackSession int        // session of the frames being tracked
+	ids          *rand.Rand // event IDs are drawn from when deterministic
+	occupancy    occupancyMeter
+	spatial      spatialMeter
+	paths        []finishedPath // recently finished, for near misses
+	panicMu      sync.Mutex
+	panics       []time.Time // recovered within stagePanicWindow, see heal
+}
+
+// newBlobTracker returns an empty centroid tracker for matching blobs from
+// one detected frame to the next.
+func newBlobTracker() *blob.CentroidTracker {
+	return blob.NewCentroidTracker(20, 40, 10)
+}
+
+// New opens the source and prepares the pipeline.
+func New(cfg Config) (*Pipeline, error) {
+	if cfg.Calibration == (speed.Calibration{}) {
+		cfg.Calibration = speed.DefaultCalibration
+	}
+	if cfg.RoadRegion.Empty() {
+		cfg.RoadRegion = detect.RoadRegion
+	}
+	if cfg.Tuning == nil {
+		cfg.Tuning = StaticTuning(control.DefaultTuning)
+	}
+	if err := cfg.Tuning.Values().Validate(); err != nil {
+		return nil, err
+	}
+	if cfg.DetectWidth < 0 {
+		return nil, errors.New("DetectWidth must not be negative")
+	}
+	if cfg.MaxFPS < 0 {
+		return nil, errors.New("MaxFPS must not be negative")
+	}
+	switch cfg.Decode {
+	case capture.DecodeSoftware, capture.DecodeV4L2M2M, capture.DecodeVAAPI:
+	default:
+		return nil, fmt.Errorf("unknown decoder %q", cfg.Decode)
+	}
+	switch cfg.Tracker {
+	case "":
+		cfg.Tracker = TrackerCSRT
+	case TrackerCSRT, TrackerIOU:
+	default:
+		return nil, fmt.Errorf("unknown tracker %q", cfg.Tracker)
+	}
+	if cfg.JPEGQuality < 0 || cfg.JPEGQuality > 100 {
+		return nil, errors.New("JPEGQuality must be between 1 and 100")
+	}
+	if cfg.StageBuffer < 0 || cfg.EventBuffer < 0 || cfg.ShedDepth < 0 {
+		return nil, errors.New("buffer sizes must not be negative")
+	}
+	if cfg.StageBuffer == 0 {
+		cfg.StageBuffer = DefaultStageBuffer
+	}
+	if cfg.EventBuffer == 0 {
+		cfg.EventBuffer = DefaultEventBuffer
+	}
+	if cfg.ThermalZone == "" {
+		cfg.ThermalZone = DefaultThermalZone
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
+	if cfg.Replay.Epoch.IsZero() {
+		cfg.Replay.Epoch = cfg.Clock().Add(-cfg.Replay.Start)
+	}
+
+	detector, err := detect.NewDetector(cfg.Mask, cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Detecting on %s\n", detector.Backend())
+
+	source := cfg.Input
+	if source == nil {
+		if capture.IsRecording(cfg.Source) {
+			source, err = capture.OpenReplay(cfg.Source, cfg.Decode, cfg.Replay)
+		} else {
+			source, err = capture.Open(cfg.Source, cfg.Decode)
+		}
+		if err != nil {
+			detector.Close()
+			return nil, fmt.Errorf("opening %s: %s", cfg.Source, err)
+		}
+	}
+
+	var ids *rand.Rand
+	if cfg.Deterministic {
+		cfg.Lossless = true
+		ids = rand.New(rand.NewSource(cfg.Seed))
+	}
+
+	return &Pipeline{
+		cfg:      cfg,
+		ids:      ids,
+		source:   source,
+		detector: detector,
+		tracker:  newBlobTracker(),
+		cars:     make(track.Register),
+		events:   make(chan Event, cfg.EventBuffer),
+		mats:     matpool.New(matPoolSize),
+	}, nil
+}
+
+// Events delivers timed vehicles when Config.OnEvent isn't set. It must be
+// drained or measurement stalls, and is closed when Run returns.
+func (p *Pipeline) Events() <-chan Event {
+	return p.events
+}
+
+// Run processes frames until the source closes, returning ErrSourceClosed,
+// freezes, returning ErrFeedFrozen, or ctx is cancelled, returning nil. A
+// stream that drops is reopened rather than closing. The source is closed on
+// return.
+//
+// Frames pass through capture, preprocess, detect, track and output stages,
+// each in its own goroutine, with timed cars measured and their events
+// delivered alongside. The stages are joined by bounded channels and capture
+// drops frames rather than wait, so slow callbacks cost frames, not timing.
+// A panic handling a frame or car in any stage but capture, the OnFrame and
+// OnEvent callbacks included, drops it and the stage carries on, see heal.
+// One in capture, or more than maxStagePanics a minute, stops the pipeline
+// and is returned as an error. With Config.Deterministic the stages run in
+// turn on the calling goroutine instead, and any panic stops the pipeline
+// so a replay never silently differs.
+func (p *Pipeline) Run(ctx context.Context) error {
+	defer func() {
+		if p.source != nil {
+			p.source.Close()
+		}
+	}()
+	defer p.detector.Close()
+	defer p.mats.Close()
+
+	if p.cfg.Deterministic {
+		return p.runSerial(ctx)
+	}
+
+	captured := make(chan *frame, p.cfg.StageBuffer)
+	preprocessed := make(chan *frame, p.cfg.StageBuffer)
+	detected := make(chan *frame, p.cfg.StageBuffer)
+	tracked := make(chan *frame, p.cfg.StageBuffer)
+	removed := make(chan removal, p.cfg.EventBuffer)
+	measured := make(chan Event, p.cfg.EventBuffer)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// a panicking stage stops the pipeline with an error rather than the
+	// process, draining its input so the stages before it can finish
+	var failed error
+	var failOnce sync.Once
+	var wg sync.WaitGroup
+	stage := func(name string, run func(), drain func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					failOnce.Do(func() { failed = recovered(name, r) })
+					cancel()
+					if drain != nil {
+						drain()
+					}
+				}
+			}()
+			run()
+		}()
+	}
+	dropFrames := func(in <-chan *frame) func() {
+		return func() {
+			for f := range in {
+				p.drop(f)
+			}
+		}
+	}
+	stage(StagePreprocess, func() { p.preprocess(captured, preprocessed) }, dropFrames(captured))
+	stage(StageDetect, func() { p.detect(preprocessed, detected) }, dropFrames(preprocessed))
+	stage(StageTrack, func() { p.track(detected, tracked, removed) }, dropFrames(detected))
+	stage(StageMeasure, func() { p.measure(removed, measured) }, func() {
+		for r := range removed {
+			p.release(r.car)
+		}
+	})
+	stage(StageOutput, func() { p.outputFrames(tracked) }, dropFrames(tracked))
+	stage(StageOutput, func() { p.outputEvents(ctx, measured) }, func() {
+		for range measured {
+		}
+	})
+	if p.cfg.ThermalLimit > 0 {
+		stage("thermal", func() { p.watchThermal(ctx) }, nil)
+	}
+	if p.cfg.StallTimeout > 0 {
+		stage("watchdog", func() { p.watchFeed(ctx, cancel) }, nil)
+	}
+	if p.cfg.Adaptive {
+		stage("adapt", func() { p.adapt(ctx, captured, preprocessed, detected, tracked) }, nil)
+	}
+
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(StageCapture, r)
+			}
+		}()
+		defer close(captured)
+		return p.capture(ctx, func(f *frame) bool {
+			return p.send(ctx, captured, f)
+		})
+	}()
+	cancel()
+	wg.Wait()
+	if atomic.LoadInt32(&p.frozen) == 1 {
+		err = ErrFeedFrozen
+	}
+	if failed != nil {
+		err = failed
+	}
+
+	for _, car := range p.cars {
+		p.release(car)
+	}
+	p.cars = make(track.Register)
+	return err
+}
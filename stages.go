@@ -0,0 +1,624 @@
+package speedcam
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync/atomic"
+	"time"
+
+	"github.com/danhigham/gocv-blob/blob"
+	"github.com/danhigham/speedcam/pkg/capture"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/event"
+	"github.com/danhigham/speedcam/pkg/metrics"
+	"github.com/danhigham/speedcam/pkg/parallel"
+	"github.com/danhigham/speedcam/pkg/resources"
+	"github.com/danhigham/speedcam/pkg/speed"
+	"github.com/danhigham/speedcam/pkg/stream"
+	"github.com/danhigham/speedcam/pkg/track"
+	uuid "github.com/satori/go.uuid"
+	"gocv.io/x/gocv"
+	"gocv.io/x/gocv/contrib"
+)
+
+// frame is a captured image on its way through the stages. Its Mats come
+// from the pipeline's pool, whoever drops the frame puts them back.
+type frame struct {
+	img        gocv.Mat  // full resolution, for evidence and output
+	small      gocv.Mat  // img scaled to Config.DetectWidth, or img itself
+	scale      float64   // of small relative to img
+	foreground gocv.Mat  // of small
+	at         time.Time // when it was captured, tracks are timed by it
+	fps        float64
+	paused     bool
+	session    int  // of the source when captured, see reconnect
+	skip       bool // between detection strides, only the trackers run
+	tune       control.TuningValues
+	boxes      []image.Rectangle
+	detecting  time.Duration
+	latency    []stream.Latency // time spent on it by each stage so far
+	overlay    stream.Overlay
+}
+
+// observe records the time a stage spent on f.
+func (p *Pipeline) observe(f *frame, stage string, d time.Duration) {
+	p.stats.observe(stage, d)
+	f.latency = append(f.latency, stream.Latency{Stage: stage, Duration: d})
+}
+
+// drop puts a frame's Mats back in the pool once it is done with.
+func (p *Pipeline) drop(f *frame) {
+	if f.scale != 1 {
+		p.mats.Put(f.small)
+	}
+	p.mats.Put(f.img)
+	p.mats.Put(f.foreground)
+}
+
+// abandonCars releases every car being tracked without timing them. The
+// blob tracker is started afresh with them, so every object it holds has a
+// car.
+func (p *Pipeline) abandonCars() {
+	for _, car := range p.cars {
+		p.release(car)
+	}
+	p.cars = make(track.Register)
+	p.tracker = newBlobTracker()
+	metrics.ActiveTracks.Set(0)
+}
+
+// release closes a car's tracker and puts its evidence frames back in the
+// pool, once the car has been measured or abandoned.
+func (p *Pipeline) release(car *track.Car) {
+	if car.Tracker != nil {
+		car.Tracker.Close()
+		car.Tracker = nil
+		resources.Trackers.Close(car)
+	}
+	for _, t := range car.Track {
+		if t.Mat != nil {
+			p.mats.Put(*t.Mat)
+		}
+	}
+	car.Track = nil
+}
+
+// removal is a car that has left the frame, waiting to be measured.
+type removal struct {
+	id   uuid.UUID
+	car  *track.Car
+	tune control.TuningValues
+}
+
+// capture reads frames, handing each to emit, until the source closes, ctx
+// is cancelled or emit returns false.
+func (p *Pipeline) capture(ctx context.Context, emit func(f *frame) bool) error {
+	fmt.Printf("Start reading stream: %v\n", p.cfg.Source)
+	var next time.Time
+	prev := gocv.NewMat() // last new frame, for the watchdog
+	defer prev.Close()
+	atomic.StoreInt32(&p.connected, 1)
+	metrics.SourceConnected.WithLabelValues(p.cfg.Camera).Set(1)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+		}
+
+		img := p.mats.Get()
+		start := time.Now()
+		captureFrame, err := p.source.NextFrame(&img)
+		if err != nil {
+			p.mats.Put(img)
+			if p.cfg.Input != nil || !capture.IsStream(p.cfg.Source) {
+				return ErrSourceClosed
+			}
+			if !p.reconnect(ctx) {
+				return nil
+			}
+			continue
+		}
+		captured := time.Since(start)
+		p.stats.observe(StageCapture, captured)
+		if img.Empty() {
+			p.mats.Put(img)
+			p.dropped()
+			continue
+		}
+
+		if p.cfg.StallTimeout > 0 {
+			p.markFresh(img, &prev)
+		}
+
+		at := captureFrame.Time
+		if at.IsZero() {
+			at = p.cfg.Clock()
+		}
+		p.rate.Tick(at)
+		fps := p.rate.FPS()
+		metrics.CaptureFPS.Set(fps)
+
+		if interval := p.frameInterval(fps); interval > 0 {
+			if at.Before(next) {
+				p.mats.Put(img)
+				p.dropped()
+				continue
+			}
+			next = at.Add(interval)
+		}
+
+		f := &frame{img: img, small: img, scale: 1, foreground: p.mats.Get(), at: at, fps: fps, session: p.session}
+		f.latency = append(f.latency, stream.Latency{Stage: StageCapture, Duration: captured})
+		if !emit(f) {
+			return nil
+		}
+	}
+}
+
+// send queues f for the stages. With Config.Lossless it waits for room,
+// otherwise it never waits, when out is full the oldest frame queued is
+// dropped to make room so the stages work on the freshest. It returns false
+// once ctx is cancelled.
+func (p *Pipeline) send(ctx context.Context, out chan *frame, f *frame) bool {
+	if !p.cfg.Lossless {
+		p.enqueue(out, f)
+		return true
+	}
+	select {
+	case out <- f:
+		return true
+	case <-ctx.Done():
+		p.drop(f)
+		return false
+	}
+}
+
+func (p *Pipeline) dropped() {
+	metrics.FramesDropped.Inc()
+	p.stats.add(&p.stats.stats.Dropped, 1)
+}
+
+// frameInterval is the least time between frames processed, from
+// Config.MaxFPS and doubled while thermally throttled. Zero processes every
+// frame.
+func (p *Pipeline) frameInterval(fps float64) time.Duration {
+	rate := p.cfg.MaxFPS
+	if p.throttled() {
+		if rate == 0 || rate > fps {
+			rate = fps
+		}
+		rate /= 2
+	}
+	if rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(time.Second) / rate)
+}
+
+// enqueue sends f to out, dropping the oldest frames queued until it fits.
+func (p *Pipeline) enqueue(out chan *frame, f *frame) {
+	for {
+		select {
+		case out <- f:
+			return
+		default:
+		}
+
+		select {
+		case old := <-out:
+			p.drop(old)
+			p.dropped()
+		default:
+		}
+	}
+}
+
+// preprocess reads the controls and tuning for each frame, scales it down for
+// detection and updates the background model with every DetectStride'th.
+func (p *Pipeline) preprocess(in <-chan *frame, out chan<- *frame) {
+	defer close(out)
+
+	var n int
+	for f := range in {
+		if p.heal(StagePreprocess, func() { p.preprocessFrame(f, &n) }, func() { p.drop(f) }) {
+			out <- f
+		}
+	}
+}
+
+// preprocessFrame preprocesses f, n counting the frames preprocessed for the
+// detection stride.
+func (p *Pipeline) preprocessFrame(f *frame, n *int) {
+	stageStart := time.Now()
+	f.paused = p.cfg.Controls != nil && p.cfg.Controls.Paused()
+	if !f.paused {
+		f.tune = p.cfg.Tuning.Values()
+		stride := f.tune.DetectStride
+		if p.degradation() >= degradeStride {
+			stride *= 2
+		}
+		f.skip = *n%stride != 0
+		*n++
+		p.downscale(f)
+	}
+	if !f.paused && !f.skip {
+		start := time.Now()
+		p.detector.Subtract(f.small, f.tune.Threshold, &f.foreground)
+		f.detecting = time.Since(start)
+	}
+	p.observe(f, StagePreprocess, time.Since(stageStart))
+}
+
+// downscale fills in f.small when the frame is wider than
+// Config.DetectWidth, or half that when falling behind.
+func (p *Pipeline) downscale(f *frame) {
+	width := p.cfg.DetectWidth
+	if p.degradation() >= degradeSmall {
+		if width <= 0 || width > f.img.Cols() {
+			width = f.img.Cols()
+		}
+		width /= 2
+	}
+	if width <= 0 || f.img.Cols() <= width {
+		return
+	}
+	f.scale = float64(width) / float64(f.img.Cols())
+	f.small = p.mats.Get()
+	p.detector.Resize(f.img, &f.small, image.Pt(width, int(float64(f.img.Rows())*f.scale)))
+}
+
+// scaleRect scales r by s about the origin.
+func scaleRect(r image.Rectangle, s float64) image.Rectangle {
+	if s == 1 {
+		return r
+	}
+	return image.Rect(int(float64(r.Min.X)*s), int(float64(r.Min.Y)*s), int(float64(r.Max.X)*s), int(float64(r.Max.Y)*s))
+}
+
+// detect finds the moving blobs in each frame's foreground, in full
+// resolution coordinates.
+func (p *Pipeline) detect(in <-chan *frame, out chan<- *frame) {
+	defer close(out)
+
+	for f := range in {
+		if p.heal(StageDetect, func() { p.detectFrame(f) }, func() { p.drop(f) }) {
+			out <- f
+		}
+	}
+}
+
+func (p *Pipeline) detectFrame(f *frame) {
+	if f.paused || f.skip {
+		return
+	}
+	metrics.FramesProcessed.Inc()
+	start := time.Now()
+	f.boxes = p.detector.Find(f.foreground, f.tune.MinArea, f.scale)
+	f.detecting += time.Since(start)
+	metrics.DetectionSeconds.Observe(f.detecting.Seconds())
+	p.observe(f, StageDetect, time.Since(start))
+	p.stats.add(&p.stats.stats.Detections, len(f.boxes))
+}
+
+// track follows the blobs from frame to frame, sending cars that have left
+// to removed.
+func (p *Pipeline) track(in <-chan *frame, out chan<- *frame, removed chan<- removal) {
+	defer close(out)
+	defer close(removed)
+
+	for f := range in {
+		var gone []removal
+		// the cars may be left half updated, so they're abandoned, e.g. a
+		// tracker that's lost its object
+		if !p.heal(StageTrack, func() { gone = p.trackFrame(f) }, func() { p.abandonCars(); p.drop(f) }) {
+			continue
+		}
+		for _, r := range gone {
+			removed <- r
+		}
+		out <- f
+	}
+}
+
+// trackFrame updates the cars from f, returning those that have left.
+func (p *Pipeline) trackFrame(f *frame) []removal {
+	start := time.Now()
+	gone := p.updateCars(f)
+	p.measureOccupancy(f)
+	p.observe(f, StageTrack, time.Since(start))
+	if p.cfg.Deterministic {
+		sortRemovals(gone)
+	}
+	return gone
+}
+
+func (p *Pipeline) updateCars(f *frame) []removal {
+	if f.session != p.trackSession {
+		// the source was reopened, cars in flight can't be timed across the
+		// outage
+		p.abandonCars()
+		p.trackSession = f.session
+	}
+	if f.paused {
+		// nor across a pause
+		p.abandonCars()
+		f.overlay = stream.Overlay{FPS: f.fps}
+		return nil
+	}
+
+	if f.skip {
+		f.overlay = p.follow(f, false)
+		f.overlay.FPS = f.fps
+		return nil
+	}
+
+	p.tracker.Update(f.boxes)
+	f.overlay = p.follow(f, true)
+	f.overlay.FPS = f.fps
+
+	metrics.ActiveTracks.Set(float64(len(p.cars)))
+
+	return p.dropLost(f.tune)
+}
+
+// dropLost removes the cars whose blob the tracker no longer has, they've
+// left the frame and are returned to be measured.
+func (p *Pipeline) dropLost(tune control.TuningValues) []removal {
+	var gone []removal
+	for i, car := range p.cars {
+		if _, ok := p.tracker.Objects[i]; !ok {
+			delete(p.cars, i)
+			gone = append(gone, removal{id: i, car: car, tune: tune})
+		}
+	}
+	return gone
+}
+
+// follow starts a correlation tracker for each blob new on a detected frame
+// and extends the track of every car still in view, returning their boxes
+// and tracks. The trackers run on the downscaled frame, everything they
+// return is scaled back up.
+func (p *Pipeline) follow(f *frame, detected bool) stream.Overlay {
+	for _, id := range p.tracker.NewObjects {
+		if !detected {
+			break
+		}
+
+		p.cars[id] = &track.Car{Track: []track.CarTrack{}}
+		if p.cfg.Tracker == TrackerCSRT {
+			p.cars[id].Tracker = contrib.NewTrackerCSRT()
+			resources.Trackers.Open(p.cars[id])
+			p.cars[id].Tracker.Init(f.small, scaleRect(p.tracker.Objects[id].CurrentRect, f.scale))
+		}
+	}
+
+	ids := make([]uuid.UUID, 0, len(p.tracker.Objects))
+	for i := range p.tracker.Objects {
+		ids = append(ids, i)
+	}
+
+	// each car's tracker and evidence frame are its own, so cars are
+	// followed in parallel, unless deterministic
+	carOverlays := make([]*stream.Overlay, len(ids))
+	followCar := func(n int) {
+		car := p.cars[ids[n]]
+
+		var rect image.Rectangle
+		switch {
+		case car.Tracker != nil:
+			rect, _ = car.Tracker.Update(f.small)
+			rect = scaleRect(rect, 1/f.scale)
+		case detected:
+			rect = p.tracker.Objects[ids[n]].CurrentRect
+		default:
+			return
+		}
+
+		newPoint := image.Pt((rect.Min.X*2+rect.Dx())/2, (rect.Min.Y*2+rect.Dy())/2)
+
+		carOverlay := stream.Overlay{Boxes: []image.Rectangle{rect}, Tracks: [][]image.Point{car.TrackPoints()}}
+		carOverlays[n] = &carOverlay
+
+		if newPoint.X > 0 && newPoint.Y > 0 {
+			// timed from capture, not from when this stage got to it
+			point := blob.NewTrackPoint(newPoint)
+			point.Created = f.at
+
+			evidence := p.evidenceFrame(f.img, carOverlay, p.degradation() < degradeNoOverlay)
+			car.Track = append(car.Track, track.CarTrack{
+				TrackPoint: point,
+				Box:        rect,
+				Mat:        &evidence,
+			})
+		}
+	}
+	if p.cfg.Deterministic {
+		for n := range ids {
+			followCar(n)
+		}
+	} else {
+		parallel.For(len(ids), followCar)
+	}
+
+	var overlay stream.Overlay
+	for _, carOverlay := range carOverlays {
+		if carOverlay != nil {
+			overlay.Boxes = append(overlay.Boxes, carOverlay.Boxes...)
+			overlay.Tracks = append(overlay.Tracks, carOverlay.Tracks...)
+		}
+	}
+	return overlay
+}
+
+// evidenceFrame copies the road from img into a Mat from the pool and, if
+// annotate is set, draws a car's box and track on it.
+func (p *Pipeline) evidenceFrame(img gocv.Mat, overlay stream.Overlay, annotate bool) gocv.Mat {
+	road := p.cfg.RoadRegion.Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+	region := img.Region(road) //Just show road in frame
+	defer region.Close()
+	evidence := p.mats.Clone(region)
+	if !annotate {
+		return evidence
+	}
+
+	stream.DrawOverlay(&evidence, overlay.Translate(road.Min.Mul(-1)), stream.OverlayOptions{Boxes: true, Tracks: true})
+	return evidence
+}
+
+// measure times each car removed, sending an event to out for those whose
+// track was long enough.
+func (p *Pipeline) measure(in <-chan removal, out chan<- Event) {
+	defer close(out)
+
+	for r := range in {
+		shed := p.cfg.ShedDepth > 0 && len(out) >= p.cfg.ShedDepth
+		var e Event
+		var timed bool
+		p.heal(StageMeasure, func() { e, timed = p.measureCar(r, shed) }, func() { p.release(r.car) })
+		if timed {
+			out <- e
+		}
+	}
+}
+
+// measureCar times a car, leaving the event's Image out when shed.
+func (p *Pipeline) measureCar(r removal, shed bool) (Event, bool) {
+	start := time.Now()
+	defer func() {
+		p.stats.observe(StageMeasure, time.Since(start))
+	}()
+
+	id, car, tune := r.id, r.car, r.tune
+	defer p.release(car)
+
+	distance, duration, err := car.SpaceTimeTravelled()
+	mat, err := car.MiddleMat()
+	if err != nil {
+		return Event{}, false
+	}
+
+	ft := distance * p.cfg.Calibration.FeetPerPixel()
+	timed := ft >= tune.MinDistance // need enough distance for a good read
+	direction := car.Direction()
+	var class string
+	if p.cfg.Classifier != nil && (timed || direction != "") {
+		class = p.classify(car)
+	}
+	lastSeen := car.Track[len(car.Track)-1].TrackPoint.Created
+	if timed && p.ids != nil {
+		id = seededID(p.ids)
+	}
+	eventID := uuid.Nil
+	if timed {
+		eventID = id
+	}
+	p.finishPath(car, class, eventID)
+	p.measureSpatial(car, timed)
+
+	if direction != "" {
+		p.count(Count{Camera: p.cfg.Camera, Direction: direction, Class: class, TimeStamp: lastSeen, Timed: timed})
+	}
+	if !timed {
+		return Event{}, false
+	}
+
+	mph := speed.MPH(ft, duration)
+
+	fmt.Printf("%s Avg Speed: %3.2f mph across %3.2f ft\n", id.String(), mph, ft)
+	fmt.Printf("Removing %s\n", id.String())
+
+	e := Event{CarMessage: event.CarMessage{
+		ID:         id,
+		Camera:     p.cfg.Camera,
+		Speed:      mph,
+		Distance:   ft,
+		Direction:  direction,
+		Class:      class,
+		Lane:       p.laneOf(car),
+		SpeedLimit: p.cfg.LimitSchedule.LimitAt(lastSeen, tune.SpeedLimit),
+		TimeStamp:  lastSeen,
+	}}
+	for _, t := range car.Track {
+		e.Track = append(e.Track, t.TrackPoint)
+	}
+	if p.cfg.Signatures {
+		e.Signature = p.signature(car)
+	}
+
+	if shed {
+		fmt.Printf("Event backlog, sending %s without evidence\n", id.String())
+		metrics.LoadShed.WithLabelValues("evidence").Inc()
+		p.stats.add(&p.stats.stats.Shed, 1)
+		e.Shed = true
+		return e, true
+	}
+	if p.cfg.JPEGQuality > 0 {
+		e.Image, err = gocv.IMEncodeWithParams(".jpg", *mat, []int{gocv.IMWriteJpegQuality, p.cfg.JPEGQuality})
+	} else {
+		e.Image, err = gocv.IMEncode(".jpg", *mat)
+	}
+	if err != nil {
+		fmt.Printf("Failed to encode evidence for %s, %s\n", id.String(), err)
+	}
+
+	return e, true
+}
+
+// count records a vehicle crossing, timed or not, and hands it to
+// Config.OnCount.
+func (p *Pipeline) count(c Count) {
+	metrics.VehiclesCounted.WithLabelValues(c.Camera, c.Direction, c.Class).Inc()
+	p.stats.add(&p.stats.stats.Counted, 1)
+	if p.cfg.OnCount != nil {
+		p.cfg.OnCount(c)
+	}
+}
+
+// outputFrames hands each frame to Config.OnFrame, then drops it.
+func (p *Pipeline) outputFrames(in <-chan *frame) {
+	// a frame's own output time isn't known until it's gone, the last
+	// frame's is shown instead
+	var last time.Duration
+	for f := range in {
+		p.heal(StageOutput, func() { last = p.outputFrame(f, last) }, func() { p.drop(f) })
+	}
+}
+
+// outputFrame hands f to Config.OnFrame, showing last as its output time,
+// then drops it, returning the time that took.
+func (p *Pipeline) outputFrame(f *frame, last time.Duration) time.Duration {
+	start := time.Now()
+	f.overlay.Latency = append(f.latency, stream.Latency{Stage: StageOutput, Duration: last})
+	if p.cfg.OnFrame != nil {
+		p.cfg.OnFrame(f.img, f.foreground, f.overlay)
+	}
+	p.drop(f)
+	took := time.Since(start)
+	p.stats.observe(StageOutput, took)
+	p.stats.add(&p.stats.stats.Frames, 1)
+	return took
+}
+
+// outputEvents hands each event to Config.OnEvent, or Events when it isn't
+// set, giving up on the rest once ctx is cancelled.
+func (p *Pipeline) outputEvents(ctx context.Context, in <-chan Event) {
+	defer close(p.events)
+
+	for e := range in {
+		p.heal(StageOutput, func() { p.outputEvent(ctx, e) }, nil)
+	}
+}
+
+func (p *Pipeline) outputEvent(ctx context.Context, e Event) {
+	p.stats.add(&p.stats.stats.Events, 1)
+	if p.cfg.OnEvent != nil {
+		p.cfg.OnEvent(e)
+		return
+	}
+	select {
+	case p.events <- e:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,92 @@
+package speedcam
+
+import (
+	"sync"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+// Stages of the pipeline, as named in Stats, the stage label of
+// speedcam_stage_seconds and the latency overlay. Preprocess covers resizing
+// and background subtraction, detect contour finding and track the tracker
+// updates.
+const (
+	StageCapture    = "capture"
+	StagePreprocess = "preprocess"
+	StageDetect     = "detect"
+	StageTrack      = "track"
+	StageMeasure    = "measure"
+	StageOutput     = "output"
+)
+
+// Stages lists the stage names in the order frames pass through them.
+var Stages = []string{StageCapture, StagePreprocess, StageDetect, StageTrack, StageMeasure, StageOutput}
+
+// StageStats is the time one stage has spent working, excluding time spent
+// waiting on the stages either side.
+type StageStats struct {
+	Count int // frames, or cars for StageMeasure
+	Total time.Duration
+	Max   time.Duration
+}
+
+func (s StageStats) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+// Stats counts what a pipeline has done since it started running.
+type Stats struct {
+	Frames        int // through every stage
+	Dropped       int // read but not processed
+	Detections    int // blobs found
+	Events        int // vehicles timed
+	Counted       int // vehicles tracked across the frame, timed or not
+	Shed          int // events sent without evidence, see Config.ShedDepth
+	NearMisses    int // see Config.NearMissDistance
+	FailedToYield int // see Config.Crosswalk
+	Stages        map[string]StageStats
+}
+
+type statsRecorder struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+func (r *statsRecorder) observe(stage string, d time.Duration) {
+	metrics.StageSeconds.WithLabelValues(stage).Observe(d.Seconds())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stats.Stages == nil {
+		r.stats.Stages = make(map[string]StageStats)
+	}
+	s := r.stats.Stages[stage]
+	s.Count++
+	s.Total += d
+	if d > s.Max {
+		s.Max = d
+	}
+	r.stats.Stages[stage] = s
+}
+
+func (r *statsRecorder) add(count *int, n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*count += n
+}
+
+// Stats returns a copy of the counts so far, safe to call while running.
+func (p *Pipeline) Stats() Stats {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	s := p.stats.stats
+	s.Stages = make(map[string]StageStats, len(p.stats.stats.Stages))
+	for name, stage := range p.stats.stats.Stages {
+		s.Stages[name] = stage
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+package speedcam
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/metrics"
+)
+
+// DefaultThermalZone is where Linux, including Raspberry Pi OS, reports the
+// SoC temperature in millidegrees.
+const DefaultThermalZone = "/sys/class/thermal/thermal_zone0/temp"
+
+const (
+	thermalInterval   = 10 * time.Second
+	thermalHysteresis = 5 // degrees below the limit before throttling stops
+)
+
+func readTemperature(path string) (float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	milli, err := strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return milli / 1000, nil
+}
+
+// watchThermal halves the frame rate while the SoC is at or above
+// Config.ThermalLimit, rather than let the firmware throttle the CPU under
+// us mid-track.
+func (p *Pipeline) watchThermal(ctx context.Context) {
+	ticker := time.NewTicker(thermalInterval)
+	defer ticker.Stop()
+
+	for {
+		temp, err := readTemperature(p.cfg.ThermalZone)
+		if err != nil {
+			fmt.Printf("Failed to read temperature, not watching it - %s\n", err)
+			return
+		}
+		metrics.CPUTemperature.Set(temp)
+
+		throttled := p.throttled()
+		switch {
+		case !throttled && temp >= p.cfg.ThermalLimit:
+			fmt.Printf("CPU at %.1fC, halving frame rate\n", temp)
+			atomic.StoreInt32(&p.throttle, 1)
+		case throttled && temp < p.cfg.ThermalLimit-thermalHysteresis:
+			fmt.Printf("CPU down to %.1fC, restoring frame rate\n", temp)
+			atomic.StoreInt32(&p.throttle, 0)
+		}
+		metrics.ThermalThrottled.Set(float64(atomic.LoadInt32(&p.throttle)))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pipeline) throttled() bool {
+	return atomic.LoadInt32(&p.throttle) == 1
+}
@@ -0,0 +1,135 @@
+// Package blob follows blobs, e.g. moving vehicles picked out of a
+// foreground mask, from frame to frame by matching each to the nearest
+// centroid seen before.
+package blob
+
+import (
+	"image"
+	"math"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// TrackPoint is where a blob's centroid was at a moment.
+type TrackPoint struct {
+	Point   image.Point
+	Created time.Time
+}
+
+// NewTrackPoint returns p as seen now.
+func NewTrackPoint(p image.Point) TrackPoint {
+	return TrackPoint{Point: p, Created: time.Now()}
+}
+
+// Object is one blob being tracked.
+type Object struct {
+	CurrentRect image.Rectangle // where it was last seen
+	History     []TrackPoint    // its latest centroids, oldest first
+	Disappeared int             // updates since it was last seen
+
+	id uuid.UUID
+}
+
+// CentroidTracker matches the rectangles of each update to the objects
+// already tracked, nearest centroids first.
+type CentroidTracker struct {
+	Objects    map[uuid.UUID]*Object
+	NewObjects []uuid.UUID // registered by the last Update
+
+	maxDisappeared int
+	maxDistance    float64
+	maxHistory     int
+}
+
+// NewCentroidTracker returns an empty tracker. An object is dropped once it
+// has gone unseen for more than maxDisappeared updates, a rectangle is only
+// matched to an object whose centroid is within maxDistance pixels of its
+// own, and each object keeps its last maxHistory centroids.
+func NewCentroidTracker(maxDisappeared, maxDistance, maxHistory int) *CentroidTracker {
+	return &CentroidTracker{
+		Objects:        make(map[uuid.UUID]*Object),
+		maxDisappeared: maxDisappeared,
+		maxDistance:    float64(maxDistance),
+		maxHistory:     maxHistory,
+	}
+}
+
+func centroid(r image.Rectangle) image.Point {
+	return image.Pt((r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2)
+}
+
+// Update matches rects, the blobs in a new frame, to the tracked objects,
+// registering those left over as new objects and dropping objects unseen
+// for too long.
+func (t *CentroidTracker) Update(rects []image.Rectangle) {
+	t.NewObjects = nil
+
+	type pair struct {
+		obj      *Object
+		rect     int
+		distance float64
+	}
+	var pairs []pair
+	for _, o := range t.Objects {
+		from := centroid(o.CurrentRect)
+		for i, r := range rects {
+			to := centroid(r)
+			d := math.Hypot(float64(to.X-from.X), float64(to.Y-from.Y))
+			if d <= t.maxDistance {
+				pairs = append(pairs, pair{obj: o, rect: i, distance: d})
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+		if a.distance != b.distance {
+			return a.distance < b.distance
+		}
+		if a.rect != b.rect {
+			return a.rect < b.rect
+		}
+		return a.obj.id.String() < b.obj.id.String()
+	})
+
+	seen := make(map[*Object]bool)
+	used := make([]bool, len(rects))
+	for _, p := range pairs {
+		if seen[p.obj] || used[p.rect] {
+			continue
+		}
+		seen[p.obj], used[p.rect] = true, true
+		t.see(p.obj, rects[p.rect])
+	}
+
+	for id, o := range t.Objects {
+		if seen[o] {
+			continue
+		}
+		o.Disappeared++
+		if o.Disappeared > t.maxDisappeared {
+			delete(t.Objects, id)
+		}
+	}
+
+	for i, r := range rects {
+		if used[i] {
+			continue
+		}
+		o := &Object{id: uuid.NewV4()}
+		t.see(o, r)
+		t.Objects[o.id] = o
+		t.NewObjects = append(t.NewObjects, o.id)
+	}
+}
+
+// see records o as seen at r.
+func (t *CentroidTracker) see(o *Object, r image.Rectangle) {
+	o.CurrentRect = r
+	o.Disappeared = 0
+	o.History = append(o.History, NewTrackPoint(centroid(r)))
+	if n := len(o.History) - t.maxHistory; t.maxHistory > 0 && n > 0 {
+		o.History = o.History[n:]
+	}
+}
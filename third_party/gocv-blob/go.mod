@@ -0,0 +1,5 @@
+module github.com/danhigham/gocv-blob
+
+go 1.13
+
+require github.com/satori/go.uuid v1.2.0
@@ -0,0 +1,161 @@
+package speedcam
+
+import (
+	"image"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/danhigham/gocv-blob/blob"
+	"github.com/danhigham/speedcam/pkg/control"
+	"github.com/danhigham/speedcam/pkg/speed"
+	"github.com/danhigham/speedcam/pkg/track"
+	uuid "github.com/satori/go.uuid"
+	"gocv.io/x/gocv"
+)
+
+// fixedClass is a Classifier labelling everything the same.
+type fixedClass string
+
+func (c fixedClass) Classify(gocv.Mat) string { return string(c) }
+
+// pathCar is a car seen at each of points in turn, step apart from start.
+func pathCar(start time.Time, step time.Duration, points []image.Point) *track.Car {
+	car := &track.Car{}
+	for i, pt := range points {
+		tp := blob.TrackPoint{Point: pt, Created: start.Add(time.Duration(i) * step)}
+		car.Track = append(car.Track, track.CarTrack{TrackPoint: tp})
+	}
+	return car
+}
+
+// leave drops id from the tracker and measures the cars it no longer has
+// as the measure stage would, with class.
+func leave(p *Pipeline, id uuid.UUID, class string) []removal {
+	delete(p.tracker.Objects, id)
+	gone := p.dropLost(control.TuningValues{})
+	for _, r := range gone {
+		p.finishPath(r.car, class, r.id)
+	}
+	return gone
+}
+
+func TestDropLostKeepsTrackedCars(t *testing.T) {
+	a, b, lost := uuid.NewV4(), uuid.NewV4(), uuid.NewV4()
+	p := &Pipeline{
+		tracker: &blob.CentroidTracker{Objects: map[uuid.UUID]*blob.Object{a: {}, b: {}}},
+		cars:    track.Register{a: &track.Car{}, b: &track.Car{}, lost: &track.Car{}},
+	}
+
+	gone := p.dropLost(control.TuningValues{})
+	if len(gone) != 1 || gone[0].id != lost {
+		t.Errorf("removed %v, want only %s", gone, lost)
+	}
+	for _, id := range []uuid.UUID{a, b} {
+		if p.cars[id] == nil {
+			t.Errorf("car %s removed while the tracker still has it", id)
+		}
+	}
+
+	p.tracker.Objects = map[uuid.UUID]*blob.Object{}
+	if gone := p.dropLost(control.TuningValues{}); len(gone) != 2 || len(p.cars) != 0 {
+		t.Errorf("removed %d cars leaving %d, want 2 leaving 0", len(gone), len(p.cars))
+	}
+}
+
+func TestFailedYieldWithOthersTracked(t *testing.T) {
+	const mph = 30.0
+	start := time.Date(2024, 6, 11, 8, 0, 0, 0, time.UTC)
+	cal := speed.DefaultCalibration
+	step := speed.FeetPerSecond(mph) / 10 / cal.FeetPerPixel()
+
+	// a vehicle drives through the crosswalk at mph while a pedestrian
+	// walks across it, both sampled ten times a second
+	var road, walk []image.Point
+	for x := 100.0; x < 460; x += step {
+		road = append(road, image.Pt(int(math.Round(x)), 240))
+	}
+	for y := 100; len(walk) < len(road)+10; y += 2 {
+		walk = append(walk, image.Pt(420, y))
+	}
+
+	var got []Yield
+	vehicle, pedestrian := uuid.NewV4(), uuid.NewV4()
+	p := &Pipeline{
+		cfg: Config{
+			Camera:      "test",
+			Calibration: cal,
+			Classifier:  fixedClass("car"),
+			Crosswalk:   image.Rect(400, 0, 440, 480),
+			OnYield:     func(y Yield) { got = append(got, y) },
+		},
+		tracker: &blob.CentroidTracker{Objects: map[uuid.UUID]*blob.Object{vehicle: {}, pedestrian: {}}},
+		cars: track.Register{
+			vehicle:    pathCar(start, 100*time.Millisecond, road),
+			pedestrian: pathCar(start, 100*time.Millisecond, walk),
+		},
+	}
+
+	if gone := p.dropLost(control.TuningValues{}); len(gone) != 0 {
+		t.Fatalf("removed %d cars while both are tracked", len(gone))
+	}
+	if gone := leave(p, vehicle, "car"); len(gone) != 1 || gone[0].id != vehicle {
+		t.Fatalf("removed %v when the vehicle left, want only it", gone)
+	}
+	if p.cars[pedestrian] == nil {
+		t.Fatal("pedestrian removed with the vehicle")
+	}
+	leave(p, pedestrian, "person")
+
+	if len(got) != 1 {
+		t.Fatalf("reported %d yields, want 1", len(got))
+	}
+	if got[0].Yielded {
+		t.Errorf("tagged as yielding at %.1f mph", got[0].MinSpeed)
+	}
+	if got[0].EventID != vehicle {
+		t.Errorf("EventID = %s, want the vehicle's %s", got[0].EventID, vehicle)
+	}
+	if math.Abs(got[0].ApproachSpeed-mph) > 2 {
+		t.Errorf("approached at %.1f mph, want %.1f ± 2", got[0].ApproachSpeed, mph)
+	}
+}
+
+func TestOccupancyWithTwoInView(t *testing.T) {
+	start := time.Date(2024, 6, 11, 8, 0, 0, 0, time.UTC)
+	a, b := uuid.NewV4(), uuid.NewV4()
+	var got []Occupancy
+	p := &Pipeline{
+		cfg:     Config{Camera: "test", OnOccupancy: func(o Occupancy) { got = append(got, o) }},
+		tracker: &blob.CentroidTracker{Objects: map[uuid.UUID]*blob.Object{}},
+		cars:    make(track.Register),
+	}
+
+	// a frame a second, a in view for the first ten and b, overtaking it,
+	// from the fourth to the seventh
+	for s := 0; s <= 12; s++ {
+		for id, in := range map[uuid.UUID]bool{a: s < 10, b: s >= 3 && s < 7} {
+			if _, tracked := p.tracker.Objects[id]; in && !tracked {
+				p.tracker.Objects[id] = &blob.Object{}
+				p.cars[id] = &track.Car{}
+			} else if !in {
+				delete(p.tracker.Objects, id)
+			}
+		}
+		p.dropLost(control.TuningValues{})
+		p.measureOccupancy(&frame{at: start.Add(time.Duration(s) * time.Second)})
+	}
+	p.measureOccupancy(&frame{at: start.Add(OccupancyInterval)})
+
+	want := Occupancy{
+		Camera:      "test",
+		Start:       start,
+		Observed:    12 * time.Second,
+		Occupied:    9 * time.Second,
+		VehicleTime: 13 * time.Second,
+		MaxVehicles: 2,
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("occupancy %+v, want %+v", got, want)
+	}
+}
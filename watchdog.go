@@ -0,0 +1,63 @@
+package speedcam
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/danhigham/speedcam/pkg/metrics"
+	"gocv.io/x/gocv"
+)
+
+// ErrFeedFrozen is returned by Run when the source has delivered no new
+// frames for Config.StallTimeout, so it can be reopened.
+var ErrFeedFrozen = errors.New("capture feed frozen")
+
+const watchdogInterval = time.Second
+
+// markFresh records when capture last read a frame that differs from the
+// one before it, prev holding a copy of that frame. A feed stuck repeating
+// one frame is as dead as one delivering none, and a live camera's sensor
+// noise means no two real frames are identical.
+func (p *Pipeline) markFresh(img gocv.Mat, prev *gocv.Mat) {
+	if !prev.Empty() && prev.Rows() == img.Rows() && prev.Cols() == img.Cols() &&
+		gocv.NormWithMats(img, *prev, gocv.NormInf) == 0 {
+		return
+	}
+	img.CopyTo(prev)
+	atomic.StoreInt64(&p.fresh, time.Now().UnixNano())
+}
+
+// watchFeed calls Config.OnFrozen and stops the pipeline with cancel once no
+// fresh frame has arrived for Config.StallTimeout. A Read blocked inside
+// OpenCV only returns once its own timeout passes, until then Run can't.
+func (p *Pipeline) watchFeed(ctx context.Context, cancel context.CancelFunc) {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	atomic.StoreInt64(&p.fresh, time.Now().UnixNano())
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		age := time.Since(time.Unix(0, atomic.LoadInt64(&p.fresh)))
+		metrics.FrameAge.WithLabelValues(p.cfg.Camera).Set(age.Seconds())
+		if age < p.cfg.StallTimeout || atomic.LoadInt32(&p.connected) == 0 {
+			// a dropped stream is already being reopened
+			continue
+		}
+
+		fmt.Printf("No new frames from %s for %s, reconnecting\n", p.cfg.Source, age.Round(time.Second))
+		atomic.StoreInt32(&p.frozen, 1)
+		if p.cfg.OnFrozen != nil {
+			p.cfg.OnFrozen(age)
+		}
+		cancel()
+		return
+	}
+}